@@ -0,0 +1,129 @@
+package delivery
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+type ApiKeyHandler struct {
+	apiKeyUsecase domain.ApiKeyUsecase
+}
+
+// Every route needs a valid token, the keys a caller manages are its own
+func NewApiKeyHandler(apiKeyUsecase domain.ApiKeyUsecase, m *mux.Router, authMiddleware *auth.Middleware) {
+	handler := ApiKeyHandler{apiKeyUsecase}
+	router_v1 := m.PathPrefix("/api/v1/keys").Subrouter()
+
+	authMiddleware.Require(router_v1.Path("/").HandlerFunc(handler.getAllApiKeys).Methods("GET"), "")
+	authMiddleware.Require(router_v1.Path("/create").HandlerFunc(handler.createApiKey).Methods("POST"), "")
+	authMiddleware.Require(router_v1.Path("/{id}").HandlerFunc(handler.revokeApiKey).Methods("DELETE"), "")
+	router_v1.Use(authMiddleware.Handler)
+}
+
+func (h *ApiKeyHandler) createApiKey(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	requestBody := domain.CreateApiKeyRequest{}
+	err := json.NewDecoder(req.Body).Decode(&requestBody)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"error while parsing json"},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	key, err := h.apiKeyUsecase.CreateApiKey(req.Context(), requestBody)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	// the key is only ever shown in this response
+	res.Header().Set("Cache-Control", "no-store")
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusCreated,
+		Status: "Success Created",
+		Data:   key,
+	})
+}
+
+func (h *ApiKeyHandler) getAllApiKeys(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	keys, err := h.apiKeyUsecase.FindAllApiKeys(req.Context())
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   keys,
+	})
+}
+
+func (h *ApiKeyHandler) revokeApiKey(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	keyId, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"api key id isn't valid"},
+		})
+		return
+	}
+
+	err = h.apiKeyUsecase.RevokeApiKey(req.Context(), keyId)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   map[string]int{"id": keyId},
+	})
+}
+
+func errorParams(err error) *utils.ResponseErrorParams {
+	errorParams := utils.ResponseErrorParams{
+		Code:   http.StatusBadGateway,
+		Status: "Bad gateway",
+		Errors: []string{err.Error()},
+	}
+
+	if strings.Contains(strings.ToLower(err.Error()), "validation") {
+		errorParams.Code = http.StatusBadRequest
+		errorParams.Status = "Bad request"
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		errorParams.Code = http.StatusNotFound
+		errorParams.Status = "Not found"
+		errorParams.Errors = []string{"api key not found"}
+	}
+	if errors.Is(err, domain.ErrUnauthenticated) {
+		errorParams.Code = http.StatusUnauthorized
+		errorParams.Status = "Unauthorized"
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		errorParams.Code = http.StatusForbidden
+		errorParams.Status = "Forbidden"
+	}
+	return &errorParams
+}
@@ -0,0 +1,110 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateApiKeyHandler(t *testing.T) {
+	mockUsecase := new(mocks.ApiKeyUsecase)
+	request := domain.CreateApiKeyRequest{Name: "ci", Scopes: []string{domain.ScopeLinksWrite}}
+	usecaseResult := domain.CreatedApiKey{
+		ApiKey: domain.ApiKey{ID: 2, UserID: 3, Name: "ci", Prefix: "0a1b2c3d", SecretHash: "hash",
+			Scopes: []string{domain.ScopeLinksWrite}, CreatedAt: 1700000000},
+		Key: "usk_0a1b2c3d_secret",
+	}
+	mockUsecase.On("CreateApiKey", mock.Anything, request).Return(usecaseResult, nil)
+
+	req := httptest.NewRequest("POST", "/api/v1/keys/create", bytes.NewBufferString(`{"name":"ci","scopes":["links:write"]}`))
+	res := httptest.NewRecorder()
+
+	handler := ApiKeyHandler{mockUsecase}
+	handler.createApiKey(res, req)
+
+	expect := `
+	{
+		"status_code":201,
+		"status":"Success Created",
+		"data":{
+			"id":2,
+			"user_id":3,
+			"name":"ci",
+			"prefix":"0a1b2c3d",
+			"scopes":["links:write"],
+			"created_at":1700000000,
+			"key":"usk_0a1b2c3d_secret"
+		}
+	}`
+
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusCreated, res.Code)
+	assert.Equal(t, "no-store", res.Header().Get("Cache-Control"))
+	assert.JSONEq(t, expect, res.Body.String())
+}
+
+func TestCreateApiKeyForbidden(t *testing.T) {
+	mockUsecase := new(mocks.ApiKeyUsecase)
+	mockUsecase.On("CreateApiKey", mock.Anything, mock.AnythingOfType("domain.CreateApiKeyRequest")).
+		Return(domain.CreatedApiKey{}, fmt.Errorf("%w: the admin scope can't be granted", domain.ErrForbidden))
+
+	req := httptest.NewRequest("POST", "/api/v1/keys/create", bytes.NewBufferString(`{"name":"ci","scopes":["admin"]}`))
+	res := httptest.NewRecorder()
+
+	handler := ApiKeyHandler{mockUsecase}
+	handler.createApiKey(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+}
+
+func TestRevokeApiKeyHandler(t *testing.T) {
+	mockUsecase := new(mocks.ApiKeyUsecase)
+	mockUsecase.On("RevokeApiKey", mock.Anything, 2).Return(nil)
+	mockUsecase.On("RevokeApiKey", mock.Anything, 4).Return(sql.ErrNoRows)
+
+	handler := ApiKeyHandler{mockUsecase}
+
+	res := httptest.NewRecorder()
+	handler.revokeApiKey(res, mux.SetURLVars(httptest.NewRequest("DELETE", "/api/v1/keys/2", nil), map[string]string{"id": "2"}))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	handler.revokeApiKey(res, mux.SetURLVars(httptest.NewRequest("DELETE", "/api/v1/keys/4", nil), map[string]string{"id": "4"}))
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestApiKeyRoutesAuthenticated(t *testing.T) {
+	mockUsecase := new(mocks.ApiKeyUsecase)
+	user := domain.User{ID: 3, Role: domain.UserRoleMember}
+	mockUsecase.On("Authenticate", mock.Anything, "usk_0a1b2c3d_secret").Return(user, []string{domain.ScopeStatsRead}, nil)
+	mockUsecase.On("FindAllApiKeys", mock.MatchedBy(func(ctx context.Context) bool {
+		caller, ok := domain.UserFromContext(ctx)
+		return ok && caller.ID == 3
+	})).Return([]domain.ApiKey{}, nil)
+
+	router := mux.NewRouter()
+	NewApiKeyHandler(mockUsecase, router, auth.NewMiddleware(mockUsecase))
+
+	// any valid key manages its own keys, whatever its scopes
+	req := httptest.NewRequest("GET", "/api/v1/keys/", nil)
+	req.Header.Set("Authorization", "Bearer usk_0a1b2c3d_secret")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/keys/", nil))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type apiKeyRepository struct {
+	db *sql.DB
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func NewApiKeyRepository(db *sql.DB) domain.ApiKeyRepository {
+	return &apiKeyRepository{db}
+}
+
+// Scan one api_keys row selected with the shared column list
+// Receiving *sql.Row or *sql.Rows as parameter
+// Returning api key data (domain.ApiKey) if success, and error if failed
+
+func scanApiKey(row scanner) (domain.ApiKey, error) {
+	key, scopes := domain.ApiKey{}, ""
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.SecretHash, &scopes,
+		&key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+// Inserting new api key data to api_keys table
+// Receiving context, and key (domain.ApiKey) as parameter
+// Returning inserted api_key_id (int) if success, and error if failed
+
+func (r *apiKeyRepository) Create(ctx context.Context, key domain.ApiKey) (int, error) {
+	sqlRes, err := r.db.ExecContext(ctx, queries.InsertApiKey, key.UserID, key.Name, key.Prefix, key.SecretHash,
+		strings.Join(key.Scopes, " "), key.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	lastInsertID, err := sqlRes.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(lastInsertID), nil
+}
+
+// Fetch one api key data from api_keys table
+// Receiving context, and prefix (string) as parameter
+// Returning api key data (domain.ApiKey) if success, and error if failed
+
+func (r *apiKeyRepository) FindByPrefix(ctx context.Context, prefix string) (domain.ApiKey, error) {
+	return scanApiKey(r.db.QueryRowContext(ctx, queries.FindApiKeyByPrefix, prefix))
+}
+
+// Fetch the api key data of one user from api_keys table
+// Receiving context, and userID (int) as parameter
+// Returning api key data ([]domain.ApiKey) if success, and error if failed
+
+func (r *apiKeyRepository) FindByUserID(ctx context.Context, userID int) ([]domain.ApiKey, error) {
+	keys := []domain.ApiKey{}
+	rows, err := r.db.QueryContext(ctx, queries.FindApiKeysByUserID, userID)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return keys, err
+		}
+
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke one api key in api_keys table
+// Receiving context, id (int), userID (int), 0 for any user, and revokedAt (unix time) as parameter
+// Returning affected rows (int), 0 when the key isn't found or already revoked, and error if failed
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id, userID int, revokedAt int64) (int, error) {
+	query, args := queries.RevokeApiKey, []interface{}{revokedAt, id}
+	if userID != 0 {
+		query, args = queries.RevokeApiKeyOfUser, append(args, userID)
+	}
+
+	sqlRes, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := sqlRes.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
+
+// Record the last time one api key was used
+// Receiving context, id (int), and usedAt (unix time) as parameter
+// Returning error if failed
+
+func (r *apiKeyRepository) UpdateLastUsed(ctx context.Context, id int, usedAt int64) error {
+	_, err := r.db.ExecContext(ctx, queries.UpdateApiKeyLastUsed, usedAt, id)
+	return err
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var apiKeyColumns = []string{"id", "user_id", "name", "prefix", "secret_hash", "scopes", "created_at", "last_used_at", "revoked_at"}
+
+func NewMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		panic(err)
+	}
+	return db, mock
+}
+
+func TestCreateApiKey(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	key := domain.ApiKey{
+		UserID:     3,
+		Name:       "ci",
+		Prefix:     "k3j9x0aq",
+		SecretHash: "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8",
+		Scopes:     []string{domain.ScopeLinksRead, domain.ScopeLinksWrite},
+		CreatedAt:  time.Now().Unix(),
+	}
+	mock.ExpectExec(queries.InsertApiKey).
+		WithArgs(key.UserID, key.Name, key.Prefix, key.SecretHash, "links:read links:write", key.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	repo := apiKeyRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	id, err := repo.Create(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindApiKeys(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectQuery(queries.FindApiKeyByPrefix).WithArgs("k3j9x0aq").
+		WillReturnRows(mock.NewRows(apiKeyColumns).AddRow(2, 3, "ci", "k3j9x0aq", "hash", "links:read stats:read", 1700000000, 1700003600, 0))
+	mock.ExpectQuery(queries.FindApiKeysByUserID).WithArgs(3).
+		WillReturnRows(mock.NewRows(apiKeyColumns).
+			AddRow(2, 3, "ci", "k3j9x0aq", "hash", "links:read stats:read", 1700000000, 1700003600, 0).
+			AddRow(4, 3, "old", "p02kx8am", "hash", "", 1690000000, 0, 1695000000))
+
+	repo := apiKeyRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	key, err := repo.FindByPrefix(ctx, "k3j9x0aq")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.ApiKey{
+		ID: 2, UserID: 3, Name: "ci", Prefix: "k3j9x0aq", SecretHash: "hash",
+		Scopes: []string{"links:read", "stats:read"}, CreatedAt: 1700000000, LastUsedAt: 1700003600,
+	}, key)
+
+	keys, err := repo.FindByUserID(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Empty(t, keys[1].Scopes)
+	assert.Equal(t, int64(1695000000), keys[1].RevokedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectExec(queries.RevokeApiKeyOfUser).WithArgs(1700000000, 2, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.RevokeApiKey).WithArgs(1700000000, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(queries.UpdateApiKeyLastUsed).WithArgs(1700000000, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := apiKeyRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	affected, err := repo.Revoke(ctx, 2, 3, 1700000000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, affected)
+
+	// already revoked
+	affected, err = repo.Revoke(ctx, 4, 0, 1700000000)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affected)
+
+	err = repo.UpdateLastUsed(ctx, 2, 1700000000)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,226 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type apiKeyConfig struct {
+	NameMaxLength    int
+	PrefixBytes      int   // random bytes of the public prefix, hex encoded
+	SecretBytes      int   // random bytes of the secret, hex encoded
+	LastUsedInterval int64 // seconds between two writes of the last used time of one key
+}
+
+var _config apiKeyConfig = apiKeyConfig{
+	NameMaxLength:    255,
+	PrefixBytes:      4,
+	SecretBytes:      32,
+	LastUsedInterval: 60,
+}
+
+// Start of every api key, so secret scanners can recognize leaked keys
+const keyPrefix = "usk_"
+
+type apiKeyUsecase struct {
+	apiKeyRepository domain.ApiKeyRepository
+	userRepository   domain.UserRepository
+}
+
+func NewApiKeyUsecase(apiKeyRepository domain.ApiKeyRepository, userRepository domain.UserRepository) domain.ApiKeyUsecase {
+	return &apiKeyUsecase{apiKeyRepository, userRepository}
+}
+
+// Split an api key into its prefix and secret
+// Returning false when the key isn't shaped like one
+
+func parseKey(token string) (string, string, bool) {
+	if !strings.HasPrefix(token, keyPrefix) {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, keyPrefix), "_")
+	if len(parts) != 2 || len(parts[0]) != 2*_config.PrefixBytes || len(parts[1]) != 2*_config.SecretBytes {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// The secrets are long random strings, a fast hash is enough to keep them unreadable from the database
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	data := make([]byte, n)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// Remove duplicated scopes, and reject unknown ones
+
+func validateScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("validation error: at least one scope is required")
+	}
+
+	result := []string{}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range domain.Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("validation error: unknown scope %q, must be one of %s", scope, strings.Join(domain.Scopes, ", "))
+		}
+
+		duplicated := false
+		for _, s := range result {
+			duplicated = duplicated || s == scope
+		}
+		if !duplicated {
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+// Create an api key for the caller, granting at most the scopes of the credentials the caller used
+// Returning the key along with its full value, which isn't stored
+
+func (u *apiKeyUsecase) CreateApiKey(ctx context.Context, request domain.CreateApiKeyRequest) (domain.CreatedApiKey, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.CreatedApiKey{}, domain.ErrUnauthenticated
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > _config.NameMaxLength {
+		return domain.CreatedApiKey{}, fmt.Errorf("validation error: name is required and can't be longer than %d characters", _config.NameMaxLength)
+	}
+
+	scopes, err := validateScopes(request.Scopes)
+	if err != nil {
+		return domain.CreatedApiKey{}, err
+	}
+	for _, scope := range scopes {
+		if !domain.HasScope(domain.ScopesFromContext(ctx), scope) {
+			return domain.CreatedApiKey{}, fmt.Errorf("%w: the %s scope can't be granted with the current credentials", domain.ErrForbidden, scope)
+		}
+	}
+
+	prefix, err := randomHex(_config.PrefixBytes)
+	if err != nil {
+		return domain.CreatedApiKey{}, err
+	}
+	secret, err := randomHex(_config.SecretBytes)
+	if err != nil {
+		return domain.CreatedApiKey{}, err
+	}
+
+	key := domain.ApiKey{
+		UserID:     user.ID,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hashSecret(secret),
+		Scopes:     scopes,
+		CreatedAt:  time.Now().Unix(),
+	}
+	key.ID, err = u.apiKeyRepository.Create(ctx, key)
+	if err != nil {
+		return domain.CreatedApiKey{}, err
+	}
+
+	return domain.CreatedApiKey{ApiKey: key, Key: keyPrefix + prefix + "_" + secret}, nil
+}
+
+func (u *apiKeyUsecase) FindAllApiKeys(ctx context.Context) ([]domain.ApiKey, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return nil, domain.ErrUnauthenticated
+	}
+	return u.apiKeyRepository.FindByUserID(ctx, user.ID)
+}
+
+// Revoke one api key of the caller, admins can revoke the key of any user
+
+func (u *apiKeyUsecase) RevokeApiKey(ctx context.Context, id int) error {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.ErrUnauthenticated
+	}
+
+	userID := user.ID
+	if user.IsAdmin() {
+		userID = 0
+	}
+
+	affected, err := u.apiKeyRepository.Revoke(ctx, id, userID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Resolve an api key to its user and scopes, and record when it was used
+// The user keeps the admin role only when the key has the admin scope
+
+func (u *apiKeyUsecase) Authenticate(ctx context.Context, token string) (domain.User, []string, error) {
+	prefix, secret, ok := parseKey(token)
+	if !ok {
+		return domain.User{}, nil, domain.ErrInvalidCredentials
+	}
+
+	key, err := u.apiKeyRepository.FindByPrefix(ctx, prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, nil, domain.ErrInvalidCredentials
+	}
+	if err != nil {
+		return domain.User{}, nil, err
+	}
+	if key.RevokedAt != 0 || subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return domain.User{}, nil, domain.ErrInvalidCredentials
+	}
+
+	user, err := u.userRepository.FindByID(ctx, key.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, nil, domain.ErrInvalidCredentials
+	}
+	if err != nil {
+		return domain.User{}, nil, err
+	}
+
+	// the last used time is informative, it is written at most once per interval and a failure doesn't reject the key
+	now := time.Now().Unix()
+	if now-key.LastUsedAt >= _config.LastUsedInterval {
+		err = u.apiKeyRepository.UpdateLastUsed(ctx, key.ID, now)
+		if err != nil {
+			log.Printf("failed to record the last use of api key %d: %v", key.ID, err)
+		}
+	}
+
+	if !domain.HasScope(key.Scopes, domain.ScopeAdmin) {
+		user.Role = domain.UserRoleMember
+	}
+	return user, key.Scopes, nil
+}
@@ -0,0 +1,159 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var member = domain.User{ID: 3, Email: "ci@example.com", Role: domain.UserRoleMember}
+
+func callerCtx(user domain.User, scopes ...string) context.Context {
+	return domain.ContextWithScopes(domain.ContextWithUser(context.Background(), user), scopes)
+}
+
+func TestCreateApiKey(t *testing.T) {
+	keyRepoMock := new(mocks.ApiKeyRepository)
+	apiKeyUsecase := apiKeyUsecase{apiKeyRepository: keyRepoMock}
+
+	ctx := callerCtx(member, domain.ScopeLinksRead, domain.ScopeLinksWrite)
+	var stored domain.ApiKey
+	keyRepoMock.On("Create", ctx, mock.AnythingOfType("domain.ApiKey")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(domain.ApiKey) }).
+		Return(5, nil)
+
+	created, err := apiKeyUsecase.CreateApiKey(ctx, domain.CreateApiKeyRequest{
+		Name:   " deploy ",
+		Scopes: []string{domain.ScopeLinksWrite, domain.ScopeLinksWrite},
+	})
+	keyRepoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, created.ID)
+	assert.Equal(t, "deploy", created.Name)
+	assert.Equal(t, []string{domain.ScopeLinksWrite}, created.Scopes)
+	assert.Equal(t, 3, stored.UserID)
+
+	// only the hash of the secret is stored
+	prefix, secret, ok := parseKey(created.Key)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(created.Key, "usk_"))
+	assert.Equal(t, stored.Prefix, prefix)
+	assert.Equal(t, hashSecret(secret), stored.SecretHash)
+	assert.NotContains(t, stored.SecretHash, secret)
+}
+
+func TestCreateApiKeyInvalid(t *testing.T) {
+	keyRepoMock := new(mocks.ApiKeyRepository)
+	apiKeyUsecase := apiKeyUsecase{apiKeyRepository: keyRepoMock}
+
+	ctx := callerCtx(member, domain.ScopeLinksRead)
+	invalidRequests := []domain.CreateApiKeyRequest{
+		{Name: "", Scopes: []string{domain.ScopeLinksRead}},
+		{Name: strings.Repeat("x", 256), Scopes: []string{domain.ScopeLinksRead}},
+		{Name: "ci"},
+		{Name: "ci", Scopes: []string{"links:delete"}},
+	}
+	for _, request := range invalidRequests {
+		_, err := apiKeyUsecase.CreateApiKey(ctx, request)
+		assert.ErrorContains(t, err, "validation error")
+	}
+
+	// a key can't grant more than the credentials it was created with
+	_, err := apiKeyUsecase.CreateApiKey(ctx, domain.CreateApiKeyRequest{Name: "ci", Scopes: []string{domain.ScopeLinksWrite}})
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+
+	_, err = apiKeyUsecase.CreateApiKey(context.Background(), domain.CreateApiKeyRequest{Name: "ci", Scopes: []string{domain.ScopeLinksRead}})
+	assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+	keyRepoMock.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	keyRepoMock := new(mocks.ApiKeyRepository)
+	apiKeyUsecase := apiKeyUsecase{apiKeyRepository: keyRepoMock}
+
+	admin := domain.User{ID: 1, Role: domain.UserRoleAdmin}
+	keyRepoMock.On("Revoke", mock.Anything, 2, 3, mock.AnythingOfType("int64")).Return(1, nil)
+	keyRepoMock.On("Revoke", mock.Anything, 4, 3, mock.AnythingOfType("int64")).Return(0, nil)
+	keyRepoMock.On("Revoke", mock.Anything, 4, 0, mock.AnythingOfType("int64")).Return(1, nil)
+
+	err := apiKeyUsecase.RevokeApiKey(callerCtx(member), 2)
+	assert.NoError(t, err)
+
+	// the key of another user isn't found, unless the caller is an admin
+	err = apiKeyUsecase.RevokeApiKey(callerCtx(member), 4)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	err = apiKeyUsecase.RevokeApiKey(callerCtx(admin, domain.ScopeAdmin), 4)
+	assert.NoError(t, err)
+	keyRepoMock.AssertExpectations(t)
+}
+
+func TestAuthenticate(t *testing.T) {
+	keyRepoMock := new(mocks.ApiKeyRepository)
+	userRepoMock := new(mocks.UserRepository)
+	apiKeyUsecase := apiKeyUsecase{keyRepoMock, userRepoMock}
+
+	secret := strings.Repeat("ab", 32)
+	token := "usk_0a1b2c3d_" + secret
+	key := domain.ApiKey{ID: 2, UserID: 1, Prefix: "0a1b2c3d", SecretHash: hashSecret(secret), Scopes: []string{domain.ScopeLinksRead}}
+	keyRepoMock.On("FindByPrefix", context.Background(), "0a1b2c3d").Return(key, nil)
+	keyRepoMock.On("UpdateLastUsed", context.Background(), 2, mock.AnythingOfType("int64")).Return(errors.New("connection refused"))
+	userRepoMock.On("FindByID", context.Background(), 1).Return(domain.User{ID: 1, Role: domain.UserRoleAdmin}, nil)
+
+	// a failed write of the last used time doesn't reject the key
+	user, scopes, err := apiKeyUsecase.Authenticate(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{domain.ScopeLinksRead}, scopes)
+	// without the admin scope, an admin only manages its own urls
+	assert.Equal(t, domain.UserRoleMember, user.Role)
+	keyRepoMock.AssertExpectations(t)
+	userRepoMock.AssertExpectations(t)
+}
+
+func TestAuthenticateRecentlyUsed(t *testing.T) {
+	keyRepoMock := new(mocks.ApiKeyRepository)
+	userRepoMock := new(mocks.UserRepository)
+	apiKeyUsecase := apiKeyUsecase{keyRepoMock, userRepoMock}
+
+	secret := strings.Repeat("cd", 32)
+	key := domain.ApiKey{ID: 2, UserID: 1, Prefix: "0a1b2c3d", SecretHash: hashSecret(secret),
+		Scopes: []string{domain.ScopeAdmin}, LastUsedAt: time.Now().Unix() - 10}
+	keyRepoMock.On("FindByPrefix", context.Background(), "0a1b2c3d").Return(key, nil)
+	userRepoMock.On("FindByID", context.Background(), 1).Return(domain.User{ID: 1, Role: domain.UserRoleAdmin}, nil)
+
+	user, _, err := apiKeyUsecase.Authenticate(context.Background(), "usk_0a1b2c3d_"+secret)
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin())
+	keyRepoMock.AssertNotCalled(t, "UpdateLastUsed", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAuthenticateInvalid(t *testing.T) {
+	keyRepoMock := new(mocks.ApiKeyRepository)
+	apiKeyUsecase := apiKeyUsecase{apiKeyRepository: keyRepoMock}
+
+	secret := strings.Repeat("ab", 32)
+	keyRepoMock.On("FindByPrefix", context.Background(), "0a1b2c3d").
+		Return(domain.ApiKey{ID: 2, Prefix: "0a1b2c3d", SecretHash: hashSecret(secret), RevokedAt: 1700000000}, nil)
+	keyRepoMock.On("FindByPrefix", context.Background(), "ffffffff").
+		Return(domain.ApiKey{ID: 3, Prefix: "ffffffff", SecretHash: hashSecret(secret)}, nil)
+	keyRepoMock.On("FindByPrefix", context.Background(), "00000000").Return(domain.ApiKey{}, sql.ErrNoRows)
+
+	for _, token := range []string{
+		"",
+		"Bearer usk_0a1b2c3d_" + secret,
+		"usk_0a1b2c3d_" + secret[:10],
+		"usk_0a1b2c3d_" + secret, // revoked
+		"usk_ffffffff_" + strings.Repeat("ef", 32), // wrong secret
+		"usk_00000000_" + secret,                   // unknown prefix
+	} {
+		_, _, err := apiKeyUsecase.Authenticate(context.Background(), token)
+		assert.ErrorIs(t, err, domain.ErrInvalidCredentials, token)
+	}
+}
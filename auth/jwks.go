@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bytes of a key set read from its source
+const maxKeySetSize = 1024 * 1024
+
+// Shortest time between two reloads of a key set, so tokens with made up key ids can't hammer the source
+const minKeySetReload = 30 * time.Second
+
+var ErrUnknownKey = errors.New("token is signed with an unknown key")
+
+// Public keys of a JSON Web Key Set, loaded from a file or an http(s) url and cached by key id
+// The set is reloaded every refresh interval, and sooner when a token names a key it doesn't hold,
+// so keys rotated in at the source are picked up without a restart
+type KeySet struct {
+	source          string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mu          sync.Mutex // guards the fields below, never held while the source is read
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+	attemptedAt time.Time        // last load, successful or not
+	loading     chan struct{}    // closed once the load in progress is done, nil when there is none
+	now         func() time.Time // replaced by tests
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Create a key set, loading it once so a wrong source is reported on startup
+// Receiving source (string), a file path or an http(s) url, and refreshInterval (time.Duration) as parameter
+// Returning the key set (*KeySet) if success, and error if the first load fails
+
+func NewKeySet(ctx context.Context, source string, refreshInterval time.Duration) (*KeySet, error) {
+	s := &KeySet{
+		source:          source,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+
+	s.attemptedAt = s.now()
+	err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Find the key a token names with its kid header
+// Concurrent misses wait for a single reload, requests with a cached key aren't held up by it:
+// a reload that is due when the key is cached runs in the background, and is shared with the misses
+// Returning ErrUnknownKey when the source doesn't hold it, even after a reload
+
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	now := s.now()
+	key, ok := s.keys[kid]
+	due := now.Sub(s.loadedAt) >= s.refreshInterval
+	loading := s.loading
+	start := loading == nil && (due || !ok) && now.Sub(s.attemptedAt) >= minKeySetReload
+	if start {
+		loading = make(chan struct{})
+		s.loading, s.attemptedAt = loading, now
+	}
+	s.mu.Unlock()
+
+	switch {
+	case start && ok:
+		// not tied to ctx, the request is done long before the source answers
+		go s.reload(context.Background(), loading)
+		return key, nil
+	case start:
+		s.reload(ctx, loading)
+	case loading != nil && !ok:
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	case !ok:
+		return nil, ErrUnknownKey
+	default:
+		return key, nil
+	}
+
+	s.mu.Lock()
+	key, ok = s.keys[kid]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Run the load requests wait on with loading, keeping the cached keys while the source is unavailable
+
+func (s *KeySet) reload(ctx context.Context, loading chan struct{}) {
+	err := s.load(ctx)
+	if err != nil {
+		log.Printf("failed to reload key set: %v", err)
+	}
+
+	s.mu.Lock()
+	s.loading = nil
+	s.mu.Unlock()
+	close(loading)
+}
+
+// Replace the cached keys with the ones of the source, taking mu only to swap them in
+
+func (s *KeySet) load(ctx context.Context) error {
+	data, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return fmt.Errorf("invalid key set: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// a key of another type doesn't make the others unusable
+			log.Printf("ignoring key %q of the key set: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.loadedAt = s.now()
+	return nil
+}
+
+func (s *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		file, err := os.Open(s.source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(io.LimitReader(file, maxKeySetSize))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key set source responded with status %d", res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxKeySetSize))
+}
+
+// Public key of an RSA or P-256 EC json web key
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		if jwk.Alg != "" && jwk.Alg != "RS256" {
+			return nil, fmt.Errorf("unsupported algorithm %q", jwk.Alg)
+		}
+		n, err := base64Int(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64Int(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("rsa keys must be at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" || (jwk.Alg != "" && jwk.Alg != "ES256") {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64Int(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64Int(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point isn't on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func base64Int(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// Clock difference tolerated with the token issuer on the exp and nbf claims
+const clockSkew = time.Minute
+
+// Scopes of a token without a scope claim, by role
+var roleScopes = map[string][]string{
+	domain.UserRoleMember: {domain.ScopeLinksRead, domain.ScopeLinksWrite, domain.ScopeStatsRead},
+	domain.UserRoleAdmin:  {domain.ScopeAdmin},
+}
+
+// Authenticator of RS256 and ES256 JWTs issued by an SSO provider, verified against the keys of a JSON Web Key Set
+// Users are keyed by the iss and sub claims, a first sign in is linked to the user of its email once the issuer verified it,
+// or to a member created for it
+type JWTAuthenticator struct {
+	keys           *KeySet
+	userRepository domain.UserRepository
+	issuer         string
+	audience       string
+	rolesClaim     string
+	adminRole      string
+	now            func() time.Time // replaced by tests
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     *int64   `json:"exp"`
+	NotBefore     *int64   `json:"nbf"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Scope         *string  `json:"scope"`
+}
+
+// The aud claim is either a single string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	*a = multiple
+	return err
+}
+
+// Create an authenticator of the tokens of one issuer for one audience
+// Receiving keys (*KeySet), userRepository (domain.UserRepository) and cfg (config.Config) as parameter
+// Returning the authenticator (*JWTAuthenticator) if success, and error if the issuer or the audience isn't configured,
+// any token signed by the provider would be accepted otherwise, whichever application it was issued to
+
+func NewJWTAuthenticator(keys *KeySet, userRepository domain.UserRepository, cfg config.Config) (*JWTAuthenticator, error) {
+	if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+		return nil, errors.New("JWT_ISSUER and JWT_AUDIENCE are required with jwt authentication")
+	}
+
+	return &JWTAuthenticator{
+		keys:           keys,
+		userRepository: userRepository,
+		issuer:         cfg.JWTIssuer,
+		audience:       cfg.JWTAudience,
+		rolesClaim:     cfg.JWTRolesClaim,
+		adminRole:      cfg.JWTAdminRole,
+		now:            time.Now,
+	}, nil
+}
+
+// Verify a JWT and resolve it to its user
+// Receiving ctx (context.Context) and token (string) as parameter
+// Returning the user (domain.User) and the scopes of the token ([]string) if success,
+// and domain.ErrInvalidCredentials if the token isn't valid
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (domain.User, []string, error) {
+	payload, err := a.verify(ctx, token)
+	if err != nil {
+		return domain.User{}, nil, fmt.Errorf("%w: %s", domain.ErrInvalidCredentials, err)
+	}
+
+	claims := jwtClaims{}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return domain.User{}, nil, fmt.Errorf("%w: invalid claims", domain.ErrInvalidCredentials)
+	}
+	err = a.validateClaims(claims)
+	if err != nil {
+		return domain.User{}, nil, fmt.Errorf("%w: %s", domain.ErrInvalidCredentials, err)
+	}
+
+	role := domain.UserRoleMember
+	if a.hasAdminRole(payload) {
+		role = domain.UserRoleAdmin
+	}
+
+	// a scope claim narrows what the role grants
+	scopes := roleScopes[role]
+	if claims.Scope != nil {
+		scopes = []string{}
+		for _, scope := range strings.Fields(*claims.Scope) {
+			if isKnownScope(scope) && domain.HasScope(roleScopes[role], scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	user, err := a.findOrCreateUser(ctx, claims)
+	if err != nil {
+		return domain.User{}, nil, err
+	}
+	user.Role = domain.UserRoleMember
+	if domain.HasScope(scopes, domain.ScopeAdmin) {
+		user.Role = domain.UserRoleAdmin
+	}
+	return user, scopes, nil
+}
+
+// Check the signature of a token with the key its header names
+// Returning the decoded payload
+
+func (a *JWTAuthenticator) verify(ctx context.Context, token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	header := jwtHeader{}
+	err = json.Unmarshal(headerData, &header)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	key, err := a.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// the algorithm must match the type of the key, so a token can't pick a weaker check
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return nil, errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, errors.New("invalid signature")
+	}
+
+	return base64.RawURLEncoding.DecodeString(parts[1])
+}
+
+func (a *JWTAuthenticator) validateClaims(claims jwtClaims) error {
+	now := a.now()
+	if claims.ExpiresAt == nil || now.Add(-clockSkew).Unix() >= *claims.ExpiresAt {
+		return errors.New("token is expired")
+	}
+	if claims.NotBefore != nil && now.Add(clockSkew).Unix() < *claims.NotBefore {
+		return errors.New("token isn't valid yet")
+	}
+	if claims.Issuer != a.issuer {
+		return errors.New("unexpected issuer")
+	}
+	found := false
+	for _, aud := range claims.Audience {
+		found = found || aud == a.audience
+	}
+	if !found {
+		return errors.New("unexpected audience")
+	}
+	if claims.Subject == "" {
+		return errors.New("missing sub claim")
+	}
+	if claims.Email == "" {
+		return errors.New("missing email claim")
+	}
+	return nil
+}
+
+// The roles claim is either an array of roles or a space separated string
+
+func (a *JWTAuthenticator) hasAdminRole(payload []byte) bool {
+	claims := map[string]json.RawMessage{}
+	if json.Unmarshal(payload, &claims) != nil {
+		return false
+	}
+
+	roles := []string{}
+	if json.Unmarshal(claims[a.rolesClaim], &roles) != nil {
+		var value string
+		json.Unmarshal(claims[a.rolesClaim], &value)
+		roles = strings.Fields(value)
+	}
+	for _, role := range roles {
+		if role == a.adminRole {
+			return true
+		}
+	}
+	return false
+}
+
+// The user an SSO account is linked to, linking it on its first sign in
+// The email claim only picks the user to link to, so an unverified address can't take over the account of its owner
+
+func (a *JWTAuthenticator) findOrCreateUser(ctx context.Context, claims jwtClaims) (domain.User, error) {
+	user, err := a.userRepository.FindByIdentity(ctx, claims.Issuer, claims.Subject)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return user, err
+	}
+	if !claims.EmailVerified {
+		return domain.User{}, fmt.Errorf("%w: the email of the account isn't verified", domain.ErrInvalidCredentials)
+	}
+
+	user, err = a.userRepository.FindByEmail(ctx, claims.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = a.createUser(ctx, claims)
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	err = a.userRepository.LinkIdentity(ctx, user.ID, claims.Issuer, claims.Subject, a.now().Unix())
+	if errors.Is(err, domain.ErrIdentityTaken) {
+		// a concurrent first sign in linked it, otherwise the user is linked to another account of the issuer
+		linked, findErr := a.userRepository.FindByIdentity(ctx, claims.Issuer, claims.Subject)
+		if findErr == nil {
+			return linked, nil
+		}
+		return domain.User{}, fmt.Errorf("%w: %s", domain.ErrInvalidCredentials, err)
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+	return user, nil
+}
+
+func (a *JWTAuthenticator) createUser(ctx context.Context, claims jwtClaims) (domain.User, error) {
+	user := domain.User{Name: claims.Name, Email: claims.Email, Role: domain.UserRoleMember, CreatedAt: a.now().Unix()}
+	if user.Name == "" {
+		user.Name = claims.Email
+	}
+	id, err := a.userRepository.Create(ctx, user)
+	if err != nil {
+		// a concurrent first request of the same user created it
+		existing, findErr := a.userRepository.FindByEmail(ctx, claims.Email)
+		if findErr == nil {
+			return existing, nil
+		}
+		return domain.User{}, err
+	}
+	user.ID = id
+	return user, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range domain.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
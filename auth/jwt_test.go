@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var jwtConfig = config.Config{
+	JWTIssuer:     "https://sso.example.com",
+	JWTAudience:   "urlshortener",
+	JWTRolesClaim: "roles",
+	JWTAdminRole:  "admin",
+}
+
+// Stand-in of the SSO provider, serving the key set it currently signs with
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []map[string]string
+	requests int
+}
+
+func newJWKSServer(keys ...map[string]string) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		json.NewEncoder(res).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	return s
+}
+
+func (s *jwksServer) rotate(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func encodeInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+		"n": encodeInt(key.N), "e": encodeInt(big.NewInt(int64(key.E)))}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": encodeInt(key.X), "y": encodeInt(key.Y)}
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":            "https://sso.example.com",
+		"sub":            "00u1",
+		"aud":            []string{"urlshortener", "wiki"},
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane",
+	}
+	for key, value := range extra {
+		claims[key] = value
+	}
+	return claims
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newJWKSServer(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	defer server.Close()
+	keys, err := NewKeySet(context.Background(), server.URL, time.Hour)
+	require.NoError(t, err)
+
+	userRepoMock := new(mocks.UserRepository)
+	userRepoMock.On("FindByIdentity", mock.Anything, "https://sso.example.com", "00u1").
+		Return(domain.User{ID: 7, Email: "jane@example.com", Role: domain.UserRoleMember}, nil)
+	authenticator, err := NewJWTAuthenticator(keys, userRepoMock, jwtConfig)
+	require.NoError(t, err)
+
+	user, scopes, err := authenticator.Authenticate(context.Background(), signToken(t, "RS256", "rsa-1", rsaKey, validClaims(nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.ID)
+	assert.Equal(t, domain.UserRoleMember, user.Role)
+	assert.Equal(t, []string{domain.ScopeLinksRead, domain.ScopeLinksWrite, domain.ScopeStatsRead}, scopes)
+
+	// the roles claim maps to the admin role, a scope claim narrows the grant
+	user, scopes, err = authenticator.Authenticate(context.Background(), signToken(t, "ES256", "ec-1", ecKey,
+		validClaims(map[string]interface{}{"roles": []string{"staff", "admin"}})))
+	assert.NoError(t, err)
+	assert.True(t, user.IsAdmin())
+	assert.Equal(t, []string{domain.ScopeAdmin}, scopes)
+
+	user, scopes, err = authenticator.Authenticate(context.Background(), signToken(t, "ES256", "ec-1", ecKey,
+		validClaims(map[string]interface{}{"roles": "admin", "scope": "links:read openid"})))
+	assert.NoError(t, err)
+	assert.False(t, user.IsAdmin())
+	assert.Equal(t, []string{domain.ScopeLinksRead}, scopes)
+
+	// a member can't grant itself the admin scope
+	user, scopes, err = authenticator.Authenticate(context.Background(), signToken(t, "RS256", "rsa-1", rsaKey,
+		validClaims(map[string]interface{}{"scope": "admin links:write"})))
+	assert.NoError(t, err)
+	assert.False(t, user.IsAdmin())
+	assert.Equal(t, []string{domain.ScopeLinksWrite}, scopes)
+	assert.Equal(t, 1, server.requests)
+}
+
+func TestNewJWTAuthenticator(t *testing.T) {
+	// tokens the provider issues to other applications must not be accepted
+	for _, cfg := range []config.Config{{JWTIssuer: "https://sso.example.com"}, {JWTAudience: "urlshortener"}} {
+		_, err := NewJWTAuthenticator(&KeySet{}, new(mocks.UserRepository), cfg)
+		assert.Error(t, err)
+	}
+}
+
+func TestJWTAuthenticateInvalid(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newJWKSServer(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey))
+	defer server.Close()
+	keys, err := NewKeySet(context.Background(), server.URL, time.Hour)
+	require.NoError(t, err)
+	authenticator, err := NewJWTAuthenticator(keys, new(mocks.UserRepository), jwtConfig)
+	require.NoError(t, err)
+
+	valid := signToken(t, "RS256", "rsa-1", rsaKey, validClaims(nil))
+	parts := strings.Split(valid, ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa-1"}`))
+
+	for name, token := range map[string]string{
+		"malformed":        "not.a-token",
+		"unsigned":         noneHeader + "." + parts[1] + ".",
+		"wrong key":        signToken(t, "RS256", "rsa-1", otherKey, validClaims(nil)),
+		"unknown kid":      signToken(t, "RS256", "rsa-2", rsaKey, validClaims(nil)),
+		"alg of another":   signToken(t, "ES256", "rsa-1", ecKey, validClaims(nil)),
+		"tampered claims":  parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"email":"root@example.com"}`)) + "." + parts[2],
+		"expired":          signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"without exp":      signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"exp": nil})),
+		"not yet valid":    signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"other issuer":     signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"other audience":   signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"aud": "wiki"})),
+		"without issuer":   signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"iss": nil})),
+		"without audience": signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"aud": nil})),
+		"without email":    signToken(t, "RS256", "rsa-1", rsaKey, validClaims(map[string]interface{}{"email": ""})),
+	} {
+		_, _, err := authenticator.Authenticate(context.Background(), token)
+		assert.ErrorIs(t, err, domain.ErrInvalidCredentials, name)
+	}
+}
+
+func TestJWTAuthenticateCreatesUser(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{ecJWK("ec-1", ecKey)}})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	keys, err := NewKeySet(context.Background(), path, time.Hour)
+	require.NoError(t, err)
+
+	userRepoMock := new(mocks.UserRepository)
+	userRepoMock.On("FindByIdentity", mock.Anything, "https://sso.example.com", "00u1").Return(domain.User{}, sql.ErrNoRows)
+	userRepoMock.On("FindByEmail", mock.Anything, "jane@example.com").Return(domain.User{}, sql.ErrNoRows)
+	userRepoMock.On("Create", mock.Anything, mock.MatchedBy(func(user domain.User) bool {
+		return user.Email == "jane@example.com" && user.Name == "Jane" && user.Role == domain.UserRoleMember
+	})).Return(9, nil)
+	userRepoMock.On("LinkIdentity", mock.Anything, 9, "https://sso.example.com", "00u1", mock.AnythingOfType("int64")).Return(nil)
+	authenticator, err := NewJWTAuthenticator(keys, userRepoMock, jwtConfig)
+	require.NoError(t, err)
+
+	user, _, err := authenticator.Authenticate(context.Background(), signToken(t, "ES256", "ec-1", ecKey, validClaims(nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, 9, user.ID)
+	userRepoMock.AssertExpectations(t)
+}
+
+func TestJWTAuthenticateLinksUser(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newJWKSServer(ecJWK("ec-1", ecKey))
+	defer server.Close()
+	keys, err := NewKeySet(context.Background(), server.URL, time.Hour)
+	require.NoError(t, err)
+
+	userRepoMock := new(mocks.UserRepository)
+	userRepoMock.On("FindByIdentity", mock.Anything, "https://sso.example.com", mock.Anything).Return(domain.User{}, sql.ErrNoRows)
+	userRepoMock.On("FindByEmail", mock.Anything, "jane@example.com").
+		Return(domain.User{ID: 7, Email: "jane@example.com", Role: domain.UserRoleMember}, nil)
+	userRepoMock.On("LinkIdentity", mock.Anything, 7, "https://sso.example.com", "00u1", mock.AnythingOfType("int64")).Return(nil)
+	userRepoMock.On("LinkIdentity", mock.Anything, 7, "https://sso.example.com", "00u2", mock.AnythingOfType("int64")).
+		Return(domain.ErrIdentityTaken)
+	authenticator, err := NewJWTAuthenticator(keys, userRepoMock, jwtConfig)
+	require.NoError(t, err)
+
+	// the first sign in of an account is linked to the user holding its verified email
+	user, _, err := authenticator.Authenticate(context.Background(), signToken(t, "ES256", "ec-1", ecKey, validClaims(nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.ID)
+
+	// an unverified email, or another account of the issuer claiming it, doesn't get to the user
+	_, _, err = authenticator.Authenticate(context.Background(), signToken(t, "ES256", "ec-1", ecKey,
+		validClaims(map[string]interface{}{"sub": "00u2", "email_verified": false})))
+	assert.ErrorIs(t, err, domain.ErrInvalidCredentials)
+	_, _, err = authenticator.Authenticate(context.Background(), signToken(t, "ES256", "ec-1", ecKey,
+		validClaims(map[string]interface{}{"sub": "00u2"})))
+	assert.ErrorIs(t, err, domain.ErrInvalidCredentials)
+	userRepoMock.AssertNumberOfCalls(t, "LinkIdentity", 2)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newJWKSServer(ecJWK("old", oldKey))
+	defer server.Close()
+	keys, err := NewKeySet(context.Background(), server.URL, time.Hour)
+	require.NoError(t, err)
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+	reloaded := func(requests int) bool {
+		keys.mu.Lock()
+		loading := keys.loading
+		keys.mu.Unlock()
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return loading == nil && server.requests == requests
+	}
+
+	_, err = keys.Key(context.Background(), "old")
+	assert.NoError(t, err)
+
+	// a key rotated in isn't looked up again right after a load
+	server.rotate(ecJWK("old", oldKey), ecJWK("new", newKey))
+	_, err = keys.Key(context.Background(), "new")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, server.requests)
+
+	now = now.Add(minKeySetReload)
+	_, err = keys.Key(context.Background(), "new")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.requests)
+
+	// keys removed at the source are used until the set is due for a reload
+	server.rotate(ecJWK("new", newKey))
+	now = now.Add(time.Minute)
+	_, err = keys.Key(context.Background(), "old")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, server.requests)
+
+	// a due reload runs in the background, the request naming a cached key doesn't wait for it
+	now = now.Add(time.Hour)
+	_, err = keys.Key(context.Background(), "old")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return reloaded(3) }, time.Second, time.Millisecond)
+	_, err = keys.Key(context.Background(), "old")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// the cached keys are used while the source is down
+	server.Close()
+	now = now.Add(2 * time.Hour)
+	_, err = keys.Key(context.Background(), "new")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return reloaded(3) }, time.Second, time.Millisecond)
+	_, err = keys.Key(context.Background(), "new")
+	assert.NoError(t, err)
+}
+
+func TestKeySetSlowSource(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		if requests > 1 {
+			<-release
+		}
+		json.NewEncoder(res).Encode(map[string]interface{}{"keys": []map[string]string{ecJWK("old", oldKey), ecJWK("new", newKey)}})
+	}))
+	defer server.Close()
+	keys, err := NewKeySet(context.Background(), server.URL, time.Hour)
+	require.NoError(t, err)
+	keys.mu.Lock()
+	delete(keys.keys, "new")
+	keys.mu.Unlock()
+	now := time.Now().Add(minKeySetReload)
+	keys.now = func() time.Time { return now }
+
+	// misses of the rotated in key wait for one reload of the source
+	found := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := keys.Key(context.Background(), "new")
+			found <- err
+		}()
+	}
+
+	// cached keys keep being served while it's slow
+	assert.Eventually(t, func() bool {
+		keys.mu.Lock()
+		defer keys.mu.Unlock()
+		return keys.loading != nil
+	}, time.Second, time.Millisecond)
+	_, err = keys.Key(context.Background(), "old")
+	assert.NoError(t, err)
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, <-found)
+	}
+	assert.Equal(t, 2, requests)
+}
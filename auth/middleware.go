@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+// Bearer token authentication of api routes, checking the scope each route requires
+// Routes that weren't given a scope are public
+type Middleware struct {
+	authenticator domain.Authenticator
+	scopes        map[*mux.Route]string // written while the routes are registered, read only afterwards
+}
+
+func NewMiddleware(authenticator domain.Authenticator) *Middleware {
+	return &Middleware{authenticator: authenticator, scopes: map[*mux.Route]string{}}
+}
+
+// Require a bearer token granting scope on route, an empty scope only requires a valid token
+// Returning the route so it can be registered in one statement
+
+func (m *Middleware) Require(route *mux.Route, scope string) *mux.Route {
+	m.scopes[route] = scope
+	return route
+}
+
+// Authenticate the requests of the routes given a scope, attaching the user and its scopes to the request context
+// To be added with Use on the router the routes belong to
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		scope, ok := m.scopes[mux.CurrentRoute(req)]
+		if !ok {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		token := BearerToken(req)
+		if token == "" {
+			unauthorized(res, "missing bearer token")
+			return
+		}
+
+		user, scopes, err := m.authenticator.Authenticate(req.Context(), token)
+		if errors.Is(err, domain.ErrInvalidCredentials) {
+			unauthorized(res, err.Error())
+			return
+		}
+		if err != nil {
+			res.Header().Set("Content-Type", "application/json")
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadGateway,
+				Status: "Bad gateway",
+				Errors: []string{err.Error()},
+			})
+			return
+		}
+
+		if scope != "" && !domain.HasScope(scopes, scope) {
+			res.Header().Set("Content-Type", "application/json")
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusForbidden,
+				Status: "Forbidden",
+				Errors: []string{fmt.Sprintf("the %s scope is required", scope)},
+			})
+			return
+		}
+
+		ctx := domain.ContextWithScopes(domain.ContextWithUser(req.Context(), user), scopes)
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
+// Token of an Authorization: Bearer header, empty when there is none
+
+func BearerToken(req *http.Request) string {
+	scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func unauthorized(res http.ResponseWriter, message string) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	utils.FormatResponse(res, &utils.ResponseErrorParams{
+		Code:   http.StatusUnauthorized,
+		Status: "Unauthorized",
+		Errors: []string{message},
+	})
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMiddleware(t *testing.T) {
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "reader").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksRead}, nil)
+	authenticator.On("Authenticate", mock.Anything, "revoked").
+		Return(domain.User{}, []string(nil), domain.ErrInvalidCredentials)
+	authenticator.On("Authenticate", mock.Anything, "unreachable").
+		Return(domain.User{}, []string(nil), errors.New("connection refused"))
+
+	// echo the user the request was authenticated as
+	echo := func(res http.ResponseWriter, req *http.Request) {
+		user, ok := domain.UserFromContext(req.Context())
+		if !ok {
+			res.Write([]byte("anonymous"))
+			return
+		}
+		res.Write([]byte(user.Email + domain.ScopesFromContext(req.Context())[0]))
+	}
+
+	middleware := NewMiddleware(authenticator)
+	router := mux.NewRouter()
+	router.Path("/public").HandlerFunc(echo)
+	middleware.Require(router.Path("/read").HandlerFunc(echo), domain.ScopeLinksRead)
+	middleware.Require(router.Path("/write").HandlerFunc(echo), domain.ScopeLinksWrite)
+	middleware.Require(router.Path("/any").HandlerFunc(echo), "")
+	router.Use(middleware.Handler)
+
+	testCases := []struct {
+		path          string
+		authorization string
+		code          int
+		body          string
+	}{
+		{"/public", "", http.StatusOK, "anonymous"},
+		{"/read", "", http.StatusUnauthorized, ""},
+		{"/read", "Basic cmVhZGVyOg==", http.StatusUnauthorized, ""},
+		{"/read", "Bearer reader", http.StatusOK, "links:read"},
+		{"/read", "bearer  reader", http.StatusOK, "links:read"},
+		{"/any", "Bearer reader", http.StatusOK, "links:read"},
+		{"/write", "Bearer reader", http.StatusForbidden, ""},
+		{"/read", "Bearer revoked", http.StatusUnauthorized, ""},
+		{"/read", "Bearer unreachable", http.StatusBadGateway, ""},
+	}
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("GET", testCase.path, nil)
+		if testCase.authorization != "" {
+			req.Header.Set("Authorization", testCase.authorization)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, testCase.code, res.Code, testCase)
+		if testCase.body != "" {
+			assert.Equal(t, testCase.body, res.Body.String(), testCase)
+		}
+		if testCase.code == http.StatusUnauthorized {
+			assert.Equal(t, `Bearer realm="api"`, res.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
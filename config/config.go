@@ -0,0 +1,251 @@
+package config
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type Config struct {
+	Port                    int
+	DatabaseDSN             string
+	DefaultRedirectType     int
+	NotActiveFallbackUrl    string
+	FallbackUrl             string
+	GeoIPDatabase           string            // path of a MaxMind format .mmdb file, geo targeting is off when empty
+	TrustedProxies          []*net.IPNet      // proxies whose X-Forwarded-For header is trusted
+	ClickQueueSize          int               // click events buffered in memory before they are written
+	ClickBatchSize          int               // click events written per multi-row insert
+	ClickFlushInterval      time.Duration     // max time a click event waits in the buffer
+	ClickBlockTimeout       time.Duration     // time a redirect waits for room in a full buffer before the event is dropped
+	ClickRollupInterval     time.Duration     // how often the hourly click rollups are refreshed
+	PreviewFetchTimeout     time.Duration     // time given to a destination page for its preview tags, 0 turns fetching off
+	PreviewSigningKey       string            // key signing the continue links of forced interstitial pages, random per process when empty
+	BaseUrl                 string            // address short url slugs are appended to, the request host when empty
+	DomainBaseUrls          map[string]string // base url of each custom domain, by host name
+	AuthMode                string            // how bearer tokens are authenticated, AuthModeApiKey or AuthModeJWT
+	JWKSSource              string            // path or http(s) url of the JSON Web Key Set JWTs are signed with
+	JWKSRefreshInterval     time.Duration     // how often the key set is reloaded, an unknown key id reloads it sooner
+	JWTIssuer               string            // expected iss claim, required with jwt authentication
+	JWTAudience             string            // expected aud claim, required with jwt authentication
+	JWTRolesClaim           string            // claim holding the roles of the user
+	JWTAdminRole            string            // role granting admin access
+	UserQuota               domain.Quota      // default limits of the links a user holds outside of workspaces
+	WorkspaceQuota          domain.Quota      // default limits of the links of a workspace
+	RateLimitStore          string            // redis:// url of the store shared by the instances, in memory when empty
+	RateLimitKey            string            // what authenticated requests are counted by, one of apikey, user or ip
+	RateLimitAuth           int               // requests carrying credentials per period by each client ip, 0 for no limit
+	RateLimitAuthPeriod     time.Duration
+	RateLimitCreate         int // links created per period by each client, 0 for no limit
+	RateLimitCreatePeriod   time.Duration
+	RateLimitRedirect       int // redirects per period by each client ip, 0 for no limit
+	RateLimitRedirectPeriod time.Duration
+	IdempotencyKeyTTL       time.Duration // time the response of a request made with an Idempotency-Key is replayed
+}
+
+const (
+	AuthModeApiKey = "apikey"
+	AuthModeJWT    = "jwt"
+)
+
+const (
+	RateLimitKeyApiKey = "apikey"
+	RateLimitKeyUser   = "user"
+	RateLimitKeyIP     = "ip"
+)
+
+// Load application config from environment variables
+// Falling back to the local development defaults when a variable is unset or invalid
+
+func Load() Config {
+	return Config{
+		Port:                 getEnvInt("PORT", 8080),
+		DatabaseDSN:          getEnv("DATABASE_DSN", "root:secret@tcp(localhost:2252)/url_short"),
+		DefaultRedirectType:  getEnvRedirectType("DEFAULT_REDIRECT_TYPE"),
+		NotActiveFallbackUrl: getEnv("NOT_ACTIVE_FALLBACK_URL", ""),
+		FallbackUrl:          getEnv("FALLBACK_URL", ""),
+		GeoIPDatabase:        getEnv("GEOIP_DATABASE", ""),
+		TrustedProxies:       getEnvCIDRs("TRUSTED_PROXIES"),
+		ClickQueueSize:       getEnvInt("CLICK_QUEUE_SIZE", 10000),
+		ClickBatchSize:       getEnvInt("CLICK_BATCH_SIZE", 500),
+		ClickFlushInterval:   getEnvDuration("CLICK_FLUSH_INTERVAL", time.Second),
+		ClickBlockTimeout:    getEnvDuration("CLICK_BLOCK_TIMEOUT", 0),
+		ClickRollupInterval:  getEnvDuration("CLICK_ROLLUP_INTERVAL", 5*time.Minute),
+		PreviewFetchTimeout:  getEnvDuration("PREVIEW_FETCH_TIMEOUT", 3*time.Second),
+		PreviewSigningKey:    getEnv("PREVIEW_SIGNING_KEY", ""),
+		BaseUrl:              getEnvBaseUrl("BASE_URL"),
+		DomainBaseUrls:       getEnvDomainBaseUrls("CUSTOM_DOMAINS"),
+		AuthMode:             getEnvAuthMode("AUTH_MODE"),
+		JWKSSource:           getEnv("JWKS_SOURCE", ""),
+		JWKSRefreshInterval:  getEnvDuration("JWKS_REFRESH_INTERVAL", time.Hour),
+		JWTIssuer:            getEnv("JWT_ISSUER", ""),
+		JWTAudience:          getEnv("JWT_AUDIENCE", ""),
+		JWTRolesClaim:        getEnv("JWT_ROLES_CLAIM", "roles"),
+		JWTAdminRole:         getEnv("JWT_ADMIN_ROLE", "admin"),
+		UserQuota: domain.Quota{
+			MonthlyCreates: getEnvInt("QUOTA_USER_MONTHLY_CREATES", 0),
+			ActiveLinks:    getEnvInt("QUOTA_USER_ACTIVE_LINKS", 0),
+		},
+		WorkspaceQuota: domain.Quota{
+			MonthlyCreates: getEnvInt("QUOTA_WORKSPACE_MONTHLY_CREATES", 0),
+			ActiveLinks:    getEnvInt("QUOTA_WORKSPACE_ACTIVE_LINKS", 0),
+		},
+		RateLimitStore:          getEnv("RATE_LIMIT_STORE", ""),
+		RateLimitKey:            getEnvRateLimitKey("RATE_LIMIT_KEY"),
+		RateLimitAuth:           getEnvInt("RATE_LIMIT_AUTH", 1200),
+		RateLimitAuthPeriod:     getEnvDuration("RATE_LIMIT_AUTH_PERIOD", time.Minute),
+		RateLimitCreate:         getEnvInt("RATE_LIMIT_CREATE", 60),
+		RateLimitCreatePeriod:   getEnvDuration("RATE_LIMIT_CREATE_PERIOD", time.Minute),
+		RateLimitRedirect:       getEnvInt("RATE_LIMIT_REDIRECT", 600),
+		RateLimitRedirectPeriod: getEnvDuration("RATE_LIMIT_REDIRECT_PERIOD", time.Minute),
+		IdempotencyKeyTTL:       getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	return value
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Parse a comma separated list of CIDRs, a bare ip address is taken as a single host
+// Invalid entries are logged and left out, so a typo never widens the trusted range
+
+func getEnvCIDRs(key string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				log.Printf("ignoring invalid %s entry %q", key, entry)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("ignoring invalid %s entry %q", key, entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// A status that isn't a redirect is logged and falls back to 302, as links created without a redirect type use it
+
+func getEnvRedirectType(key string) int {
+	value := getEnvInt(key, http.StatusFound)
+	switch value {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return value
+	}
+	log.Printf("ignoring invalid %s %d", key, value)
+	return http.StatusFound
+}
+
+// An unknown mode is logged and falls back to api keys
+
+func getEnvAuthMode(key string) string {
+	value := strings.ToLower(getEnv(key, AuthModeApiKey))
+	if value != AuthModeApiKey && value != AuthModeJWT {
+		log.Printf("ignoring invalid %s %q", key, value)
+		return AuthModeApiKey
+	}
+	return value
+}
+
+// An unknown key is logged and falls back to counting by user
+
+func getEnvRateLimitKey(key string) string {
+	value := strings.ToLower(getEnv(key, RateLimitKeyUser))
+	if value != RateLimitKeyApiKey && value != RateLimitKeyUser && value != RateLimitKeyIP {
+		log.Printf("ignoring invalid %s %q", key, value)
+		return RateLimitKeyUser
+	}
+	return value
+}
+
+// Parse an absolute http(s) base url, without its trailing slash
+// An invalid url is logged and left empty, so links fall back to the request host
+
+func getEnvBaseUrl(key string) string {
+	value := getEnv(key, "")
+	if value == "" {
+		return ""
+	}
+
+	baseUrl, ok := parseBaseUrl(value)
+	if !ok {
+		log.Printf("ignoring invalid %s %q", key, value)
+	}
+	return baseUrl
+}
+
+// Parse a comma separated list of host=base_url pairs, e.g. go.acme.com=https://go.acme.com
+// Invalid entries are logged and left out
+
+func getEnvDomainBaseUrls(key string) map[string]string {
+	domains := map[string]string{}
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		host, value, found := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		baseUrl, ok := parseBaseUrl(strings.TrimSpace(value))
+		if !found || host == "" || !ok {
+			log.Printf("ignoring invalid %s entry %q", key, entry)
+			continue
+		}
+		domains[host] = baseUrl
+	}
+	return domains
+}
+
+func parseBaseUrl(value string) (string, bool) {
+	baseUrl, err := url.Parse(value)
+	if err != nil || (baseUrl.Scheme != "http" && baseUrl.Scheme != "https") || baseUrl.Host == "" ||
+		baseUrl.RawQuery != "" || baseUrl.Fragment != "" {
+		return "", false
+	}
+	return strings.TrimSuffix(baseUrl.String(), "/"), true
+}
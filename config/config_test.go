@@ -0,0 +1,114 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDefault(t *testing.T) {
+	t.Setenv("PORT", "")
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "")
+	t.Setenv("CLICK_FLUSH_INTERVAL", "")
+	t.Setenv("PREVIEW_FETCH_TIMEOUT", "")
+
+	cfg := Load()
+	assert.Equal(t, 8080, cfg.Port)
+	assert.Equal(t, http.StatusFound, cfg.DefaultRedirectType)
+	assert.Equal(t, time.Second, cfg.ClickFlushInterval)
+	assert.Equal(t, 3*time.Second, cfg.PreviewFetchTimeout)
+	assert.Equal(t, 24*time.Hour, cfg.IdempotencyKeyTTL)
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	t.Setenv("PORT", "9090")
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "301")
+	t.Setenv("CLICK_FLUSH_INTERVAL", "250ms")
+	t.Setenv("PREVIEW_FETCH_TIMEOUT", "0")
+
+	cfg := Load()
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, http.StatusMovedPermanently, cfg.DefaultRedirectType)
+	assert.Equal(t, 250*time.Millisecond, cfg.ClickFlushInterval)
+	assert.Zero(t, cfg.PreviewFetchTimeout)
+}
+
+func TestLoadInvalidInt(t *testing.T) {
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "temporary")
+
+	cfg := Load()
+	assert.Equal(t, http.StatusFound, cfg.DefaultRedirectType)
+
+	// statuses that aren't redirects would fail every create without a redirect type
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "404")
+	assert.Equal(t, http.StatusFound, Load().DefaultRedirectType)
+	t.Setenv("DEFAULT_REDIRECT_TYPE", "307")
+	assert.Equal(t, http.StatusTemporaryRedirect, Load().DefaultRedirectType)
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1,not-an-ip, fd00::/8")
+
+	cfg := Load()
+	assert.Len(t, cfg.TrustedProxies, 3)
+	assert.Equal(t, "10.0.0.0/8", cfg.TrustedProxies[0].String())
+	assert.Equal(t, "192.168.1.1/32", cfg.TrustedProxies[1].String())
+	assert.Equal(t, "fd00::/8", cfg.TrustedProxies[2].String())
+}
+
+func TestLoadBaseUrls(t *testing.T) {
+	t.Setenv("BASE_URL", "https://sho.rt/")
+	t.Setenv("CUSTOM_DOMAINS", "Go.Acme.com=https://go.acme.com, links.foo.io=https://links.foo.io/s/,broken,bad=ftp://bad")
+
+	cfg := Load()
+	assert.Equal(t, "https://sho.rt", cfg.BaseUrl)
+	assert.Equal(t, map[string]string{
+		"go.acme.com":  "https://go.acme.com",
+		"links.foo.io": "https://links.foo.io/s",
+	}, cfg.DomainBaseUrls)
+
+	t.Setenv("BASE_URL", "sho.rt")
+	assert.Empty(t, Load().BaseUrl)
+}
+
+func TestLoadAuthMode(t *testing.T) {
+	t.Setenv("AUTH_MODE", "")
+	assert.Equal(t, AuthModeApiKey, Load().AuthMode)
+
+	t.Setenv("AUTH_MODE", "JWT")
+	assert.Equal(t, AuthModeJWT, Load().AuthMode)
+
+	t.Setenv("AUTH_MODE", "basic")
+	assert.Equal(t, AuthModeApiKey, Load().AuthMode)
+}
+
+func TestLoadQuotas(t *testing.T) {
+	t.Setenv("QUOTA_USER_MONTHLY_CREATES", "100")
+	t.Setenv("QUOTA_WORKSPACE_ACTIVE_LINKS", "5000")
+
+	cfg := Load()
+	assert.Equal(t, domain.Quota{MonthlyCreates: 100}, cfg.UserQuota)
+	assert.Equal(t, domain.Quota{ActiveLinks: 5000}, cfg.WorkspaceQuota)
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	t.Setenv("RATE_LIMIT_KEY", "ApiKey")
+	t.Setenv("RATE_LIMIT_CREATE", "0")
+	t.Setenv("RATE_LIMIT_REDIRECT_PERIOD", "1s")
+	t.Setenv("RATE_LIMIT_AUTH", "")
+
+	cfg := Load()
+	assert.Equal(t, 1200, cfg.RateLimitAuth)
+	assert.Equal(t, time.Minute, cfg.RateLimitAuthPeriod)
+	assert.Equal(t, RateLimitKeyApiKey, cfg.RateLimitKey)
+	assert.Equal(t, 0, cfg.RateLimitCreate)
+	assert.Equal(t, time.Minute, cfg.RateLimitCreatePeriod)
+	assert.Equal(t, 600, cfg.RateLimitRedirect)
+	assert.Equal(t, time.Second, cfg.RateLimitRedirectPeriod)
+
+	t.Setenv("RATE_LIMIT_KEY", "token")
+	assert.Equal(t, RateLimitKeyUser, Load().RateLimitKey)
+}
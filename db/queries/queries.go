@@ -1,16 +1,291 @@
 package queries
 
+// Selected columns of urls table, in the order scanned by the repository
+const urlColumns string = `id, url, short_url, click_count, created_at, redirect_type, query_policy, ` +
+	`utm_source, utm_medium, utm_campaign, password_hash, single_use, consumed_at, ` +
+	`active_from, expires_at, fallback_url, bot_click_count, title, description, image, force_preview, ` +
+	`COALESCE(owner_id, 0), COALESCE(workspace_id, 0)`
+
 // INSERT NEW URL
-const InsertURL string = `INSERT INTO urls (url, short_url) VALUES (?,?)`
+const InsertURL string = `INSERT INTO urls (url, short_url, redirect_type, query_policy, utm_source, utm_medium, utm_campaign, ` +
+	`password_hash, single_use, active_from, expires_at, fallback_url, title, description, image, ` +
+	`force_preview, owner_id, workspace_id, created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,NULLIF(?, 0),NULLIF(?, 0),?)`
 
 // Find URL by Short URL
-const FindByShort string = `SELECT id, url, short_url, click_count, created_at FROM urls WHERE short_url = ?`
+const FindByShort string = `SELECT ` + urlColumns + ` FROM urls WHERE short_url = ?`
 
 // Find URL by URL ID
-const FindByID string = `SELECT id, url, short_url, click_count, created_at FROM urls WHERE id = ?`
+const FindByID string = `SELECT ` + urlColumns + ` FROM urls WHERE id = ?`
+
+// Restrict a query by URL ID to the urls of one user: its personal urls and the urls of the workspaces it's a member of
+const andUserUrl string = ` AND ((owner_id = ? AND workspace_id IS NULL) OR ` +
+	`workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))`
+
+// Find URL by URL ID among the urls of one user
+const FindByIDAndUser string = FindByID + andUserUrl
 
 // Find All Url
-const FindAll string = `SELECT id, url, short_url, click_count, created_at FROM urls`
+const FindAll string = `SELECT ` + urlColumns + ` FROM urls`
+
+// Find All Url of one owner, outside of any workspace
+const FindAllByOwner string = FindAll + ` WHERE owner_id = ? AND workspace_id IS NULL`
+
+// Restrict one of the Find All Url status queries to the urls of one owner, outside of any workspace
+const AndOwner string = ` AND owner_id = ? AND workspace_id IS NULL`
+
+// Find All Url of one workspace
+const FindAllByWorkspace string = FindAll + ` WHERE workspace_id = ?`
+
+// Restrict one of the Find All Url status queries to the urls of one workspace
+const AndWorkspace string = ` AND workspace_id = ?`
+
+// Find All Url not active yet at the given time
+const FindAllScheduled string = FindAll + ` WHERE active_from > ?`
+
+// Find All Url active at the given time
+const FindAllActive string = FindAll + ` WHERE active_from <= ? AND (expires_at = 0 OR expires_at > ?)`
+
+// Find All Url expired at the given time
+const FindAllExpired string = FindAll + ` WHERE expires_at <> 0 AND expires_at <= ?`
+
+// Mark single use URL as consumed, only the first concurrent request affects the row
+const MarkConsumed = `UPDATE urls SET consumed_at = ? WHERE id = ? AND single_use = 1 AND consumed_at = 0`
 
 // Delete URL by ID
 const DeleteByID = `DELETE FROM urls WHERE id = ?`
+
+// Delete URL by ID among the urls one user may edit: its personal urls and the urls of the workspaces it's at least an editor of
+const DeleteByIDAndUser = DeleteByID + ` AND ((owner_id = ? AND workspace_id IS NULL) OR ` +
+	`workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role IN ('editor', 'owner')))`
+
+// INSERT NEW URL TARGET
+const InsertTarget string = `INSERT INTO url_targets (url_id, url, weight) VALUES (?,?,?)`
+
+// Find URL Targets by URL IDs, followed by one placeholder per id
+const FindTargetsByUrlIDs string = `SELECT id, url_id, url, weight, click_count FROM url_targets WHERE url_id IN `
+
+// Delete URL Targets by URL ID
+const DeleteTargetsByUrlID string = `DELETE FROM url_targets WHERE url_id = ?`
+
+// INSERT NEW URL RULE
+const InsertRule string = `INSERT INTO url_rules (url_id, position, os, device, language, country, url) VALUES (?,?,?,?,?,?,?)`
+
+// Find URL Rules by URL IDs, followed by one placeholder per id
+const FindRulesByUrlIDs string = `SELECT id, url_id, position, os, device, language, country, url FROM url_rules WHERE url_id IN `
+
+// Delete URL Rules by URL ID
+const DeleteRulesByUrlID string = `DELETE FROM url_rules WHERE url_id = ?`
+
+// Increase click count and bot click count of URL by the given amounts
+const IncrementClickCount string = `UPDATE urls SET click_count = click_count + ?, bot_click_count = bot_click_count + ? WHERE id = ?`
+
+// Increase click count of URL Target by the given amount
+const IncrementTargetClickCount string = `UPDATE url_targets SET click_count = click_count + ? WHERE id = ? AND url_id = ?`
+
+// INSERT NEW CLICK EVENTS, followed by one ClickEventValues group per event
+const InsertClickEvents string = `INSERT INTO click_events (url_id, target_id, clicked_at, referrer, os, device, browser, country, bot) VALUES `
+
+const ClickEventValues string = `(?,?,?,?,?,?,?,?,?)`
+
+// Recompute the hourly click rollups of the click events in a time range, from must be the start of an hour
+const RollupClicks string = `INSERT INTO click_rollups (url_id, hour_start, referrer, country, browser, os, device, bot, clicks) ` +
+	`SELECT url_id, clicked_at DIV 3600 * 3600 AS hour_start, referrer, country, browser, os, device, bot, COUNT(*) FROM click_events ` +
+	`WHERE clicked_at >= ? AND clicked_at < ? GROUP BY url_id, hour_start, referrer, country, browser, os, device, bot ` +
+	`ON DUPLICATE KEY UPDATE clicks = VALUES(clicks)`
+
+// Find the start of the oldest hour whose click rollups may be missing, the hour of the first click event when none were rolled up
+const FindRollupWatermark string = `SELECT COALESCE((SELECT hour_start FROM click_rollup_watermark WHERE id = 1), ` +
+	`(SELECT MIN(clicked_at) DIV 3600 * 3600 FROM click_events), 0)`
+
+// Move the click rollup watermark forward, never back when instances refresh the rollups concurrently
+const SetRollupWatermark string = `INSERT INTO click_rollup_watermark (id, hour_start) VALUES (1, ?) ` +
+	`ON DUPLICATE KEY UPDATE hour_start = GREATEST(hour_start, VALUES(hour_start))`
+
+// Lock the visitor sketch of URL for one day
+const FindVisitorSketchForUpdate string = `SELECT sketch FROM url_visitors WHERE url_id = ? AND day = ? FOR UPDATE`
+
+// Insert or replace the visitor sketch of URL for one day
+const UpsertVisitorSketch string = `INSERT INTO url_visitors (url_id, day, sketch) VALUES (?,?,?) ON DUPLICATE KEY UPDATE sketch = VALUES(sketch)`
+
+// Find the visitor sketches of URL in a time range
+const FindVisitorSketches string = `SELECT day, sketch FROM url_visitors WHERE url_id = ? AND day >= ? AND day < ? ORDER BY day`
+
+// Count clicks of URL per time bucket, args: bucket offset, bucket size, bucket size, bucket offset, url_id, from, to, max bot
+// A max bot of 0 counts human clicks only, 1 counts bot clicks as well
+const CountClicksByTime string = `SELECT (hour_start - ?) DIV ? * ? + ? AS bucket, SUM(clicks) FROM click_rollups ` +
+	`WHERE url_id = ? AND hour_start >= ? AND hour_start < ? AND bot <= ? GROUP BY bucket ORDER BY bucket`
+
+// Count bot clicks of URL in a time range
+const CountBotClicks string = `SELECT COALESCE(SUM(clicks), 0) FROM click_rollups WHERE url_id = ? AND hour_start >= ? AND hour_start < ? AND bot = 1`
+
+const clicksInRange string = ` FROM click_rollups WHERE url_id = ? AND hour_start >= ? AND hour_start < ? AND bot <= ?`
+
+// Count clicks of URL per referrer domain, top rows first
+const CountClicksByReferrer string = `SELECT referrer, SUM(clicks) AS total` + clicksInRange + ` GROUP BY referrer ORDER BY total DESC, referrer LIMIT ?`
+
+// Count clicks of URL per country, top rows first
+const CountClicksByCountry string = `SELECT country, SUM(clicks) AS total` + clicksInRange + ` GROUP BY country ORDER BY total DESC, country LIMIT ?`
+
+// Count clicks of URL per browser, top rows first
+const CountClicksByBrowser string = `SELECT browser, SUM(clicks) AS total` + clicksInRange + ` GROUP BY browser ORDER BY total DESC, browser LIMIT ?`
+
+// Count clicks of URL per os, top rows first
+const CountClicksByOs string = `SELECT os, SUM(clicks) AS total` + clicksInRange + ` GROUP BY os ORDER BY total DESC, os LIMIT ?`
+
+// Count clicks of URL per device, top rows first
+const CountClicksByDevice string = `SELECT device, SUM(clicks) AS total` + clicksInRange + ` GROUP BY device ORDER BY total DESC, device LIMIT ?`
+
+// INSERT NEW USER
+const InsertUser string = `INSERT INTO users (name, email, role, created_at) VALUES (?,?,?,?)`
+
+// Find User by User ID
+const FindUserByID string = `SELECT id, name, email, role, created_at FROM users WHERE id = ?`
+
+// Find User by Email
+const FindUserByEmail string = `SELECT id, name, email, role, created_at FROM users WHERE email = ?`
+
+// Find the User an SSO account of an issuer is linked to
+const FindUserByIdentity string = `SELECT u.id, u.name, u.email, u.role, u.created_at FROM users u ` +
+	`JOIN user_identities i ON i.user_id = u.id WHERE i.issuer = ? AND i.subject = ?`
+
+// Link an SSO account to a User
+const InsertUserIdentity string = `INSERT INTO user_identities (issuer, subject, user_id, created_at) VALUES (?,?,?,?)`
+
+// Selected columns of api_keys table, in the order scanned by the repository
+const apiKeyColumns string = `id, user_id, name, prefix, secret_hash, scopes, created_at, last_used_at, revoked_at`
+
+// INSERT NEW API KEY, scopes are separated by spaces
+const InsertApiKey string = `INSERT INTO api_keys (user_id, name, prefix, secret_hash, scopes, created_at) VALUES (?,?,?,?,?,?)`
+
+// Find API Key by its public prefix
+const FindApiKeyByPrefix string = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = ?`
+
+// Find API Keys of one user, revoked ones included
+const FindApiKeysByUserID string = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = ? ORDER BY id`
+
+// Revoke API Key by ID, keys already revoked keep their revocation time
+const RevokeApiKey string = `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at = 0`
+
+// Revoke API Key by ID among the keys of one user
+const RevokeApiKeyOfUser string = RevokeApiKey + ` AND user_id = ?`
+
+// Record the last time API Key authenticated a request
+const UpdateApiKeyLastUsed string = `UPDATE api_keys SET last_used_at = ? WHERE id = ?`
+
+// Selected columns of workspaces table, in the order scanned by the repository
+const workspaceColumns string = `id, name, slug, slug_namespace, created_at`
+
+// INSERT NEW WORKSPACE
+const InsertWorkspace string = `INSERT INTO workspaces (name, slug, slug_namespace, created_at) VALUES (?,?,?,?)`
+
+// Find Workspace by Workspace ID
+const FindWorkspaceByID string = `SELECT ` + workspaceColumns + ` FROM workspaces WHERE id = ?`
+
+// Find Workspaces one user is a member of, along with the role of the user
+const FindWorkspacesByUserID string = `SELECT w.id, w.name, w.slug, w.slug_namespace, w.created_at, m.role FROM workspaces w ` +
+	`JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = ? ORDER BY w.id`
+
+// Delete Workspace by ID, its members go with it and its urls are left without a workspace
+const DeleteWorkspaceByID string = `DELETE FROM workspaces WHERE id = ?`
+
+// Selected columns of workspace_members table joined with users, in the order scanned by the repository
+const workspaceMemberColumns string = `m.workspace_id, m.user_id, u.name, u.email, m.role, m.created_at ` +
+	`FROM workspace_members m JOIN users u ON u.id = m.user_id`
+
+// Find one Member of a Workspace
+const FindWorkspaceMember string = `SELECT ` + workspaceMemberColumns + ` WHERE m.workspace_id = ? AND m.user_id = ?`
+
+// Find Members of a Workspace
+const FindWorkspaceMembers string = `SELECT ` + workspaceMemberColumns + ` WHERE m.workspace_id = ? ORDER BY m.created_at, m.user_id`
+
+// Add a Member to a Workspace, or change the role of an existing one
+const UpsertWorkspaceMember string = `INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?,?,?,?) ` +
+	`ON DUPLICATE KEY UPDATE role = VALUES(role)`
+
+// Find the Owners of a Workspace, locking them until a role change or removal is done
+const FindWorkspaceOwnersForUpdate string = `SELECT user_id FROM workspace_members WHERE workspace_id = ? AND role = ? FOR UPDATE`
+
+// Remove a Member from a Workspace
+const DeleteWorkspaceMember string = `DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`
+
+// Count the links a subject created since a period start and the links it holds, args: period start, subject id
+const usageCounts string = `COUNT(IF(created_at >= ?, 1, NULL)), COUNT(*) FROM urls WHERE `
+
+// Urls counted for a user, its personal ones, and for a workspace
+const userUsageUrls string = `owner_id = ? AND workspace_id IS NULL`
+const workspaceUsageUrls string = `workspace_id = ?`
+
+// Count the usage of a User from its urls
+const CountUserUsage string = `SELECT ` + usageCounts + userUsageUrls
+
+// Count the usage of a Workspace from its urls
+const CountWorkspaceUsage string = `SELECT ` + usageCounts + workspaceUsageUrls
+
+// Create the usage counters of a subject from its urls, or recount them for a new month, locking the row until the end of the transaction
+// args: subject type, subject id, period start, period start, subject id
+// MySQL assigns from left to right, so the counters are compared with the previous period start
+const startUsagePeriod string = `INSERT INTO usage_counters (subject_type, subject_id, period_start, monthly_creates, active_links) ` +
+	`SELECT ?, ?, ?, ` + usageCounts
+const onNewUsagePeriod string = ` ON DUPLICATE KEY UPDATE ` +
+	`monthly_creates = IF(period_start = VALUES(period_start), monthly_creates, VALUES(monthly_creates)), ` +
+	`active_links = IF(period_start = VALUES(period_start), active_links, VALUES(active_links)), ` +
+	`period_start = VALUES(period_start)`
+
+// Create the usage counters of a User, or recount them for a new month
+const StartUserUsagePeriod string = startUsagePeriod + userUsageUrls + onNewUsagePeriod
+
+// Create the usage counters of a Workspace, or recount them for a new month
+const StartWorkspaceUsagePeriod string = startUsagePeriod + workspaceUsageUrls + onNewUsagePeriod
+
+// Usage counters of a subject along with its quota, args: default monthly creates, default active links, subject type, subject id
+const usageWithQuota string = `c.monthly_creates, c.active_links, COALESCE(q.monthly_creates, ?), COALESCE(q.active_links, ?) ` +
+	`FROM usage_counters c LEFT JOIN quotas q ON q.subject_type = c.subject_type AND q.subject_id = c.subject_id ` +
+	`WHERE c.subject_type = ? AND c.subject_id = ?`
+
+// Find the usage counters of a subject along with its quota, locking them until the end of the transaction
+const FindUsageForUpdate string = `SELECT ` + usageWithQuota + ` FOR UPDATE`
+
+// Find the usage counters of a subject along with its quota and the period they count from, without locking them
+const FindUsageWithQuota string = `SELECT c.period_start, ` + usageWithQuota
+
+// Count one more link created and held by a subject
+const IncrementUsage string = `UPDATE usage_counters SET monthly_creates = monthly_creates + 1, active_links = active_links + 1 ` +
+	`WHERE subject_type = ? AND subject_id = ?`
+
+// Count one less link held by a subject, never below 0 as the links of a deleted workspace weren't counted for their owner
+const DecrementActiveLinks string = `UPDATE usage_counters SET active_links = GREATEST(active_links, 1) - 1 ` +
+	`WHERE subject_type = ? AND subject_id = ?`
+
+// Lock URL by ID and find the subject its usage is counted for
+const FindUrlSubjectForUpdate string = `SELECT COALESCE(workspace_id, 0), COALESCE(owner_id, 0) FROM urls WHERE id = ? FOR UPDATE`
+
+// Find the period the usage counters of a subject count from
+const FindUsagePeriod string = `SELECT period_start FROM usage_counters WHERE subject_type = ? AND subject_id = ?`
+
+// Find the usage counters of a subject
+const FindUsage string = `SELECT period_start, monthly_creates, active_links FROM usage_counters WHERE subject_type = ? AND subject_id = ?`
+
+// Find the Quota of a subject
+const FindQuota string = `SELECT monthly_creates, active_links FROM quotas WHERE subject_type = ? AND subject_id = ?`
+
+// Set the Quota of a subject
+const UpsertQuota string = `INSERT INTO quotas (subject_type, subject_id, monthly_creates, active_links) VALUES (?,?,?,?) ` +
+	`ON DUPLICATE KEY UPDATE monthly_creates = VALUES(monthly_creates), active_links = VALUES(active_links)`
+
+// Remove the expired Idempotency Keys of a User
+const DeleteExpiredIdempotencyKeys string = `DELETE FROM idempotency_keys WHERE user_id = ? AND expires_at <= ?`
+
+// Reserve an Idempotency Key for a request in progress
+const InsertIdempotencyKey string = `INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, status_code, response, headers, expires_at) ` +
+	`VALUES (?,?,?,0,'','',?)`
+
+// Find an Idempotency Key of a User
+const FindIdempotencyKey string = `SELECT request_hash, status_code, response, headers, expires_at FROM idempotency_keys ` +
+	`WHERE user_id = ? AND idempotency_key = ?`
+
+// Keep the response of the request of an Idempotency Key
+const CompleteIdempotencyKey string = `UPDATE idempotency_keys SET status_code = ?, response = ?, headers = ?, expires_at = ? ` +
+	`WHERE user_id = ? AND idempotency_key = ?`
+
+// Remove an Idempotency Key whose request is still in progress
+const ReleaseIdempotencyKey string = `DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND status_code = 0`
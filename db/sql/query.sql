@@ -1,8 +1,177 @@
+CREATE TABLE users (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL UNIQUE,
+    role VARCHAR(16) NOT NULL DEFAULT 'member',
+    created_at INT UNSIGNED NOT NULL DEFAULT 0
+);
+
+-- SSO accounts users sign in with, one per issuer and user, the subject is what the issuer keys the account by
+CREATE TABLE user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    created_at INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (issuer, subject),
+    UNIQUE KEY (user_id, issuer),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE api_keys (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    user_id INT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    prefix CHAR(8) NOT NULL UNIQUE,
+    secret_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL DEFAULT '',
+    created_at INT UNSIGNED NOT NULL DEFAULT 0,
+    last_used_at INT UNSIGNED NOT NULL DEFAULT 0,
+    revoked_at INT UNSIGNED NOT NULL DEFAULT 0,
+    INDEX (user_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE workspaces (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    slug VARCHAR(32) NOT NULL UNIQUE,
+    slug_namespace VARCHAR(16) NOT NULL DEFAULT 'shared',
+    created_at INT UNSIGNED NOT NULL DEFAULT 0
+);
+
+CREATE TABLE workspace_members (
+    workspace_id INT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    role VARCHAR(16) NOT NULL DEFAULT 'viewer',
+    created_at INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (workspace_id, user_id),
+    INDEX (user_id),
+    FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
 CREATE TABLE urls (
     id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
     url TEXT NOT NULL,
-    short_url VARCHAR(15) NOT NULL UNIQUE,
+    short_url VARCHAR(48) NOT NULL UNIQUE,
     click_count INT UNSIGNED DEFAULT 0,
     created_at INT UNSIGNED DEFAULT 0,
-    INDEX (short_url)
-);
\ No newline at end of file
+    redirect_type SMALLINT UNSIGNED NOT NULL DEFAULT 308,
+    query_policy VARCHAR(16) NOT NULL DEFAULT 'drop',
+    utm_source VARCHAR(255) NOT NULL DEFAULT '',
+    utm_medium VARCHAR(255) NOT NULL DEFAULT '',
+    utm_campaign VARCHAR(255) NOT NULL DEFAULT '',
+    password_hash VARCHAR(255) NOT NULL DEFAULT '',
+    single_use BOOLEAN NOT NULL DEFAULT FALSE,
+    consumed_at INT UNSIGNED NOT NULL DEFAULT 0,
+    active_from INT UNSIGNED NOT NULL DEFAULT 0,
+    expires_at INT UNSIGNED NOT NULL DEFAULT 0,
+    fallback_url VARCHAR(2048) NOT NULL DEFAULT '',
+    bot_click_count INT UNSIGNED NOT NULL DEFAULT 0,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    description VARCHAR(1024) NOT NULL DEFAULT '',
+    image VARCHAR(2048) NOT NULL DEFAULT '',
+    force_preview BOOLEAN NOT NULL DEFAULT FALSE,
+    owner_id INT UNSIGNED NULL,
+    workspace_id INT UNSIGNED NULL,
+    INDEX (short_url),
+    INDEX (owner_id),
+    INDEX (workspace_id),
+    FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE SET NULL,
+    FOREIGN KEY (workspace_id) REFERENCES workspaces (id) ON DELETE SET NULL
+);
+
+CREATE TABLE url_targets (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    url_id INT UNSIGNED NOT NULL,
+    url TEXT NOT NULL,
+    weight INT UNSIGNED NOT NULL DEFAULT 1,
+    click_count INT UNSIGNED DEFAULT 0,
+    INDEX (url_id),
+    FOREIGN KEY (url_id) REFERENCES urls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE url_rules (
+    id INT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    url_id INT UNSIGNED NOT NULL,
+    position INT UNSIGNED NOT NULL DEFAULT 0,
+    os VARCHAR(16) NOT NULL DEFAULT '',
+    device VARCHAR(16) NOT NULL DEFAULT '',
+    language VARCHAR(35) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL DEFAULT '',
+    url TEXT NOT NULL,
+    INDEX (url_id, position),
+    FOREIGN KEY (url_id) REFERENCES urls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE click_events (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    url_id INT UNSIGNED NOT NULL,
+    target_id INT UNSIGNED NOT NULL DEFAULT 0,
+    clicked_at INT UNSIGNED NOT NULL,
+    referrer VARCHAR(255) NOT NULL DEFAULT '',
+    os VARCHAR(16) NOT NULL DEFAULT '',
+    device VARCHAR(16) NOT NULL DEFAULT '',
+    browser VARCHAR(16) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL DEFAULT '',
+    bot BOOLEAN NOT NULL DEFAULT FALSE,
+    INDEX (url_id, clicked_at),
+    INDEX (clicked_at),
+    FOREIGN KEY (url_id) REFERENCES urls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE click_rollups (
+    url_id INT UNSIGNED NOT NULL,
+    hour_start INT UNSIGNED NOT NULL,
+    referrer VARCHAR(255) NOT NULL DEFAULT '',
+    country CHAR(2) NOT NULL DEFAULT '',
+    browser VARCHAR(16) NOT NULL DEFAULT '',
+    os VARCHAR(16) NOT NULL DEFAULT '',
+    device VARCHAR(16) NOT NULL DEFAULT '',
+    bot BOOLEAN NOT NULL DEFAULT FALSE,
+    clicks INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (url_id, hour_start, referrer, country, browser, os, device, bot),
+    FOREIGN KEY (url_id) REFERENCES urls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE click_rollup_watermark (
+    id TINYINT UNSIGNED PRIMARY KEY,
+    hour_start INT UNSIGNED NOT NULL
+);
+
+CREATE TABLE url_visitors (
+    url_id INT UNSIGNED NOT NULL,
+    day INT UNSIGNED NOT NULL,
+    sketch VARBINARY(4097) NOT NULL,
+    PRIMARY KEY (url_id, day),
+    FOREIGN KEY (url_id) REFERENCES urls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE quotas (
+    subject_type VARCHAR(16) NOT NULL,
+    subject_id INT UNSIGNED NOT NULL,
+    monthly_creates INT UNSIGNED NOT NULL DEFAULT 0,
+    active_links INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (subject_type, subject_id)
+);
+
+CREATE TABLE usage_counters (
+    subject_type VARCHAR(16) NOT NULL,
+    subject_id INT UNSIGNED NOT NULL,
+    period_start INT UNSIGNED NOT NULL DEFAULT 0,
+    monthly_creates INT UNSIGNED NOT NULL DEFAULT 0,
+    active_links INT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (subject_type, subject_id)
+);
+
+CREATE TABLE idempotency_keys (
+    user_id INT UNSIGNED NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+    response MEDIUMBLOB NOT NULL,
+    headers TEXT NOT NULL,
+    expires_at INT UNSIGNED NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
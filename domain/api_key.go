@@ -0,0 +1,43 @@
+package domain
+
+import "context"
+
+// Credential of a user for calling the api, sent as usk_<prefix>_<secret>
+// Only a hash of the secret is stored, the full key is shown once when it is created
+type ApiKey struct {
+	ID         int      `json:"id"`
+	UserID     int      `json:"user_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"` // public part of the key, to tell keys apart
+	SecretHash string   `json:"-"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"`
+	RevokedAt  int64    `json:"revoked_at,omitempty"`
+}
+
+type CreateApiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// Api key returned by its creation, the only time the full key can be read
+type CreatedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
+
+type ApiKeyRepository interface {
+	Create(context.Context, ApiKey) (int, error)
+	FindByPrefix(context.Context, string) (ApiKey, error)
+	FindByUserID(context.Context, int) ([]ApiKey, error)
+	Revoke(ctx context.Context, id, userID int, revokedAt int64) (int, error) // userID 0 revokes the key whoever owns it
+	UpdateLastUsed(ctx context.Context, id int, usedAt int64) error
+}
+
+type ApiKeyUsecase interface {
+	Authenticator
+	CreateApiKey(context.Context, CreateApiKeyRequest) (CreatedApiKey, error)
+	FindAllApiKeys(context.Context) ([]ApiKey, error)
+	RevokeApiKey(ctx context.Context, id int) error
+}
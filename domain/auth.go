@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// Permissions a bearer token can be granted, the admin scope grants every other one
+const (
+	ScopeLinksRead  = "links:read"
+	ScopeLinksWrite = "links:write"
+	ScopeStatsRead  = "stats:read"
+	ScopeAdmin      = "admin"
+)
+
+var Scopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeStatsRead, ScopeAdmin}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid or revoked credentials")
+	ErrForbidden          = errors.New("permission denied")
+)
+
+// Tell whether the granted scopes allow scope
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type scopesContextKey struct{}
+
+// Attach the scopes granted to the bearer token of a request to its context
+func ContextWithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesContextKey{}, scopes)
+}
+
+// Read the scopes attached by ContextWithScopes, nil for anonymous requests
+func ScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesContextKey{}).([]string)
+	return scopes
+}
+
+// Resolve the bearer token of a request to its user and the scopes it grants
+// The user only keeps the admin role when the admin scope is granted
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (User, []string, error)
+}
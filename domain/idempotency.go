@@ -0,0 +1,22 @@
+package domain
+
+import "context"
+
+// Outcome of a request made with an Idempotency-Key header, kept so the retries of the request get the same response
+type IdempotencyRecord struct {
+	UserID      int
+	Key         string
+	RequestHash string // hex sha256 of the method, uri and body of the request
+	StatusCode  int    // 0 while the request is in progress
+	Response    []byte
+	Headers     map[string][]string // response headers replayed along with the response
+	ExpiresAt   int64
+}
+
+type IdempotencyRepository interface {
+	// Reserve the key of record for a request in progress, expired records of the user are removed first
+	// Returning the record holding the key and false when it is taken already
+	Reserve(ctx context.Context, record IdempotencyRecord, now int64) (IdempotencyRecord, bool, error)
+	Complete(ctx context.Context, record IdempotencyRecord) error
+	Release(ctx context.Context, userID int, key string) error // free a key still in progress, so the request can be retried
+}
@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type ApiKeyRepository struct {
+	mock.Mock
+}
+
+func (r *ApiKeyRepository) Create(ctx context.Context, key domain.ApiKey) (int, error) {
+	args := r.Mock.Called(ctx, key)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *ApiKeyRepository) FindByPrefix(ctx context.Context, prefix string) (domain.ApiKey, error) {
+	args := r.Mock.Called(ctx, prefix)
+	return args.Get(0).(domain.ApiKey), args.Error(1)
+}
+
+func (r *ApiKeyRepository) FindByUserID(ctx context.Context, userID int) ([]domain.ApiKey, error) {
+	args := r.Mock.Called(ctx, userID)
+	return args.Get(0).([]domain.ApiKey), args.Error(1)
+}
+
+func (r *ApiKeyRepository) Revoke(ctx context.Context, id, userID int, revokedAt int64) (int, error) {
+	args := r.Mock.Called(ctx, id, userID, revokedAt)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *ApiKeyRepository) UpdateLastUsed(ctx context.Context, id int, usedAt int64) error {
+	args := r.Mock.Called(ctx, id, usedAt)
+	return args.Error(0)
+}
@@ -0,0 +1,32 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type ApiKeyUsecase struct {
+	mock.Mock
+}
+
+func (u *ApiKeyUsecase) Authenticate(ctx context.Context, token string) (domain.User, []string, error) {
+	args := u.Mock.Called(ctx, token)
+	return args.Get(0).(domain.User), args.Get(1).([]string), args.Error(2)
+}
+
+func (u *ApiKeyUsecase) CreateApiKey(ctx context.Context, request domain.CreateApiKeyRequest) (domain.CreatedApiKey, error) {
+	args := u.Mock.Called(ctx, request)
+	return args.Get(0).(domain.CreatedApiKey), args.Error(1)
+}
+
+func (u *ApiKeyUsecase) FindAllApiKeys(ctx context.Context) ([]domain.ApiKey, error) {
+	args := u.Mock.Called(ctx)
+	return args.Get(0).([]domain.ApiKey), args.Error(1)
+}
+
+func (u *ApiKeyUsecase) RevokeApiKey(ctx context.Context, id int) error {
+	args := u.Mock.Called(ctx, id)
+	return args.Error(0)
+}
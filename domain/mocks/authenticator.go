@@ -0,0 +1,17 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type Authenticator struct {
+	mock.Mock
+}
+
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (domain.User, []string, error) {
+	args := a.Mock.Called(ctx, token)
+	return args.Get(0).(domain.User), args.Get(1).([]string), args.Error(2)
+}
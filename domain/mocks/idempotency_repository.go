@@ -0,0 +1,27 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type IdempotencyRepository struct {
+	mock.Mock
+}
+
+func (r *IdempotencyRepository) Reserve(ctx context.Context, record domain.IdempotencyRecord, now int64) (domain.IdempotencyRecord, bool, error) {
+	args := r.Mock.Called(ctx, record, now)
+	return args.Get(0).(domain.IdempotencyRecord), args.Bool(1), args.Error(2)
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, record domain.IdempotencyRecord) error {
+	args := r.Mock.Called(ctx, record)
+	return args.Error(0)
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, userID int, key string) error {
+	args := r.Mock.Called(ctx, userID, key)
+	return args.Error(0)
+}
@@ -0,0 +1,17 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type PreviewFetcher struct {
+	mock.Mock
+}
+
+func (f *PreviewFetcher) Fetch(ctx context.Context, url string) (domain.LinkPreview, error) {
+	args := f.Mock.Called(ctx, url)
+	return args.Get(0).(domain.LinkPreview), args.Error(1)
+}
@@ -16,22 +16,82 @@ func (r *UrlRepository) Create(ctx context.Context, params domain.CreateUrlParam
 	return args.Int(0), args.Error(1)
 }
 
+func (r *UrlRepository) CheckQuota(ctx context.Context, params domain.CreateUrlParams) error {
+	args := r.Mock.Called(ctx, params)
+	return args.Error(0)
+}
+
 func (r *UrlRepository) FindByShortUrl(ctx context.Context, shortUrl string) (domain.Url, error) {
 	args := r.Mock.Called(ctx, shortUrl)
 	return args.Get(0).(domain.Url), args.Error(1)
 }
 
-func (r *UrlRepository) FindByID(ctx context.Context, id int) (domain.Url, error) {
-	args := r.Mock.Called(ctx, id)
+func (r *UrlRepository) FindByID(ctx context.Context, id, userID int) (domain.Url, error) {
+	args := r.Mock.Called(ctx, id, userID)
 	return args.Get(0).(domain.Url), args.Error(1)
 }
 
-func (r *UrlRepository) FindAll(ctx context.Context) ([]domain.Url, error) {
-	args := r.Mock.Called(ctx)
+func (r *UrlRepository) FindAll(ctx context.Context, filter domain.UrlFilter) ([]domain.Url, error) {
+	args := r.Mock.Called(ctx, filter)
 	return args.Get(0).([]domain.Url), args.Error(1)
 }
 
-func (r *UrlRepository) DeleteByID(ctx context.Context, id int) (int, error) {
-	args := r.Mock.Called(ctx, id)
+func (r *UrlRepository) DeleteByID(ctx context.Context, id, userID int) (int, error) {
+	args := r.Mock.Called(ctx, id, userID)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *UrlRepository) MarkConsumed(ctx context.Context, id int, consumedAt int64) (int, error) {
+	args := r.Mock.Called(ctx, id, consumedAt)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *UrlRepository) ReplaceTargets(ctx context.Context, urlID int, targets []domain.UrlTarget) error {
+	args := r.Mock.Called(ctx, urlID, targets)
+	return args.Error(0)
+}
+
+func (r *UrlRepository) ReplaceRules(ctx context.Context, urlID int, rules []domain.UrlRule) error {
+	args := r.Mock.Called(ctx, urlID, rules)
+	return args.Error(0)
+}
+
+func (r *UrlRepository) CreateClickEvents(ctx context.Context, events []domain.ClickEvent) error {
+	args := r.Mock.Called(ctx, events)
+	return args.Error(0)
+}
+
+func (r *UrlRepository) RollupClicks(ctx context.Context, from, to int64) error {
+	args := r.Mock.Called(ctx, from, to)
+	return args.Error(0)
+}
+
+func (r *UrlRepository) FindRollupWatermark(ctx context.Context) (int64, error) {
+	args := r.Mock.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (r *UrlRepository) SetRollupWatermark(ctx context.Context, hourStart int64) error {
+	args := r.Mock.Called(ctx, hourStart)
+	return args.Error(0)
+}
+
+func (r *UrlRepository) CountClicks(ctx context.Context, filter domain.StatsFilter) ([]domain.ClickBucket, error) {
+	args := r.Mock.Called(ctx, filter)
+	return args.Get(0).([]domain.ClickBucket), args.Error(1)
+}
+
+func (r *UrlRepository) CountClicksBy(ctx context.Context, filter domain.StatsFilter, dimension string, limit int) ([]domain.ClickCount, error) {
+	args := r.Mock.Called(ctx, filter, dimension, limit)
+	return args.Get(0).([]domain.ClickCount), args.Error(1)
+}
+
+func (r *UrlRepository) FindVisitorSketches(ctx context.Context, urlID int, from, to int64) ([]domain.VisitorSketch, error) {
+	args := r.Mock.Called(ctx, urlID, from, to)
+	return args.Get(0).([]domain.VisitorSketch), args.Error(1)
+}
+
+func (r *UrlRepository) CountBotClicks(ctx context.Context, filter domain.StatsFilter) (int, error) {
+	args := r.Mock.Called(ctx, filter)
 	return args.Int(0), args.Error(1)
 }
@@ -11,8 +11,8 @@ type UrlUsecase struct {
 	mock.Mock
 }
 
-func (u *UrlUsecase) CreateNewURL(ctx context.Context, url string) (domain.Url, error) {
-	args := u.Mock.Called(ctx, url)
+func (u *UrlUsecase) CreateNewURL(ctx context.Context, request domain.CreateUrlRequest) (domain.Url, error) {
+	args := u.Mock.Called(ctx, request)
 	return args.Get(0).(domain.Url), args.Error(1)
 }
 
@@ -21,8 +21,48 @@ func (u *UrlUsecase) FindUrlByShort(ctx context.Context, shortUrl string) (domai
 	return args.Get(0).(domain.Url), args.Error(1)
 }
 
-func (u *UrlUsecase) FindAllUrl(ctx context.Context) ([]domain.Url, error) {
-	args := u.Mock.Called(ctx)
+func (u *UrlUsecase) FindUrlByID(ctx context.Context, id int) (domain.Url, error) {
+	args := u.Mock.Called(ctx, id)
+	return args.Get(0).(domain.Url), args.Error(1)
+}
+
+func (u *UrlUsecase) GetUrlDetails(ctx context.Context, id int) (domain.UrlDetails, error) {
+	args := u.Mock.Called(ctx, id)
+	return args.Get(0).(domain.UrlDetails), args.Error(1)
+}
+
+func (u *UrlUsecase) GetUrlDetailsByShort(ctx context.Context, shortUrl string) (domain.UrlDetails, error) {
+	args := u.Mock.Called(ctx, shortUrl)
+	return args.Get(0).(domain.UrlDetails), args.Error(1)
+}
+
+func (u *UrlUsecase) UnlockUrl(ctx context.Context, shortUrl, password, clientIP string) (domain.Url, error) {
+	args := u.Mock.Called(ctx, shortUrl, password, clientIP)
+	return args.Get(0).(domain.Url), args.Error(1)
+}
+
+func (u *UrlUsecase) ConsumeUrl(ctx context.Context, id int) error {
+	args := u.Mock.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (u *UrlUsecase) UpdateTargets(ctx context.Context, id int, targets []domain.UrlTarget) (domain.Url, error) {
+	args := u.Mock.Called(ctx, id, targets)
+	return args.Get(0).(domain.Url), args.Error(1)
+}
+
+func (u *UrlUsecase) UpdateRules(ctx context.Context, id int, rules []domain.UrlRule) (domain.Url, error) {
+	args := u.Mock.Called(ctx, id, rules)
+	return args.Get(0).(domain.Url), args.Error(1)
+}
+
+func (u *UrlUsecase) RecordClick(ctx context.Context, event domain.ClickEvent) error {
+	args := u.Mock.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (u *UrlUsecase) FindAllUrl(ctx context.Context, status string, workspaceID int) ([]domain.Url, error) {
+	args := u.Mock.Called(ctx, status, workspaceID)
 	return args.Get(0).([]domain.Url), args.Error(1)
 }
 
@@ -30,3 +70,13 @@ func (u *UrlUsecase) DeleteByID(ctx context.Context, ID int) (domain.Url, error)
 	args := u.Mock.Called(ctx, ID)
 	return args.Get(0).(domain.Url), args.Error(1)
 }
+
+func (u *UrlUsecase) GetStats(ctx context.Context, id int, request domain.StatsRequest) (domain.UrlStats, error) {
+	args := u.Mock.Called(ctx, id, request)
+	return args.Get(0).(domain.UrlStats), args.Error(1)
+}
+
+func (u *UrlUsecase) Close(ctx context.Context) error {
+	args := u.Mock.Called(ctx)
+	return args.Error(0)
+}
@@ -0,0 +1,27 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type UsageRepository struct {
+	mock.Mock
+}
+
+func (r *UsageRepository) FindQuota(ctx context.Context, subjectType string, subjectID int, defaults domain.Quota) (domain.Quota, error) {
+	args := r.Mock.Called(ctx, subjectType, subjectID, defaults)
+	return args.Get(0).(domain.Quota), args.Error(1)
+}
+
+func (r *UsageRepository) SetQuota(ctx context.Context, subjectType string, subjectID int, quota domain.Quota) error {
+	args := r.Mock.Called(ctx, subjectType, subjectID, quota)
+	return args.Error(0)
+}
+
+func (r *UsageRepository) FindUsage(ctx context.Context, subjectType string, subjectID int, periodStart int64) (domain.Usage, error) {
+	args := r.Mock.Called(ctx, subjectType, subjectID, periodStart)
+	return args.Get(0).(domain.Usage), args.Error(1)
+}
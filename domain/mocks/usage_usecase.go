@@ -0,0 +1,22 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type UsageUsecase struct {
+	mock.Mock
+}
+
+func (u *UsageUsecase) GetUsage(ctx context.Context, workspaceID int) (domain.Usage, error) {
+	args := u.Mock.Called(ctx, workspaceID)
+	return args.Get(0).(domain.Usage), args.Error(1)
+}
+
+func (u *UsageUsecase) SetQuota(ctx context.Context, request domain.SetQuotaRequest) (domain.Usage, error) {
+	args := u.Mock.Called(ctx, request)
+	return args.Get(0).(domain.Usage), args.Error(1)
+}
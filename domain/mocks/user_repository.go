@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type UserRepository struct {
+	mock.Mock
+}
+
+func (r *UserRepository) Create(ctx context.Context, user domain.User) (int, error) {
+	args := r.Mock.Called(ctx, user)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id int) (domain.User, error) {
+	args := r.Mock.Called(ctx, id)
+	return args.Get(0).(domain.User), args.Error(1)
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (domain.User, error) {
+	args := r.Mock.Called(ctx, email)
+	return args.Get(0).(domain.User), args.Error(1)
+}
+
+func (r *UserRepository) FindByIdentity(ctx context.Context, issuer, subject string) (domain.User, error) {
+	args := r.Mock.Called(ctx, issuer, subject)
+	return args.Get(0).(domain.User), args.Error(1)
+}
+
+func (r *UserRepository) LinkIdentity(ctx context.Context, userID int, issuer, subject string, createdAt int64) error {
+	args := r.Mock.Called(ctx, userID, issuer, subject, createdAt)
+	return args.Error(0)
+}
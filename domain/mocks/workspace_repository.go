@@ -0,0 +1,52 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type WorkspaceRepository struct {
+	mock.Mock
+}
+
+func (r *WorkspaceRepository) Create(ctx context.Context, workspace domain.Workspace, ownerID int) (int, error) {
+	args := r.Mock.Called(ctx, workspace, ownerID)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *WorkspaceRepository) FindByID(ctx context.Context, id int) (domain.Workspace, error) {
+	args := r.Mock.Called(ctx, id)
+	return args.Get(0).(domain.Workspace), args.Error(1)
+}
+
+func (r *WorkspaceRepository) FindByUserID(ctx context.Context, userID int) ([]domain.Workspace, error) {
+	args := r.Mock.Called(ctx, userID)
+	return args.Get(0).([]domain.Workspace), args.Error(1)
+}
+
+func (r *WorkspaceRepository) DeleteByID(ctx context.Context, id int) (int, error) {
+	args := r.Mock.Called(ctx, id)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *WorkspaceRepository) FindMember(ctx context.Context, workspaceID, userID int) (domain.WorkspaceMember, error) {
+	args := r.Mock.Called(ctx, workspaceID, userID)
+	return args.Get(0).(domain.WorkspaceMember), args.Error(1)
+}
+
+func (r *WorkspaceRepository) FindMembers(ctx context.Context, workspaceID int) ([]domain.WorkspaceMember, error) {
+	args := r.Mock.Called(ctx, workspaceID)
+	return args.Get(0).([]domain.WorkspaceMember), args.Error(1)
+}
+
+func (r *WorkspaceRepository) SetMember(ctx context.Context, member domain.WorkspaceMember) error {
+	args := r.Mock.Called(ctx, member)
+	return args.Error(0)
+}
+
+func (r *WorkspaceRepository) DeleteMember(ctx context.Context, workspaceID, userID int) (int, error) {
+	args := r.Mock.Called(ctx, workspaceID, userID)
+	return args.Int(0), args.Error(1)
+}
@@ -0,0 +1,47 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/mock"
+)
+
+type WorkspaceUsecase struct {
+	mock.Mock
+}
+
+func (u *WorkspaceUsecase) CreateWorkspace(ctx context.Context, request domain.CreateWorkspaceRequest) (domain.Workspace, error) {
+	args := u.Mock.Called(ctx, request)
+	return args.Get(0).(domain.Workspace), args.Error(1)
+}
+
+func (u *WorkspaceUsecase) FindAllWorkspaces(ctx context.Context) ([]domain.Workspace, error) {
+	args := u.Mock.Called(ctx)
+	return args.Get(0).([]domain.Workspace), args.Error(1)
+}
+
+func (u *WorkspaceUsecase) GetWorkspace(ctx context.Context, id int) (domain.Workspace, error) {
+	args := u.Mock.Called(ctx, id)
+	return args.Get(0).(domain.Workspace), args.Error(1)
+}
+
+func (u *WorkspaceUsecase) DeleteWorkspace(ctx context.Context, id int) error {
+	args := u.Mock.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (u *WorkspaceUsecase) FindMembers(ctx context.Context, workspaceID int) ([]domain.WorkspaceMember, error) {
+	args := u.Mock.Called(ctx, workspaceID)
+	return args.Get(0).([]domain.WorkspaceMember), args.Error(1)
+}
+
+func (u *WorkspaceUsecase) SetMember(ctx context.Context, workspaceID int, request domain.SetWorkspaceMemberRequest) (domain.WorkspaceMember, error) {
+	args := u.Mock.Called(ctx, workspaceID, request)
+	return args.Get(0).(domain.WorkspaceMember), args.Error(1)
+}
+
+func (u *WorkspaceUsecase) RemoveMember(ctx context.Context, workspaceID, userID int) error {
+	args := u.Mock.Called(ctx, workspaceID, userID)
+	return args.Error(0)
+}
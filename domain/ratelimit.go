@@ -0,0 +1,14 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Keeps the state of the rate limiter buckets, an external store shares it between the instances of a deployment
+type RateLimitStore interface {
+	// Atomically take a token from the bucket of key, allowing limit tokens per period, at now (unix nanoseconds)
+	// The state of a bucket is the time it is full again, 0 when there is none, and expires after period
+	// Returning the state after the take, unchanged when the bucket is empty, and whether a token was taken
+	Take(ctx context.Context, key string, limit int, period time.Duration, now int64) (int64, bool, error)
+}
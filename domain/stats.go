@@ -0,0 +1,78 @@
+package domain
+
+// Width of the time buckets of a click time series
+const (
+	StatsIntervalHour = "hour"
+	StatsIntervalDay  = "day"
+	StatsIntervalWeek = "week" // weeks start on monday, UTC
+)
+
+// Click event attributes the stats can be broken down by
+const (
+	StatsDimensionReferrer = "referrer"
+	StatsDimensionCountry  = "country"
+	StatsDimensionBrowser  = "browser"
+	StatsDimensionOs       = "os"
+	StatsDimensionDevice   = "device"
+)
+
+type StatsFilter struct {
+	UrlID        int
+	From         int64 // unix time, inclusive
+	To           int64 // unix time, exclusive
+	BucketSize   int64 // seconds
+	BucketOffset int64 // seconds after the unix epoch the first bucket starts at
+	IncludeBots  bool  // count bot clicks along with human ones
+}
+
+// Clicks of one time bucket, Time is the unix time the bucket starts at
+// Visitors is only estimated for day and week buckets
+type ClickBucket struct {
+	Time     int64 `json:"time"`
+	Clicks   int   `json:"clicks"`
+	Visitors int   `json:"visitors,omitempty"`
+}
+
+// HyperLogLog sketch of the visitors of one url in one UTC day
+type VisitorSketch struct {
+	Day    int64
+	Sketch []byte
+}
+
+// Clicks sharing one value of a breakdown dimension, empty when the value is unknown
+type ClickCount struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+type UrlStats struct {
+	UrlID     int           `json:"url_id"`
+	From      int64         `json:"from"`
+	To        int64         `json:"to"`
+	Interval  string        `json:"interval"`
+	Clicks    int           `json:"clicks"`
+	BotClicks int           `json:"bot_clicks"`
+	Visitors  int           `json:"visitors"` // estimated unique visitors, counted over whole UTC days
+	Series    []ClickBucket `json:"series"`
+	Referrers []ClickCount  `json:"referrers"`
+	Countries []ClickCount  `json:"countries"`
+	Browsers  []ClickCount  `json:"browsers"`
+	Os        []ClickCount  `json:"os"`
+	Devices   []ClickCount  `json:"devices"`
+}
+
+// Clicks of a url over its last days, counted over whole UTC days up to now
+type StatsSummary struct {
+	From      int64 `json:"from"`
+	To        int64 `json:"to"`
+	Clicks    int   `json:"clicks"`
+	BotClicks int   `json:"bot_clicks"`
+	Visitors  int   `json:"visitors"` // estimated unique visitors
+}
+
+type StatsRequest struct {
+	From        int64
+	To          int64
+	Interval    string
+	IncludeBots bool
+}
@@ -1,31 +1,235 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// How the query string of an incoming short link request is passed to the destination
+const (
+	QueryPolicyDrop     = "drop"     // incoming query is discarded
+	QueryPolicyKeep     = "keep"     // merged, destination value wins on conflict
+	QueryPolicyOverride = "override" // merged, incoming value wins on conflict
+	QueryPolicyAppend   = "append"   // merged, both values are kept on conflict
+)
+
+var (
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrTooManyAttempts = errors.New("too many failed password attempts, try again later")
+	ErrUrlConsumed     = errors.New("link has already been used")
+	ErrUrlNotActive    = errors.New("link is not active yet")
+	ErrUrlExpired      = errors.New("link has expired")
+)
+
+// Lifecycle states of a short link, relative to its activation window
+const (
+	UrlStatusScheduled = "scheduled"
+	UrlStatusActive    = "active"
+	UrlStatusExpired   = "expired"
+)
+
+// Visitor platforms a targeting rule can match on
+const (
+	OsIOS     = "ios"
+	OsAndroid = "android"
+	OsWindows = "windows"
+	OsMacOS   = "macos"
+	OsLinux   = "linux"
+	OsOther   = "other"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+// Browser families reported by the user agent parser
+const (
+	BrowserChrome  = "chrome"
+	BrowserFirefox = "firefox"
+	BrowserSafari  = "safari"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+	BrowserOther   = "other"
+)
 
 type Url struct {
+	ID            int         `json:"id"`
+	OwnerID       int         `json:"owner_id,omitempty"`     // user the url belongs to, 0 for urls created anonymously
+	WorkspaceID   int         `json:"workspace_id,omitempty"` // workspace the url belongs to, 0 for personal urls
+	Url           string      `json:"url"`
+	ShortUrl      string      `json:"short_url"`
+	ClickCount    int         `json:"click_count"` // human clicks only
+	BotClickCount int         `json:"bot_click_count"`
+	CreatedAt     int64       `json:"created_at"`
+	RedirectType  int         `json:"redirect_type"`
+	QueryPolicy   string      `json:"query_policy"`
+	UtmSource     string      `json:"utm_source,omitempty"`
+	UtmMedium     string      `json:"utm_medium,omitempty"`
+	UtmCampaign   string      `json:"utm_campaign,omitempty"`
+	PasswordHash  string      `json:"-"`
+	Protected     bool        `json:"password_protected"`
+	SingleUse     bool        `json:"single_use"`
+	ConsumedAt    int64       `json:"consumed_at,omitempty"`
+	ActiveFrom    int64       `json:"active_from,omitempty"`
+	ExpiresAt     int64       `json:"expires_at,omitempty"`
+	FallbackUrl   string      `json:"fallback_url,omitempty"`
+	Title         string      `json:"title,omitempty"` // Open Graph preview served to social crawlers
+	Description   string      `json:"description,omitempty"`
+	Image         string      `json:"image,omitempty"`
+	ForcePreview  bool        `json:"force_preview"` // every visitor sees the interstitial page before the redirect
+	Targets       []UrlTarget `json:"targets,omitempty"`
+	Rules         []UrlRule   `json:"rules,omitempty"`
+}
+
+// One weighted destination of a split short url
+type UrlTarget struct {
 	ID         int    `json:"id"`
+	UrlID      int    `json:"-"`
 	Url        string `json:"url"`
-	ShortUrl   string `json:"short_url"`
+	Weight     int    `json:"weight"`
 	ClickCount int    `json:"click_count"`
-	CreatedAt  int64  `json:"created_at"`
 }
 
-type CreateUrlParams struct {
+// One targeting rule of a url, rules are evaluated by position and the first match wins
+// Empty conditions match every visitor
+type UrlRule struct {
+	ID       int    `json:"id"`
+	UrlID    int    `json:"-"`
+	Position int    `json:"position"`
+	Os       string `json:"os,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Language string `json:"language,omitempty"`
+	Country  string `json:"country,omitempty"`
 	Url      string `json:"url"`
-	ShortUrl string `json:"short_url"`
+}
+
+// One redirect served by a short url, with the visitor attributes kept for analytics
+type ClickEvent struct {
+	UrlID     int
+	TargetID  int
+	ClickedAt int64
+	Referrer  string // host of the Referer header
+	Os        string
+	Device    string
+	Browser   string
+	Country   string // ISO 3166-1 alpha-2 code, empty when unknown
+	Visitor   uint64 // hash of the visitor ip and user agent, counted by the unique visitor sketches
+	Bot       bool   // crawler or link preview, left out of click_count and of the visitor sketches
+}
+
+type CreateUrlParams struct {
+	Url          string      `json:"url"`
+	ShortUrl     string      `json:"short_url"`
+	CreatedAt    int64       `json:"created_at"`
+	OwnerID      int         `json:"owner_id"`
+	WorkspaceID  int         `json:"workspace_id"`
+	RedirectType int         `json:"redirect_type"`
+	QueryPolicy  string      `json:"query_policy"`
+	UtmSource    string      `json:"utm_source"`
+	UtmMedium    string      `json:"utm_medium"`
+	UtmCampaign  string      `json:"utm_campaign"`
+	PasswordHash string      `json:"-"`
+	SingleUse    bool        `json:"single_use"`
+	ActiveFrom   int64       `json:"active_from"`
+	ExpiresAt    int64       `json:"expires_at"`
+	FallbackUrl  string      `json:"fallback_url"`
+	Title        string      `json:"title"`
+	Description  string      `json:"description"`
+	Image        string      `json:"image"`
+	ForcePreview bool        `json:"force_preview"`
+	Targets      []UrlTarget `json:"targets"`
+	Rules        []UrlRule   `json:"rules"`
+	Quota        Quota       `json:"-"` // default limits of the quota subject of the url, the quotas table overrides them
+	PeriodStart  int64       `json:"-"` // start of the month monthly creates are counted in
+}
+
+type CreateUrlRequest struct {
+	Url          string      `json:"url"`
+	WorkspaceID  int         `json:"workspace_id"` // 0 creates a personal url
+	RedirectType int         `json:"redirect_type"`
+	QueryPolicy  string      `json:"query_policy"`
+	UtmSource    string      `json:"utm_source"`
+	UtmMedium    string      `json:"utm_medium"`
+	UtmCampaign  string      `json:"utm_campaign"`
+	Password     string      `json:"password"`
+	SingleUse    bool        `json:"single_use"`
+	ActiveFrom   int64       `json:"active_from"`
+	ExpiresAt    int64       `json:"expires_at"`
+	FallbackUrl  string      `json:"fallback_url"`
+	Title        string      `json:"title"` // preview fields left empty are fetched from the destination page
+	Description  string      `json:"description"`
+	Image        string      `json:"image"`
+	ForcePreview bool        `json:"force_preview"`
+	Targets      []UrlTarget `json:"targets"`
+	Rules        []UrlRule   `json:"rules"`
+}
+
+// A url with its lifecycle status and a summary of its recent clicks
+type UrlDetails struct {
+	Url
+	Status string       `json:"status"` // one of UrlStatus*
+	Stats  StatsSummary `json:"stats"`
+}
+
+type UrlFilter struct {
+	Status      string // one of UrlStatus*, empty for every url
+	Now         int64  // reference unix time of the status
+	OwnerID     int    // personal urls of one user, 0 for the urls of every user
+	WorkspaceID int    // urls of one workspace, taking precedence over OwnerID
 }
 
 type UrlRepository interface {
 	Create(context.Context, CreateUrlParams) (int, error)
+	CheckQuota(context.Context, CreateUrlParams) error
 	FindByShortUrl(context.Context, string) (Url, error)
-	FindByID(context.Context, int) (Url, error)
-	FindAll(context.Context) ([]Url, error)
-	DeleteByID(context.Context, int) (int, error)
+	FindByID(ctx context.Context, id, userID int) (Url, error) // userID 0 finds the url whoever it belongs to
+	FindAll(context.Context, UrlFilter) ([]Url, error)
+	DeleteByID(ctx context.Context, id, userID int) (int, error)
+	MarkConsumed(ctx context.Context, id int, consumedAt int64) (int, error)
+	ReplaceTargets(ctx context.Context, urlID int, targets []UrlTarget) error
+	ReplaceRules(ctx context.Context, urlID int, rules []UrlRule) error
+	CreateClickEvents(context.Context, []ClickEvent) error
+	RollupClicks(ctx context.Context, from, to int64) error
+	FindRollupWatermark(context.Context) (int64, error)
+	SetRollupWatermark(ctx context.Context, hourStart int64) error
+	FindVisitorSketches(ctx context.Context, urlID int, from, to int64) ([]VisitorSketch, error)
+	CountBotClicks(context.Context, StatsFilter) (int, error)
+	CountClicks(context.Context, StatsFilter) ([]ClickBucket, error)
+	CountClicksBy(ctx context.Context, filter StatsFilter, dimension string, limit int) ([]ClickCount, error)
 }
 
 type UrlUsecase interface {
-	CreateNewURL(context.Context, string) (Url, error)
+	CreateNewURL(context.Context, CreateUrlRequest) (Url, error)
 	FindUrlByShort(context.Context, string) (Url, error)
-	FindAllUrl(context.Context) ([]Url, error)
+	FindUrlByID(context.Context, int) (Url, error)
+	GetUrlDetails(ctx context.Context, id int) (UrlDetails, error)
+	GetUrlDetailsByShort(ctx context.Context, shortUrl string) (UrlDetails, error)
+	UnlockUrl(ctx context.Context, shortUrl, password, clientIP string) (Url, error)
+	ConsumeUrl(context.Context, int) error
+	UpdateTargets(ctx context.Context, id int, targets []UrlTarget) (Url, error)
+	UpdateRules(ctx context.Context, id int, rules []UrlRule) (Url, error)
+	RecordClick(context.Context, ClickEvent) error
+	GetStats(ctx context.Context, id int, request StatsRequest) (UrlStats, error)
+	Close(context.Context) error
+	FindAllUrl(ctx context.Context, status string, workspaceID int) ([]Url, error) // workspaceID 0 lists the personal urls of the caller
 	DeleteByID(context.Context, int) (Url, error)
 }
+
+// Resolve the country of an ip address, from a local GeoIP database
+type GeoLocator interface {
+	Country(ip net.IP) (string, error)
+}
+
+// Open Graph metadata of a web page, empty fields weren't found
+type LinkPreview struct {
+	Title       string
+	Description string
+	Image       string
+}
+
+// Read the preview metadata of a destination page, called once when a link is created
+type PreviewFetcher interface {
+	Fetch(ctx context.Context, url string) (LinkPreview, error)
+}
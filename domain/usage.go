@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Subjects links are counted for, workspace links count for their workspace and other links for their owner
+const (
+	QuotaSubjectUser      = "user"
+	QuotaSubjectWorkspace = "workspace"
+)
+
+// Names of the quotas of a subject
+const (
+	QuotaMonthlyCreates = "monthly_creates"
+	QuotaActiveLinks    = "active_links"
+)
+
+// Limits of a subject, 0 for no limit
+type Quota struct {
+	MonthlyCreates int `json:"monthly_creates"` // links created per calendar month (UTC)
+	ActiveLinks    int `json:"active_links"`    // links held until they're deleted, expired ones included
+}
+
+type Usage struct {
+	SubjectType    string `json:"subject_type"`
+	SubjectID      int    `json:"subject_id"`
+	PeriodStart    int64  `json:"period_start"` // start of the month monthly creates are counted in
+	PeriodEnd      int64  `json:"period_end"`
+	MonthlyCreates int    `json:"monthly_creates"`
+	ActiveLinks    int    `json:"active_links"`
+	Quota          Quota  `json:"quota"`
+}
+
+// Set the quota of one subject, replacing the configured defaults
+type SetQuotaRequest struct {
+	SubjectType string `json:"subject_type"`
+	SubjectID   int    `json:"subject_id"`
+	Quota
+}
+
+// Returned when creating a link would go over one of the quotas of its subject
+type QuotaExceededError struct {
+	Quota string // one of Quota*
+	Usage Usage
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d links exceeded", e.Quota, e.Limit())
+}
+
+func (e *QuotaExceededError) Limit() int {
+	if e.Quota == QuotaMonthlyCreates {
+		return e.Usage.Quota.MonthlyCreates
+	}
+	return e.Usage.Quota.ActiveLinks
+}
+
+// Start and end of the calendar month (UTC) now falls in
+func QuotaPeriod(now int64) (int64, int64) {
+	month := time.Unix(now, 0).UTC()
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Unix(), start.AddDate(0, 1, 0).Unix()
+}
+
+// Subject the links of a workspace, or of an owner outside of any workspace, are counted for
+func QuotaSubject(workspaceID, ownerID int) (string, int) {
+	if workspaceID != 0 {
+		return QuotaSubjectWorkspace, workspaceID
+	}
+	return QuotaSubjectUser, ownerID
+}
+
+type UsageRepository interface {
+	FindQuota(ctx context.Context, subjectType string, subjectID int, defaults Quota) (Quota, error)
+	SetQuota(ctx context.Context, subjectType string, subjectID int, quota Quota) error
+	FindUsage(ctx context.Context, subjectType string, subjectID int, periodStart int64) (Usage, error)
+}
+
+type UsageUsecase interface {
+	GetUsage(ctx context.Context, workspaceID int) (Usage, error) // usage of the caller when workspaceID is 0
+	SetQuota(context.Context, SetQuotaRequest) (Usage, error)
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// Roles of a user account, admins manage the links of every user
+const (
+	UserRoleMember = "member"
+	UserRoleAdmin  = "admin"
+)
+
+var ErrUnauthenticated = errors.New("authentication required")
+
+// The user already has another account of the issuer, or the account is linked already
+var ErrIdentityTaken = errors.New("the account of the issuer is linked to another user")
+
+type User struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (u User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+type userContextKey struct{}
+
+// Attach the authenticated user of a request to its context, for the usecases to scope their work by
+func ContextWithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// Read the authenticated user attached by ContextWithUser, false for anonymous requests
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(User)
+	return user, ok
+}
+
+type UserRepository interface {
+	Create(context.Context, User) (int, error)
+	FindByID(context.Context, int) (User, error)
+	FindByEmail(context.Context, string) (User, error)
+	FindByIdentity(ctx context.Context, issuer, subject string) (User, error)
+	LinkIdentity(ctx context.Context, userID int, issuer, subject string, createdAt int64) error
+}
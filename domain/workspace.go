@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrWorkspaceSlugTaken = errors.New("validation error: workspace slug is already taken")
+
+// Taking the owner role away from the last owner of a workspace, by a role change or a removal
+var ErrLastWorkspaceOwner = errors.New("validation error: a workspace must keep at least one owner")
+
+// Roles of a workspace member, each one granting what the previous ones do
+// Viewers read the links of the workspace and their stats, editors manage the links, owners manage the workspace
+const (
+	WorkspaceRoleViewer = "viewer"
+	WorkspaceRoleEditor = "editor"
+	WorkspaceRoleOwner  = "owner"
+)
+
+var workspaceRoleRanks = map[string]int{
+	WorkspaceRoleViewer: 1,
+	WorkspaceRoleEditor: 2,
+	WorkspaceRoleOwner:  3,
+}
+
+// Slug namespace policies of a workspace
+const (
+	SlugNamespaceShared    = "shared"    // slugs are picked among the ones of every workspace, e.g. /abc123
+	SlugNamespaceWorkspace = "workspace" // slugs are prefixed by the workspace slug, e.g. /sales/abc123
+)
+
+type Workspace struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Slug          string `json:"slug"`
+	SlugNamespace string `json:"slug_namespace"` // one of SlugNamespace*
+	CreatedAt     int64  `json:"created_at"`
+	Role          string `json:"role,omitempty"` // role of the caller, when listed among its workspaces
+}
+
+type WorkspaceMember struct {
+	WorkspaceID int    `json:"workspace_id"`
+	UserID      int    `json:"user_id"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Role        string `json:"role"` // one of WorkspaceRole*
+	CreatedAt   int64  `json:"created_at"`
+}
+
+type CreateWorkspaceRequest struct {
+	Name          string `json:"name"`
+	Slug          string `json:"slug"`
+	SlugNamespace string `json:"slug_namespace"`
+}
+
+// Add a user to a workspace by its email, or change the role of a member
+type SetWorkspaceMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Tell whether role grants at least the permissions of required
+func WorkspaceRoleAllows(role, required string) bool {
+	return workspaceRoleRanks[role] != 0 && workspaceRoleRanks[role] >= workspaceRoleRanks[required]
+}
+
+type WorkspaceRepository interface {
+	Create(ctx context.Context, workspace Workspace, ownerID int) (int, error) // the owner is added as the first member
+	FindByID(context.Context, int) (Workspace, error)
+	FindByUserID(context.Context, int) ([]Workspace, error)
+	DeleteByID(context.Context, int) (int, error)
+	FindMember(ctx context.Context, workspaceID, userID int) (WorkspaceMember, error)
+	FindMembers(ctx context.Context, workspaceID int) ([]WorkspaceMember, error)
+	SetMember(context.Context, WorkspaceMember) error
+	DeleteMember(ctx context.Context, workspaceID, userID int) (int, error)
+}
+
+type WorkspaceUsecase interface {
+	CreateWorkspace(context.Context, CreateWorkspaceRequest) (Workspace, error)
+	FindAllWorkspaces(context.Context) ([]Workspace, error)
+	GetWorkspace(ctx context.Context, id int) (Workspace, error)
+	DeleteWorkspace(ctx context.Context, id int) error
+	FindMembers(ctx context.Context, workspaceID int) ([]WorkspaceMember, error)
+	SetMember(ctx context.Context, workspaceID int, request SetWorkspaceMemberRequest) (WorkspaceMember, error)
+	RemoveMember(ctx context.Context, workspaceID, userID int) error
+}
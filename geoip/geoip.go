@@ -0,0 +1,57 @@
+package geoip
+
+import (
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Country lookup backed by a MaxMind format database (GeoLite2-Country, GeoIP2-City, ...)
+// The file is memory mapped once, lookups never leave the host
+type Database struct {
+	reader *maxminddb.Reader
+}
+
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Open a .mmdb file from disk
+// Receiving path (string) as parameter
+// Returning the database (*Database) if success, and error if the file is missing or invalid
+
+func Open(path string) (*Database, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{reader}, nil
+}
+
+// Lookup the country of one ip address
+// Receiving ip (net.IP) as parameter
+// Returning the uppercased ISO 3166-1 alpha-2 code, empty when the address isn't in the database
+
+func (d *Database) Country(ip net.IP) (string, error) {
+	result := record{}
+	err := d.reader.Lookup(ip, &result)
+	if err != nil {
+		return "", err
+	}
+
+	country := result.Country.IsoCode
+	if country == "" {
+		country = result.RegisteredCountry.IsoCode
+	}
+	return strings.ToUpper(country), nil
+}
+
+func (d *Database) Close() error {
+	return d.reader.Close()
+}
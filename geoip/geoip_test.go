@@ -0,0 +1,144 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountry(t *testing.T) {
+	path := writeTestDatabase(t, map[string]string{
+		"203.0.113.0/24":  "ID",
+		"198.51.100.0/25": "us",
+	})
+
+	database, err := Open(path)
+	assert.NoError(t, err)
+	defer database.Close()
+
+	testCases := map[string]string{
+		"203.0.113.9":    "ID",
+		"198.51.100.1":   "US",
+		"198.51.100.200": "",
+		"192.0.2.1":      "",
+	}
+	for ip, expect := range testCases {
+		country, err := database.Country(net.ParseIP(ip))
+		assert.NoError(t, err, ip)
+		assert.Equal(t, expect, country, ip)
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+}
+
+// Write a minimal IPv4 country database in the MaxMind DB format
+// https://maxmind.github.io/MaxMind-DB/
+
+func writeTestDatabase(t *testing.T, countries map[string]string) string {
+	const empty = -1
+
+	// each node holds its two records: a child node index, empty, or -(data offset + 2)
+	nodes := [][2]int{{empty, empty}}
+	data := bytes.Buffer{}
+
+	for cidr, country := range countries {
+		_, network, err := net.ParseCIDR(cidr)
+		assert.NoError(t, err)
+		ones, _ := network.Mask.Size()
+
+		offset := data.Len()
+		writeMap(&data, 1)
+		writeString(&data, "country")
+		writeMap(&data, 1)
+		writeString(&data, "iso_code")
+		writeString(&data, country)
+
+		ip, node := network.IP.To4(), 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -(offset + 2)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	file := bytes.Buffer{}
+	for _, node := range nodes {
+		for _, value := range node {
+			record := value
+			switch {
+			case value == empty:
+				record = len(nodes)
+			case value < 0:
+				record = len(nodes) + 16 + (-value - 2)
+			}
+			file.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	writeMap(&file, 9)
+	writeString(&file, "node_count")
+	writeUint(&file, 6, uint64(len(nodes)))
+	writeString(&file, "record_size")
+	writeUint(&file, 5, 24)
+	writeString(&file, "ip_version")
+	writeUint(&file, 5, 4)
+	writeString(&file, "database_type")
+	writeString(&file, "Test-Country")
+	writeString(&file, "languages")
+	file.Write([]byte{0x00, 11 - 7})
+	writeString(&file, "binary_format_major_version")
+	writeUint(&file, 5, 2)
+	writeString(&file, "binary_format_minor_version")
+	writeUint(&file, 5, 0)
+	writeString(&file, "build_epoch")
+	writeUint(&file, 9, 1700000000)
+	writeString(&file, "description")
+	writeMap(&file, 0)
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	assert.NoError(t, os.WriteFile(path, file.Bytes(), 0o600))
+	return path
+}
+
+func writeControl(buf *bytes.Buffer, dataType, size int) {
+	if dataType > 7 {
+		buf.Write([]byte{byte(size), byte(dataType - 7)})
+		return
+	}
+	buf.WriteByte(byte(dataType<<5 | size))
+}
+
+func writeMap(buf *bytes.Buffer, size int) {
+	writeControl(buf, 7, size)
+}
+
+func writeString(buf *bytes.Buffer, value string) {
+	writeControl(buf, 2, len(value))
+	buf.WriteString(value)
+}
+
+func writeUint(buf *bytes.Buffer, dataType int, value uint64) {
+	encoded := []byte{}
+	for ; value > 0; value >>= 8 {
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+	writeControl(buf, dataType, len(encoded))
+	buf.Write(encoded)
+}
@@ -0,0 +1,196 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+const (
+	maxKeyLength = 255
+	// Time a key stays reserved for a request in progress, so a crashed instance doesn't hold it until the ttl
+	lockTimeout = time.Minute
+	// Time given to store the response once the request is done, whether the client is still there or not
+	storeTimeout = 5 * time.Second
+)
+
+// Replays the response of a request made with an Idempotency-Key header to its retries
+// Keys are scoped to the user, routes that weren't allowed a key ignore the header
+type Middleware struct {
+	repository domain.IdempotencyRepository
+	ttl        time.Duration
+	routes     map[*mux.Route]bool // written while the routes are registered, read only afterwards
+	now        func() time.Time
+}
+
+// Response of a request, passed through to the client and kept to be replayed
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	headers map[string][]string // replayed headers, as they were when the status was written
+	body    bytes.Buffer
+}
+
+func NewMiddleware(repository domain.IdempotencyRepository, ttl time.Duration) *Middleware {
+	return &Middleware{repository: repository, ttl: ttl, routes: map[*mux.Route]bool{}, now: time.Now}
+}
+
+// Allow the requests of route to carry an Idempotency-Key header
+// Returning the route so it can be registered in one statement
+
+func (m *Middleware) Allow(route *mux.Route) *mux.Route {
+	m.routes[route] = true
+	return route
+}
+
+// Run the first request of a key, and answer its retries with the response it got
+// Reusing a key for a different request is a 422, and retrying while the first request is in progress a 409
+// Responses of server errors and 429s aren't kept, so the request can be retried for real
+// To be added with Use on the router the routes belong to, after the auth middleware so the user is known
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("Idempotency-Key")
+		user, authenticated := domain.UserFromContext(req.Context())
+		if key == "" || !authenticated || !m.routes[mux.CurrentRoute(req)] {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		if !validKey(key) {
+			writeError(res, http.StatusBadRequest, "Bad request", fmt.Sprintf("Idempotency-Key must be 1 to %d printable ascii characters", maxKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(res, http.StatusBadGateway, "Bad gateway", err.Error())
+			return
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := m.now()
+		record := domain.IdempotencyRecord{UserID: user.ID, Key: key, RequestHash: requestHash(req, body), ExpiresAt: now.Add(lockTimeout).Unix()}
+		stored, reserved, err := m.repository.Reserve(req.Context(), record, now.Unix())
+		if err != nil {
+			writeError(res, http.StatusBadGateway, "Bad gateway", err.Error())
+			return
+		}
+		if !reserved {
+			replay(res, record, stored)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if recorder.status >= http.StatusInternalServerError || recorder.status == http.StatusTooManyRequests {
+			err = m.repository.Release(ctx, user.ID, key)
+		} else {
+			if recorder.headers == nil {
+				recorder.headers = replayedHeaders(res.Header())
+			}
+			record.StatusCode, record.Response, record.Headers = recorder.status, recorder.body.Bytes(), recorder.headers
+			record.ExpiresAt = now.Add(m.ttl).Unix()
+			err = m.repository.Complete(ctx, record)
+		}
+		if err != nil {
+			log.Printf("failed to store the response of idempotency key %q of user %d: %v", key, user.ID, err)
+		}
+	})
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status, r.headers = status, replayedHeaders(r.Header())
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.headers == nil {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// Answer a request whose key is taken with the response stored for it
+
+func replay(res http.ResponseWriter, record, stored domain.IdempotencyRecord) {
+	if stored.RequestHash != record.RequestHash {
+		writeError(res, http.StatusUnprocessableEntity, "Unprocessable entity", "Idempotency-Key was already used for a different request")
+		return
+	}
+	if stored.StatusCode == 0 {
+		res.Header().Set("Retry-After", "1")
+		writeError(res, http.StatusConflict, "Conflict", "a request with the same Idempotency-Key is in progress")
+		return
+	}
+
+	// responses kept before their headers were stored are all json
+	if stored.Headers == nil {
+		stored.Headers = map[string][]string{"Content-Type": {"application/json"}}
+	}
+	for name, values := range stored.Headers {
+		res.Header()[name] = values
+	}
+	res.Header().Set("Idempotent-Replayed", "true")
+	res.WriteHeader(stored.StatusCode)
+	res.Write(stored.Response)
+}
+
+// Headers of a response replayed along with it: its content type, location, and the quota it ran into
+// The others describe the request that got it, like the RateLimit-* headers, or the connection
+
+func replayedHeaders(header http.Header) map[string][]string {
+	replayed := map[string][]string{}
+	for name, values := range header {
+		if name == "Content-Type" || name == "Location" || name == "Retry-After" || strings.HasPrefix(name, "X-Quota-") {
+			replayed[name] = append([]string(nil), values...)
+		}
+	}
+	return replayed
+}
+
+// Hash of the method, uri and body of a request, query parameters change the response as much as the body
+
+func requestHash(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validKey(key string) bool {
+	if len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func writeError(res http.ResponseWriter, code int, status, message string) {
+	res.Header().Set("Content-Type", "application/json")
+	utils.FormatResponse(res, &utils.ResponseErrorParams{
+		Code:   code,
+		Status: status,
+		Errors: []string{message},
+	})
+}
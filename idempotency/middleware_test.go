@@ -0,0 +1,136 @@
+package idempotency
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newRouter(repository domain.IdempotencyRepository, create http.HandlerFunc) *mux.Router {
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "writer").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksWrite}, nil)
+	authMiddleware := auth.NewMiddleware(authenticator)
+
+	middleware := NewMiddleware(repository, 24*time.Hour)
+	middleware.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	router := mux.NewRouter()
+	authMiddleware.Require(middleware.Allow(router.Path("/create").HandlerFunc(create).Methods("POST")), domain.ScopeLinksWrite)
+	authMiddleware.Require(router.Path("/other").HandlerFunc(create).Methods("POST"), domain.ScopeLinksWrite)
+	router.Use(authMiddleware.Handler)
+	router.Use(middleware.Handler)
+	return router
+}
+
+func serve(router *mux.Router, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer writer")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestMiddleware(t *testing.T) {
+	repository := new(mocks.IdempotencyRepository)
+	created := 0
+	router := newRouter(repository, func(res http.ResponseWriter, req *http.Request) {
+		created++
+		body, _ := io.ReadAll(req.Body)
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("X-Quota-Remaining", "4")
+		res.Header().Set("RateLimit-Remaining", "9")
+		res.WriteHeader(http.StatusCreated)
+		res.Write(body)
+	})
+
+	hash := requestHash(httptest.NewRequest("POST", "/create", nil), []byte(`{"url":"https://www.google.com"}`))
+	reserved := domain.IdempotencyRecord{UserID: 3, Key: "retry-1", RequestHash: hash, ExpiresAt: 1700000060}
+	completed := reserved
+	completed.StatusCode, completed.Response, completed.ExpiresAt = http.StatusCreated, []byte(`{"url":"https://www.google.com"}`), 1700086400
+	// headers describing the request rather than its response aren't kept
+	completed.Headers = map[string][]string{"Content-Type": {"application/json"}, "X-Quota-Remaining": {"4"}}
+
+	repository.On("Reserve", mock.Anything, reserved, int64(1700000000)).Return(reserved, true, nil).Once()
+	repository.On("Complete", mock.Anything, completed).Return(nil).Once()
+	res := serve(router, "/create", "retry-1", `{"url":"https://www.google.com"}`)
+	assert.Equal(t, http.StatusCreated, res.Code)
+	assert.Equal(t, `{"url":"https://www.google.com"}`, res.Body.String())
+
+	// the retry gets the stored response without creating another link
+	repository.On("Reserve", mock.Anything, reserved, int64(1700000000)).Return(completed, false, nil).Once()
+	res = serve(router, "/create", "retry-1", `{"url":"https://www.google.com"}`)
+	assert.Equal(t, http.StatusCreated, res.Code)
+	assert.Equal(t, "true", res.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	assert.Equal(t, "4", res.Header().Get("X-Quota-Remaining"))
+	assert.Empty(t, res.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, `{"url":"https://www.google.com"}`, res.Body.String())
+
+	repository.On("Reserve", mock.Anything, mock.AnythingOfType("domain.IdempotencyRecord"), int64(1700000000)).Return(completed, false, nil).Once()
+	res = serve(router, "/create", "retry-1", `{"url":"https://www.bing.com"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	assert.JSONEq(t, `{"status_code":422,"status":"Unprocessable entity","errors":["Idempotency-Key was already used for a different request"]}`, res.Body.String())
+
+	repository.On("Reserve", mock.Anything, reserved, int64(1700000000)).Return(reserved, false, nil).Once()
+	res = serve(router, "/create", "retry-1", `{"url":"https://www.google.com"}`)
+	assert.Equal(t, http.StatusConflict, res.Code)
+
+	// without a key, or on a route that doesn't allow one, requests go through
+	serve(router, "/create", "", `{"url":"https://www.google.com"}`)
+	serve(router, "/other", "retry-1", `{"url":"https://www.google.com"}`)
+	assert.Equal(t, 3, created)
+
+	res = serve(router, "/create", "retry\n1", `{"url":"https://www.google.com"}`)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	repository.AssertExpectations(t)
+}
+
+func TestMiddlewareFailedRequest(t *testing.T) {
+	repository := new(mocks.IdempotencyRepository)
+	status := http.StatusBadGateway
+	router := newRouter(repository, func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(status)
+	})
+
+	repository.On("Reserve", mock.Anything, mock.AnythingOfType("domain.IdempotencyRecord"), int64(1700000000)).
+		Return(domain.IdempotencyRecord{}, true, nil)
+	repository.On("Release", mock.Anything, 3, "retry-1").Return(nil)
+
+	// server errors and rate limits free the key so the request can be retried
+	assert.Equal(t, http.StatusBadGateway, serve(router, "/create", "retry-1", `{}`).Code)
+	status = http.StatusTooManyRequests
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "/create", "retry-1", `{}`).Code)
+	repository.AssertNumberOfCalls(t, "Release", 2)
+
+	// client errors are kept like successes
+	status = http.StatusBadRequest
+	repository.On("Complete", mock.Anything, mock.AnythingOfType("domain.IdempotencyRecord")).Return(errors.New("connection refused"))
+	assert.Equal(t, http.StatusBadRequest, serve(router, "/create", "retry-1", `{}`).Code)
+	repository.AssertNumberOfCalls(t, "Complete", 1)
+}
+
+func TestMiddlewareRepositoryFailure(t *testing.T) {
+	repository := new(mocks.IdempotencyRepository)
+	router := newRouter(repository, func(res http.ResponseWriter, req *http.Request) {
+		t.Error("the request shouldn't run when its key can't be reserved")
+	})
+
+	repository.On("Reserve", mock.Anything, mock.AnythingOfType("domain.IdempotencyRecord"), int64(1700000000)).
+		Return(domain.IdempotencyRecord{}, false, errors.New("connection refused"))
+	assert.Equal(t, http.StatusBadGateway, serve(router, "/create", "retry-1", `{}`).Code)
+}
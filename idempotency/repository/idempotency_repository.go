@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// MySQL error number of a unique key violation
+const errDuplicateEntry = 1062
+
+type idempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) domain.IdempotencyRepository {
+	return &idempotencyRepository{db}
+}
+
+// Inserting a new idempotency key to idempotency_keys table, once the expired keys of the user are deleted
+// Receiving context, record (domain.IdempotencyRecord), and now (int64) as parameter
+// Returning the record holding the key and true if it was reserved, the stored record and false if it is taken, and error if failed
+
+func (r *idempotencyRepository) Reserve(ctx context.Context, record domain.IdempotencyRecord, now int64) (domain.IdempotencyRecord, bool, error) {
+	_, err := r.db.ExecContext(ctx, queries.DeleteExpiredIdempotencyKeys, record.UserID, now)
+	if err != nil {
+		return domain.IdempotencyRecord{}, false, err
+	}
+
+	_, err = r.db.ExecContext(ctx, queries.InsertIdempotencyKey, record.UserID, record.Key, record.RequestHash, record.ExpiresAt)
+	mysqlErr := &mysql.MySQLError{}
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+		stored := domain.IdempotencyRecord{UserID: record.UserID, Key: record.Key}
+		var headers []byte
+		err = r.db.QueryRowContext(ctx, queries.FindIdempotencyKey, record.UserID, record.Key).
+			Scan(&stored.RequestHash, &stored.StatusCode, &stored.Response, &headers, &stored.ExpiresAt)
+		// keys still in progress have no headers yet
+		if err == nil && len(headers) > 0 {
+			err = json.Unmarshal(headers, &stored.Headers)
+		}
+		return stored, false, err
+	}
+	if err != nil {
+		return domain.IdempotencyRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+// Store the response of the request of an idempotency key in idempotency_keys table
+// Receiving context, and record (domain.IdempotencyRecord) as parameter
+// Returning error if failed
+
+func (r *idempotencyRepository) Complete(ctx context.Context, record domain.IdempotencyRecord) error {
+	headers, err := json.Marshal(record.Headers)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, queries.CompleteIdempotencyKey, record.StatusCode, record.Response, headers, record.ExpiresAt, record.UserID, record.Key)
+	return err
+}
+
+// Delete an idempotency key still in progress from idempotency_keys table
+// Receiving context, userID (int), and key (string) as parameter
+// Returning error if failed
+
+func (r *idempotencyRepository) Release(ctx context.Context, userID int, key string) error {
+	_, err := r.db.ExecContext(ctx, queries.ReleaseIdempotencyKey, userID, key)
+	return err
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func NewMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		panic(err)
+	}
+	return db, mock
+}
+
+func TestReserve(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	record := domain.IdempotencyRecord{UserID: 3, Key: "retry-1", RequestHash: "4f2a", ExpiresAt: 1700000060}
+	mock.ExpectExec(queries.DeleteExpiredIdempotencyKeys).WithArgs(3, 1700000000).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(queries.InsertIdempotencyKey).WithArgs(3, "retry-1", "4f2a", 1700000060).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.DeleteExpiredIdempotencyKeys).WithArgs(3, 1700000001).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(queries.InsertIdempotencyKey).WithArgs(3, "retry-1", "4f2a", 1700000060).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '3-retry-1' for key 'PRIMARY'"})
+	mock.ExpectQuery(queries.FindIdempotencyKey).WithArgs(3, "retry-1").
+		WillReturnRows(mock.NewRows([]string{"request_hash", "status_code", "response", "headers", "expires_at"}).
+			AddRow("4f2a", 201, []byte(`{"status_code":201}`), `{"Content-Type":["application/json"]}`, 1700086400))
+
+	repo := idempotencyRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reserved, ok, err := repo.Reserve(ctx, record, 1700000000)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, record, reserved)
+
+	stored, ok, err := repo.Reserve(ctx, record, 1700000001)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, domain.IdempotencyRecord{UserID: 3, Key: "retry-1", RequestHash: "4f2a", StatusCode: 201,
+		Response: []byte(`{"status_code":201}`), Headers: map[string][]string{"Content-Type": {"application/json"}}, ExpiresAt: 1700086400}, stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompleteAndRelease(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectExec(queries.CompleteIdempotencyKey).
+		WithArgs(201, []byte(`{"status_code":201}`), []byte(`{"Content-Type":["application/json"]}`), 1700086400, 3, "retry-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.ReleaseIdempotencyKey).WithArgs(3, "retry-2").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := idempotencyRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := repo.Complete(ctx, domain.IdempotencyRecord{UserID: 3, Key: "retry-1", StatusCode: 201,
+		Response: []byte(`{"status_code":201}`), Headers: map[string][]string{"Content-Type": {"application/json"}}, ExpiresAt: 1700086400})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Release(ctx, 3, "retry-2"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
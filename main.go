@@ -1,29 +1,175 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	apikeyDelivery "github.com/mrizalr/urlshortener/apikey/delivery"
+	apikeyRepository "github.com/mrizalr/urlshortener/apikey/repository"
+	apikeyUsecase "github.com/mrizalr/urlshortener/apikey/usecase"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/geoip"
+	"github.com/mrizalr/urlshortener/idempotency"
+	idempotencyRepository "github.com/mrizalr/urlshortener/idempotency/repository"
+	"github.com/mrizalr/urlshortener/preview"
+	"github.com/mrizalr/urlshortener/ratelimit"
 	"github.com/mrizalr/urlshortener/url/delivery"
 	"github.com/mrizalr/urlshortener/url/repository"
 	"github.com/mrizalr/urlshortener/url/usecase"
+	usageDelivery "github.com/mrizalr/urlshortener/usage/delivery"
+	usageRepository "github.com/mrizalr/urlshortener/usage/repository"
+	usageUsecase "github.com/mrizalr/urlshortener/usage/usecase"
+	userRepository "github.com/mrizalr/urlshortener/user/repository"
+	workspaceDelivery "github.com/mrizalr/urlshortener/workspace/delivery"
+	workspaceRepository "github.com/mrizalr/urlshortener/workspace/repository"
+	workspaceUsecase "github.com/mrizalr/urlshortener/workspace/usecase"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// Time given to in-flight requests and buffered click events on shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	cfg := config.Load()
+
 	_mux := mux.NewRouter()
-	db, err := sql.Open("mysql", "root:secret@tcp(localhost:2252)/url_short")
+	db, err := sql.Open("mysql", cfg.DatabaseDSN)
 	if err != nil {
 		panic(err)
 	}
+	defer db.Close()
+
+	apiKeyUsecase := apikeyUsecase.NewApiKeyUsecase(apikeyRepository.NewApiKeyRepository(db), userRepository.NewUserRepository(db))
+	if len(os.Args) > 1 && os.Args[1] == "create-api-key" {
+		createApiKey(db, apiKeyUsecase, os.Args[2:])
+		return
+	}
+	var authenticator domain.Authenticator = apiKeyUsecase
+	if cfg.AuthMode == config.AuthModeJWT {
+		keys, err := auth.NewKeySet(context.Background(), cfg.JWKSSource, cfg.JWKSRefreshInterval)
+		if err != nil {
+			log.Fatalf("failed to load the key set of %q: %v", cfg.JWKSSource, err)
+		}
+		authenticator, err = auth.NewJWTAuthenticator(keys, userRepository.NewUserRepository(db), cfg)
+		if err != nil {
+			log.Fatalf("failed to configure jwt authentication: %v", err)
+		}
+	}
+	authMiddleware := auth.NewMiddleware(authenticator)
+
+	var rateLimitStore domain.RateLimitStore = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore != "" {
+		redisStore, err := ratelimit.NewRedisStore(cfg.RateLimitStore)
+		if err != nil {
+			log.Fatalf("failed to configure the rate limit store: %v", err)
+		}
+		defer redisStore.Close()
+		rateLimitStore = redisStore
+	}
+	rateLimiter := ratelimit.NewMiddleware(rateLimitStore, cfg.RateLimitKey, cfg.TrustedProxies)
+	// the middlewares of the root router run before the auth middleware of each subrouter
+	_mux.Use(rateLimiter.AuthHandler(ratelimit.Policy{Name: "auth", Limit: cfg.RateLimitAuth, Period: cfg.RateLimitAuthPeriod}))
+
+	var geoLocator domain.GeoLocator
+	if cfg.GeoIPDatabase != "" {
+		geoDatabase, err := geoip.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			panic(err)
+		}
+		defer geoDatabase.Close()
+		geoLocator = geoDatabase
+	}
+
+	var previewFetcher domain.PreviewFetcher
+	if cfg.PreviewFetchTimeout > 0 {
+		previewFetcher = preview.NewFetcher(cfg.PreviewFetchTimeout)
+	}
 
 	urlRepository := repository.NewUrlRepository(db)
-	urlUsecase := usecase.NewUrlUsecase(urlRepository)
-	delivery.NewUrlHandler(urlUsecase, _mux)
+	workspaces := workspaceRepository.NewWorkspaceRepository(db)
+	urlUsecase := usecase.NewUrlUsecase(urlRepository, workspaces, previewFetcher, cfg)
+	idempotencyMiddleware := idempotency.NewMiddleware(idempotencyRepository.NewIdempotencyRepository(db), cfg.IdempotencyKeyTTL)
+	delivery.NewUrlHandler(urlUsecase, geoLocator, cfg, _mux, authMiddleware, rateLimiter, idempotencyMiddleware)
+	workspaceDelivery.NewWorkspaceHandler(workspaceUsecase.NewWorkspaceUsecase(workspaces, userRepository.NewUserRepository(db)), _mux, authMiddleware)
+	usageDelivery.NewUsageHandler(usageUsecase.NewUsageUsecase(usageRepository.NewUsageRepository(db), workspaces, cfg), _mux, authMiddleware)
+	// with JWTs, the SSO provider issues the credentials
+	if cfg.AuthMode == config.AuthModeApiKey {
+		apikeyDelivery.NewApiKeyHandler(apiKeyUsecase, _mux, authMiddleware)
+	}
+	// expvar publishes the command line and memory stats of the process, admins only
+	debugRouter := _mux.PathPrefix("/debug").Subrouter()
+	authMiddleware.Require(debugRouter.Path("/vars").Handler(expvar.Handler()).Methods("GET"), domain.ScopeAdmin)
+	debugRouter.Use(authMiddleware.Handler)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: _mux}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("failed to shutdown server: %v", err)
+	}
+
+	// redirects are done, whatever is still buffered can be flushed
+	err = urlUsecase.Close(ctx)
+	if err != nil {
+		log.Printf("failed to flush click events: %v", err)
+	}
+}
+
+// Bootstrap a key from the command line, as the first one can't be created through the api
+// Usage: create-api-key <email> <name> <scope>...
+// The user is created when it doesn't exist yet, as an admin when the admin scope is asked for
+
+func createApiKey(db *sql.DB, apiKeyUsecase domain.ApiKeyUsecase, args []string) {
+	if len(args) < 3 {
+		log.Fatal("usage: create-api-key <email> <name> <scope>...")
+	}
+	email, name, scopes := args[0], args[1], args[2:]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := userRepository.NewUserRepository(db)
+	user, err := users.FindByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		user = domain.User{Name: email, Email: email, Role: domain.UserRoleMember, CreatedAt: time.Now().Unix()}
+		if domain.HasScope(scopes, domain.ScopeAdmin) {
+			user.Role = domain.UserRoleAdmin
+		}
+		user.ID, err = users.Create(ctx, user)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", 8080), _mux))
+	ctx = domain.ContextWithScopes(domain.ContextWithUser(ctx, user), []string{domain.ScopeAdmin})
+	key, err := apiKeyUsecase.CreateApiKey(ctx, domain.CreateApiKeyRequest{Name: name, Scopes: scopes})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(key.Key)
 }
@@ -0,0 +1,194 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"golang.org/x/net/html"
+)
+
+// Bytes of a destination page read while looking for its meta tags, the head is expected well within
+const maxPageSize = 512 * 1024
+
+const maxRedirects = 5
+
+const userAgent = "Mozilla/5.0 (compatible; urlshortener-preview/1.0)"
+
+var (
+	ErrNotHTML        = errors.New("destination isn't an html page")
+	ErrPrivateAddress = errors.New("destination resolves to a private address")
+)
+
+// Link preview fetcher reading the Open Graph and Twitter meta tags of a destination page over HTTP
+// Destinations resolving to loopback, private or link-local addresses are refused
+type Fetcher struct {
+	client       *http.Client
+	allowPrivate bool // only set by tests, which serve pages from loopback
+}
+
+// Create a fetcher giving up on a destination after timeout, redirects included
+// Receiving timeout (time.Duration) as parameter
+// Returning the fetcher (*Fetcher)
+
+func NewFetcher(timeout time.Duration) *Fetcher {
+	f := &Fetcher{}
+	dialer := &net.Dialer{Timeout: timeout, Control: f.checkAddress}
+	f.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// Refuse connections to addresses of the host network, checked on the resolved address
+// so a public hostname pointing to a private address is refused as well
+
+func (f *Fetcher) checkAddress(network, address string, conn syscall.RawConn) error {
+	if f.allowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// Fetch the preview of one destination page
+// Receiving ctx (context.Context) and destination (string) as parameter
+// Returning the preview (domain.LinkPreview) if success, and error if the page can't be fetched or isn't html
+
+func (f *Fetcher) Fetch(ctx context.Context, destination string) (domain.LinkPreview, error) {
+	result := domain.LinkPreview{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return result, fmt.Errorf("destination responded with status %d", res.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return result, ErrNotHTML
+	}
+
+	result = parse(io.LimitReader(res.Body, maxPageSize))
+	result.Image = absoluteUrl(res.Request.URL, result.Image)
+	return result, nil
+}
+
+// Read the preview from the meta tags of an html document
+// Open Graph tags win over Twitter ones, which win over the plain title and description
+
+func parse(page io.Reader) domain.LinkPreview {
+	tags := map[string]string{}
+	title, inTitle := "", false
+
+	tokenizer := html.NewTokenizer(page)
+tokens:
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			break tokens
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "meta":
+				key, content := "", ""
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(strings.TrimSpace(attr.Val))
+						}
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				if _, ok := tags[key]; !ok && key != "" && content != "" {
+					tags[key] = content
+				}
+			case "title":
+				inTitle = title == ""
+			case "body":
+				// meta tags belong in the head, the rest of the page isn't needed
+				break tokens
+			}
+		case html.TextToken:
+			if inTitle {
+				title = strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+			}
+		case html.EndTagToken:
+			inTitle = false
+		}
+	}
+
+	return domain.LinkPreview{
+		Title:       firstOf(tags["og:title"], tags["twitter:title"], title),
+		Description: firstOf(tags["og:description"], tags["twitter:description"], tags["description"]),
+		Image:       firstOf(tags["og:image:secure_url"], tags["og:image"], tags["og:image:url"], tags["twitter:image"], tags["twitter:image:src"]),
+	}
+}
+
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Resolve a possibly relative image url against the page url, dropping anything that isn't http(s)
+
+func absoluteUrl(page *url.URL, image string) string {
+	if image == "" {
+		return ""
+	}
+
+	ref, err := url.Parse(image)
+	if err != nil {
+		return ""
+	}
+	resolved := page.ResolveReference(ref)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return ""
+	}
+	return resolved.String()
+}
@@ -0,0 +1,88 @@
+package preview
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>
+		Example   Domain
+	</title>
+	<meta name="description" content="Plain description">
+	<meta name="twitter:title" content="Twitter title">
+	<meta property="og:description" content=" Open Graph description ">
+	<meta property="og:image" content="/images/card.png">
+</head>
+<body>
+	<meta property="og:title" content="Not in the head">
+</body>
+</html>`
+
+func testServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		res.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/moved", func(res http.ResponseWriter, req *http.Request) {
+		http.Redirect(res, req, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/file.pdf", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/pdf")
+		res.Write([]byte("%PDF-1.4"))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestFetch(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	fetcher := NewFetcher(time.Second)
+	fetcher.allowPrivate = true
+
+	preview, err := fetcher.Fetch(context.Background(), server.URL+"/moved")
+	assert.NoError(t, err)
+	assert.Equal(t, domain.LinkPreview{
+		Title:       "Twitter title",
+		Description: "Open Graph description",
+		Image:       server.URL + "/images/card.png",
+	}, preview)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/file.pdf")
+	assert.ErrorIs(t, err, ErrNotHTML)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/missing")
+	assert.ErrorContains(t, err, "status 404")
+}
+
+func TestFetchPrivateAddress(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	_, err := NewFetcher(time.Second).Fetch(context.Background(), server.URL+"/page")
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+}
+
+func TestParse(t *testing.T) {
+	preview := parse(strings.NewReader(`<html><head><title>Only a title</title>` +
+		`<meta property="og:image" content="javascript:alert(1)"></head></html>`))
+	assert.Equal(t, "Only a title", preview.Title)
+	assert.Empty(t, preview.Description)
+	page, _ := url.Parse("https://example.com/a/b")
+	assert.Empty(t, absoluteUrl(page, preview.Image))
+	assert.Equal(t, "https://example.com/a/card.png", absoluteUrl(page, "card.png"))
+
+	assert.Equal(t, domain.LinkPreview{}, parse(strings.NewReader("not html at all")))
+}
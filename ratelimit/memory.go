@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often expired buckets are removed from a memory store
+const memorySweepInterval = time.Minute
+
+// Rate limiter store of a single instance, its buckets are lost on restart
+type MemoryStore struct {
+	mu      sync.Mutex
+	states  map[string]memoryState
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+type memoryState struct {
+	value     int64
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]memoryState{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit int, period time.Duration, now int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clock := s.now()
+	if clock.Sub(s.sweptAt) >= memorySweepInterval {
+		for stateKey, state := range s.states {
+			if !clock.Before(state.expiresAt) {
+				delete(s.states, stateKey)
+			}
+		}
+		s.sweptAt = clock
+	}
+
+	state, ok := s.states[key]
+	if !ok || !clock.Before(state.expiresAt) {
+		state.value = 0
+	}
+	next, allowed := take(state.value, Policy{Limit: limit, Period: period}, now)
+	if allowed {
+		s.states[key] = memoryState{value: next, expiresAt: clock.Add(period)}
+	}
+	return next, allowed, nil
+}
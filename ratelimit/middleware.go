@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+// Allows Limit requests per Period to each client, in bursts of up to Limit requests
+type Policy struct {
+	Name   string // keeps the buckets of the policies of a client apart
+	Limit  int
+	Period time.Duration
+}
+
+// Outcome of taking a token from a bucket
+type result struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next token, when the request isn't allowed
+}
+
+// Token bucket rate limiting of api routes, routes that weren't given a policy aren't limited
+// Authenticated requests are counted by one of the config.RateLimitKey*, anonymous ones by client ip
+// Requests carrying credentials can also be limited by client ip before they are authenticated
+type Middleware struct {
+	store          domain.RateLimitStore
+	keyBy          string
+	trustedProxies []*net.IPNet
+	policies       map[*mux.Route]Policy // written while the routes are registered, read only afterwards
+	now            func() time.Time
+}
+
+func NewMiddleware(store domain.RateLimitStore, keyBy string, trustedProxies []*net.IPNet) *Middleware {
+	return &Middleware{store: store, keyBy: keyBy, trustedProxies: trustedProxies, policies: map[*mux.Route]Policy{}, now: time.Now}
+}
+
+// Limit the requests of route by policy, a policy without a limit or period leaves the route unlimited
+// Returning the route so it can be registered in one statement
+
+func (m *Middleware) Limit(route *mux.Route, policy Policy) *mux.Route {
+	if policy.enabled() {
+		m.policies[route] = policy
+	}
+	return route
+}
+
+// Whether the policy limits anything, a policy whose tokens would come back in less than a nanosecond can't
+// and is logged
+
+func (p Policy) enabled() bool {
+	if p.Limit <= 0 || p.Period <= 0 {
+		return false
+	}
+	if p.Period < time.Duration(p.Limit) {
+		log.Printf("ignoring rate limit policy %s of %d requests per %s, shorter than a nanosecond per request", p.Name, p.Limit, p.Period)
+		return false
+	}
+	return true
+}
+
+// Take a token from the bucket of the client for the requests of the limited routes, answering 429 when it's empty
+// The RateLimit-* headers tell clients where they stand, a store failing lets the requests through
+// To be added with Use on the router the routes belong to, after the auth middleware so the user is known
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		policy, ok := m.policies[mux.CurrentRoute(req)]
+		if !ok || m.limit(res, req, policy, m.clientKey(req)) {
+			next.ServeHTTP(res, req)
+		}
+	})
+}
+
+// Take a token from the bucket of the client ip for the requests carrying credentials, before they are checked,
+// so a flood of bad credentials doesn't reach the authenticator
+// Requests without credentials are rejected by the auth middleware without any lookup, public routes have their
+// own limits. A policy without a limit or period leaves the requests unlimited
+// To be added with Use on the router, before the auth middleware
+
+func (m *Middleware) AuthHandler(policy Policy) mux.MiddlewareFunc {
+	enabled := policy.enabled()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if !enabled || req.Header.Get("Authorization") == "" ||
+				m.limit(res, req, policy, "ip:"+utils.ClientIP(req, m.trustedProxies)) {
+				next.ServeHTTP(res, req)
+			}
+		})
+	}
+}
+
+// Take a token from the bucket of client for policy, setting the RateLimit-* headers
+// Returning whether the request goes on, the 429 is already written when it doesn't
+
+func (m *Middleware) limit(res http.ResponseWriter, req *http.Request, policy Policy, client string) bool {
+	key := fmt.Sprintf("ratelimit:%s:%s", policy.Name, client)
+	now := m.now().UnixNano()
+	state, allowed, err := m.store.Take(req.Context(), key, policy.Limit, policy.Period, now)
+	if err != nil {
+		log.Printf("failed to rate limit %s: %v", key, err)
+		return true
+	}
+
+	outcome := outcomeOf(state, allowed, policy, now)
+	res.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+	res.Header().Set("RateLimit-Remaining", strconv.Itoa(outcome.remaining))
+	res.Header().Set("RateLimit-Reset", strconv.FormatInt(seconds(outcome.reset), 10))
+	res.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, seconds(policy.Period)))
+	if !outcome.allowed {
+		res.Header().Set("Retry-After", strconv.FormatInt(seconds(outcome.retryAfter), 10))
+		res.Header().Set("Content-Type", "application/json")
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusTooManyRequests,
+			Status: "Too many requests",
+			Errors: []string{fmt.Sprintf("rate limit of %d requests per %s exceeded", policy.Limit, policy.Period)},
+		})
+		return false
+	}
+	return true
+}
+
+// Client a request is counted for, api keys are hashed so they aren't kept in the store
+// Counting by api key gives each key of a user its own limit
+
+func (m *Middleware) clientKey(req *http.Request) string {
+	user, ok := domain.UserFromContext(req.Context())
+	if ok && m.keyBy == config.RateLimitKeyApiKey {
+		if token := auth.BearerToken(req); token != "" {
+			sum := sha256.Sum256([]byte(token))
+			return "apikey:" + hex.EncodeToString(sum[:16])
+		}
+	}
+	if ok && m.keyBy != config.RateLimitKeyIP {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	return "ip:" + utils.ClientIP(req, m.trustedProxies)
+}
+
+// Take a token from the bucket of policy, whose state is the time (unix nanoseconds) it is full again
+// Each token pushes that time one emission interval further, as long as it stays within a period from now
+// Returning the new state, unchanged when the bucket is empty, and whether a token was taken
+
+func take(fullAt int64, policy Policy, now int64) (int64, bool) {
+	interval := policy.interval()
+	if fullAt < now {
+		fullAt = now
+	}
+
+	next := fullAt + interval
+	if next-now > int64(policy.Period) {
+		return fullAt, false
+	}
+	return next, true
+}
+
+// Where the client stands after a take from the bucket of policy left it in state
+
+func outcomeOf(state int64, allowed bool, policy Policy, now int64) result {
+	interval := policy.interval()
+	if !allowed {
+		return result{reset: time.Duration(state - now), retryAfter: time.Duration(state + interval - now - int64(policy.Period))}
+	}
+	return result{
+		allowed:   true,
+		remaining: int((int64(policy.Period) - (state - now)) / interval),
+		reset:     time.Duration(state - now),
+	}
+}
+
+// Time (nanoseconds) a token takes to come back, at least a nanosecond so policies the middleware ignores
+// still can't divide by zero
+
+func (p Policy) interval() int64 {
+	interval := int64(p.Period) / int64(p.Limit)
+	if interval < 1 {
+		return 1
+	}
+	return interval
+}
+
+// Whole seconds of duration, rounded up so clients never retry too early
+
+func seconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
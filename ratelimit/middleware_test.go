@@ -0,0 +1,231 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit int, period time.Duration, now int64) (int64, bool, error) {
+	return 0, false, errors.New("connection refused")
+}
+
+func TestTake(t *testing.T) {
+	policy := Policy{Name: "create", Limit: 3, Period: 3 * time.Second}
+	now := time.Now().UnixNano()
+
+	state, allowed := take(0, policy, now)
+	assert.Equal(t, result{allowed: true, remaining: 2, reset: time.Second}, outcomeOf(state, allowed, policy, now))
+	state, allowed = take(state, policy, now)
+	assert.Equal(t, result{allowed: true, remaining: 1, reset: 2 * time.Second}, outcomeOf(state, allowed, policy, now))
+	state, allowed = take(state, policy, now)
+	assert.Equal(t, result{allowed: true, remaining: 0, reset: 3 * time.Second}, outcomeOf(state, allowed, policy, now))
+
+	// an empty bucket leaves the state as is
+	later := now + int64(500*time.Millisecond)
+	denied, allowed := take(state, policy, later)
+	assert.Equal(t, state, denied)
+	assert.Equal(t, result{reset: 2500 * time.Millisecond, retryAfter: 500 * time.Millisecond}, outcomeOf(denied, allowed, policy, later))
+
+	// one token back per second
+	later = now + int64(time.Second)
+	next, allowed := take(state, policy, later)
+	assert.Equal(t, result{allowed: true, remaining: 0, reset: 3 * time.Second}, outcomeOf(next, allowed, policy, later))
+	later = now + int64(time.Minute)
+	next, allowed = take(state, policy, later)
+	assert.Equal(t, result{allowed: true, remaining: 2, reset: time.Second}, outcomeOf(next, allowed, policy, later))
+}
+
+func TestMiddleware(t *testing.T) {
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "writer").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksWrite}, nil)
+	authenticator.On("Authenticate", mock.Anything, "other-writer-key").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksWrite}, nil)
+
+	ok := func(res http.ResponseWriter, req *http.Request) {}
+	newRouter := func(keyBy string) *mux.Router {
+		now := time.Unix(1700000000, 0)
+		limiter := NewMiddleware(NewMemoryStore(), keyBy, []*net.IPNet{{IP: net.IPv4(10, 0, 0, 1), Mask: net.CIDRMask(32, 32)}})
+		limiter.now = func() time.Time { return now }
+		authMiddleware := auth.NewMiddleware(authenticator)
+
+		router := mux.NewRouter()
+		authMiddleware.Require(limiter.Limit(router.Path("/create").HandlerFunc(ok), Policy{Name: "create", Limit: 2, Period: time.Minute}), domain.ScopeLinksWrite)
+		limiter.Limit(router.Path("/{short}").HandlerFunc(ok), Policy{Name: "redirect"})
+		router.Use(authMiddleware.Handler)
+		router.Use(limiter.Handler)
+		return router
+	}
+	serve := func(router *mux.Router, path, token, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.RemoteAddr = remoteAddr
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	router := newRouter(config.RateLimitKeyUser)
+	res := serve(router, "/create", "writer", "192.0.2.1:5000")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "2", res.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", res.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", res.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", res.Header().Get("RateLimit-Policy"))
+
+	// the user is limited whatever its key or address
+	res = serve(router, "/create", "other-writer-key", "192.0.2.2:5000")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "0", res.Header().Get("RateLimit-Remaining"))
+	res = serve(router, "/create", "writer", "192.0.2.3:5000")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "30", res.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"status_code":429,"status":"Too many requests","errors":["rate limit of 2 requests per 1m0s exceeded"]}`, res.Body.String())
+
+	// unauthenticated requests are rejected before they are counted, and a route without a limit isn't limited
+	assert.Equal(t, http.StatusUnauthorized, serve(router, "/create", "", "192.0.2.1:5000").Code)
+	res = serve(router, "/ha51Fad", "", "192.0.2.1:5000")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("RateLimit-Limit"))
+
+	router = newRouter(config.RateLimitKeyApiKey)
+	serve(router, "/create", "writer", "192.0.2.1:5000")
+	serve(router, "/create", "writer", "192.0.2.1:5000")
+	assert.Equal(t, http.StatusTooManyRequests, serve(router, "/create", "writer", "192.0.2.1:5000").Code)
+	assert.Equal(t, http.StatusOK, serve(router, "/create", "other-writer-key", "192.0.2.1:5000").Code)
+}
+
+func TestMiddlewareAuthHandler(t *testing.T) {
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "guess").Return(domain.User{}, []string(nil), domain.ErrInvalidCredentials)
+
+	limiter := NewMiddleware(NewMemoryStore(), config.RateLimitKeyUser, nil)
+	authMiddleware := auth.NewMiddleware(authenticator)
+	router := mux.NewRouter()
+	authMiddleware.Require(router.Path("/create").HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}), domain.ScopeLinksWrite)
+	router.Use(limiter.AuthHandler(Policy{Name: "auth", Limit: 2, Period: time.Minute}))
+	router.Use(authMiddleware.Handler)
+
+	serve := func(token, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/create", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.RemoteAddr = remoteAddr
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	// bad credentials are counted by client ip before they reach the authenticator
+	assert.Equal(t, http.StatusUnauthorized, serve("guess", "192.0.2.1:5000").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("guess", "192.0.2.1:5000").Code)
+	res := serve("guess", "192.0.2.1:5000")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "30", res.Header().Get("Retry-After"))
+	authenticator.AssertNumberOfCalls(t, "Authenticate", 2)
+
+	// requests without credentials aren't counted
+	assert.Equal(t, http.StatusUnauthorized, serve("", "192.0.2.1:5000").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("guess", "192.0.2.2:5000").Code)
+}
+
+func TestMiddlewareInvalidPolicy(t *testing.T) {
+	limiter := NewMiddleware(NewMemoryStore(), config.RateLimitKeyIP, nil)
+	router := mux.NewRouter()
+	// 10 requests per 5ns would take a token every 0ns
+	limiter.Limit(router.Path("/{short}").HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}), Policy{Name: "redirect", Limit: 10, Period: 5})
+	router.Use(limiter.AuthHandler(Policy{Name: "auth", Limit: 10, Period: 5}))
+	router.Use(limiter.Handler)
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("GET", "/ha51Fad", nil)
+		req.Header.Set("Authorization", "Bearer guess")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, res.Header().Get("RateLimit-Limit"))
+	}
+
+	// stores don't divide by zero either
+	_, allowed, err := NewMemoryStore().Take(context.Background(), "ratelimit:redirect:ip:192.0.2.1", 10, 5, time.Now().UnixNano())
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestMiddlewareClientIP(t *testing.T) {
+	limiter := NewMiddleware(NewMemoryStore(), config.RateLimitKeyUser, []*net.IPNet{{IP: net.IPv4(10, 0, 0, 1), Mask: net.CIDRMask(32, 32)}})
+	router := mux.NewRouter()
+	limiter.Limit(router.Path("/{short}").HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}), Policy{Name: "redirect", Limit: 1, Period: time.Minute})
+	router.Use(limiter.Handler)
+
+	serve := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest("GET", "/ha51Fad", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// clients behind the trusted proxy have their own bucket, a spoofed header from elsewhere is ignored
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:5000", "198.51.100.7"))
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:5000", "198.51.100.8"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:5000", "198.51.100.7"))
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1:5000", "198.51.100.9"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:5000", "198.51.100.10"))
+}
+
+func TestMiddlewareStoreFailure(t *testing.T) {
+	limiter := NewMiddleware(failingStore{}, config.RateLimitKeyIP, nil)
+	router := mux.NewRouter()
+	limiter.Limit(router.Path("/{short}").HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}), Policy{Name: "redirect", Limit: 1, Period: time.Minute})
+	router.Use(limiter.Handler)
+
+	for i := 0; i < 3; i++ {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest("GET", "/ha51Fad", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	store.Take(ctx, "a", 2, time.Second, now.UnixNano())
+	store.Take(ctx, "a", 2, time.Second, now.UnixNano())
+	_, allowed, _ := store.Take(ctx, "a", 2, time.Second, now.UnixNano())
+	assert.False(t, allowed)
+	store.Take(ctx, "b", 2, time.Hour, now.UnixNano())
+	assert.Equal(t, now.Add(time.Second).UnixNano(), store.states["a"].value)
+
+	// expired states start over, and are swept once in a while
+	now = now.Add(time.Second)
+	state, allowed, _ := store.Take(ctx, "a", 2, time.Second, now.UnixNano())
+	assert.True(t, allowed)
+	assert.Equal(t, now.Add(500*time.Millisecond).UnixNano(), state)
+
+	now = now.Add(2 * memorySweepInterval)
+	state, _, _ = store.Take(ctx, "b", 2, time.Hour, now.UnixNano())
+	assert.Equal(t, map[string]memoryState{"b": {value: state, expiresAt: now.Add(time.Hour)}}, store.states)
+}
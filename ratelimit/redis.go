@@ -0,0 +1,310 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	redisTimeout   = time.Second // per command, when the context has no deadline
+	redisIdleConns = 16
+	// Bounds of the replies read, well above what the store gets, so a corrupt length doesn't allocate gigabytes
+	maxRedisBulkLength  = 1 << 20
+	maxRedisArrayLength = 1 << 10
+)
+
+// Takes a token like take does, in a single round trip the server runs atomically
+// KEYS[1] is the bucket, ARGV the limit, period (nanoseconds), now (unix nanoseconds) and expiry (milliseconds)
+// of its state. Lua numbers are doubles, precise to a fraction of a microsecond at today's unix nanoseconds
+const takeScript = `local limit, period, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local interval = math.max(math.floor(period / limit), 1)
+local fullAt = math.max(tonumber(redis.call('GET', KEYS[1]) or 0), now)
+local nextAt = fullAt + interval
+if nextAt - now > period then
+  return {string.format('%.0f', fullAt), 0}
+end
+redis.call('SET', KEYS[1], string.format('%.0f', nextAt), 'PX', ARGV[4])
+return {string.format('%.0f', nextAt), 1}`
+
+// Rate limiter store shared through a server speaking the Redis protocol, Redis itself or a compatible one
+// with Lua scripting, each take is a script run on the server
+type RedisStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	idle     chan *redisConn
+}
+
+// A Redis error reply
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Receiving a redis://[[username]:password@]host[:port][/db] url as parameter
+// Returning a store connecting on first use
+
+func NewRedisStore(rawUrl string) (*RedisStore, error) {
+	redisUrl, err := url.Parse(rawUrl)
+	if err != nil || redisUrl.Scheme != "redis" || redisUrl.Hostname() == "" {
+		return nil, errors.New("the redis url must look like redis://[[username]:password@]host[:port][/db]")
+	}
+
+	store := &RedisStore{addr: redisUrl.Host, idle: make(chan *redisConn, redisIdleConns)}
+	if redisUrl.Port() == "" {
+		store.addr = net.JoinHostPort(redisUrl.Hostname(), "6379")
+	}
+	if password, ok := redisUrl.User.Password(); ok {
+		store.username, store.password = redisUrl.User.Username(), password
+	}
+	if db := strings.Trim(redisUrl.Path, "/"); db != "" {
+		store.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return store, nil
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit int, period time.Duration, now int64) (int64, bool, error) {
+	conn, pooled, err := s.conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	state, allowed, err := s.take(ctx, conn, key, limit, period, now)
+	if err != nil {
+		// the connection may be left in the middle of a reply
+		conn.conn.Close()
+		// an idle connection may have been closed by the server since it was used, the take is tried again once
+		// on a new one. At worst the server did run it, and the client loses a token it didn't use
+		if !pooled || !closedConn(err) {
+			return 0, false, err
+		}
+		conn, err = s.dial(ctx)
+		if err != nil {
+			return 0, false, err
+		}
+		state, allowed, err = s.take(ctx, conn, key, limit, period, now)
+		if err != nil {
+			conn.conn.Close()
+			return 0, false, err
+		}
+	}
+
+	select {
+	case s.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+	return state, allowed, nil
+}
+
+// Close the idle connections
+
+func (s *RedisStore) Close() error {
+	for {
+		select {
+		case conn := <-s.idle:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (s *RedisStore) take(ctx context.Context, conn *redisConn, key string, limit int, period time.Duration, now int64) (int64, bool, error) {
+	ttlMilliseconds := period.Milliseconds()
+	if ttlMilliseconds < 1 {
+		ttlMilliseconds = 1
+	}
+
+	reply, err := conn.do(ctx, "EVAL", takeScript, "1", key, strconv.Itoa(limit), strconv.FormatInt(int64(period), 10),
+		strconv.FormatInt(now, 10), strconv.FormatInt(ttlMilliseconds, 10))
+	if err != nil {
+		return 0, false, err
+	}
+
+	replies, _ := reply.([]interface{})
+	if len(replies) != 2 {
+		return 0, false, fmt.Errorf("invalid rate limit reply %v of %s", reply, key)
+	}
+	value, _ := replies[0].(string)
+	state, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid rate limit state %q of %s", value, key)
+	}
+	allowed, _ := replies[1].(int64)
+	return state, allowed == 1, nil
+}
+
+// An idle connection and true, or a new one and false
+
+func (s *RedisStore) conn(ctx context.Context) (*redisConn, bool, error) {
+	select {
+	case conn := <-s.idle:
+		return conn, true, nil
+	default:
+	}
+
+	conn, err := s.dial(ctx)
+	return conn, false, err
+}
+
+// A new connection, authenticated and on the configured database
+
+func (s *RedisStore) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: redisTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, s.password}
+		}
+		_, err = conn.do(ctx, args...)
+	}
+	if err == nil && s.db != 0 {
+		_, err = conn.do(ctx, "SELECT", strconv.Itoa(s.db))
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Whether err is the connection being closed by the server, rather than a timeout or an error reply
+
+func closedConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Send a command and read its reply: a string, an int64, a []interface{} of replies, or nil for a null reply
+// An error reply is returned as a redisError, even when it's one of the replies of an array, as scripts return
+
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	command := strings.Builder{}
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, command.String())
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := readReply(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	err = replyError(reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// The first error reply of reply, looking into arrays
+
+func replyError(reply interface{}) error {
+	switch reply := reply.(type) {
+	case redisError:
+		return reply
+	case []interface{}:
+		for _, element := range reply {
+			err := replyError(element)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return redisError(value), nil
+	case ':':
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		return number, nil
+	case '$':
+		length, err := strconv.Atoi(value)
+		if err != nil || length > maxRedisBulkLength {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		// a null bulk string
+		if length < 0 {
+			return nil, nil
+		}
+		data := make([]byte, length+2)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		if string(data[length:]) != "\r\n" {
+			return nil, fmt.Errorf("redis: bulk string of %d bytes not ended by a line break", length)
+		}
+		return string(data[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(value)
+		if err != nil || length > maxRedisArrayLength {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		// a null array
+		if length < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, length)
+		for i := range replies {
+			replies[i], err = readReply(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
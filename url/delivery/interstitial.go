@@ -0,0 +1,136 @@
+package delivery
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// Query parameter asking for the interstitial page
+const previewParam = "preview"
+
+// Query parameter of the continue link of a forced interstitial page, a token signed for the visitor
+const continueParam = "continue"
+
+// Time the continue link of a forced interstitial page stays valid
+const continueTokenLifetime = 10 * time.Minute
+
+// Short url suffix asking for the interstitial page, e.g. /abc123+
+const previewSuffix = "+"
+
+// Tell whether a request gets the interstitial page instead of the redirect
+// The + suffix always asks for the page, a link forcing it is only skipped through the continue link of its
+// own page, so a shared ?preview=0 doesn't get anyone past it
+
+func (h *UrlHandler) wantsInterstitial(req *http.Request, url domain.Url, suffixed bool) bool {
+	if suffixed {
+		return true
+	}
+	if url.ForcePreview {
+		return !h.validContinueToken(req, url, req.URL.Query().Get(continueParam))
+	}
+	preview, _ := strconv.ParseBool(req.URL.Query().Get(previewParam))
+	return preview
+}
+
+// Address the continue button of the interstitial page points to
+// The short url without its + suffix, keeping the incoming query for passthrough
+
+func (h *UrlHandler) continueUrl(req *http.Request, link domain.Url) string {
+	query := req.URL.Query()
+	query.Del(previewParam)
+	query.Del(continueParam)
+	if link.ForcePreview {
+		query.Set(continueParam, h.continueToken(req, link, time.Now().Add(continueTokenLifetime).Unix()))
+	}
+
+	next := url.URL{Path: strings.TrimSuffix(req.URL.Path, previewSuffix), RawQuery: query.Encode()}
+	return next.String()
+}
+
+// Token of the continue link of a forced interstitial page: its expiry and a signature of the link, the expiry
+// and the client ip, so it's of no use once expired or to anyone it's passed on to
+
+func (h *UrlHandler) continueToken(req *http.Request, link domain.Url, expiresAt int64) string {
+	mac := hmac.New(sha256.New, h.continueKey)
+	fmt.Fprintf(mac, "%s\n%d\n%s", link.ShortUrl, expiresAt, h.clientIP(req))
+	return strconv.FormatInt(expiresAt, 10) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *UrlHandler) validContinueToken(req *http.Request, link domain.Url, token string) bool {
+	expiry, _, found := strings.Cut(token, ".")
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if !found || err != nil || expiresAt < time.Now().Unix() {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(h.continueToken(req, link, expiresAt)))
+}
+
+// Key signing the continue links, a random one when none is configured, whose links then only work on the
+// instance that rendered the page until it restarts
+
+func continueKey(cfg config.Config) []byte {
+	if cfg.PreviewSigningKey != "" {
+		return []byte(cfg.PreviewSigningKey)
+	}
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		log.Fatalf("failed to generate the preview signing key: %v", err)
+	}
+	return key
+}
+
+// Warnings about a destination shown on the interstitial page, empty when nothing looks suspicious
+// Only the address itself is checked, the destination is never fetched
+
+func safetyWarnings(destination string) []string {
+	target, err := url.Parse(destination)
+	if err != nil || target.Host == "" {
+		return []string{"The destination isn't a valid web address."}
+	}
+
+	warnings := []string{}
+	if target.Scheme != "https" {
+		warnings = append(warnings, "The connection to the destination isn't encrypted.")
+	}
+	if target.User != nil {
+		warnings = append(warnings, "The address contains a user name, which can hide the real domain.")
+	}
+	if net.ParseIP(target.Hostname()) != nil {
+		warnings = append(warnings, "The destination is an IP address instead of a domain name.")
+	}
+	for _, label := range strings.Split(target.Hostname(), ".") {
+		if strings.HasPrefix(strings.ToLower(label), "xn--") {
+			warnings = append(warnings, "The domain uses international characters that can imitate another domain.")
+			break
+		}
+	}
+	if target.Port() != "" {
+		warnings = append(warnings, "The destination uses a non standard port.")
+	}
+	return warnings
+}
+
+// Scheme and host of a destination, shown instead of the full address for single use links
+// so the interstitial page can't be used to read the address without consuming the link
+
+func destinationOrigin(destination string) string {
+	target, err := url.Parse(destination)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: target.Scheme, Host: target.Host}).String()
+}
@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWantsInterstitial(t *testing.T) {
+	handler := UrlHandler{continueKey: []byte("secret")}
+	link := domain.Url{ShortUrl: "abc123"}
+	token := handler.continueToken(httptest.NewRequest("GET", "/api/v1/url/abc123", nil), link, time.Now().Add(time.Minute).Unix())
+	expired := handler.continueToken(httptest.NewRequest("GET", "/api/v1/url/abc123", nil), link, time.Now().Add(-time.Minute).Unix())
+
+	testCases := []struct {
+		target   string
+		suffixed bool
+		force    bool
+		expect   bool
+	}{
+		{"/api/v1/url/abc123", false, false, false},
+		{"/api/v1/url/abc123+", true, false, true},
+		{"/api/v1/url/abc123?preview=1", false, false, true},
+		{"/api/v1/url/abc123?preview=true", false, false, true},
+		{"/api/v1/url/abc123", false, true, true},
+		{"/api/v1/url/abc123?preview=0", false, true, true},
+		{"/api/v1/url/abc123?preview=maybe", false, true, true},
+		{"/api/v1/url/abc123?continue=" + token, false, true, false},
+		{"/api/v1/url/abc123?continue=" + expired, false, true, true},
+		{"/api/v1/url/abc123?continue=" + token + "x", false, true, true},
+		{"/api/v1/url/abc123+?continue=" + token, true, true, true},
+	}
+
+	for _, testCase := range testCases {
+		req := httptest.NewRequest("GET", testCase.target, nil)
+		link.ForcePreview = testCase.force
+		assert.Equal(t, testCase.expect, handler.wantsInterstitial(req, link, testCase.suffixed), testCase.target)
+	}
+
+	// the token is signed for the client it was given to
+	req := httptest.NewRequest("GET", "/api/v1/url/abc123?continue="+token, nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	assert.True(t, handler.wantsInterstitial(req, domain.Url{ShortUrl: "abc123", ForcePreview: true}, false))
+	assert.True(t, handler.wantsInterstitial(httptest.NewRequest("GET", "/api/v1/url/xyz789?continue="+token, nil),
+		domain.Url{ShortUrl: "xyz789", ForcePreview: true}, false))
+}
+
+func TestContinueUrl(t *testing.T) {
+	handler := UrlHandler{continueKey: []byte("secret")}
+	req := httptest.NewRequest("GET", "/api/v1/url/abc123+?ref=mail&preview=1", nil)
+	assert.Equal(t, "/api/v1/url/abc123?ref=mail", handler.continueUrl(req, domain.Url{}))
+
+	link := domain.Url{ShortUrl: "abc123", ForcePreview: true}
+	next, err := url.Parse(handler.continueUrl(req, link))
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/v1/url/abc123", next.Path)
+	assert.Equal(t, "mail", next.Query().Get("ref"))
+	assert.False(t, handler.wantsInterstitial(httptest.NewRequest("GET", next.String(), nil), link, false))
+}
+
+func TestSafetyWarnings(t *testing.T) {
+	assert.Empty(t, safetyWarnings("https://example.com/path?q=1"))
+	assert.Len(t, safetyWarnings("http://example.com"), 1)
+	assert.Len(t, safetyWarnings("https://paypal.com@203.0.113.9:8443/login"), 3)
+	assert.Len(t, safetyWarnings("https://xn--pypal-4ve.com"), 1)
+	assert.Len(t, safetyWarnings("not a url"), 1)
+}
+
+func TestDestinationOrigin(t *testing.T) {
+	assert.Equal(t, "https://example.com:8443", destinationOrigin("https://example.com:8443/secret?token=1"))
+}
@@ -0,0 +1,255 @@
+package delivery
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+// Bounds and defaults of the QR code query parameters
+const (
+	qrDefaultSize   = 256
+	qrMinSize       = 32
+	qrMaxSize       = 2048
+	qrDefaultMargin = 4 // the quiet zone required by the QR code specification
+	qrMaxMargin     = 16
+	qrDefaultLevel  = "M"
+)
+
+// Rendered QR codes kept in memory, and how long clients may cache them
+const (
+	qrCacheSize   = 512
+	qrCacheMaxAge = 24 * 60 * 60
+)
+
+var (
+	qrDefaultForeground = color.RGBA{A: 0xff}
+	qrDefaultBackground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+var qrContentTypes = map[string]string{
+	utils.QRFormatPNG: "image/png",
+	utils.QRFormatSVG: "image/svg+xml",
+}
+
+// Least recently used cache of rendered QR codes, keyed by content and options
+// A nil cache renders every request
+type qrCache struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+}
+
+type qrCacheEntry struct {
+	key   string
+	image []byte
+}
+
+func newQRCache(capacity int) *qrCache {
+	return &qrCache{capacity: capacity, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *qrCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*qrCacheEntry).image, true
+}
+
+func (c *qrCache) put(key string, image []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&qrCacheEntry{key, image})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*qrCacheEntry).key)
+	}
+}
+
+// Parse the QR code options of a request, falling back to the defaults for missing parameters
+// Returning the options if valid, and an error message for the client if not
+
+func parseQROptions(query url.Values) (utils.QROptions, error) {
+	options := utils.QROptions{
+		Format:     strings.ToLower(query.Get("format")),
+		Size:       qrDefaultSize,
+		Level:      strings.ToUpper(query.Get("ecc")),
+		Margin:     qrDefaultMargin,
+		Foreground: qrDefaultForeground,
+		Background: qrDefaultBackground,
+	}
+	if options.Format == "" {
+		options.Format = utils.QRFormatPNG
+	}
+	if _, ok := qrContentTypes[options.Format]; !ok {
+		return options, errors.New("format must be png or svg")
+	}
+	if options.Level == "" {
+		options.Level = qrDefaultLevel
+	}
+	if !utils.IsQRLevel(options.Level) {
+		return options, errors.New("ecc must be one of L, M, Q or H")
+	}
+
+	var err error
+	if query.Get("size") != "" {
+		options.Size, err = strconv.Atoi(query.Get("size"))
+		if err != nil || options.Size < qrMinSize || options.Size > qrMaxSize {
+			return options, fmt.Errorf("size must be a number of pixels between %d and %d", qrMinSize, qrMaxSize)
+		}
+	}
+	if query.Get("margin") != "" {
+		options.Margin, err = strconv.Atoi(query.Get("margin"))
+		if err != nil || options.Margin < 0 || options.Margin > qrMaxMargin {
+			return options, fmt.Errorf("margin must be a number of modules between 0 and %d", qrMaxMargin)
+		}
+	}
+	for param, value := range map[string]*color.RGBA{"color": &options.Foreground, "background": &options.Background} {
+		if query.Get(param) == "" {
+			continue
+		}
+
+		*value, err = utils.ParseHexColor(query.Get(param))
+		if err != nil {
+			return options, fmt.Errorf("%s %s", param, err.Error())
+		}
+	}
+	return options, nil
+}
+
+// Render the QR code of content, from the cache when it was rendered with the same options before
+// Returning the image and its ETag
+
+func (h *UrlHandler) renderQRCode(content string, options utils.QROptions) ([]byte, string, error) {
+	key := fmt.Sprintf("%s|%s|%d|%s|%d|%v|%v", content, options.Format, options.Size, options.Level,
+		options.Margin, options.Foreground, options.Background)
+	sum := sha256.Sum256([]byte(key))
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+
+	image, ok := h.qrCodes.get(key)
+	if ok {
+		return image, etag, nil
+	}
+
+	image, err := utils.RenderQRCode(content, options)
+	if err != nil {
+		return nil, "", err
+	}
+	h.qrCodes.put(key, image)
+	return image, etag, nil
+}
+
+// QR code of a short url with the default options, as a data uri to embed in a json response
+
+func (h *UrlHandler) qrDataUri(req *http.Request, shortUrl string) (string, error) {
+	options, _ := parseQROptions(url.Values{})
+	image, _, err := h.renderQRCode(h.shortLink(req, shortUrl), options)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + qrContentTypes[options.Format] + ";base64," + base64.StdEncoding.EncodeToString(image), nil
+}
+
+func (h *UrlHandler) getUrlQRCode(res http.ResponseWriter, req *http.Request) {
+	urlId, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		res.Header().Set("Content-Type", "application/json")
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"url id isn't valid"},
+		})
+		return
+	}
+
+	options, err := parseQROptions(req.URL.Query())
+	if err != nil {
+		res.Header().Set("Content-Type", "application/json")
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{err.Error()},
+		})
+		return
+	}
+
+	url, err := h.urlUsecase.FindUrlByID(req.Context(), urlId)
+	if err != nil {
+		errorParams := utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			errorParams.Code = http.StatusNotFound
+			errorParams.Status = "Not found"
+			errorParams.Errors = []string{"url not found"}
+		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		utils.FormatResponse(res, &errorParams)
+		return
+	}
+
+	image, etag, err := h.renderQRCode(h.shortLink(req, url.ShortUrl), options)
+	if err != nil {
+		res.Header().Set("Content-Type", "application/json")
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		})
+		return
+	}
+
+	res.Header().Set("Content-Type", qrContentTypes[options.Format])
+	res.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", qrCacheMaxAge))
+	res.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+	res.Write(image)
+}
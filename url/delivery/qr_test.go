@@ -0,0 +1,171 @@
+package delivery
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/mrizalr/urlshortener/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseQROptions(t *testing.T) {
+	options, err := parseQROptions(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, utils.QROptions{
+		Format:     utils.QRFormatPNG,
+		Size:       qrDefaultSize,
+		Level:      "M",
+		Margin:     qrDefaultMargin,
+		Foreground: qrDefaultForeground,
+		Background: qrDefaultBackground,
+	}, options)
+
+	options, err = parseQROptions(url.Values{"format": {"SVG"}, "size": {"512"}, "ecc": {"h"}, "margin": {"0"},
+		"color": {"#1a2b3c"}, "background": {"fff"}})
+	assert.NoError(t, err)
+	assert.Equal(t, utils.QROptions{
+		Format:     utils.QRFormatSVG,
+		Size:       512,
+		Level:      "H",
+		Margin:     0,
+		Foreground: color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 0xff},
+		Background: qrDefaultBackground,
+	}, options)
+
+	invalid := []url.Values{
+		{"format": {"gif"}},
+		{"ecc": {"X"}},
+		{"size": {"big"}},
+		{"size": {"16"}},
+		{"size": {"4096"}},
+		{"margin": {"-1"}},
+		{"margin": {"17"}},
+		{"color": {"black"}},
+		{"background": {"#12"}},
+	}
+	for _, query := range invalid {
+		_, err := parseQROptions(query)
+		assert.Error(t, err, query.Encode())
+	}
+}
+
+func TestQRCache(t *testing.T) {
+	cache := newQRCache(2)
+	cache.put("a", []byte("a"))
+	cache.put("b", []byte("b"))
+
+	// reading a makes b the least recently used one
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.put("c", []byte("c"))
+
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	image, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), image)
+
+	var disabled *qrCache
+	disabled.put("a", []byte("a"))
+	_, ok = disabled.get("a")
+	assert.False(t, ok)
+}
+
+func TestGetUrlQRCode(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("FindUrlByID", mock.Anything, 1).Return(domain.Url{ID: 1, ShortUrl: "ha51Fad"}, nil)
+	mockUsecase.On("FindUrlByID", mock.Anything, 2).Return(domain.Url{}, sql.ErrNoRows)
+
+	handler := UrlHandler{urlUsecase: mockUsecase, qrCodes: newQRCache(qrCacheSize)}
+	qr := func(id, query, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://sho.rt/api/v1/url/"+id+"/qr?"+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		res := httptest.NewRecorder()
+		handler.getUrlQRCode(res, req)
+		return res
+	}
+
+	res := qr("1", "", "")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "image/png", res.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=86400", res.Header().Get("Cache-Control"))
+	img, err := png.Decode(bytes.NewReader(res.Body.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, qrDefaultSize, img.Bounds().Dx())
+
+	expect, _ := utils.RenderQRCode("http://sho.rt/api/v1/url/ha51Fad", utils.QROptions{Format: utils.QRFormatPNG,
+		Size: qrDefaultSize, Level: "M", Margin: qrDefaultMargin, Foreground: qrDefaultForeground, Background: qrDefaultBackground})
+	assert.Equal(t, expect, res.Body.Bytes())
+
+	// the same options give the same etag, so clients can revalidate
+	etag := res.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	res = qr("1", "", etag)
+	assert.Equal(t, http.StatusNotModified, res.Code)
+	assert.Empty(t, res.Body.Bytes())
+
+	res = qr("1", "format=svg&size=128&color=%23c00", etag)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "image/svg+xml", res.Header().Get("Content-Type"))
+	assert.NotEqual(t, etag, res.Header().Get("ETag"))
+	assert.True(t, strings.HasPrefix(res.Body.String(), "<svg"))
+	assert.Contains(t, res.Body.String(), `fill="#cc0000"`)
+
+	assert.Equal(t, http.StatusBadRequest, qr("1", "size=1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, qr("one", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, qr("2", "", "").Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestCreateNewUrlWithQRCode(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("CreateNewURL", mock.Anything, mock.AnythingOfType("domain.CreateUrlRequest")).
+		Return(domain.Url{ID: 1, Url: "https://www.github.com/mrizalr", ShortUrl: "h52GbxA"}, nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	create := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "https://sho.rt/api/v1/url/create"+query, strings.NewReader(`{"url":"www.github.com/mrizalr"}`))
+		res := httptest.NewRecorder()
+		handler.createNewUrlShortener(res, req)
+		return res
+	}
+
+	res := create("?qr=true")
+	assert.Equal(t, http.StatusCreated, res.Code)
+
+	body := struct {
+		Data struct {
+			ShortUrl string `json:"short_url"`
+			QRCode   string `json:"qr_code"`
+		} `json:"data"`
+	}{}
+	assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	assert.Equal(t, "h52GbxA", body.Data.ShortUrl)
+	assert.True(t, strings.HasPrefix(body.Data.QRCode, "data:image/png;base64,"))
+
+	image, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(body.Data.QRCode, "data:image/png;base64,"))
+	assert.NoError(t, err)
+	expect, _ := utils.RenderQRCode("https://sho.rt/api/v1/url/h52GbxA", utils.QROptions{Format: utils.QRFormatPNG,
+		Size: qrDefaultSize, Level: "M", Margin: qrDefaultMargin, Foreground: qrDefaultForeground, Background: qrDefaultBackground})
+	assert.Equal(t, expect, image)
+
+	assert.NotContains(t, create("").Body.String(), "qr_code")
+	assert.Equal(t, http.StatusBadRequest, create("?qr=maybe").Code)
+}
@@ -0,0 +1,153 @@
+package delivery
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// Cookie identifying a visitor, so split urls keep sending them to the same target
+const visitorCookieName = "visitor_id"
+
+const visitorCookieMaxAge = 365 * 24 * 60 * 60
+
+// Build the redirect location of a short link
+// Merging the incoming query by the link query_policy, then applying the link UTM parameters
+// The query of the stored url is kept as it is written, signatures may cover it, parameters are only appended
+// to it and those overridden left out. Returning the stored url untouched when there is nothing to add
+
+func destinationUrl(link domain.Url, incoming url.Values) (string, error) {
+	merge := len(incoming) > 0 && link.QueryPolicy != "" && link.QueryPolicy != domain.QueryPolicyDrop
+	utm := map[string]string{
+		"utm_source":   link.UtmSource,
+		"utm_medium":   link.UtmMedium,
+		"utm_campaign": link.UtmCampaign,
+	}
+	hasUtm := link.UtmSource != "" || link.UtmMedium != "" || link.UtmCampaign != ""
+	if !merge && !hasUtm {
+		return link.Url, nil
+	}
+
+	destination, err := url.Parse(link.Url)
+	if err != nil {
+		return "", err
+	}
+
+	query := destination.Query()
+	added := url.Values{}
+	overridden := map[string]bool{}
+	if merge {
+		for key, values := range incoming {
+			switch link.QueryPolicy {
+			case domain.QueryPolicyKeep:
+				if _, ok := query[key]; !ok {
+					added[key] = values
+				}
+			case domain.QueryPolicyOverride:
+				added[key], overridden[key] = values, true
+			case domain.QueryPolicyAppend:
+				added[key] = values
+			}
+		}
+	}
+
+	for key, value := range utm {
+		if value != "" {
+			added[key], overridden[key] = []string{value}, true
+		}
+	}
+	if len(added) == 0 {
+		return link.Url, nil
+	}
+
+	pairs := []string{}
+	for _, pair := range strings.Split(destination.RawQuery, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if pair != "" && !overridden[key] {
+			pairs = append(pairs, pair)
+		}
+	}
+	destination.RawQuery = strings.Join(append(pairs, added.Encode()), "&")
+	return destination.String(), nil
+}
+
+// Pick the target of a split url for one visitor
+// The pick is a hash of the url and visitor ids over the cumulative weights, so it is sticky
+// for as long as the targets and weights don't change
+
+func pickTarget(link domain.Url, visitorID string) (domain.UrlTarget, bool) {
+	totalWeight := 0
+	for _, target := range link.Targets {
+		totalWeight += target.Weight
+	}
+	if totalWeight <= 0 {
+		return domain.UrlTarget{}, false
+	}
+
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%d:%s", link.ID, visitorID)
+	bucket := int(hash.Sum32() % uint32(totalWeight))
+
+	for _, target := range link.Targets {
+		if bucket < target.Weight {
+			return target, true
+		}
+		bucket -= target.Weight
+	}
+	return domain.UrlTarget{}, false
+}
+
+// Host of the Referer header, recorded on click events
+// Returning an empty string for direct visits
+
+func referrerHost(req *http.Request) string {
+	referrer, err := url.Parse(req.Referer())
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(referrer.Hostname())
+}
+
+// Fingerprint of a visitor for the unique visitor sketches, the ip and user agent themselves aren't stored
+
+func visitorHash(ip, userAgent string) uint64 {
+	sum := sha256.Sum256([]byte(ip + "\x00" + userAgent))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Read the visitor id cookie, issuing a new random id when the visitor has none
+
+func (h *UrlHandler) visitorID(res http.ResponseWriter, req *http.Request) string {
+	cookie, err := req.Cookie(visitorCookieName)
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return h.clientIP(req) + req.UserAgent()
+	}
+
+	value := hex.EncodeToString(id)
+	http.SetCookie(res, &http.Cookie{
+		Name:     visitorCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   visitorCookieMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return value
+}
@@ -0,0 +1,96 @@
+package delivery
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationUrl(t *testing.T) {
+	incoming := url.Values{"ref": {"mail"}, "q": {"rust"}}
+	testCases := []struct {
+		name   string
+		link   domain.Url
+		expect string
+	}{
+		{
+			name:   "drop",
+			link:   domain.Url{Url: "https://example.com/a?q=go", QueryPolicy: domain.QueryPolicyDrop},
+			expect: "https://example.com/a?q=go",
+		},
+		{
+			name:   "keep",
+			link:   domain.Url{Url: "https://example.com/a?q=go", QueryPolicy: domain.QueryPolicyKeep},
+			expect: "https://example.com/a?q=go&ref=mail",
+		},
+		{
+			name:   "override",
+			link:   domain.Url{Url: "https://example.com/a?q=go", QueryPolicy: domain.QueryPolicyOverride},
+			expect: "https://example.com/a?q=rust&ref=mail",
+		},
+		{
+			name:   "append",
+			link:   domain.Url{Url: "https://example.com/a?q=go", QueryPolicy: domain.QueryPolicyAppend},
+			expect: "https://example.com/a?q=go&q=rust&ref=mail",
+		},
+		{
+			name: "utm",
+			link: domain.Url{
+				Url:         "https://example.com/a?utm_source=old",
+				QueryPolicy: domain.QueryPolicyDrop,
+				UtmSource:   "newsletter",
+				UtmMedium:   "email",
+				UtmCampaign: "launch",
+			},
+			expect: "https://example.com/a?utm_campaign=launch&utm_medium=email&utm_source=newsletter",
+		},
+		{
+			// signed urls keep the order and escaping of their own query
+			name:   "signed",
+			link:   domain.Url{Url: "https://cdn.example.com/f?X-Sig=a%2Fb%3D&z=1&a=%7e&utm_source=old", QueryPolicy: domain.QueryPolicyKeep, UtmSource: "newsletter"},
+			expect: "https://cdn.example.com/f?X-Sig=a%2Fb%3D&z=1&a=%7e&q=rust&ref=mail&utm_source=newsletter",
+		},
+		{
+			name:   "nothing to add",
+			link:   domain.Url{Url: "https://cdn.example.com/f?ref=x&X-Sig=a%2Fb&q=%7e", QueryPolicy: domain.QueryPolicyKeep},
+			expect: "https://cdn.example.com/f?ref=x&X-Sig=a%2Fb&q=%7e",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			destination, err := destinationUrl(testCase.link, incoming)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expect, destination)
+		})
+	}
+}
+
+func TestPickTarget(t *testing.T) {
+	link := domain.Url{
+		ID: 1,
+		Targets: []domain.UrlTarget{
+			{ID: 1, Url: "https://example.com/a", Weight: 70},
+			{ID: 2, Url: "https://example.com/b", Weight: 30},
+		},
+	}
+
+	picked := map[int]int{}
+	for i := 0; i < 10000; i++ {
+		target, ok := pickTarget(link, fmt.Sprintf("visitor-%d", i))
+		assert.True(t, ok)
+		picked[target.ID]++
+	}
+	assert.InDelta(t, 7000, picked[1], 300)
+	assert.InDelta(t, 3000, picked[2], 300)
+
+	first, _ := pickTarget(link, "visitor-1")
+	again, _ := pickTarget(link, "visitor-1")
+	assert.Equal(t, first, again)
+
+	_, ok := pickTarget(domain.Url{ID: 1}, "visitor-1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,79 @@
+package delivery
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+// Path prefix of the url routes, short urls are served right under it
+const urlPathPrefix = "/api/v1/url"
+
+// Url data returned by the api, with the fields computed for the response
+type urlResponse struct {
+	domain.Url
+	ShortLink string `json:"short_link"`        // absolute address of the short url
+	QRLink    string `json:"qr_link"`           // absolute address of its QR code image
+	QRCode    string `json:"qr_code,omitempty"` // png data uri, only when asked for on create
+}
+
+// Url returned by the details endpoints, with its status and the summary of its recent clicks
+type urlDetailsResponse struct {
+	urlResponse
+	Status string              `json:"status"`
+	Stats  domain.StatsSummary `json:"stats"`
+}
+
+func (h *UrlHandler) newUrlResponse(req *http.Request, url domain.Url) urlResponse {
+	return urlResponse{
+		Url:       url,
+		ShortLink: h.shortLink(req, url.ShortUrl),
+		QRLink:    fmt.Sprintf("%s%s/%d/qr", h.requestOrigin(req), urlPathPrefix, url.ID),
+	}
+}
+
+func (h *UrlHandler) newUrlResponses(req *http.Request, urls []domain.Url) []urlResponse {
+	responses := make([]urlResponse, 0, len(urls))
+	for _, url := range urls {
+		responses = append(responses, h.newUrlResponse(req, url))
+	}
+	return responses
+}
+
+func (h *UrlHandler) newUrlDetailsResponse(req *http.Request, details domain.UrlDetails) urlDetailsResponse {
+	return urlDetailsResponse{
+		urlResponse: h.newUrlResponse(req, details.Url),
+		Status:      details.Status,
+		Stats:       details.Stats,
+	}
+}
+
+// Public address of a short url
+// The base url of the custom domain the request was sent to comes first, then the configured base url,
+// then the host and scheme of the request
+
+func (h *UrlHandler) shortLink(req *http.Request, shortUrl string) string {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+
+	baseUrl, ok := h.config.DomainBaseUrls[strings.ToLower(host)]
+	if !ok {
+		baseUrl = h.config.BaseUrl
+	}
+	if baseUrl == "" {
+		baseUrl = h.requestOrigin(req) + urlPathPrefix
+	}
+	return baseUrl + "/" + shortUrl
+}
+
+// Scheme and host the request was sent to, for links back to this api
+
+func (h *UrlHandler) requestOrigin(req *http.Request) string {
+	return utils.RequestScheme(req, h.config.TrustedProxies) + "://" + req.Host
+}
@@ -0,0 +1,63 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShortLink(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	handler := UrlHandler{config: config.Config{
+		BaseUrl:        "https://sho.rt",
+		DomainBaseUrls: map[string]string{"go.acme.com": "https://go.acme.com/s"},
+		TrustedProxies: []*net.IPNet{proxies},
+	}}
+
+	testCases := []struct {
+		name   string
+		target string
+		expect string
+	}{
+		{name: "custom domain", target: "http://go.acme.com/api/v1/url/create", expect: "https://go.acme.com/s/ha51Fad"},
+		{name: "custom domain with port", target: "http://GO.ACME.COM:8080/api/v1/url/create", expect: "https://go.acme.com/s/ha51Fad"},
+		{name: "base url", target: "http://api.sho.rt/api/v1/url/create", expect: "https://sho.rt/ha51Fad"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", testCase.target, nil)
+			assert.Equal(t, testCase.expect, handler.shortLink(req, "ha51Fad"))
+		})
+	}
+
+	// without a base url, the request host and the scheme forwarded by a trusted proxy are used
+	handler.config.BaseUrl = ""
+	req := httptest.NewRequest("POST", "http://api.sho.rt:8080/api/v1/url/create", nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	assert.Equal(t, "https://api.sho.rt:8080/api/v1/url/ha51Fad", handler.shortLink(req, "ha51Fad"))
+}
+
+func TestNewUrlResponse(t *testing.T) {
+	handler := UrlHandler{config: config.Config{BaseUrl: "https://sho.rt"}}
+	req := httptest.NewRequest("GET", "https://api.sho.rt/api/v1/url/", nil)
+
+	response := handler.newUrlResponse(req, domain.Url{ID: 7, ShortUrl: "ha51Fad"})
+	data, err := json.Marshal(response)
+	assert.NoError(t, err)
+
+	body := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(data, &body))
+	assert.Equal(t, "ha51Fad", body["short_url"])
+	assert.Equal(t, "https://sho.rt/ha51Fad", body["short_link"])
+	assert.Equal(t, "https://api.sho.rt/api/v1/url/7/qr", body["qr_link"])
+	assert.NotContains(t, body, "qr_code")
+
+	assert.Len(t, handler.newUrlResponses(req, []domain.Url{{ID: 1}, {ID: 2}}), 2)
+	assert.NotNil(t, handler.newUrlResponses(req, nil))
+}
@@ -0,0 +1,81 @@
+package delivery
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+// Request attributes the targeting rules are evaluated against, also recorded on click events
+type visitor struct {
+	Os        string
+	Device    string
+	Browser   string
+	Languages []string
+	Country   string // empty when no GeoIP database is configured or the address is unknown
+}
+
+func (h *UrlHandler) newVisitor(req *http.Request) visitor {
+	userAgent := utils.ParseUserAgent(req.UserAgent())
+	return visitor{
+		Os:        userAgent.OS,
+		Device:    userAgent.Device,
+		Browser:   userAgent.Browser,
+		Languages: utils.ParseAcceptLanguage(req.Header.Get("Accept-Language")),
+		Country:   h.country(req),
+	}
+}
+
+func (h *UrlHandler) country(req *http.Request) string {
+	if h.geoLocator == nil {
+		return ""
+	}
+
+	ip := net.ParseIP(h.clientIP(req))
+	if ip == nil {
+		return ""
+	}
+
+	country, err := h.geoLocator.Country(ip)
+	if err != nil {
+		log.Printf("failed to lookup country of %s: %v", ip, err)
+		return ""
+	}
+	return country
+}
+
+// Find the first rule, by position, whose conditions all match the visitor
+
+func matchRule(rules []domain.UrlRule, v visitor) (domain.UrlRule, bool) {
+	for _, rule := range rules {
+		if rule.Os != "" && rule.Os != v.Os {
+			continue
+		}
+		if rule.Device != "" && rule.Device != v.Device {
+			continue
+		}
+		if rule.Language != "" && !matchLanguage(rule.Language, v.Languages) {
+			continue
+		}
+		if rule.Country != "" && rule.Country != v.Country {
+			continue
+		}
+		return rule, true
+	}
+	return domain.UrlRule{}, false
+}
+
+// A rule language matches the same tag or any of its subtags, e.g. "en" matches "en-us"
+
+func matchLanguage(language string, languages []string) bool {
+	for _, tag := range languages {
+		if tag == language || strings.HasPrefix(tag, language+"-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package delivery
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRule(t *testing.T) {
+	rules := []domain.UrlRule{
+		{ID: 1, Position: 0, Os: domain.OsIOS, Url: "https://apps.apple.com/app/id1"},
+		{ID: 2, Position: 1, Os: domain.OsAndroid, Url: "https://play.google.com/store/apps/details?id=app"},
+		{ID: 3, Position: 2, Device: domain.DeviceDesktop, Language: "id", Url: "https://example.com/id"},
+		{ID: 4, Position: 3, Country: "DE", Url: "https://example.com/de"},
+	}
+
+	testCases := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		remoteAddr     string
+		expectID       int
+	}{
+		{"ios", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148 Safari/604.1", "id", "192.0.2.1:1234", 1},
+		{"android", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/118.0 Mobile Safari/537.36", "", "192.0.2.1:1234", 2},
+		{"desktop language", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0", "en-US;q=0.5, id-ID", "192.0.2.1:1234", 3},
+		{"country", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0", "en-US", "198.51.100.7:1234", 4},
+		{"no match", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0", "en-US", "192.0.2.1:1234", 0},
+	}
+
+	handler := UrlHandler{geoLocator: geoLocatorStub{"198.51.100.7": "DE"}}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ha51Fad", nil)
+			req.Header.Set("User-Agent", testCase.userAgent)
+			req.Header.Set("Accept-Language", testCase.acceptLanguage)
+			req.RemoteAddr = testCase.remoteAddr
+
+			rule, ok := matchRule(rules, handler.newVisitor(req))
+			assert.Equal(t, testCase.expectID != 0, ok)
+			assert.Equal(t, testCase.expectID, rule.ID)
+		})
+	}
+}
+
+func TestMatchLanguage(t *testing.T) {
+	assert.True(t, matchLanguage("en", []string{"fr", "en-gb"}))
+	assert.True(t, matchLanguage("pt-br", []string{"pt-br"}))
+	assert.False(t, matchLanguage("pt-br", []string{"pt"}))
+	assert.False(t, matchLanguage("en", []string{"eng"}))
+}
+
+func TestVisitorWithoutGeoLocator(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ha51Fad", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+
+	handler := UrlHandler{}
+	assert.Empty(t, handler.newVisitor(req).Country)
+}
+
+// In memory GeoLocator keyed by ip address
+type geoLocatorStub map[string]string
+
+func (g geoLocatorStub) Country(ip net.IP) (string, error) {
+	return g[ip.String()], nil
+}
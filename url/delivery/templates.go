@@ -0,0 +1,201 @@
+package delivery
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Protected link</title>
+</head>
+<body>
+	<h1>This link is password protected</h1>
+	{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+	<form method="POST" action="{{.Action}}">
+		<label for="password">Password</label>
+		<input id="password" name="password" type="password" autofocus required>
+		<button type="submit">Continue</button>
+	</form>
+</body>
+</html>
+`))
+
+var notActiveTemplate = template.Must(template.New("not_active").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Link not active yet</title>
+</head>
+<body>
+	<h1>This link is not active yet</h1>
+	<p>It will be available from <time datetime="{{.ActiveFrom}}">{{.ActiveFrom}}</time>.</p>
+</body>
+</html>
+`))
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>{{.Title}}</title>
+</head>
+<body>
+	<h1>{{.Title}}</h1>
+	<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>{{.Title}}</title>
+	<meta property="og:type" content="website">
+	<meta property="og:title" content="{{.Title}}">
+	{{if .Description}}<meta property="og:description" content="{{.Description}}">
+	<meta name="description" content="{{.Description}}">{{end}}
+	{{if .Image}}<meta property="og:image" content="{{.Image}}">{{end}}
+	<meta name="twitter:card" content="{{if .Image}}summary_large_image{{else}}summary{{end}}">
+	<meta name="twitter:title" content="{{.Title}}">
+	{{if .Description}}<meta name="twitter:description" content="{{.Description}}">{{end}}
+	{{if .Image}}<meta name="twitter:image" content="{{.Image}}">{{end}}
+</head>
+<body>
+	<h1>{{.Title}}</h1>
+	{{if .Description}}<p>{{.Description}}</p>{{end}}
+	<p><a href="{{.Url}}">{{.Url}}</a></p>
+</body>
+</html>
+`))
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<title>Where this link goes</title>
+</head>
+<body>
+	<h1>This link goes to</h1>
+	<p><code>{{.Destination}}</code></p>
+	{{if .HostOnly}}<p>This link can only be used once, the full address is shown when you continue.</p>{{end}}
+	<dl>
+		{{if .CreatedAt}}<dt>Created</dt>
+		<dd><time datetime="{{.CreatedAt}}">{{.CreatedAt}}</time></dd>{{end}}
+		<dt>Clicks</dt>
+		<dd>{{.ClickCount}}</dd>
+		<dt>Safety</dt>
+		<dd>{{if .Warnings}}Check the destination before continuing{{else}}No issues found{{end}}</dd>
+	</dl>
+	{{if .Warnings}}<ul role="alert">{{range .Warnings}}
+		<li>{{.}}</li>{{end}}
+	</ul>{{end}}
+	<p><a href="{{.Continue}}" role="button" rel="noreferrer">Continue</a></p>
+</body>
+</html>
+`))
+
+// Render the password form of a protected short url
+// The form posts back to the requested path, keeping its query string for passthrough
+
+func renderPasswordForm(res http.ResponseWriter, req *http.Request, statusCode int, errorMessage string) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	res.WriteHeader(statusCode)
+	passwordTemplate.Execute(res, struct {
+		Action string
+		Error  string
+	}{req.URL.RequestURI(), errorMessage})
+}
+
+func renderNotActivePage(res http.ResponseWriter, url domain.Url) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	res.WriteHeader(http.StatusNotFound)
+	notActiveTemplate.Execute(res, struct {
+		ActiveFrom string
+	}{time.Unix(url.ActiveFrom, 0).UTC().Format(time.RFC3339)})
+}
+
+// Links with any of the preview fields set are served to crawlers as a preview page
+
+func hasPreview(url domain.Url) bool {
+	return url.Title != "" || url.Description != "" || url.Image != ""
+}
+
+// Render the Open Graph and Twitter card tags of a link for social crawlers
+// The title falls back to the destination url so the card is never empty, single use links only show its origin
+// as anyone can get the card without consuming the link
+
+func renderPreviewPage(res http.ResponseWriter, url domain.Url) {
+	shown := url.Url
+	if url.SingleUse {
+		shown = destinationOrigin(url.Url)
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	res.Header().Set("Vary", "User-Agent")
+	res.WriteHeader(http.StatusOK)
+	previewTemplate.Execute(res, struct {
+		Title       string
+		Description string
+		Image       string
+		Url         string
+	}{firstNonEmpty(url.Title, shown), url.Description, url.Image, shown})
+}
+
+// Render the destination of a link with its safety warnings, before the visitor follows it
+// Receiving next as the address of the continue button, and hostOnly to hide all but the destination host
+
+func renderInterstitialPage(res http.ResponseWriter, url domain.Url, destination, next string, hostOnly bool) {
+	shown := destination
+	if hostOnly {
+		shown = destinationOrigin(destination)
+	}
+	// urls created before their creation time was stored don't have one
+	createdAt := ""
+	if url.CreatedAt != 0 {
+		createdAt = time.Unix(url.CreatedAt, 0).UTC().Format(time.RFC3339)
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	res.Header().Set("Referrer-Policy", "no-referrer")
+	res.WriteHeader(http.StatusOK)
+	interstitialTemplate.Execute(res, struct {
+		Destination string
+		HostOnly    bool
+		CreatedAt   string
+		ClickCount  int
+		Warnings    []string
+		Continue    string
+	}{shown, shown != destination, createdAt, url.ClickCount,
+		safetyWarnings(destination), next})
+}
+
+func renderStatusPage(res http.ResponseWriter, statusCode int, title, message string) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	res.WriteHeader(statusCode)
+	statusTemplate.Execute(res, struct {
+		Title   string
+		Message string
+	}{title, message})
+}
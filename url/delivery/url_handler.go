@@ -2,29 +2,68 @@ package delivery
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/config"
 	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/idempotency"
+	"github.com/mrizalr/urlshortener/ratelimit"
 	"github.com/mrizalr/urlshortener/utils"
 )
 
+// Max age (seconds) of the Cache-Control header sent with 301 and 308 redirects
+const permanentRedirectMaxAge = 24 * 60 * 60
+
 type UrlHandler struct {
-	urlUsecase domain.UrlUsecase
+	urlUsecase  domain.UrlUsecase
+	geoLocator  domain.GeoLocator // nil when geo targeting is off
+	config      config.Config
+	qrCodes     *qrCache // nil renders every QR code
+	continueKey []byte   // signs the continue links of forced interstitial pages
 }
 
-func NewUrlHandler(urlUsecase domain.UrlUsecase, m *mux.Router) {
-	handler := UrlHandler{urlUsecase}
-	router_v1 := m.PathPrefix("/api/v1/url").Subrouter()
+func NewUrlHandler(urlUsecase domain.UrlUsecase, geoLocator domain.GeoLocator, cfg config.Config, m *mux.Router, authMiddleware *auth.Middleware, rateLimiter *ratelimit.Middleware,
+	idempotencyMiddleware *idempotency.Middleware) {
+	handler := UrlHandler{urlUsecase, geoLocator, cfg, newQRCache(qrCacheSize), continueKey(cfg)}
+	router_v1 := m.PathPrefix(urlPathPrefix).Subrouter()
+	createPolicy := ratelimit.Policy{Name: "create", Limit: cfg.RateLimitCreate, Period: cfg.RateLimitCreatePeriod}
+	redirectPolicy := ratelimit.Policy{Name: "redirect", Limit: cfg.RateLimitRedirect, Period: cfg.RateLimitRedirectPeriod}
 
-	router_v1.Path("/").HandlerFunc(handler.getAllUrl).Methods("GET")
-	router_v1.Path("/create").HandlerFunc(handler.createNewUrlShortener).Methods("POST")
-	router_v1.Path("/{id}").HandlerFunc(handler.deleteUrlByID).Methods("DELETE")
-	router_v1.Path("/{short}").HandlerFunc(handler.getUrlByShort).Methods("GET")
+	authMiddleware.Require(router_v1.Path("/").HandlerFunc(handler.getAllUrl).Methods("GET"), domain.ScopeLinksRead)
+	create := router_v1.Path("/create").HandlerFunc(handler.createNewUrlShortener).Methods("POST")
+	authMiddleware.Require(rateLimiter.Limit(idempotencyMiddleware.Allow(create), createPolicy), domain.ScopeLinksWrite)
+	// generated short urls and workspace slugs always contain a letter, so a numeric path segment is a url id
+	// to the routes managing links
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}").HandlerFunc(handler.deleteUrlByID).Methods("DELETE"), domain.ScopeLinksWrite)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/targets").HandlerFunc(handler.updateUrlTargets).Methods("PUT"), domain.ScopeLinksWrite)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/rules").HandlerFunc(handler.updateUrlRules).Methods("PUT"), domain.ScopeLinksWrite)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/stats").HandlerFunc(handler.getUrlStats).Methods("GET"), domain.ScopeStatsRead)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/qr").HandlerFunc(handler.getUrlQRCode).Methods("GET"), domain.ScopeLinksRead)
+	authMiddleware.Require(router_v1.Path("/by-slug/{short}").HandlerFunc(handler.getUrlDetailsByShort).Methods("GET"), domain.ScopeLinksRead)
+	authMiddleware.Require(router_v1.Path("/by-slug/{namespace}/{short}").HandlerFunc(handler.getUrlDetailsByShort).Methods("GET"), domain.ScopeLinksRead)
+	// short urls picked before numeric paths became url ids may be all digits, so only requests with a bearer token
+	// get the details of an id, and ids without a url fall back to the redirect
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}").HeadersRegexp("Authorization", `(?i)^bearer `).HandlerFunc(handler.getUrlDetails).
+		Methods("GET", "HEAD"), domain.ScopeLinksRead)
+	// redirects are public, short urls of a workspace namespace are prefixed by the workspace slug
+	for _, path := range []string{"/{short}", "/{namespace}/{short}"} {
+		rateLimiter.Limit(router_v1.Path(path).HandlerFunc(handler.getUrlByShort).Methods("GET", "HEAD"), redirectPolicy)
+		rateLimiter.Limit(router_v1.Path(path).HandlerFunc(handler.unlockUrlByShort).Methods("POST"), redirectPolicy)
+	}
+	router_v1.Use(authMiddleware.Handler)
+	router_v1.Use(rateLimiter.Handler)
+	router_v1.Use(idempotencyMiddleware.Handler)
 }
 
 func (h *UrlHandler) createNewUrlShortener(res http.ResponseWriter, req *http.Request) {
@@ -41,9 +80,20 @@ func (h *UrlHandler) createNewUrlShortener(res http.ResponseWriter, req *http.Re
 	}
 	defer req.Body.Close()
 
-	requestBody := struct {
-		Url string `json:"url"`
-	}{}
+	withQRCode := false
+	if req.URL.Query().Get("qr") != "" {
+		withQRCode, err = strconv.ParseBool(req.URL.Query().Get("qr"))
+		if err != nil {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadRequest,
+				Status: "Bad request",
+				Errors: []string{"qr must be true or false"},
+			})
+			return
+		}
+	}
+
+	requestBody := domain.CreateUrlRequest{}
 	err = json.Unmarshal(body, &requestBody)
 	if err != nil {
 		utils.FormatResponse(res, &utils.ResponseErrorParams{
@@ -56,7 +106,7 @@ func (h *UrlHandler) createNewUrlShortener(res http.ResponseWriter, req *http.Re
 		return
 	}
 
-	url, err := h.urlUsecase.CreateNewURL(context.Background(), requestBody.Url)
+	url, err := h.urlUsecase.CreateNewURL(req.Context(), requestBody)
 	if err != nil {
 		errorParams := utils.ResponseErrorParams{
 			Code:   http.StatusBadGateway,
@@ -69,35 +119,93 @@ func (h *UrlHandler) createNewUrlShortener(res http.ResponseWriter, req *http.Re
 			errorParams.Status = "Bad request"
 			errorParams.Errors = []string{err.Error()}
 		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+		// the monthly quota frees up at the end of the month, active links only when some are deleted
+		quotaErr := &domain.QuotaExceededError{}
+		if errors.As(err, &quotaErr) {
+			setQuotaHeaders(res, quotaErr, time.Now().Unix())
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+			if quotaErr.Quota == domain.QuotaMonthlyCreates {
+				errorParams.Code = http.StatusTooManyRequests
+				errorParams.Status = "Too many requests"
+			}
+		}
 
 		utils.FormatResponse(res, &errorParams)
 		return
 	}
 
+	response := h.newUrlResponse(req, url)
+	if withQRCode {
+		// the link is created already, a failed render only leaves the QR code out
+		response.QRCode, err = h.qrDataUri(req, url.ShortUrl)
+		if err != nil {
+			log.Printf("failed to render the qr code of url %d: %v", url.ID, err)
+		}
+	}
+
 	utils.FormatResponse(res, &utils.ResponseSuccessParams{
 		Code:   http.StatusCreated,
 		Status: "Success Created",
-		Data:   url,
+		Data:   response,
 	})
 }
 
 func (h *UrlHandler) getAllUrl(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", "application/json")
 
-	urls, err := h.urlUsecase.FindAllUrl(context.Background())
+	status := req.URL.Query().Get("status")
+	workspaceID := 0
+	if req.URL.Query().Get("workspace_id") != "" {
+		id, err := strconv.Atoi(req.URL.Query().Get("workspace_id"))
+		if err != nil || id <= 0 {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadRequest,
+				Status: "Bad request",
+				Errors: []string{"workspace_id isn't valid"},
+			})
+			return
+		}
+		workspaceID = id
+	}
+
+	urls, err := h.urlUsecase.FindAllUrl(req.Context(), status, workspaceID)
 	if err != nil {
-		utils.FormatResponse(res, &utils.ResponseErrorParams{
+		errorParams := utils.ResponseErrorParams{
 			Code:   http.StatusBadGateway,
 			Status: "Bad gateway",
 			Errors: []string{err.Error()},
-		})
+		}
+
+		if strings.Contains(strings.ToLower(err.Error()), "validation") {
+			errorParams.Code = http.StatusBadRequest
+			errorParams.Status = "Bad request"
+		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+
+		utils.FormatResponse(res, &errorParams)
 		return
 	}
 
 	utils.FormatResponse(res, &utils.ResponseSuccessParams{
 		Code:   http.StatusOK,
 		Status: "Success",
-		Data:   urls,
+		Data:   h.newUrlResponses(req, urls),
 	})
 }
 
@@ -115,26 +223,294 @@ func (h *UrlHandler) deleteUrlByID(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	url, err := h.urlUsecase.DeleteByID(context.Background(), urlId)
+	url, err := h.urlUsecase.DeleteByID(req.Context(), urlId)
+	if err != nil {
+		errorParams := utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			errorParams.Code = http.StatusNotFound
+			errorParams.Status = "Not found"
+			errorParams.Errors = []string{"url not found"}
+		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+
+		utils.FormatResponse(res, &errorParams)
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   h.newUrlResponse(req, url),
+	})
+}
+
+func (h *UrlHandler) updateUrlTargets(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	urlIdStr := mux.Vars(req)["id"]
+	urlId, err := strconv.Atoi(urlIdStr)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"url id isn't valid"},
+		})
+		return
+	}
+
+	requestBody := struct {
+		Targets []domain.UrlTarget `json:"targets"`
+	}{}
+	err = json.NewDecoder(req.Body).Decode(&requestBody)
 	if err != nil {
 		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"error while parsing json"},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	url, err := h.urlUsecase.UpdateTargets(req.Context(), urlId, requestBody.Targets)
+	h.updateUrlResponse(res, req, url, err)
+}
+
+func (h *UrlHandler) updateUrlRules(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	urlIdStr := mux.Vars(req)["id"]
+	urlId, err := strconv.Atoi(urlIdStr)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"url id isn't valid"},
+		})
+		return
+	}
+
+	requestBody := struct {
+		Rules []domain.UrlRule `json:"rules"`
+	}{}
+	err = json.NewDecoder(req.Body).Decode(&requestBody)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"error while parsing json"},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	url, err := h.urlUsecase.UpdateRules(req.Context(), urlId, requestBody.Rules)
+	h.updateUrlResponse(res, req, url, err)
+}
+
+func (h *UrlHandler) getUrlStats(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	urlIdStr := mux.Vars(req)["id"]
+	urlId, err := strconv.Atoi(urlIdStr)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"url id isn't valid"},
+		})
+		return
+	}
+
+	query := req.URL.Query()
+	request := domain.StatsRequest{Interval: query.Get("interval")}
+	if query.Get("include_bots") != "" {
+		request.IncludeBots, err = strconv.ParseBool(query.Get("include_bots"))
+		if err != nil {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadRequest,
+				Status: "Bad request",
+				Errors: []string{"include_bots must be true or false"},
+			})
+			return
+		}
+	}
+	for param, value := range map[string]*int64{"from": &request.From, "to": &request.To} {
+		if query.Get(param) == "" {
+			continue
+		}
+
+		*value, err = strconv.ParseInt(query.Get(param), 10, 64)
+		if err != nil {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadRequest,
+				Status: "Bad request",
+				Errors: []string{fmt.Sprintf("%s must be a unix timestamp", param)},
+			})
+			return
+		}
+	}
+
+	stats, err := h.urlUsecase.GetStats(req.Context(), urlId, request)
+	if err != nil {
+		errorParams := utils.ResponseErrorParams{
 			Code:   http.StatusBadGateway,
 			Status: "Bad gateway",
 			Errors: []string{err.Error()},
+		}
+
+		if strings.Contains(strings.ToLower(err.Error()), "validation") {
+			errorParams.Code = http.StatusBadRequest
+			errorParams.Status = "Bad request"
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			errorParams.Code = http.StatusNotFound
+			errorParams.Status = "Not found"
+			errorParams.Errors = []string{"url not found"}
+		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+
+		utils.FormatResponse(res, &errorParams)
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   stats,
+	})
+}
+
+func (h *UrlHandler) getUrlDetails(res http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	urlId, err := strconv.Atoi(id)
+	if err != nil {
+		res.Header().Set("Content-Type", "application/json")
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"url id isn't valid"},
 		})
 		return
 	}
 
+	details, err := h.urlUsecase.GetUrlDetails(req.Context(), urlId)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, shortErr := h.urlUsecase.FindUrlByShort(req.Context(), id)
+		if !errors.Is(shortErr, sql.ErrNoRows) {
+			h.getUrlByShort(res, mux.SetURLVars(req, map[string]string{"short": id}))
+			return
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	h.urlDetailsResponse(res, req, details, err)
+}
+
+func (h *UrlHandler) getUrlDetailsByShort(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	details, err := h.urlUsecase.GetUrlDetailsByShort(req.Context(), shortVar(req))
+	h.urlDetailsResponse(res, req, details, err)
+}
+
+func (h *UrlHandler) urlDetailsResponse(res http.ResponseWriter, req *http.Request, details domain.UrlDetails, err error) {
+	if err != nil {
+		errorParams := utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			errorParams.Code = http.StatusNotFound
+			errorParams.Status = "Not found"
+			errorParams.Errors = []string{"url not found"}
+		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+
+		utils.FormatResponse(res, &errorParams)
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   h.newUrlDetailsResponse(req, details),
+	})
+}
+
+func (h *UrlHandler) updateUrlResponse(res http.ResponseWriter, req *http.Request, url domain.Url, err error) {
+	if err != nil {
+		errorParams := utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		}
+
+		if strings.Contains(strings.ToLower(err.Error()), "validation") {
+			errorParams.Code = http.StatusBadRequest
+			errorParams.Status = "Bad request"
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			errorParams.Code = http.StatusNotFound
+			errorParams.Status = "Not found"
+			errorParams.Errors = []string{"url not found"}
+		}
+		if errors.Is(err, domain.ErrUnauthenticated) {
+			errorParams.Code = http.StatusUnauthorized
+			errorParams.Status = "Unauthorized"
+		}
+		if errors.Is(err, domain.ErrForbidden) {
+			errorParams.Code = http.StatusForbidden
+			errorParams.Status = "Forbidden"
+		}
+
+		utils.FormatResponse(res, &errorParams)
+		return
+	}
+
 	utils.FormatResponse(res, &utils.ResponseSuccessParams{
 		Code:   http.StatusOK,
 		Status: "Success",
-		Data:   url,
+		Data:   h.newUrlResponse(req, url),
 	})
 }
 
 func (h *UrlHandler) getUrlByShort(res http.ResponseWriter, req *http.Request) {
-	shortUrl := mux.Vars(req)["short"]
+	shortUrl := shortVar(req)
+	suffixed := strings.HasSuffix(shortUrl, previewSuffix)
+	shortUrl = strings.TrimSuffix(shortUrl, previewSuffix)
+
 	url, err := h.urlUsecase.FindUrlByShort(context.Background(), shortUrl)
+	if h.unavailableResponse(res, req, url, err) {
+		return
+	}
 	if err != nil {
 		utils.FormatResponse(res, &utils.ResponseErrorParams{
 			Code:   http.StatusBadGateway,
@@ -144,5 +520,264 @@ func (h *UrlHandler) getUrlByShort(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	http.Redirect(res, req, url.Url, http.StatusPermanentRedirect)
+	if url.Protected {
+		renderPasswordForm(res, req, http.StatusOK, "")
+		return
+	}
+
+	// crawlers building a link preview get the stored one instead of following the redirect
+	if hasPreview(url) && utils.IsBot(req) {
+		h.recordClick(req, url, 0, h.newVisitor(req))
+		renderPreviewPage(res, url)
+		return
+	}
+
+	// a single use link is only consumed by a person following it, HEAD probes, mail scanners and
+	// unfurlers without a preview to show get a page that neither consumes it nor reveals its destination
+	if url.SingleUse && utils.IsBot(req) {
+		h.recordClick(req, url, 0, h.newVisitor(req))
+		renderStatusPage(res, http.StatusOK, "Single use link", "This link can only be opened once, open it in a browser to follow it.")
+		return
+	}
+
+	if h.wantsInterstitial(req, url, suffixed) {
+		// nothing is consumed or counted until the visitor continues
+		destination, _, err := h.resolveDestination(res, req, url, h.newVisitor(req))
+		if err != nil {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadGateway,
+				Status: "Bad gateway",
+				Errors: []string{err.Error()},
+			})
+			return
+		}
+
+		renderInterstitialPage(res, url, destination, h.continueUrl(req, url), url.SingleUse)
+		return
+	}
+
+	redirectType := url.RedirectType
+	if redirectType == 0 {
+		redirectType = http.StatusPermanentRedirect
+	}
+
+	h.redirect(res, req, url, redirectType, false)
+}
+
+func (h *UrlHandler) unlockUrlByShort(res http.ResponseWriter, req *http.Request) {
+	shortUrl := shortVar(req)
+	suffixed := strings.HasSuffix(shortUrl, previewSuffix)
+	shortUrl = strings.TrimSuffix(shortUrl, previewSuffix)
+	password := req.PostFormValue("password")
+
+	url, err := h.urlUsecase.UnlockUrl(context.Background(), shortUrl, password, h.clientIP(req))
+	if errors.Is(err, domain.ErrInvalidPassword) {
+		renderPasswordForm(res, req, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, domain.ErrTooManyAttempts) {
+		renderPasswordForm(res, req, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if h.unavailableResponse(res, req, url, err) {
+		return
+	}
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		})
+		return
+	}
+
+	// 303 makes the browser follow up with GET, so the password never reaches the destination
+	h.redirect(res, req, url, http.StatusSeeOther, h.wantsInterstitial(req, url, suffixed))
+}
+
+// Describe the exceeded quota in X-Quota-* headers, with the time the monthly quota resets at
+
+func setQuotaHeaders(res http.ResponseWriter, quotaErr *domain.QuotaExceededError, now int64) {
+	used := quotaErr.Usage.ActiveLinks
+	if quotaErr.Quota == domain.QuotaMonthlyCreates {
+		used = quotaErr.Usage.MonthlyCreates
+	}
+	remaining := quotaErr.Limit() - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	res.Header().Set("X-Quota-Name", quotaErr.Quota)
+	res.Header().Set("X-Quota-Limit", strconv.Itoa(quotaErr.Limit()))
+	res.Header().Set("X-Quota-Remaining", strconv.Itoa(remaining))
+	if quotaErr.Quota == domain.QuotaMonthlyCreates {
+		res.Header().Set("X-Quota-Reset", strconv.FormatInt(quotaErr.Usage.PeriodEnd, 10))
+		res.Header().Set("Retry-After", strconv.FormatInt(quotaErr.Usage.PeriodEnd-now, 10))
+	}
+}
+
+// Short url of the request path, along with its workspace namespace if any
+
+func shortVar(req *http.Request) string {
+	vars := mux.Vars(req)
+	if vars["namespace"] != "" {
+		return vars["namespace"] + "/" + vars["short"]
+	}
+	return vars["short"]
+}
+
+// Resolve the destination of a short url for one visitor, by its targeting rules then its split targets
+// Returning the destination with the incoming query merged, and the id of the picked target, 0 when none was
+
+func (h *UrlHandler) resolveDestination(res http.ResponseWriter, req *http.Request, url domain.Url, attributes visitor) (string, int, error) {
+	link, targetID := url, 0
+	rule, matched := matchRule(url.Rules, attributes)
+	if matched {
+		link.Url = rule.Url
+	} else if len(url.Targets) > 0 {
+		target, ok := pickTarget(url, h.visitorID(res, req))
+		if ok {
+			link.Url, targetID = target.Url, target.ID
+		}
+	}
+
+	incoming := req.URL.Query()
+	incoming.Del(previewParam)
+	incoming.Del(continueParam)
+	destination, err := destinationUrl(link, incoming)
+	return destination, targetID, err
+}
+
+// Send the visitor to the destination of a short url, consuming single use urls and recording the click
+// With interstitial, the destination is shown with a continue button instead, once the password of a
+// protected url was accepted
+
+func (h *UrlHandler) redirect(res http.ResponseWriter, req *http.Request, url domain.Url, redirectType int, interstitial bool) {
+	visitor := h.newVisitor(req)
+	destination, targetID, err := h.resolveDestination(res, req, url, visitor)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadGateway,
+			Status: "Bad gateway",
+			Errors: []string{err.Error()},
+		})
+		return
+	}
+
+	cacheControl := redirectCacheControl(redirectType)
+	if len(url.Targets) > 0 || len(url.Rules) > 0 {
+		// the destination depends on the visitor, so the response can't be shared
+		cacheControl = redirectCacheControl(http.StatusFound)
+	}
+
+	if url.SingleUse {
+		err = h.urlUsecase.ConsumeUrl(context.Background(), url.ID)
+		if h.unavailableResponse(res, req, url, err) {
+			return
+		}
+		if err != nil {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadGateway,
+				Status: "Bad gateway",
+				Errors: []string{err.Error()},
+			})
+			return
+		}
+
+		// a consumed url must never be replayed from a browser cache
+		cacheControl = redirectCacheControl(http.StatusFound)
+	}
+
+	h.recordClick(req, url, targetID, visitor)
+
+	if interstitial {
+		renderInterstitialPage(res, url, destination, destination, false)
+		return
+	}
+
+	res.Header().Set("Cache-Control", cacheControl)
+	if hasPreview(url) {
+		// crawlers get the preview page from the same url
+		res.Header().Set("Vary", "User-Agent")
+	}
+	http.Redirect(res, req, destination, redirectType)
+}
+
+// Record one served short url for analytics, a failure is only logged so it never blocks the visitor
+
+func (h *UrlHandler) recordClick(req *http.Request, url domain.Url, targetID int, attributes visitor) {
+	err := h.urlUsecase.RecordClick(context.Background(), domain.ClickEvent{
+		UrlID:    url.ID,
+		TargetID: targetID,
+		Referrer: referrerHost(req),
+		Os:       attributes.Os,
+		Device:   attributes.Device,
+		Browser:  attributes.Browser,
+		Country:  attributes.Country,
+		Visitor:  visitorHash(h.clientIP(req), req.UserAgent()),
+		Bot:      utils.IsBot(req),
+	})
+	if err != nil {
+		log.Printf("failed to record click of url %d: %v", url.ID, err)
+	}
+}
+
+// Serve a short url that can't be resolved to its destination
+// Unknown or deleted urls fall back to the global landing page, expired, used and scheduled urls
+// to their own fallback url first, rendering the built-in page when no fallback is configured
+// Returning false when err isn't one of those cases
+
+func (h *UrlHandler) unavailableResponse(res http.ResponseWriter, req *http.Request, url domain.Url, err error) bool {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		h.fallbackResponse(res, req, h.config.FallbackUrl, func() {
+			renderStatusPage(res, http.StatusNotFound, "Link not found", "This link doesn't exist or has been deleted.")
+		})
+	case errors.Is(err, domain.ErrUrlExpired), errors.Is(err, domain.ErrUrlConsumed):
+		h.fallbackResponse(res, req, firstNonEmpty(url.FallbackUrl, h.config.FallbackUrl), func() {
+			renderStatusPage(res, http.StatusGone, "Link no longer available", "This link has expired or has already been used.")
+		})
+	case errors.Is(err, domain.ErrUrlNotActive):
+		h.fallbackResponse(res, req, firstNonEmpty(url.FallbackUrl, h.config.NotActiveFallbackUrl), func() {
+			renderNotActivePage(res, url)
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *UrlHandler) fallbackResponse(res http.ResponseWriter, req *http.Request, fallbackUrl string, render func()) {
+	if fallbackUrl == "" {
+		render()
+		return
+	}
+
+	res.Header().Set("Cache-Control", redirectCacheControl(http.StatusFound))
+	http.Redirect(res, req, fallbackUrl, http.StatusFound)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (h *UrlHandler) clientIP(req *http.Request) string {
+	return utils.ClientIP(req, h.config.TrustedProxies)
+}
+
+// Permanent redirects may be cached by browsers for a bounded time,
+// temporary ones must hit the server every time so clicks are counted
+
+func redirectCacheControl(redirectType int) string {
+	switch redirectType {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		return fmt.Sprintf("public, max-age=%d", permanentRedirectMaxAge)
+	default:
+		return "private, no-cache, no-store, must-revalidate"
+	}
 }
@@ -3,30 +3,45 @@ package delivery
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/config"
 	"github.com/mrizalr/urlshortener/domain"
 	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/mrizalr/urlshortener/idempotency"
+	"github.com/mrizalr/urlshortener/ratelimit"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateNewUrlHandler(t *testing.T) {
 	mockUsecase := new(mocks.UrlUsecase)
 	usecaseResult := domain.Url{
-		ID:         1,
-		Url:        "www.github.com/mrizalr",
-		ShortUrl:   "h52GbxA",
-		ClickCount: 0,
-		CreatedAt:  time.Now().Unix(),
+		ID:           1,
+		Url:          "www.github.com/mrizalr",
+		ShortUrl:     "h52GbxA",
+		ClickCount:   0,
+		CreatedAt:    time.Now().Unix(),
+		RedirectType: 302,
+		QueryPolicy:  "drop",
 	}
 
-	mockUsecase.On("CreateNewURL", context.Background(), mock.AnythingOfType("string")).Return(usecaseResult, nil)
+	mockUsecase.On("CreateNewURL", mock.Anything, mock.AnythingOfType("domain.CreateUrlRequest")).Return(usecaseResult, nil)
 
 	reqJson := fmt.Sprintf(`{"url":"%s"}`, usecaseResult.Url)
 	reqBody := bytes.NewReader([]byte(reqJson))
@@ -34,7 +49,7 @@ func TestCreateNewUrlHandler(t *testing.T) {
 	req := httptest.NewRequest("POST", "/api/v1/url/create", reqBody)
 	res := httptest.NewRecorder()
 
-	handler := UrlHandler{mockUsecase}
+	handler := UrlHandler{urlUsecase: mockUsecase}
 	handler.createNewUrlShortener(res, req)
 
 	result := res.Result()
@@ -51,39 +66,82 @@ func TestCreateNewUrlHandler(t *testing.T) {
 			"id":1,
 			"url":"www.github.com/mrizalr",
 			"short_url":"h52GbxA",
+			"short_link":"http://example.com/api/v1/url/h52GbxA",
+			"qr_link":"http://example.com/api/v1/url/1/qr",
 			"click_count":0,
-			"created_at":%d
+			"bot_click_count":0,
+			"created_at":%d,
+			"redirect_type":302,
+			"query_policy":"drop",
+			"password_protected":false,
+			"single_use":false,
+			"force_preview":false
 		}
-	}`, time.Now().Unix())
+	}`, usecaseResult.CreatedAt)
 
 	mockUsecase.AssertExpectations(t)
 	assert.JSONEq(t, expect, string(resultBody))
 }
 
+func TestCreateUrlQuotaExceeded(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	periodEnd := time.Now().Unix() + 3600
+	usage := domain.Usage{SubjectType: "user", SubjectID: 3, PeriodEnd: periodEnd, MonthlyCreates: 100, ActiveLinks: 40,
+		Quota: domain.Quota{MonthlyCreates: 100, ActiveLinks: 40}}
+	mockUsecase.On("CreateNewURL", mock.Anything, domain.CreateUrlRequest{Url: "https://github.com"}).
+		Return(domain.Url{}, &domain.QuotaExceededError{Quota: domain.QuotaMonthlyCreates, Usage: usage}).Once()
+	mockUsecase.On("CreateNewURL", mock.Anything, domain.CreateUrlRequest{Url: "https://github.com"}).
+		Return(domain.Url{}, &domain.QuotaExceededError{Quota: domain.QuotaActiveLinks, Usage: usage}).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+
+	// monthly creates are retried once the month is over
+	res := httptest.NewRecorder()
+	handler.createNewUrlShortener(res, httptest.NewRequest("POST", "/api/v1/url/create", bytes.NewBufferString(`{"url":"https://github.com"}`)))
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "100", res.Header().Get("X-Quota-Limit"))
+	assert.Equal(t, "0", res.Header().Get("X-Quota-Remaining"))
+	assert.Equal(t, strconv.FormatInt(periodEnd, 10), res.Header().Get("X-Quota-Reset"))
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+	assert.Contains(t, res.Body.String(), "monthly_creates quota of 100 links exceeded")
+
+	// active links are freed by deleting links
+	res = httptest.NewRecorder()
+	handler.createNewUrlShortener(res, httptest.NewRequest("POST", "/api/v1/url/create", bytes.NewBufferString(`{"url":"https://github.com"}`)))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Equal(t, domain.QuotaActiveLinks, res.Header().Get("X-Quota-Name"))
+	assert.Empty(t, res.Header().Get("Retry-After"))
+	mockUsecase.AssertExpectations(t)
+}
+
 func TestGetAllUrlHandler(t *testing.T) {
 	mockUsecase := new(mocks.UrlUsecase)
 	usecaseResult := []domain.Url{
 		{
-			ID:         1,
-			Url:        "www.github.com/mrizalr",
-			ShortUrl:   "h52GbxA",
-			ClickCount: 163,
-			CreatedAt:  time.Date(2021, 03, 23, 12, 13, 32, 43, time.Local).Unix(),
+			ID:           1,
+			Url:          "www.github.com/mrizalr",
+			ShortUrl:     "h52GbxA",
+			ClickCount:   163,
+			CreatedAt:    time.Date(2021, 03, 23, 12, 13, 32, 43, time.Local).Unix(),
+			RedirectType: 308,
+			QueryPolicy:  "drop",
 		}, {
-			ID:         2,
-			Url:        "www.linkedin.com/in/mrizalr",
-			ShortUrl:   "hJS62h",
-			ClickCount: 123,
-			CreatedAt:  time.Date(2021, 03, 23, 12, 13, 32, 43, time.Local).Unix(),
+			ID:           2,
+			Url:          "www.linkedin.com/in/mrizalr",
+			ShortUrl:     "hJS62h",
+			ClickCount:   123,
+			CreatedAt:    time.Date(2021, 03, 23, 12, 13, 32, 43, time.Local).Unix(),
+			RedirectType: 302,
+			QueryPolicy:  "drop",
 		},
 	}
 
-	mockUsecase.On("FindAllUrl", context.Background()).Return(usecaseResult, nil)
+	mockUsecase.On("FindAllUrl", mock.Anything, "", 0).Return(usecaseResult, nil)
 
 	req := httptest.NewRequest("GET", "/api/v1/url/", nil)
 	res := httptest.NewRecorder()
 
-	handler := UrlHandler{mockUsecase}
+	handler := UrlHandler{urlUsecase: mockUsecase}
 	handler.getAllUrl(res, req)
 
 	result := res.Result()
@@ -100,15 +158,31 @@ func TestGetAllUrlHandler(t *testing.T) {
 			"id":1,
 			"url":"www.github.com/mrizalr",
 			"short_url":"h52GbxA",
+			"short_link":"http://example.com/api/v1/url/h52GbxA",
+			"qr_link":"http://example.com/api/v1/url/1/qr",
 			"click_count":163,
-			"created_at":%d
+			"bot_click_count":0,
+			"created_at":%d,
+			"redirect_type":308,
+			"query_policy":"drop",
+			"password_protected":false,
+			"single_use":false,
+			"force_preview":false
 		},
 		{
 			"id":2,
 			"url":"www.linkedin.com/in/mrizalr",
 			"short_url":"hJS62h",
+			"short_link":"http://example.com/api/v1/url/hJS62h",
+			"qr_link":"http://example.com/api/v1/url/2/qr",
 			"click_count":123,
-			"created_at":%d
+			"bot_click_count":0,
+			"created_at":%d,
+			"redirect_type":302,
+			"query_policy":"drop",
+			"password_protected":false,
+			"single_use":false,
+			"force_preview":false
 		}]
 	}`, usecaseResult[0].CreatedAt, usecaseResult[1].CreatedAt)
 
@@ -119,14 +193,16 @@ func TestGetAllUrlHandler(t *testing.T) {
 func TestDeleteUrlByIDHandler(t *testing.T) {
 	mockUsecase := new(mocks.UrlUsecase)
 	usecaseResult := domain.Url{
-		ID:         1,
-		Url:        "www.github.com/mrizalr",
-		ShortUrl:   "h52GbxA",
-		ClickCount: 163,
-		CreatedAt:  time.Date(2021, 03, 23, 12, 13, 32, 43, time.Local).Unix(),
+		ID:           1,
+		Url:          "www.github.com/mrizalr",
+		ShortUrl:     "h52GbxA",
+		ClickCount:   163,
+		CreatedAt:    time.Date(2021, 03, 23, 12, 13, 32, 43, time.Local).Unix(),
+		RedirectType: 308,
+		QueryPolicy:  "drop",
 	}
 
-	mockUsecase.On("DeleteByID", context.Background(), usecaseResult.ID).Return(usecaseResult, nil)
+	mockUsecase.On("DeleteByID", mock.Anything, usecaseResult.ID).Return(usecaseResult, nil)
 
 	req := httptest.NewRequest("DELETE", "/api/v1/url/1", nil)
 	res := httptest.NewRecorder()
@@ -134,7 +210,7 @@ func TestDeleteUrlByIDHandler(t *testing.T) {
 	params := map[string]string{"id": "1"}
 	req = mux.SetURLVars(req, params)
 
-	handler := UrlHandler{mockUsecase}
+	handler := UrlHandler{urlUsecase: mockUsecase}
 	handler.deleteUrlByID(res, req)
 
 	result := res.Result()
@@ -151,8 +227,16 @@ func TestDeleteUrlByIDHandler(t *testing.T) {
 			"id":1,
 			"url":"www.github.com/mrizalr",
 			"short_url":"h52GbxA",
+			"short_link":"http://example.com/api/v1/url/h52GbxA",
+			"qr_link":"http://example.com/api/v1/url/1/qr",
 			"click_count":163,
-			"created_at":%d
+			"bot_click_count":0,
+			"created_at":%d,
+			"redirect_type":308,
+			"query_policy":"drop",
+			"password_protected":false,
+			"single_use":false,
+			"force_preview":false
 		}
 	}`, usecaseResult.CreatedAt)
 
@@ -160,14 +244,172 @@ func TestDeleteUrlByIDHandler(t *testing.T) {
 	assert.JSONEq(t, expect, string(resultBody))
 }
 
+func TestGetUrlDetails(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.UrlDetails{
+		Url: domain.Url{
+			ID:           4,
+			Url:          "https://github.com/mrizalr",
+			ShortUrl:     "h52GbxA",
+			ClickCount:   163,
+			CreatedAt:    1697846400,
+			RedirectType: 302,
+			QueryPolicy:  "keep",
+			ExpiresAt:    1700395200,
+		},
+		Status: domain.UrlStatusExpired,
+		Stats:  domain.StatsSummary{From: 1697846400, To: 1700395200, Clicks: 9, BotClicks: 2, Visitors: 5},
+	}
+	mockUsecase.On("GetUrlDetails", mock.Anything, 4).Return(usecaseResult, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/url/4", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "4"})
+	res := httptest.NewRecorder()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlDetails(res, req)
+
+	expect := `
+	{
+		"status_code":200,
+		"status":"Success",
+		"data":{
+			"id":4,
+			"url":"https://github.com/mrizalr",
+			"short_url":"h52GbxA",
+			"short_link":"http://example.com/api/v1/url/h52GbxA",
+			"qr_link":"http://example.com/api/v1/url/4/qr",
+			"click_count":163,
+			"bot_click_count":0,
+			"created_at":1697846400,
+			"redirect_type":302,
+			"query_policy":"keep",
+			"password_protected":false,
+			"single_use":false,
+			"expires_at":1700395200,
+			"force_preview":false,
+			"status":"expired",
+			"stats":{"from":1697846400,"to":1700395200,"clicks":9,"bot_clicks":2,"visitors":5}
+		}
+	}`
+
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, expect, res.Body.String())
+}
+
+func TestGetUrlDetailsByShortNotFound(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("GetUrlDetailsByShort", mock.Anything, "h52GbxA").Return(domain.UrlDetails{}, sql.ErrNoRows)
+
+	req := httptest.NewRequest("GET", "/api/v1/url/by-slug/h52GbxA", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": "h52GbxA"})
+	res := httptest.NewRecorder()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlDetailsByShort(res, req)
+
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Contains(t, res.Body.String(), "url not found")
+}
+
+func TestUrlDetailsRoutes(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("GetUrlDetails", mock.Anything, 12).Return(domain.UrlDetails{Url: domain.Url{ID: 12, ShortUrl: "h52GbxA"}}, nil)
+	mockUsecase.On("GetUrlDetailsByShort", mock.Anything, "h52GbxA").Return(domain.UrlDetails{Url: domain.Url{ID: 12, ShortUrl: "h52GbxA"}}, nil)
+	mockUsecase.On("GetUrlDetailsByShort", mock.Anything, "sales/h52GbxA").
+		Return(domain.UrlDetails{Url: domain.Url{ID: 14, ShortUrl: "sales/h52GbxA", WorkspaceID: 4}}, nil)
+	mockUsecase.On("FindUrlByShort", context.Background(), "h52GbxA").Return(domain.Url{}, sql.ErrNoRows)
+	mockUsecase.On("FindUrlByShort", context.Background(), "sales/h52GbxA").Return(domain.Url{}, sql.ErrNoRows)
+
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "reader").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksRead}, nil)
+
+	router := mux.NewRouter()
+	cfg := config.Config{RateLimitRedirect: 2, RateLimitRedirectPeriod: time.Minute}
+	NewUrlHandler(mockUsecase, nil, cfg, router, auth.NewMiddleware(authenticator), ratelimit.NewMiddleware(ratelimit.NewMemoryStore(), config.RateLimitKeyUser, nil),
+		idempotency.NewMiddleware(new(mocks.IdempotencyRepository), time.Hour))
+
+	// numeric paths are url ids, anything else is a short url to redirect, prefixed by a workspace slug or not
+	for path, expect := range map[string]int{
+		"/api/v1/url/12":                    http.StatusOK,
+		"/api/v1/url/by-slug/h52GbxA":       http.StatusOK,
+		"/api/v1/url/by-slug/sales/h52GbxA": http.StatusOK,
+		"/api/v1/url/h52GbxA":               http.StatusNotFound,
+		"/api/v1/url/sales/h52GbxA":         http.StatusNotFound,
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer reader")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, expect, res.Code, path)
+	}
+	mockUsecase.AssertExpectations(t)
+
+	// the client already had its 2 redirects of the minute, link details aren't limited
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/url/h52GbxA", nil))
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "2", res.Header().Get("RateLimit-Limit"))
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+	mockUsecase.AssertNumberOfCalls(t, "FindUrlByShort", 2)
+}
+
+func TestNumericShortUrlRoutes(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("GetUrlDetails", mock.Anything, 12).Return(domain.UrlDetails{Url: domain.Url{ID: 12, ShortUrl: "h52GbxA"}}, nil)
+	mockUsecase.On("GetUrlDetails", mock.Anything, 2024).Return(domain.UrlDetails{}, sql.ErrNoRows)
+	mockUsecase.On("GetUrlDetails", mock.Anything, 77).Return(domain.UrlDetails{}, sql.ErrNoRows)
+	mockUsecase.On("FindUrlByShort", mock.Anything, "2024").
+		Return(domain.Url{ID: 5, Url: "https://www.google.com", ShortUrl: "2024", RedirectType: http.StatusFound}, nil)
+	mockUsecase.On("FindUrlByShort", mock.Anything, "77").Return(domain.Url{}, sql.ErrNoRows)
+	mockUsecase.On("RecordClick", mock.Anything, mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "reader").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksRead}, nil)
+
+	router := mux.NewRouter()
+	NewUrlHandler(mockUsecase, nil, config.Config{}, router, auth.NewMiddleware(authenticator), ratelimit.NewMiddleware(ratelimit.NewMemoryStore(), config.RateLimitKeyUser, nil),
+		idempotency.NewMiddleware(new(mocks.IdempotencyRepository), time.Hour))
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	// with a bearer token a numeric path is a url id, short urls made of digits still redirect
+	assert.Equal(t, http.StatusOK, serve("/api/v1/url/12", "reader").Code)
+	res := serve("/api/v1/url/2024", "reader")
+	assert.Equal(t, http.StatusFound, res.Code)
+	assert.Equal(t, "https://www.google.com", res.Header().Get("Location"))
+	res = serve("/api/v1/url/77", "reader")
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+
+	// visitors don't have one, they are redirected without the details being looked up
+	res = serve("/api/v1/url/2024", "")
+	assert.Equal(t, http.StatusFound, res.Code)
+	assert.Equal(t, "https://www.google.com", res.Header().Get("Location"))
+	mockUsecase.AssertNumberOfCalls(t, "GetUrlDetails", 3)
+}
+
 func TestGetUrl(t *testing.T) {
 	mockUsecase := new(mocks.UrlUsecase)
 	usecaseResult := domain.Url{
-		ID:         1,
-		Url:        "https://www.google.com",
-		ShortUrl:   "ha51Fad",
-		ClickCount: 23,
-		CreatedAt:  time.Now().Unix(),
+		ID:           1,
+		Url:          "https://www.google.com",
+		ShortUrl:     "ha51Fad",
+		ClickCount:   23,
+		CreatedAt:    time.Now().Unix(),
+		RedirectType: http.StatusFound,
 	}
 	mockUsecase.On("FindUrlByShort", context.Background(), mock.AnythingOfType("string")).
 		Return(usecaseResult, nil)
@@ -178,7 +420,9 @@ func TestGetUrl(t *testing.T) {
 	params := map[string]string{"short": usecaseResult.ShortUrl}
 	req = mux.SetURLVars(req, params)
 
-	handler := UrlHandler{mockUsecase}
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
 	handler.getUrlByShort(res, req)
 
 	result := res.Result()
@@ -187,4 +431,815 @@ func TestGetUrl(t *testing.T) {
 	assert.NoError(t, err)
 	mockUsecase.AssertExpectations(t)
 	assert.Equal(t, usecaseResult.Url, fmt.Sprintf("%s://%s", redirectUrl.Scheme, redirectUrl.Host))
+	assert.Equal(t, http.StatusFound, result.StatusCode)
+	assert.Contains(t, result.Header.Get("Cache-Control"), "no-store")
+}
+
+func TestGetUrlPermanentRedirect(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://www.google.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusMovedPermanently,
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).
+		Return(usecaseResult, nil)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), nil)
+	res := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlByShort(res, req)
+
+	result := res.Result()
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusMovedPermanently, result.StatusCode)
+	assert.Equal(t, "public, max-age=86400", result.Header.Get("Cache-Control"))
+}
+
+func TestGetUrlQueryPassthrough(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://www.google.com/search?q=golang",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusFound,
+		QueryPolicy:  domain.QueryPolicyKeep,
+		UtmSource:    "newsletter",
+		UtmCampaign:  "launch",
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).
+		Return(usecaseResult, nil)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s?ref=mail&q=rust", usecaseResult.ShortUrl), nil)
+	res := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlByShort(res, req)
+
+	result := res.Result()
+	redirectUrl, err := result.Location()
+
+	assert.NoError(t, err)
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, "golang", redirectUrl.Query().Get("q"))
+	assert.Equal(t, "mail", redirectUrl.Query().Get("ref"))
+	assert.Equal(t, "newsletter", redirectUrl.Query().Get("utm_source"))
+	assert.Equal(t, "launch", redirectUrl.Query().Get("utm_campaign"))
+	assert.False(t, redirectUrl.Query().Has("utm_medium"))
+}
+
+func TestGetUrlPasswordProtected(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://www.google.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusFound,
+		PasswordHash: "$2a$10$hash",
+		Protected:    true,
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).
+		Return(usecaseResult, nil)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), nil)
+	res := httptest.NewRecorder()
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlByShort(res, req)
+
+	result := res.Result()
+	resultBody, err := io.ReadAll(result.Body)
+
+	assert.NoError(t, err)
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Location"))
+	assert.Contains(t, string(resultBody), `name="password"`)
+	assert.NotContains(t, string(resultBody), usecaseResult.Url)
+	assert.NotContains(t, string(resultBody), usecaseResult.PasswordHash)
+}
+
+func TestUnlockUrlByShort(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://www.google.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusPermanentRedirect,
+		Protected:    true,
+	}
+	mockUsecase.On("UnlockUrl", context.Background(), usecaseResult.ShortUrl, "s3cret", "192.0.2.1").
+		Return(usecaseResult, nil)
+	mockUsecase.On("UnlockUrl", context.Background(), usecaseResult.ShortUrl, "wrong", "192.0.2.1").
+		Return(domain.Url{}, domain.ErrInvalidPassword)
+
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	unlock := func(password string) *http.Response {
+		reqBody := strings.NewReader("password=" + password)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), reqBody)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+
+		res := httptest.NewRecorder()
+		handler.unlockUrlByShort(res, req)
+		return res.Result()
+	}
+
+	result := unlock("wrong")
+	assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Location"))
+
+	result = unlock("s3cret")
+	assert.Equal(t, http.StatusSeeOther, result.StatusCode)
+	assert.Equal(t, usecaseResult.Url, result.Header.Get("Location"))
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlSingleUse(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://www.google.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusPermanentRedirect,
+		SingleUse:    true,
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).
+		Return(usecaseResult, nil)
+	mockUsecase.On("ConsumeUrl", context.Background(), usecaseResult.ID).Return(nil).Once()
+	mockUsecase.On("ConsumeUrl", context.Background(), usecaseResult.ID).Return(domain.ErrUrlConsumed).Once()
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	resolve := func() *http.Response {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0")
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	result := resolve()
+	assert.Equal(t, http.StatusPermanentRedirect, result.StatusCode)
+	assert.Contains(t, result.Header.Get("Cache-Control"), "no-store")
+
+	// a concurrent request that lost the race to consume the url
+	result = resolve()
+	assert.Equal(t, http.StatusGone, result.StatusCode)
+	assert.Empty(t, result.Header.Get("Location"))
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlSingleUseBots(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{ID: 1, Url: "https://example.com/invite?token=s3cret", ShortUrl: "ha51Fad", SingleUse: true}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.MatchedBy(func(event domain.ClickEvent) bool {
+		return event.Bot && event.UrlID == 1
+	})).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	requests := map[string][2]string{
+		"head":         {"HEAD", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0"},
+		"mail scanner": {"GET", "Mozilla/5.0 (compatible; Barracuda Sentinel; +https://barracuda.com)"},
+		"unfurler":     {"GET", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"},
+	}
+	for name, request := range requests {
+		req := httptest.NewRequest(request[0], "/api/v1/url/ha51Fad", nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+		req.Header.Set("User-Agent", request[1])
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+
+		// the link stays unconsumed and its destination isn't revealed
+		assert.Equal(t, http.StatusOK, res.Code, name)
+		assert.Empty(t, res.Header().Get("Location"), name)
+		assert.NotContains(t, res.Body.String(), "s3cret", name)
+	}
+	mockUsecase.AssertNotCalled(t, "ConsumeUrl", mock.Anything, mock.Anything)
+}
+
+func TestGetUrlConsumed(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("FindUrlByShort", context.Background(), "ha51Fad").
+		Return(domain.Url{ID: 1, SingleUse: true, ConsumedAt: time.Now().Unix()}, domain.ErrUrlConsumed)
+
+	req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": "ha51Fad"})
+	res := httptest.NewRecorder()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlByShort(res, req)
+
+	result := res.Result()
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusGone, result.StatusCode)
+	mockUsecase.AssertNotCalled(t, "ConsumeUrl")
+}
+
+func TestGetAllUrlInvalidStatus(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("FindAllUrl", mock.Anything, "deleted", 0).
+		Return([]domain.Url(nil), errors.New("validation error: status must be one of scheduled, active or expired"))
+
+	req := httptest.NewRequest("GET", "/api/v1/url/?status=deleted", nil)
+	res := httptest.NewRecorder()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getAllUrl(res, req)
+
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusBadRequest, res.Result().StatusCode)
+}
+
+func TestManageUrlUnauthenticated(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("FindAllUrl", mock.Anything, "", 0).Return([]domain.Url(nil), domain.ErrUnauthenticated)
+	mockUsecase.On("DeleteByID", mock.Anything, 2).Return(domain.Url{}, domain.ErrUnauthenticated)
+	mockUsecase.On("DeleteByID", mock.Anything, 3).Return(domain.Url{}, sql.ErrNoRows)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+
+	res := httptest.NewRecorder()
+	handler.getAllUrl(res, httptest.NewRequest("GET", "/api/v1/url/", nil))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	res = httptest.NewRecorder()
+	handler.deleteUrlByID(res, mux.SetURLVars(httptest.NewRequest("DELETE", "/api/v1/url/2", nil), map[string]string{"id": "2"}))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	// the url of another user isn't found
+	res = httptest.NewRecorder()
+	handler.deleteUrlByID(res, mux.SetURLVars(httptest.NewRequest("DELETE", "/api/v1/url/3", nil), map[string]string{"id": "3"}))
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestManageWorkspaceUrl(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("FindAllUrl", mock.Anything, "", 4).Return([]domain.Url{{ID: 9, ShortUrl: "sales/h52GbxA", WorkspaceID: 4}}, nil)
+	mockUsecase.On("DeleteByID", mock.Anything, 9).Return(domain.Url{}, fmt.Errorf("%w: the editor role is required", domain.ErrForbidden))
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+
+	res := httptest.NewRecorder()
+	handler.getAllUrl(res, httptest.NewRequest("GET", "/api/v1/url/?workspace_id=4", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `"workspace_id":4`)
+
+	res = httptest.NewRecorder()
+	handler.getAllUrl(res, httptest.NewRequest("GET", "/api/v1/url/?workspace_id=sales", nil))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	// viewers of a workspace can't delete its links
+	res = httptest.NewRecorder()
+	handler.deleteUrlByID(res, mux.SetURLVars(httptest.NewRequest("DELETE", "/api/v1/url/9", nil), map[string]string{"id": "9"}))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlNotActive(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:         1,
+		Url:        "https://www.google.com",
+		ShortUrl:   "ha51Fad",
+		ActiveFrom: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC).Unix(),
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).
+		Return(usecaseResult, domain.ErrUrlNotActive)
+
+	resolve := func(handler UrlHandler) *http.Response {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	result := resolve(UrlHandler{urlUsecase: mockUsecase})
+	resultBody, err := io.ReadAll(result.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	assert.Contains(t, string(resultBody), "2030-01-02T03:04:05Z")
+	assert.NotContains(t, string(resultBody), usecaseResult.Url)
+
+	cfg := config.Config{NotActiveFallbackUrl: "https://example.com/coming-soon"}
+	result = resolve(UrlHandler{urlUsecase: mockUsecase, config: cfg})
+	assert.Equal(t, http.StatusFound, result.StatusCode)
+	assert.Equal(t, cfg.NotActiveFallbackUrl, result.Header.Get("Location"))
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlExpired(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	mockUsecase.On("FindUrlByShort", context.Background(), "ha51Fad").
+		Return(domain.Url{ID: 1, ExpiresAt: time.Now().Unix() - 60}, domain.ErrUrlExpired)
+
+	req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": "ha51Fad"})
+	res := httptest.NewRecorder()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.getUrlByShort(res, req)
+
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusGone, res.Result().StatusCode)
+}
+
+func TestGetUrlFallback(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	expired := domain.Url{ID: 1, ShortUrl: "ha51Fad", FallbackUrl: "https://example.com/campaign-over"}
+	mockUsecase.On("FindUrlByShort", context.Background(), "unknown").Return(domain.Url{}, sql.ErrNoRows)
+	mockUsecase.On("FindUrlByShort", context.Background(), expired.ShortUrl).Return(expired, domain.ErrUrlExpired)
+
+	resolve := func(handler UrlHandler, shortUrl string) *http.Response {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", shortUrl), nil)
+		req = mux.SetURLVars(req, map[string]string{"short": shortUrl})
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	// built-in page without any fallback configured
+	result := resolve(UrlHandler{urlUsecase: mockUsecase}, "unknown")
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	assert.Contains(t, result.Header.Get("Content-Type"), "text/html")
+
+	cfg := config.Config{FallbackUrl: "https://example.com"}
+	result = resolve(UrlHandler{urlUsecase: mockUsecase, config: cfg}, "unknown")
+	assert.Equal(t, http.StatusFound, result.StatusCode)
+	assert.Equal(t, cfg.FallbackUrl, result.Header.Get("Location"))
+
+	// the url own fallback wins over the global one
+	result = resolve(UrlHandler{urlUsecase: mockUsecase, config: cfg}, expired.ShortUrl)
+	assert.Equal(t, http.StatusFound, result.StatusCode)
+	assert.Equal(t, expired.FallbackUrl, result.Header.Get("Location"))
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlTargets(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://example.com/a",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusPermanentRedirect,
+		Targets: []domain.UrlTarget{
+			{ID: 10, Url: "https://example.com/a", Weight: 70},
+			{ID: 11, Url: "https://example.com/b", Weight: 30},
+		},
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	resolve := func(cookie *http.Cookie) *http.Response {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	result := resolve(nil)
+	cookies := result.Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, visitorCookieName, cookies[0].Name)
+	assert.Contains(t, result.Header.Get("Cache-Control"), "no-store")
+
+	// a returning visitor keeps the same target
+	location := result.Header.Get("Location")
+	for i := 0; i < 5; i++ {
+		result = resolve(cookies[0])
+		assert.Equal(t, location, result.Header.Get("Location"))
+		assert.Empty(t, result.Cookies())
+	}
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestUpdateUrlTargets(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	targets := []domain.UrlTarget{
+		{Url: "https://example.com/a", Weight: 70},
+		{Url: "https://example.com/b", Weight: 30},
+	}
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://example.com/a",
+		ShortUrl:     "h52GbxA",
+		RedirectType: 302,
+		QueryPolicy:  "drop",
+		Targets: []domain.UrlTarget{
+			{ID: 1, Url: "https://example.com/a", Weight: 70, ClickCount: 7},
+			{ID: 2, Url: "https://example.com/b", Weight: 30, ClickCount: 3},
+		},
+	}
+	mockUsecase.On("UpdateTargets", mock.Anything, 1, targets).Return(usecaseResult, nil)
+
+	reqJson := `{"targets":[{"url":"https://example.com/a","weight":70},{"url":"https://example.com/b","weight":30}]}`
+	req := httptest.NewRequest("PUT", "/api/v1/url/1/targets", strings.NewReader(reqJson))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	res := httptest.NewRecorder()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	handler.updateUrlTargets(res, req)
+
+	result := res.Result()
+	resultBody, err := io.ReadAll(result.Body)
+	assert.NoError(t, err)
+
+	expect := `
+	{
+		"status_code":200,
+		"status":"Success",
+		"data":{
+			"id":1,
+			"url":"https://example.com/a",
+			"short_url":"h52GbxA",
+			"short_link":"http://example.com/api/v1/url/h52GbxA",
+			"qr_link":"http://example.com/api/v1/url/1/qr",
+			"click_count":0,
+			"bot_click_count":0,
+			"created_at":0,
+			"redirect_type":302,
+			"query_policy":"drop",
+			"password_protected":false,
+			"single_use":false,
+			"force_preview":false,
+			"targets":[
+				{"id":1,"url":"https://example.com/a","weight":70,"click_count":7},
+				{"id":2,"url":"https://example.com/b","weight":30,"click_count":3}
+			]
+		}
+	}`
+
+	mockUsecase.AssertExpectations(t)
+	assert.JSONEq(t, expect, string(resultBody))
+}
+
+func TestGetUrlRules(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://example.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusPermanentRedirect,
+		Rules: []domain.UrlRule{
+			{ID: 1, Position: 0, Os: domain.OsIOS, Url: "https://apps.apple.com/app/id1"},
+			{ID: 2, Position: 1, Os: domain.OsAndroid, Url: "https://play.google.com/store/apps/details?id=app"},
+		},
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	resolve := func(userAgent string) *http.Response {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/url/%s", usecaseResult.ShortUrl), nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+		req.Header.Set("User-Agent", userAgent)
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	result := resolve("Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148 Safari/604.1")
+	assert.Equal(t, "https://apps.apple.com/app/id1", result.Header.Get("Location"))
+	assert.Contains(t, result.Header.Get("Cache-Control"), "no-store")
+
+	result = resolve("Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/118.0 Mobile Safari/537.36")
+	assert.Equal(t, "https://play.google.com/store/apps/details?id=app", result.Header.Get("Location"))
+
+	// no rule matches, the default destination is used
+	result = resolve("Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0")
+	assert.Equal(t, usecaseResult.Url, result.Header.Get("Location"))
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestUpdateUrlRules(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	rules := []domain.UrlRule{{Os: "ios", Url: "https://apps.apple.com/app/id1"}}
+	mockUsecase.On("UpdateRules", mock.Anything, 1, rules).
+		Return(domain.Url{}, errors.New("validation error: rule os must be one of ios, android, windows, macos or linux")).Once()
+	mockUsecase.On("UpdateRules", mock.Anything, 1, rules).
+		Return(domain.Url{ID: 1, Rules: []domain.UrlRule{{ID: 1, Os: "ios", Url: rules[0].Url}}}, nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	update := func() *http.Response {
+		reqJson := `{"rules":[{"os":"ios","url":"https://apps.apple.com/app/id1"}]}`
+		req := httptest.NewRequest("PUT", "/api/v1/url/1/rules", strings.NewReader(reqJson))
+		req = mux.SetURLVars(req, map[string]string{"id": "1"})
+
+		res := httptest.NewRecorder()
+		handler.updateUrlRules(res, req)
+		return res.Result()
+	}
+
+	assert.Equal(t, http.StatusBadRequest, update().StatusCode)
+	assert.Equal(t, http.StatusOK, update().StatusCode)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlGeoRules(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://example.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusFound,
+		Rules:        []domain.UrlRule{{ID: 1, Country: "ID", Url: "https://example.com/id"}},
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	userAgent := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0 Safari/537.36"
+	mockUsecase.On("RecordClick", context.Background(), domain.ClickEvent{
+		UrlID:    1,
+		Referrer: "news.ycombinator.com",
+		Os:       domain.OsWindows,
+		Device:   domain.DeviceDesktop,
+		Browser:  domain.BrowserChrome,
+		Country:  "ID",
+		Visitor:  visitorHash("203.0.113.9", userAgent),
+	}).Return(nil).Once()
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil).Once()
+
+	cfg := config.Config{TrustedProxies: []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}}
+	handler := UrlHandler{urlUsecase: mockUsecase, geoLocator: geoLocatorStub{"203.0.113.9": "ID"}, config: cfg}
+	resolve := func(remoteAddr, forwardedFor string) *http.Response {
+		req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad", nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("Referer", "https://News.ycombinator.com/item?id=1")
+		req.Header.Set("User-Agent", userAgent)
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	result := resolve("10.0.0.2:4321", "203.0.113.9")
+	assert.Equal(t, "https://example.com/id", result.Header.Get("Location"))
+
+	// the header isn't trusted from a client connecting directly
+	result = resolve("198.51.100.1:4321", "203.0.113.9")
+	assert.Equal(t, usecaseResult.Url, result.Header.Get("Location"))
+
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlStats(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	request := domain.StatsRequest{From: 1700000000, To: 1700086400, Interval: "hour"}
+	mockUsecase.On("GetStats", mock.Anything, 1, request).
+		Return(domain.UrlStats{UrlID: 1, Clicks: 3, Series: []domain.ClickBucket{{Time: 1699999200, Clicks: 3}}}, nil).Once()
+	mockUsecase.On("GetStats", mock.Anything, 2, domain.StatsRequest{}).
+		Return(domain.UrlStats{}, sql.ErrNoRows).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	stats := func(id, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/url/"+id+"/stats?"+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		res := httptest.NewRecorder()
+		handler.getUrlStats(res, req)
+		return res
+	}
+
+	res := stats("1", "from=1700000000&to=1700086400&interval=hour&include_bots=false")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `"series":[{"time":1699999200,"clicks":3}]`)
+
+	assert.Equal(t, http.StatusNotFound, stats("2", "").Code)
+	assert.Equal(t, http.StatusBadRequest, stats("1", "from=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, stats("1", "include_bots=maybe").Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlBotClick(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{ID: 1, Url: "https://example.com", ShortUrl: "ha51Fad", RedirectType: http.StatusFound}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+
+	isBot := func(event domain.ClickEvent) bool { return event.Bot }
+	isHuman := func(event domain.ClickEvent) bool { return !event.Bot }
+	mockUsecase.On("RecordClick", context.Background(), mock.MatchedBy(isBot)).Return(nil).Twice()
+	mockUsecase.On("RecordClick", context.Background(), mock.MatchedBy(isHuman)).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	resolve := func(method, userAgent string) *http.Response {
+		req := httptest.NewRequest(method, "/api/v1/url/ha51Fad", nil)
+		req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+		req.Header.Set("User-Agent", userAgent)
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res.Result()
+	}
+
+	// bots still get the redirect, their click is only recorded apart
+	assert.Equal(t, http.StatusFound, resolve("GET", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)").StatusCode)
+	assert.Equal(t, http.StatusFound, resolve("HEAD", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0").StatusCode)
+	assert.Equal(t, http.StatusFound, resolve("GET", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0").StatusCode)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlPreviewPage(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "https://example.com",
+		ShortUrl:     "ha51Fad",
+		RedirectType: http.StatusFound,
+		SingleUse:    true,
+		Title:        `Launch <day>`,
+		Description:  "Everything we shipped",
+		Image:        "https://example.com/card.png",
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.MatchedBy(func(event domain.ClickEvent) bool {
+		return event.Bot && event.UrlID == 1
+	})).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+	req.Header.Set("User-Agent", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)")
+
+	res := httptest.NewRecorder()
+	handler.getUrlByShort(res, req)
+
+	// the crawler gets the card without consuming the single use link
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "User-Agent", res.Header().Get("Vary"))
+	body := res.Body.String()
+	assert.Contains(t, body, `<meta property="og:title" content="Launch &lt;day&gt;">`)
+	assert.Contains(t, body, `<meta property="og:description" content="Everything we shipped">`)
+	assert.Contains(t, body, `<meta property="og:image" content="https://example.com/card.png">`)
+	assert.Contains(t, body, `<meta name="twitter:card" content="summary_large_image">`)
+	mockUsecase.AssertNotCalled(t, "ConsumeUrl", mock.Anything, mock.Anything)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlPreviewSingleUse(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{ID: 1, Url: "https://example.com/invite?token=s3cret", ShortUrl: "ha51Fad", SingleUse: true,
+		Description: "Join the team"}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.MatchedBy(func(event domain.ClickEvent) bool {
+		return event.Bot && event.UrlID == 1
+	})).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+	req.Header.Set("User-Agent", "")
+
+	res := httptest.NewRecorder()
+	handler.getUrlByShort(res, req)
+
+	// anyone can pass for a crawler, so the card of a single use link only shows the origin of its destination
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `<a href="https://example.com">https://example.com</a>`)
+	assert.NotContains(t, res.Body.String(), "s3cret")
+	mockUsecase.AssertNotCalled(t, "ConsumeUrl", mock.Anything, mock.Anything)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlPreviewHuman(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{ID: 1, Url: "https://example.com", ShortUrl: "ha51Fad", RedirectType: http.StatusFound, Title: "Launch day"}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0")
+
+	res := httptest.NewRecorder()
+	handler.getUrlByShort(res, req)
+
+	assert.Equal(t, http.StatusFound, res.Code)
+	assert.Equal(t, "https://example.com", res.Header().Get("Location"))
+	assert.Equal(t, "User-Agent", res.Header().Get("Vary"))
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlInterstitial(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{
+		ID:           1,
+		Url:          "http://example.com/landing",
+		ShortUrl:     "ha51Fad",
+		ClickCount:   42,
+		CreatedAt:    1700000000,
+		RedirectType: http.StatusFound,
+		QueryPolicy:  domain.QueryPolicyKeep,
+		ForcePreview: true,
+	}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	resolve := func(short, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/url/"+short+query, nil)
+		req = mux.SetURLVars(req, map[string]string{"short": short})
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0")
+
+		res := httptest.NewRecorder()
+		handler.getUrlByShort(res, req)
+		return res
+	}
+
+	// forced by the link, the page is shown without counting a click
+	res := resolve("ha51Fad+", "?ref=mail")
+	assert.Equal(t, http.StatusOK, res.Code)
+	body := res.Body.String()
+	assert.Contains(t, body, "<code>http://example.com/landing?ref=mail</code>")
+	assert.Contains(t, body, `<time datetime="2023-11-14T22:13:20Z">`)
+	assert.Contains(t, body, "<dd>42</dd>")
+	assert.Contains(t, body, "The connection to the destination isn&#39;t encrypted.")
+	continueLink := regexp.MustCompile(`<a href="/api/v1/url/ha51Fad(\?continue=[^"]+&amp;ref=mail)" role="button" rel="noreferrer">Continue</a>`).FindStringSubmatch(body)
+	require.Len(t, continueLink, 2)
+	mockUsecase.AssertNotCalled(t, "RecordClick", mock.Anything, mock.Anything)
+
+	// preview=0 doesn't get past a forced page, shared links would skip it for everyone
+	res = resolve("ha51Fad", "?preview=0&ref=mail")
+	assert.Equal(t, http.StatusOK, res.Code)
+	mockUsecase.AssertNotCalled(t, "RecordClick", mock.Anything, mock.Anything)
+
+	// the continue button skips the page, and its token isn't passed through
+	res = resolve("ha51Fad", html.UnescapeString(continueLink[1]))
+	assert.Equal(t, http.StatusFound, res.Code)
+	assert.Equal(t, "http://example.com/landing?ref=mail", res.Header().Get("Location"))
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestGetUrlInterstitialSingleUse(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{ID: 1, Url: "https://example.com/invite?token=s3cret", ShortUrl: "ha51Fad", SingleUse: true}
+	mockUsecase.On("FindUrlByShort", context.Background(), usecaseResult.ShortUrl).Return(usecaseResult, nil)
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	req := httptest.NewRequest("GET", "/api/v1/url/ha51Fad?preview=1", nil)
+	req = mux.SetURLVars(req, map[string]string{"short": usecaseResult.ShortUrl})
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0")
+
+	res := httptest.NewRecorder()
+	handler.getUrlByShort(res, req)
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), "<code>https://example.com</code>")
+	assert.NotContains(t, res.Body.String(), "s3cret")
+	// without a creation time, the page doesn't make one up
+	assert.NotContains(t, res.Body.String(), "<dt>Created</dt>")
+	mockUsecase.AssertNotCalled(t, "ConsumeUrl", mock.Anything, mock.Anything)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestUnlockUrlInterstitial(t *testing.T) {
+	mockUsecase := new(mocks.UrlUsecase)
+	usecaseResult := domain.Url{ID: 1, Url: "https://www.google.com", ShortUrl: "ha51Fad", Protected: true}
+	mockUsecase.On("UnlockUrl", context.Background(), usecaseResult.ShortUrl, "s3cret", "192.0.2.1").
+		Return(usecaseResult, nil)
+	mockUsecase.On("RecordClick", context.Background(), mock.AnythingOfType("domain.ClickEvent")).Return(nil).Once()
+
+	handler := UrlHandler{urlUsecase: mockUsecase}
+	req := httptest.NewRequest("POST", "/api/v1/url/ha51Fad+", strings.NewReader("password=s3cret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"short": "ha51Fad+"})
+
+	res := httptest.NewRecorder()
+	handler.unlockUrlByShort(res, req)
+
+	// the password was accepted, so the page links straight to the destination
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `<a href="https://www.google.com" role="button" rel="noreferrer">Continue</a>`)
+	mockUsecase.AssertExpectations(t)
 }
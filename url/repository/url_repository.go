@@ -3,25 +3,66 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/mrizalr/urlshortener/db/queries"
 	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
 )
 
 type urlRepository struct {
 	db *sql.DB
 }
 
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
 func NewUrlRepository(db *sql.DB) domain.UrlRepository {
 	return &urlRepository{db}
 }
 
-// Inserting new shortener url data to urls table
+// Scan one urls row selected with the shared column list
+// Receiving *sql.Row or *sql.Rows as parameter
+// Returning url data (domain.Url) if success, and error if failed
+
+func scanUrl(row scanner) (domain.Url, error) {
+	url := domain.Url{}
+	err := row.Scan(&url.ID, &url.Url, &url.ShortUrl, &url.ClickCount, &url.CreatedAt, &url.RedirectType,
+		&url.QueryPolicy, &url.UtmSource, &url.UtmMedium, &url.UtmCampaign, &url.PasswordHash,
+		&url.SingleUse, &url.ConsumedAt, &url.ActiveFrom, &url.ExpiresAt,
+		&url.FallbackUrl, &url.BotClickCount, &url.Title, &url.Description, &url.Image, &url.ForcePreview, &url.OwnerID, &url.WorkspaceID)
+	url.Protected = url.PasswordHash != ""
+	return url, err
+}
+
+// Inserting new shortener url data to urls table, with its targets to url_targets table
+// The usage counters of its quota subject are checked and updated in the same transaction
 // Receiving context, and CreateURLParams as parameter
-// Returning inserted url_id (int) if success, and error if failed
+// Returning inserted url_id (int) if success, and *domain.QuotaExceededError or error if failed
 
 func (r *urlRepository) Create(ctx context.Context, params domain.CreateUrlParams) (int, error) {
-	sqlRes, err := r.db.ExecContext(ctx, queries.InsertURL, params.Url, params.ShortUrl)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	subjectType, subjectID := domain.QuotaSubject(params.WorkspaceID, params.OwnerID)
+	if subjectID != 0 {
+		err = checkQuota(ctx, tx, subjectType, subjectID, params)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	sqlRes, err := tx.ExecContext(ctx, queries.InsertURL, params.Url, params.ShortUrl, params.RedirectType,
+		params.QueryPolicy, params.UtmSource, params.UtmMedium, params.UtmCampaign, params.PasswordHash,
+		params.SingleUse, params.ActiveFrom, params.ExpiresAt,
+		params.FallbackUrl, params.Title, params.Description, params.Image, params.ForcePreview, params.OwnerID, params.WorkspaceID, params.CreatedAt)
 	if err != nil {
 		return 0, err
 	}
@@ -31,80 +72,682 @@ func (r *urlRepository) Create(ctx context.Context, params domain.CreateUrlParam
 		return 0, err
 	}
 
+	err = insertTargets(ctx, tx, int(lastInsertID), params.Targets)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertRules(ctx, tx, int(lastInsertID), params.Rules)
+	if err != nil {
+		return 0, err
+	}
+
+	if subjectID != 0 {
+		_, err = tx.ExecContext(ctx, queries.IncrementUsage, subjectType, subjectID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	return int(lastInsertID), nil
 }
 
+// Lock the usage counters of a subject, and check one more link fits in its quota
+// The counters are counted from the urls of the subject when they are created and when a new month starts,
+// so links created before quotas existed, or moved to the subject by a deleted workspace or user, are caught up with
+
+func checkQuota(ctx context.Context, tx *sql.Tx, subjectType string, subjectID int, params domain.CreateUrlParams) error {
+	var periodStart int64
+	err := tx.QueryRowContext(ctx, queries.FindUsagePeriod, subjectType, subjectID).Scan(&periodStart)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err != nil || periodStart != params.PeriodStart {
+		query := queries.StartUserUsagePeriod
+		if subjectType == domain.QuotaSubjectWorkspace {
+			query = queries.StartWorkspaceUsagePeriod
+		}
+		_, err = tx.ExecContext(ctx, query, subjectType, subjectID, params.PeriodStart, params.PeriodStart, subjectID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, periodEnd := domain.QuotaPeriod(params.PeriodStart)
+	usage := domain.Usage{SubjectType: subjectType, SubjectID: subjectID, PeriodStart: params.PeriodStart, PeriodEnd: periodEnd}
+	err = tx.QueryRowContext(ctx, queries.FindUsageForUpdate, params.Quota.MonthlyCreates, params.Quota.ActiveLinks, subjectType, subjectID).
+		Scan(&usage.MonthlyCreates, &usage.ActiveLinks, &usage.Quota.MonthlyCreates, &usage.Quota.ActiveLinks)
+	if err != nil {
+		return err
+	}
+
+	return quotaExceeded(usage)
+}
+
+// Check one more link fits in the quota of its subject, without locking anything
+// An early answer sparing the work of a create that will fail, Create checks again in its transaction
+// Receiving context, and CreateURLParams as parameter
+// Returning *domain.QuotaExceededError when the link doesn't fit, and error if failed
+
+func (r *urlRepository) CheckQuota(ctx context.Context, params domain.CreateUrlParams) error {
+	subjectType, subjectID := domain.QuotaSubject(params.WorkspaceID, params.OwnerID)
+	if subjectID == 0 {
+		return nil
+	}
+
+	_, periodEnd := domain.QuotaPeriod(params.PeriodStart)
+	usage := domain.Usage{SubjectType: subjectType, SubjectID: subjectID, PeriodEnd: periodEnd}
+	err := r.db.QueryRowContext(ctx, queries.FindUsageWithQuota, params.Quota.MonthlyCreates, params.Quota.ActiveLinks, subjectType, subjectID).
+		Scan(&usage.PeriodStart, &usage.MonthlyCreates, &usage.ActiveLinks, &usage.Quota.MonthlyCreates, &usage.Quota.ActiveLinks)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the counters are only counted by the first create of the subject
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if usage.PeriodStart != params.PeriodStart {
+		usage.PeriodStart, usage.MonthlyCreates = params.PeriodStart, 0
+	}
+	return quotaExceeded(usage)
+}
+
+func quotaExceeded(usage domain.Usage) error {
+	if usage.Quota.ActiveLinks > 0 && usage.ActiveLinks >= usage.Quota.ActiveLinks {
+		return &domain.QuotaExceededError{Quota: domain.QuotaActiveLinks, Usage: usage}
+	}
+	if usage.Quota.MonthlyCreates > 0 && usage.MonthlyCreates >= usage.Quota.MonthlyCreates {
+		return &domain.QuotaExceededError{Quota: domain.QuotaMonthlyCreates, Usage: usage}
+	}
+	return nil
+}
+
+func insertTargets(ctx context.Context, tx *sql.Tx, urlID int, targets []domain.UrlTarget) error {
+	for _, target := range targets {
+		_, err := tx.ExecContext(ctx, queries.InsertTarget, urlID, target.Url, target.Weight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertRules(ctx context.Context, tx *sql.Tx, urlID int, rules []domain.UrlRule) error {
+	for _, rule := range rules {
+		_, err := tx.ExecContext(ctx, queries.InsertRule, urlID, rule.Position, rule.Os, rule.Device, rule.Language, rule.Country, rule.Url)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch url_targets and url_rules data of the given urls, and attach them to each url
+// Receiving context, and urls ([]domain.Url) as parameter
+// Returning error if failed
+
+func (r *urlRepository) attachRelations(ctx context.Context, urls []domain.Url) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(urls))
+	for i, url := range urls {
+		ids[i] = url.ID
+	}
+
+	targets, err := r.findTargets(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	rules, err := r.findRules(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range urls {
+		urls[i].Targets = targets[urls[i].ID]
+		urls[i].Rules = rules[urls[i].ID]
+	}
+	return nil
+}
+
+func (r *urlRepository) findTargets(ctx context.Context, ids []interface{}) (map[int][]domain.UrlTarget, error) {
+	rows, err := r.db.QueryContext(ctx, queries.FindTargetsByUrlIDs+placeholders(len(ids)), ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make(map[int][]domain.UrlTarget)
+	for rows.Next() {
+		target := domain.UrlTarget{}
+		err = rows.Scan(&target.ID, &target.UrlID, &target.Url, &target.Weight, &target.ClickCount)
+		if err != nil {
+			return nil, err
+		}
+
+		targets[target.UrlID] = append(targets[target.UrlID], target)
+	}
+	return targets, rows.Err()
+}
+
+func (r *urlRepository) findRules(ctx context.Context, ids []interface{}) (map[int][]domain.UrlRule, error) {
+	rows, err := r.db.QueryContext(ctx, queries.FindRulesByUrlIDs+placeholders(len(ids)), ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[int][]domain.UrlRule)
+	for rows.Next() {
+		rule := domain.UrlRule{}
+		err = rows.Scan(&rule.ID, &rule.UrlID, &rule.Position, &rule.Os, &rule.Device, &rule.Language, &rule.Country, &rule.Url)
+		if err != nil {
+			return nil, err
+		}
+
+		rules[rule.UrlID] = append(rules[rule.UrlID], rule)
+	}
+
+	for _, urlRules := range rules {
+		sort.SliceStable(urlRules, func(i, j int) bool {
+			return urlRules[i].Position < urlRules[j].Position
+		})
+	}
+	return rules, rows.Err()
+}
+
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", n), ",") + ")"
+}
+
 // Fetch one url data from urls table
 // Receiving context, and shortUrl (string) as parameter
 // Returning url data (domain.Url) if success, and error if failed
 
 func (r *urlRepository) FindByShortUrl(ctx context.Context, shortUrl string) (domain.Url, error) {
-	url := domain.Url{}
-	err := r.db.QueryRowContext(ctx, queries.FindByShort, shortUrl).
-		Scan(&url.ID, &url.Url, &url.ShortUrl, &url.ClickCount, &url.CreatedAt)
-
+	url, err := scanUrl(r.db.QueryRowContext(ctx, queries.FindByShort, shortUrl))
 	if err != nil {
 		return url, err
 	}
 
-	return url, nil
+	urls := []domain.Url{url}
+	err = r.attachRelations(ctx, urls)
+	return urls[0], err
 }
 
 // Fetch one url data from urls table
-// Receiving context, and id (int) as parameter
+// Receiving context, id (int), and userID (int), 0 for any user, as parameter
 // Returning url data (domain.Url) if success, and error if failed
 
-func (r *urlRepository) FindByID(ctx context.Context, id int) (domain.Url, error) {
-	url := domain.Url{}
-	err := r.db.QueryRowContext(ctx, queries.FindByID, id).
-		Scan(&url.ID, &url.Url, &url.ShortUrl, &url.ClickCount, &url.CreatedAt)
+func (r *urlRepository) FindByID(ctx context.Context, id, userID int) (domain.Url, error) {
+	query, args := queries.FindByID, []interface{}{id}
+	if userID != 0 {
+		query, args = queries.FindByIDAndUser, append(args, userID, userID)
+	}
 
+	url, err := scanUrl(r.db.QueryRowContext(ctx, query, args...))
 	if err != nil {
 		return url, err
 	}
 
-	return url, nil
+	urls := []domain.Url{url}
+	err = r.attachRelations(ctx, urls)
+	return urls[0], err
 }
 
 // Fetch all url data from urls table
-// Receiving context as parameter
+// Receiving context, and filter (domain.UrlFilter) as parameter
 // Returning url data ([] domain.Url) if success, and error if failed
 
-func (r *urlRepository) FindAll(ctx context.Context) ([]domain.Url, error) {
+func (r *urlRepository) FindAll(ctx context.Context, filter domain.UrlFilter) ([]domain.Url, error) {
 	urls := []domain.Url{}
-	rows, err := r.db.QueryContext(ctx, queries.FindAll)
+
+	query, args := queries.FindAll, []interface{}{}
+	switch filter.Status {
+	case domain.UrlStatusScheduled:
+		query, args = queries.FindAllScheduled, []interface{}{filter.Now}
+	case domain.UrlStatusActive:
+		query, args = queries.FindAllActive, []interface{}{filter.Now, filter.Now}
+	case domain.UrlStatusExpired:
+		query, args = queries.FindAllExpired, []interface{}{filter.Now}
+	}
+	switch {
+	case filter.WorkspaceID != 0:
+		if query == queries.FindAll {
+			query = queries.FindAllByWorkspace
+		} else {
+			query += queries.AndWorkspace
+		}
+		args = append(args, filter.WorkspaceID)
+	case filter.OwnerID != 0:
+		if query == queries.FindAll {
+			query = queries.FindAllByOwner
+		} else {
+			query += queries.AndOwner
+		}
+		args = append(args, filter.OwnerID)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return urls, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		url := domain.Url{}
-		err = rows.Scan(&url.ID, &url.Url, &url.ShortUrl, &url.ClickCount, &url.CreatedAt)
+		url, err := scanUrl(rows)
 		if err != nil {
 			return urls, err
 		}
 
 		urls = append(urls, url)
 	}
+	rows.Close()
 
-	return urls, nil
+	err = r.attachRelations(ctx, urls)
+	return urls, err
 }
 
-// Delete one url data from urls table
-// Receiving context, and id (int) as parameter
+// Delete one url data from urls table, and count one less active link for its quota subject
+// Receiving context, id (int), and userID (int), 0 for any user, as parameter
 // Returning deleted url_id (int) if success, and error if failed
 
-func (r *urlRepository) DeleteByID(ctx context.Context, ID int) (int, error) {
-	sqlRes, err := r.db.ExecContext(ctx, queries.DeleteByID, ID)
+func (r *urlRepository) DeleteByID(ctx context.Context, ID, userID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback()
+
+	var workspaceID, ownerID int
+	err = tx.QueryRowContext(ctx, queries.FindUrlSubjectForUpdate, ID).Scan(&workspaceID, &ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	query, args := queries.DeleteByID, []interface{}{ID}
+	if userID != 0 {
+		query, args = queries.DeleteByIDAndUser, append(args, userID, userID)
+	}
+	sqlRes, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := sqlRes.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, sql.ErrNoRows
+	}
 
 	lastDeletedId, err := sqlRes.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
+	subjectType, subjectID := domain.QuotaSubject(workspaceID, ownerID)
+	if subjectID != 0 {
+		_, err = tx.ExecContext(ctx, queries.DecrementActiveLinks, subjectType, subjectID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	return int(lastDeletedId), nil
 }
+
+// Mark one single use url data as consumed
+// Receiving context, id (int), and consumedAt (unix time) as parameter
+// Returning affected rows (int), 0 when the url was already consumed, and error if failed
+
+func (r *urlRepository) MarkConsumed(ctx context.Context, id int, consumedAt int64) (int, error) {
+	sqlRes, err := r.db.ExecContext(ctx, queries.MarkConsumed, consumedAt, id)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := sqlRes.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
+
+// Replace all targets of one url in url_targets table
+// Receiving context, urlID (int), and targets ([]domain.UrlTarget) as parameter
+// Returning error if failed
+
+func (r *urlRepository) ReplaceTargets(ctx context.Context, urlID int, targets []domain.UrlTarget) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, queries.DeleteTargetsByUrlID, urlID)
+	if err != nil {
+		return err
+	}
+
+	err = insertTargets(ctx, tx, urlID, targets)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replace all rules of one url in url_rules table
+// Receiving context, urlID (int), and rules ([]domain.UrlRule) as parameter
+// Returning error if failed
+
+func (r *urlRepository) ReplaceRules(ctx context.Context, urlID int, rules []domain.UrlRule) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, queries.DeleteRulesByUrlID, urlID)
+	if err != nil {
+		return err
+	}
+
+	err = insertRules(ctx, tx, urlID, rules)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Insert a batch of click events into click_events table with one multi-row insert
+// Increasing click count (bot click count for bots) of the urls, and click count of their resolved targets
+// by their human clicks, by the clicks in the batch, all in the same transaction
+// Receiving context, and events ([]domain.ClickEvent, TargetID 0 when the url has no targets) as parameter
+// Returning error if failed
+
+func (r *urlRepository) CreateClickEvents(ctx context.Context, events []domain.ClickEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	type targetKey struct{ urlID, targetID int }
+	urlClicks, botClicks, targetClicks := map[int]int{}, map[int]int{}, map[targetKey]int{}
+	values := make([]string, len(events))
+	args := make([]interface{}, 0, len(events)*9)
+	for i, event := range events {
+		values[i] = queries.ClickEventValues
+		args = append(args, event.UrlID, event.TargetID, event.ClickedAt,
+			event.Referrer, event.Os, event.Device, event.Browser, event.Country, event.Bot)
+
+		if event.Bot {
+			botClicks[event.UrlID]++
+			continue
+		}
+		urlClicks[event.UrlID]++
+		if event.TargetID != 0 {
+			targetClicks[targetKey{event.UrlID, event.TargetID}]++
+		}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, queries.InsertClickEvents+strings.Join(values, ","), args...)
+	if err != nil {
+		return err
+	}
+
+	// rows are updated in id order, so concurrent batches can't deadlock on each other
+	urlIDs := make([]int, 0, len(urlClicks)+len(botClicks))
+	for urlID := range urlClicks {
+		urlIDs = append(urlIDs, urlID)
+	}
+	for urlID := range botClicks {
+		if _, ok := urlClicks[urlID]; !ok {
+			urlIDs = append(urlIDs, urlID)
+		}
+	}
+	sort.Ints(urlIDs)
+	for _, urlID := range urlIDs {
+		_, err = tx.ExecContext(ctx, queries.IncrementClickCount, urlClicks[urlID], botClicks[urlID], urlID)
+		if err != nil {
+			return err
+		}
+	}
+
+	targets := make([]targetKey, 0, len(targetClicks))
+	for target := range targetClicks {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].targetID < targets[j].targetID
+	})
+	for _, target := range targets {
+		_, err = tx.ExecContext(ctx, queries.IncrementTargetClickCount, targetClicks[target], target.targetID, target.urlID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mergeVisitorSketches(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Add the visitors of a batch of click events to the stored sketch of each url and day
+// Bot events and events without a visitor hash are left out
+
+func mergeVisitorSketches(ctx context.Context, tx *sql.Tx, events []domain.ClickEvent) error {
+	type sketchKey struct {
+		urlID int
+		day   int64
+	}
+	sketches := map[sketchKey]*utils.HyperLogLog{}
+	for _, event := range events {
+		if event.Visitor == 0 || event.Bot {
+			continue
+		}
+
+		key := sketchKey{event.UrlID, event.ClickedAt / 86400 * 86400}
+		if sketches[key] == nil {
+			sketches[key] = utils.NewHyperLogLog()
+		}
+		sketches[key].Add(event.Visitor)
+	}
+
+	keys := make([]sketchKey, 0, len(sketches))
+	for key := range sketches {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].urlID != keys[j].urlID {
+			return keys[i].urlID < keys[j].urlID
+		}
+		return keys[i].day < keys[j].day
+	})
+
+	for _, key := range keys {
+		sketch := sketches[key]
+
+		stored := []byte{}
+		err := tx.QueryRowContext(ctx, queries.FindVisitorSketchForUpdate, key.urlID, key.day).Scan(&stored)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			previous := &utils.HyperLogLog{}
+			err = previous.UnmarshalBinary(stored)
+			if err != nil {
+				return err
+			}
+			sketch.Merge(previous)
+		}
+
+		data, err := sketch.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, queries.UpsertVisitorSketch, key.urlID, key.day, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recompute the hourly rollups of click_events into click_rollups table
+// Receiving context, from (unix time, start of an hour), and to (unix time, exclusive) as parameter
+// Returning error if failed
+
+func (r *urlRepository) RollupClicks(ctx context.Context, from, to int64) error {
+	_, err := r.db.ExecContext(ctx, queries.RollupClicks, from, to)
+	return err
+}
+
+// Fetch the click rollup watermark from click_rollup_watermark table
+// Receiving context as parameter
+// Returning the start of the oldest hour whose rollups may be missing (int64), 0 when there are no click events, and error if failed
+
+func (r *urlRepository) FindRollupWatermark(ctx context.Context) (int64, error) {
+	var watermark int64
+	err := r.db.QueryRowContext(ctx, queries.FindRollupWatermark).Scan(&watermark)
+	return watermark, err
+}
+
+// Store the click rollup watermark in click_rollup_watermark table
+// Receiving context, and hourStart (unix time, start of an hour) as parameter
+// Returning error if failed
+
+func (r *urlRepository) SetRollupWatermark(ctx context.Context, hourStart int64) error {
+	_, err := r.db.ExecContext(ctx, queries.SetRollupWatermark, hourStart)
+	return err
+}
+
+// Fetch the visitor sketches of one url from url_visitors table
+// Receiving context, urlID (int), from (unix time of a day start, inclusive), and to (unix time, exclusive) as parameter
+// Returning sketches ([]domain.VisitorSketch) in day order if success, and error if failed
+
+func (r *urlRepository) FindVisitorSketches(ctx context.Context, urlID int, from, to int64) ([]domain.VisitorSketch, error) {
+	sketches := []domain.VisitorSketch{}
+
+	rows, err := r.db.QueryContext(ctx, queries.FindVisitorSketches, urlID, from, to)
+	if err != nil {
+		return sketches, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sketch := domain.VisitorSketch{}
+		err = rows.Scan(&sketch.Day, &sketch.Sketch)
+		if err != nil {
+			return sketches, err
+		}
+		sketches = append(sketches, sketch)
+	}
+	return sketches, rows.Err()
+}
+
+// Count clicks of one url per time bucket in click_rollups table
+// Receiving context, and filter (domain.StatsFilter) as parameter
+// Returning the non empty buckets ([]domain.ClickBucket) in time order if success, and error if failed
+
+func (r *urlRepository) CountClicks(ctx context.Context, filter domain.StatsFilter) ([]domain.ClickBucket, error) {
+	buckets := []domain.ClickBucket{}
+
+	rows, err := r.db.QueryContext(ctx, queries.CountClicksByTime, filter.BucketOffset, filter.BucketSize,
+		filter.BucketSize, filter.BucketOffset, filter.UrlID, filter.From, filter.To, maxBot(filter))
+	if err != nil {
+		return buckets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		bucket := domain.ClickBucket{}
+		err = rows.Scan(&bucket.Time, &bucket.Clicks)
+		if err != nil {
+			return buckets, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// Count bot clicks of one url in click_rollups table
+// Receiving context, and filter (domain.StatsFilter) as parameter
+// Returning bot clicks (int) if success, and error if failed
+
+func (r *urlRepository) CountBotClicks(ctx context.Context, filter domain.StatsFilter) (int, error) {
+	clicks := 0
+	err := r.db.QueryRowContext(ctx, queries.CountBotClicks, filter.UrlID, filter.From, filter.To).Scan(&clicks)
+	return clicks, err
+}
+
+// Highest value of the bot column counted by a filter
+func maxBot(filter domain.StatsFilter) int {
+	if filter.IncludeBots {
+		return 1
+	}
+	return 0
+}
+
+var countClicksByQueries = map[string]string{
+	domain.StatsDimensionReferrer: queries.CountClicksByReferrer,
+	domain.StatsDimensionCountry:  queries.CountClicksByCountry,
+	domain.StatsDimensionBrowser:  queries.CountClicksByBrowser,
+	domain.StatsDimensionOs:       queries.CountClicksByOs,
+	domain.StatsDimensionDevice:   queries.CountClicksByDevice,
+}
+
+// Count clicks of one url per value of a click event attribute in click_rollups table
+// Receiving context, filter (domain.StatsFilter), dimension (one of domain.StatsDimension*), and limit (int) as parameter
+// Returning the most clicked values ([]domain.ClickCount) if success, and error if failed
+
+func (r *urlRepository) CountClicksBy(ctx context.Context, filter domain.StatsFilter, dimension string, limit int) ([]domain.ClickCount, error) {
+	counts := []domain.ClickCount{}
+
+	query, ok := countClicksByQueries[dimension]
+	if !ok {
+		return counts, fmt.Errorf("unknown stats dimension %q", dimension)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, filter.UrlID, filter.From, filter.To, maxBot(filter), limit)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		count := domain.ClickCount{}
+		err = rows.Scan(&count.Value, &count.Clicks)
+		if err != nil {
+			return counts, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
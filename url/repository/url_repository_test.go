@@ -3,15 +3,27 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/mrizalr/urlshortener/db/queries"
 	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
 	"github.com/stretchr/testify/assert"
 )
 
+var targetColumns = []string{"id", "url_id", "url", "weight", "click_count"}
+
+var ruleColumns = []string{"id", "url_id", "position", "os", "device", "language", "country", "url"}
+
+var urlColumns = []string{"id", "url", "short_url", "click_count", "created_at", "redirect_type", "query_policy",
+	"utm_source", "utm_medium", "utm_campaign", "password_hash",
+	"single_use", "consumed_at", "active_from", "expires_at",
+	"fallback_url", "bot_click_count", "title", "description", "image", "force_preview", "owner_id", "workspace_id"}
+
 func NewMock() (*sql.DB, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
@@ -25,13 +37,38 @@ func TestCreateURL(t *testing.T) {
 	defer db.Close()
 
 	params := domain.CreateUrlParams{
-		Url:      "www.github.com/mrizalr/urlshortener",
-		ShortUrl: "xhYsg23",
+		Url:          "www.github.com/mrizalr/urlshortener",
+		ShortUrl:     "xhYsg23",
+		CreatedAt:    1700000000,
+		RedirectType: 302,
+		QueryPolicy:  "keep",
+		UtmSource:    "newsletter",
+		Title:        "urlshortener",
+		Image:        "https://opengraph.githubassets.com/1/mrizalr/urlshortener",
+		Targets: []domain.UrlTarget{
+			{Url: "https://www.github.com/a", Weight: 70},
+			{Url: "https://www.github.com/b", Weight: 30},
+		},
+		Rules: []domain.UrlRule{
+			{Position: 0, Os: "ios", Url: "https://apps.apple.com/app/id1"},
+		},
 	}
 
+	mock.ExpectBegin()
 	mock.ExpectExec(queries.InsertURL).
-		WithArgs(params.Url, params.ShortUrl).
+		WithArgs(params.Url, params.ShortUrl, params.RedirectType, params.QueryPolicy,
+			params.UtmSource, params.UtmMedium, params.UtmCampaign, params.PasswordHash, params.SingleUse, params.ActiveFrom, params.ExpiresAt,
+			params.FallbackUrl, params.Title, params.Description, params.Image, params.ForcePreview, params.OwnerID, params.WorkspaceID, params.CreatedAt).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, target := range params.Targets {
+		mock.ExpectExec(queries.InsertTarget).WithArgs(1, target.Url, target.Weight).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	for _, rule := range params.Rules {
+		mock.ExpectExec(queries.InsertRule).WithArgs(1, rule.Position, rule.Os, rule.Device, rule.Language, rule.Country, rule.Url).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
 
 	repo := urlRepository{db}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -41,6 +78,7 @@ func TestCreateURL(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestFindByShortUrl(t *testing.T) {
@@ -48,16 +86,39 @@ func TestFindByShortUrl(t *testing.T) {
 	defer db.Close()
 
 	params := domain.Url{
-		ID:         1,
-		Url:        "www.github.com/mrizalr/urlshortener",
-		ShortUrl:   "xh52VsC",
-		ClickCount: 162,
-		CreatedAt:  time.Now().Unix(),
+		ID:            1,
+		Url:           "www.github.com/mrizalr/urlshortener",
+		ShortUrl:      "xh52VsC",
+		ClickCount:    162,
+		BotClickCount: 12,
+		CreatedAt:     time.Now().Unix(),
+		RedirectType:  307,
+		QueryPolicy:   "override",
+		UtmCampaign:   "launch",
+		PasswordHash:  "$2a$10$hash",
+		SingleUse:     true,
+		ConsumedAt:    time.Now().Unix(),
+		FallbackUrl:   "https://www.github.com",
+		Title:         "mrizalr/urlshortener",
+		Description:   "URL shortener written in Go",
+		Image:         "https://opengraph.githubassets.com/1/mrizalr/urlshortener",
+		ForcePreview:  true,
+		OwnerID:       5,
+		WorkspaceID:   2,
 	}
 
-	rows := mock.NewRows([]string{"id", "url", "short_url", "click_count", "created_at"}).
-		AddRow(params.ID, params.Url, params.ShortUrl, params.ClickCount, params.CreatedAt)
+	rows := mock.NewRows(urlColumns).
+		AddRow(params.ID, params.Url, params.ShortUrl, params.ClickCount, params.CreatedAt, params.RedirectType,
+			params.QueryPolicy, params.UtmSource, params.UtmMedium, params.UtmCampaign, params.PasswordHash,
+			params.SingleUse, params.ConsumedAt, params.ActiveFrom, params.ExpiresAt, params.FallbackUrl, params.BotClickCount,
+			params.Title, params.Description, params.Image, params.ForcePreview, params.OwnerID, params.WorkspaceID)
 	mock.ExpectQuery(queries.FindByShort).WithArgs(params.ShortUrl).WillReturnRows(rows)
+	mock.ExpectQuery(queries.FindTargetsByUrlIDs + "(?)").WithArgs(params.ID).
+		WillReturnRows(mock.NewRows(targetColumns).AddRow(3, params.ID, "https://www.github.com/a", 1, 12))
+	mock.ExpectQuery(queries.FindRulesByUrlIDs + "(?)").WithArgs(params.ID).
+		WillReturnRows(mock.NewRows(ruleColumns).
+			AddRow(5, params.ID, 1, "android", "", "", "", "https://play.google.com").
+			AddRow(4, params.ID, 0, "ios", "", "", "", "https://apps.apple.com"))
 
 	repo := urlRepository{db}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -71,7 +132,25 @@ func TestFindByShortUrl(t *testing.T) {
 	assert.Equal(t, params.Url, url.Url)
 	assert.Equal(t, params.ShortUrl, url.ShortUrl)
 	assert.Equal(t, params.ClickCount, url.ClickCount)
+	assert.Equal(t, params.BotClickCount, url.BotClickCount)
 	assert.Equal(t, params.CreatedAt, url.CreatedAt)
+	assert.Equal(t, params.RedirectType, url.RedirectType)
+	assert.Equal(t, params.QueryPolicy, url.QueryPolicy)
+	assert.Equal(t, params.OwnerID, url.OwnerID)
+	assert.Equal(t, params.WorkspaceID, url.WorkspaceID)
+	assert.Equal(t, params.UtmCampaign, url.UtmCampaign)
+	assert.True(t, url.Protected)
+	assert.True(t, url.SingleUse)
+	assert.Equal(t, params.ConsumedAt, url.ConsumedAt)
+	assert.Equal(t, params.FallbackUrl, url.FallbackUrl)
+	assert.Equal(t, params.Title, url.Title)
+	assert.Equal(t, params.Description, url.Description)
+	assert.Equal(t, params.Image, url.Image)
+	assert.True(t, url.ForcePreview)
+	assert.Equal(t, []domain.UrlTarget{{ID: 3, UrlID: 1, Url: "https://www.github.com/a", Weight: 1, ClickCount: 12}}, url.Targets)
+	assert.Len(t, url.Rules, 2)
+	assert.Equal(t, "ios", url.Rules[0].Os)
+	assert.Equal(t, "android", url.Rules[1].Os)
 }
 
 func TestFindAll(t *testing.T) {
@@ -95,21 +174,70 @@ func TestFindAll(t *testing.T) {
 		},
 	}
 
-	rows := mock.NewRows([]string{"id", "url", "short_url", "click_count", "created_at"})
+	rows := mock.NewRows(urlColumns)
 	for _, param := range params {
-		rows.AddRow(param.ID, param.Url, param.ShortUrl, param.ClickCount, param.CreatedAt)
+		rows.AddRow(param.ID, param.Url, param.ShortUrl, param.ClickCount, param.CreatedAt, param.RedirectType,
+			param.QueryPolicy, param.UtmSource, param.UtmMedium, param.UtmCampaign, param.PasswordHash,
+			param.SingleUse, param.ConsumedAt, param.ActiveFrom, param.ExpiresAt, param.FallbackUrl, param.BotClickCount,
+			param.Title, param.Description, param.Image, param.ForcePreview, param.OwnerID, param.WorkspaceID)
 	}
 
 	mock.ExpectQuery(queries.FindAll).WillReturnRows(rows)
+	mock.ExpectQuery(queries.FindTargetsByUrlIDs+"(?,?)").WithArgs(1, 2).
+		WillReturnRows(mock.NewRows(targetColumns).
+			AddRow(1, 2, "https://www.linkedin.com/a", 1, 0).
+			AddRow(2, 2, "https://www.linkedin.com/b", 1, 0))
+	mock.ExpectQuery(queries.FindRulesByUrlIDs+"(?,?)").WithArgs(1, 2).WillReturnRows(mock.NewRows(ruleColumns))
 
 	repo := urlRepository{db}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	urls, err := repo.FindAll(ctx)
+	urls, err := repo.FindAll(ctx, domain.UrlFilter{})
 	assert.NoError(t, err)
 	assert.NotNil(t, urls)
 	assert.Len(t, urls, 2)
+	assert.Empty(t, urls[0].Targets)
+	assert.Len(t, urls[1].Targets, 2)
+}
+
+func TestFindAllByStatus(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	now := time.Now().Unix()
+	testCases := []struct {
+		status      string
+		ownerID     int
+		workspaceID int
+		query       string
+		args        []driver.Value
+	}{
+		{domain.UrlStatusScheduled, 0, 0, queries.FindAllScheduled, []driver.Value{now}},
+		{domain.UrlStatusActive, 0, 0, queries.FindAllActive, []driver.Value{now, now}},
+		{domain.UrlStatusExpired, 0, 0, queries.FindAllExpired, []driver.Value{now}},
+		{"", 5, 0, queries.FindAllByOwner, []driver.Value{5}},
+		{domain.UrlStatusActive, 5, 0, queries.FindAllActive + queries.AndOwner, []driver.Value{now, now, 5}},
+		{"", 5, 2, queries.FindAllByWorkspace, []driver.Value{2}},
+		{domain.UrlStatusExpired, 0, 2, queries.FindAllExpired + queries.AndWorkspace, []driver.Value{now, 2}},
+	}
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, testCase := range testCases {
+		rows := mock.NewRows(urlColumns).
+			AddRow(1, "https://www.github.com/mrizalr", "2HsEgd", 0, now, 302, "drop", "", "", "", "", false, 0, 0, 0, "", 0, "", "", "", false, testCase.ownerID, testCase.workspaceID)
+		mock.ExpectQuery(testCase.query).WithArgs(testCase.args...).WillReturnRows(rows)
+		mock.ExpectQuery(queries.FindTargetsByUrlIDs + "(?)").WithArgs(1).WillReturnRows(mock.NewRows(targetColumns))
+		mock.ExpectQuery(queries.FindRulesByUrlIDs + "(?)").WithArgs(1).WillReturnRows(mock.NewRows(ruleColumns))
+
+		urls, err := repo.FindAll(ctx, domain.UrlFilter{Status: testCase.status, Now: now, OwnerID: testCase.ownerID, WorkspaceID: testCase.workspaceID})
+		assert.NoError(t, err)
+		assert.Len(t, urls, 1)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestDeleteByID(t *testing.T) {
@@ -124,14 +252,410 @@ func TestDeleteByID(t *testing.T) {
 		CreatedAt:  time.Now().Unix(),
 	}
 
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindUrlSubjectForUpdate).WithArgs(params.ID).
+		WillReturnRows(mock.NewRows([]string{"workspace_id", "owner_id"}).AddRow(4, 3))
 	mock.ExpectExec(queries.DeleteByID).WithArgs(params.ID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(queries.DecrementActiveLinks).WithArgs(domain.QuotaSubjectWorkspace, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindUrlSubjectForUpdate).WithArgs(2).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	// the url of a workspace the user can't edit is left alone
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindUrlSubjectForUpdate).WithArgs(params.ID).
+		WillReturnRows(mock.NewRows([]string{"workspace_id", "owner_id"}).AddRow(4, 3))
+	mock.ExpectExec(queries.DeleteByIDAndUser).WithArgs(params.ID, 5, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
 
 	repo := urlRepository{db}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	id, err := repo.DeleteByID(ctx, 1)
+	id, err := repo.DeleteByID(ctx, 1, 0)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, id)
+
+	_, err = repo.DeleteByID(ctx, 2, 0)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = repo.DeleteByID(ctx, 1, 5)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindByIDUser(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	// the url of another owner, or of a workspace the user isn't a member of, isn't found
+	mock.ExpectQuery(queries.FindByIDAndUser).WithArgs(1, 5, 5).WillReturnError(sql.ErrNoRows)
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := repo.FindByID(ctx, 1, 5)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateURLQuota(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	// november 2023
+	params := domain.CreateUrlParams{
+		Url:         "https://www.github.com/mrizalr/urlshortener",
+		ShortUrl:    "xhYsg23",
+		OwnerID:     3,
+		Quota:       domain.Quota{MonthlyCreates: 10},
+		PeriodStart: 1698796800,
+	}
+	usageColumns := []string{"monthly_creates", "active_links", "quota_monthly_creates", "quota_active_links"}
+
+	// the counters of a subject without any are counted from the urls it already holds
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindUsagePeriod).WithArgs(domain.QuotaSubjectUser, 3).WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(queries.StartUserUsagePeriod).WithArgs(domain.QuotaSubjectUser, 3, params.PeriodStart, params.PeriodStart, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(queries.FindUsageForUpdate).WithArgs(10, 0, domain.QuotaSubjectUser, 3).
+		WillReturnRows(mock.NewRows(usageColumns).AddRow(9, 40, 10, 0))
+	mock.ExpectExec(queries.InsertURL).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectExec(queries.IncrementUsage).WithArgs(domain.QuotaSubjectUser, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// counters of the current month aren't counted again, the quotas table overrides the default limits
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindUsagePeriod).WithArgs(domain.QuotaSubjectUser, 3).
+		WillReturnRows(mock.NewRows([]string{"period_start"}).AddRow(params.PeriodStart))
+	mock.ExpectQuery(queries.FindUsageForUpdate).WithArgs(10, 0, domain.QuotaSubjectUser, 3).
+		WillReturnRows(mock.NewRows(usageColumns).AddRow(10, 41, 10, 41))
+	mock.ExpectRollback()
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	id, err := repo.Create(ctx, params)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, id)
+
+	_, err = repo.Create(ctx, params)
+	quotaErr := &domain.QuotaExceededError{}
+	assert.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, domain.QuotaActiveLinks, quotaErr.Quota)
+	assert.Equal(t, 41, quotaErr.Limit())
+	assert.Equal(t, int64(1701388800), quotaErr.Usage.PeriodEnd)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckQuota(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	// november 2023
+	params := domain.CreateUrlParams{OwnerID: 3, Quota: domain.Quota{MonthlyCreates: 10}, PeriodStart: 1698796800}
+	usageColumns := []string{"period_start", "monthly_creates", "active_links", "quota_monthly_creates", "quota_active_links"}
+
+	mock.ExpectQuery(queries.FindUsageWithQuota).WithArgs(10, 0, domain.QuotaSubjectUser, 3).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(queries.FindUsageWithQuota).WithArgs(10, 0, domain.QuotaSubjectUser, 3).
+		WillReturnRows(mock.NewRows(usageColumns).AddRow(params.PeriodStart, 10, 40, 10, 0))
+	// the creates of a past month don't count
+	mock.ExpectQuery(queries.FindUsageWithQuota).WithArgs(10, 0, domain.QuotaSubjectUser, 3).
+		WillReturnRows(mock.NewRows(usageColumns).AddRow(1696118400, 10, 40, 10, 0))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, repo.CheckQuota(ctx, params))
+	err := repo.CheckQuota(ctx, params)
+	quotaErr := &domain.QuotaExceededError{}
+	assert.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, domain.QuotaMonthlyCreates, quotaErr.Quota)
+	assert.NoError(t, repo.CheckQuota(ctx, params))
+
+	// links without an owner have no quota
+	assert.NoError(t, repo.CheckQuota(ctx, domain.CreateUrlParams{PeriodStart: params.PeriodStart}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateURLQuotaNewPeriod(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	// december 2023, the counters of a workspace are recounted from its urls, counting the two created this month
+	params := domain.CreateUrlParams{Url: "https://www.github.com", ShortUrl: "sales/xhYsg24", CreatedAt: 1701475200, OwnerID: 3, WorkspaceID: 4,
+		Quota: domain.Quota{MonthlyCreates: 3}, PeriodStart: 1701388800}
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindUsagePeriod).WithArgs(domain.QuotaSubjectWorkspace, 4).
+		WillReturnRows(mock.NewRows([]string{"period_start"}).AddRow(1698796800))
+	mock.ExpectExec(queries.StartWorkspaceUsagePeriod).WithArgs(domain.QuotaSubjectWorkspace, 4, params.PeriodStart, params.PeriodStart, 4).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(queries.FindUsageForUpdate).WithArgs(3, 0, domain.QuotaSubjectWorkspace, 4).
+		WillReturnRows(mock.NewRows([]string{"monthly_creates", "active_links", "quota_monthly_creates", "quota_active_links"}).AddRow(2, 12, 3, 0))
+	mock.ExpectExec(queries.InsertURL).WithArgs(params.Url, params.ShortUrl, 0, "", "", "", "", "", false, int64(0), int64(0),
+		"", "", "", "", false, 3, 4, params.CreatedAt).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(queries.IncrementUsage).WithArgs(domain.QuotaSubjectWorkspace, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	id, err := repo.Create(ctx, params)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkConsumed(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	consumedAt := time.Now().Unix()
+	mock.ExpectExec(queries.MarkConsumed).WithArgs(consumedAt, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.MarkConsumed).WithArgs(consumedAt, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	affected, err := repo.MarkConsumed(ctx, 1, consumedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, affected)
+
+	affected, err = repo.MarkConsumed(ctx, 1, consumedAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, affected)
+}
+
+func TestReplaceTargets(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	targets := []domain.UrlTarget{
+		{Url: "https://www.github.com/a", Weight: 1},
+		{Url: "https://www.github.com/b", Weight: 2},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(queries.DeleteTargetsByUrlID).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(queries.InsertTarget).WithArgs(1, targets[0].Url, targets[0].Weight).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectExec(queries.InsertTarget).WithArgs(1, targets[1].Url, targets[1].Weight).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := repo.ReplaceTargets(ctx, 1, targets)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateClickEvents(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	now := time.Now().Unix()
+	events := []domain.ClickEvent{
+		{UrlID: 2, ClickedAt: now, Os: "linux", Device: "desktop", Browser: "firefox"},
+		{UrlID: 1, TargetID: 4, ClickedAt: now, Referrer: "news.ycombinator.com", Os: "ios", Device: "mobile", Browser: "safari", Country: "ID", Visitor: 0x9e3779b97f4a7c15},
+		{UrlID: 1, TargetID: 4, ClickedAt: now, Os: "ios", Device: "mobile", Browser: "safari", Country: "ID", Visitor: 0x9e3779b97f4a7c15},
+		{UrlID: 1, TargetID: 4, ClickedAt: now, Browser: "other", Visitor: 0xbb67ae8584caa73b, Bot: true},
+		{UrlID: 3, ClickedAt: now, Browser: "other", Visitor: 0xbb67ae8584caa73b, Bot: true},
+	}
+
+	stored := utils.NewHyperLogLog()
+	stored.Add(0x6a09e667f3bcc908)
+	storedSketch, _ := stored.MarshalBinary()
+	day := now / 86400 * 86400
+
+	args := []driver.Value{}
+	for _, event := range events {
+		args = append(args, event.UrlID, event.TargetID, event.ClickedAt, event.Referrer, event.Os, event.Device, event.Browser, event.Country, event.Bot)
+	}
+
+	mock.ExpectBegin()
+	values := strings.TrimSuffix(strings.Repeat(queries.ClickEventValues+",", len(events)), ",")
+	mock.ExpectExec(queries.InsertClickEvents + values).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(1, 5))
+	mock.ExpectExec(queries.IncrementClickCount).WithArgs(2, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.IncrementClickCount).WithArgs(1, 0, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.IncrementClickCount).WithArgs(0, 1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.IncrementTargetClickCount).WithArgs(2, 4, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(queries.FindVisitorSketchForUpdate).WithArgs(1, day).
+		WillReturnRows(mock.NewRows([]string{"sketch"}).AddRow(storedSketch))
+	mock.ExpectExec(queries.UpsertVisitorSketch).WithArgs(1, day, sketchCount(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, repo.CreateClickEvents(ctx, events))
+	assert.NoError(t, repo.CreateClickEvents(ctx, nil))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Matches a marshaled visitor sketch by its estimate
+type sketchCount int
+
+func (c sketchCount) Match(value driver.Value) bool {
+	data, ok := value.([]byte)
+	sketch := &utils.HyperLogLog{}
+	return ok && sketch.UnmarshalBinary(data) == nil && sketch.Count() == int(c)
+}
+
+func TestFindVisitorSketches(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectQuery(queries.FindVisitorSketches).WithArgs(1, 1699920000, 1700092800).
+		WillReturnRows(mock.NewRows([]string{"day", "sketch"}).
+			AddRow(1699920000, []byte{2, 0, 1, 3}).
+			AddRow(1700006400, []byte{2, 0, 2, 1}))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sketches, err := repo.FindVisitorSketches(ctx, 1, 1699920000, 1700092800)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.VisitorSketch{
+		{Day: 1699920000, Sketch: []byte{2, 0, 1, 3}},
+		{Day: 1700006400, Sketch: []byte{2, 0, 2, 1}},
+	}, sketches)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountBotClicks(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	filter := domain.StatsFilter{UrlID: 1, From: 1700000000, To: 1700086400, BucketSize: 3600}
+	mock.ExpectQuery(queries.CountBotClicks).WithArgs(filter.UrlID, filter.From, filter.To).
+		WillReturnRows(mock.NewRows([]string{"clicks"}).AddRow(7))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	clicks, err := repo.CountBotClicks(ctx, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, clicks)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRollupClicks(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectExec(queries.RollupClicks).WithArgs(1699995600, 1700000000).WillReturnResult(sqlmock.NewResult(0, 4))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, repo.RollupClicks(ctx, 1699995600, 1700000000))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRollupWatermark(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectQuery(queries.FindRollupWatermark).WillReturnRows(mock.NewRows([]string{"hour_start"}).AddRow(1699992000))
+	mock.ExpectExec(queries.SetRollupWatermark).WithArgs(1699995600).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	watermark, err := repo.FindRollupWatermark(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1699992000), watermark)
+	assert.NoError(t, repo.SetRollupWatermark(ctx, 1699995600))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplaceRules(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	rules := []domain.UrlRule{
+		{Position: 0, Os: "ios", Url: "https://apps.apple.com/app/id1"},
+		{Position: 1, Device: "desktop", Language: "en", Url: "https://www.github.com"},
+		{Position: 2, Country: "ID", Url: "https://www.github.com/id"},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(queries.DeleteRulesByUrlID).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, rule := range rules {
+		mock.ExpectExec(queries.InsertRule).WithArgs(1, rule.Position, rule.Os, rule.Device, rule.Language, rule.Country, rule.Url).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := repo.ReplaceRules(ctx, 1, rules)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountClicks(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	filter := domain.StatsFilter{UrlID: 1, From: 1700000000, To: 1700086400, BucketSize: 3600}
+	mock.ExpectQuery(queries.CountClicksByTime).
+		WithArgs(filter.BucketOffset, filter.BucketSize, filter.BucketSize, filter.BucketOffset, filter.UrlID, filter.From, filter.To, 0).
+		WillReturnRows(mock.NewRows([]string{"bucket", "SUM(clicks)"}).
+			AddRow(1699999200, 4).
+			AddRow(1700006400, 1))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	buckets, err := repo.CountClicks(ctx, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.ClickBucket{{Time: 1699999200, Clicks: 4}, {Time: 1700006400, Clicks: 1}}, buckets)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountClicksBy(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	filter := domain.StatsFilter{UrlID: 1, From: 1700000000, To: 1700086400, BucketSize: 3600}
+	filter.IncludeBots = true
+	mock.ExpectQuery(queries.CountClicksByCountry).WithArgs(filter.UrlID, filter.From, filter.To, 1, 10).
+		WillReturnRows(mock.NewRows([]string{"country", "total"}).
+			AddRow("ID", 12).
+			AddRow("", 3))
+
+	repo := urlRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	counts, err := repo.CountClicksBy(ctx, filter, domain.StatsDimensionCountry, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.ClickCount{{Value: "ID", Clicks: 12}, {Value: "", Clicks: 3}}, counts)
+
+	_, err = repo.CountClicksBy(ctx, filter, "password_hash", 10)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
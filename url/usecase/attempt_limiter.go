@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of tracked keys after which expired entries are swept on the next failure
+const attemptSweepSize = 10000
+
+type attempt struct {
+	count     int
+	expiresAt time.Time
+}
+
+// Counting failed attempts per key (e.g. short url and client ip) inside a fixed window
+// A key is blocked once it reaches max failures, until its window expires
+
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string]attempt
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string]attempt),
+	}
+}
+
+func (l *attemptLimiter) blocked(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(a.expiresAt) {
+		delete(l.attempts, key)
+		return false
+	}
+	return a.count >= l.max
+}
+
+func (l *attemptLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.attempts) >= attemptSweepSize {
+		for k, a := range l.attempts {
+			if now.After(a.expiresAt) {
+				delete(l.attempts, k)
+			}
+		}
+	}
+
+	a, ok := l.attempts[key]
+	if !ok || now.After(a.expiresAt) {
+		a = attempt{expiresAt: now.Add(l.window)}
+	}
+	a.count++
+	l.attempts[key] = a
+}
+
+func (l *attemptLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
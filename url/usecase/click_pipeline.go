@@ -0,0 +1,262 @@
+package usecase
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// Counters of the click pipeline, published on /debug/vars
+// queued counts the events waiting in the buffers, those of every pipeline of the process
+var clickMetrics = expvar.NewMap("click_pipeline")
+
+// Timeout of one batch insert or rollup query
+const clickWriteTimeout = 10 * time.Second
+
+// Time range of click events rolled up per query when catching up with missed hours
+const rollupChunk = 24 * 60 * 60
+
+// Write-behind buffer of click events
+// Redirects enqueue events into a bounded channel, a single writer flushes them as multi-row inserts
+// every flushInterval or batchSize events, another loop refreshes the hourly rollups every rollupInterval
+type clickPipeline struct {
+	repository     domain.UrlRepository
+	events         chan domain.ClickEvent
+	batchSize      int
+	flushInterval  time.Duration
+	blockTimeout   time.Duration
+	rollupInterval time.Duration
+
+	done      chan struct{}
+	closeOnce sync.Once
+	flushed   chan struct{} // closed once the writer flushed the last events
+	stopped   sync.WaitGroup
+}
+
+func newClickPipeline(repository domain.UrlRepository, queueSize, batchSize int, flushInterval, blockTimeout, rollupInterval time.Duration) *clickPipeline {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	if rollupInterval <= 0 {
+		rollupInterval = 5 * time.Minute
+	}
+
+	p := &clickPipeline{
+		repository:     repository,
+		events:         make(chan domain.ClickEvent, queueSize),
+		batchSize:      batchSize,
+		flushInterval:  flushInterval,
+		blockTimeout:   blockTimeout,
+		rollupInterval: rollupInterval,
+		done:           make(chan struct{}),
+		flushed:        make(chan struct{}),
+	}
+	p.stopped.Add(2)
+	go p.write()
+	go p.rollup()
+	return p
+}
+
+// Add one click event to the buffer without blocking the redirect for longer than blockTimeout
+// Returning false when the buffer is full or closed and the event was dropped
+
+func (p *clickPipeline) enqueue(event domain.ClickEvent) bool {
+	select {
+	case <-p.done:
+		clickMetrics.Add("dropped", 1)
+		return false
+	default:
+	}
+
+	select {
+	case p.events <- event:
+		clickMetrics.Add("enqueued", 1)
+		clickMetrics.Add("queued", 1)
+		return true
+	default:
+	}
+
+	if p.blockTimeout > 0 {
+		timer := time.NewTimer(p.blockTimeout)
+		defer timer.Stop()
+
+		select {
+		case p.events <- event:
+			clickMetrics.Add("enqueued", 1)
+			clickMetrics.Add("queued", 1)
+			clickMetrics.Add("blocked", 1)
+			return true
+		case <-timer.C:
+		case <-p.done:
+		}
+	}
+
+	clickMetrics.Add("dropped", 1)
+	return false
+}
+
+func (p *clickPipeline) write() {
+	defer p.stopped.Done()
+	defer close(p.flushed)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]domain.ClickEvent, 0, p.batchSize)
+	flush := func() {
+		p.flush(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event := <-p.events:
+			clickMetrics.Add("queued", -1)
+			batch = append(batch, event)
+			if len(batch) >= p.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.done:
+			for {
+				select {
+				case event := <-p.events:
+					clickMetrics.Add("queued", -1)
+					batch = append(batch, event)
+					if len(batch) >= p.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *clickPipeline) flush(batch []domain.ClickEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clickWriteTimeout)
+	defer cancel()
+
+	clickMetrics.Add("batches", 1)
+	err := p.repository.CreateClickEvents(ctx, batch)
+	if err != nil {
+		clickMetrics.Add("failed", int64(len(batch)))
+		log.Printf("failed to write %d click events: %v", len(batch), err)
+		return
+	}
+	clickMetrics.Add("written", int64(len(batch)))
+}
+
+func (p *clickPipeline) rollup() {
+	defer p.stopped.Done()
+
+	ticker := time.NewTicker(p.rollupInterval)
+	defer ticker.Stop()
+
+	// catch up with the hours missed while the service was down
+	p.refreshRollups()
+	for {
+		select {
+		case <-ticker.C:
+			p.refreshRollups()
+		case <-p.done:
+			// the last events must be written before the final refresh
+			<-p.flushed
+			p.refreshRollups()
+			return
+		}
+	}
+}
+
+// Recompute the rollups from the watermark, the start of the oldest hour whose rollups may be missing, up to now
+// Hours missed while the service was down or a refresh failed are caught up a chunk at a time, moving the watermark
+// after each one. It never passes the previous hour, so events flushed after an hour ended are still counted
+
+func (p *clickPipeline) refreshRollups() {
+	now := time.Now().Unix()
+	previousHour := (now/3600 - 1) * 3600
+
+	ctx, cancel := context.WithTimeout(context.Background(), clickWriteTimeout)
+	from, err := p.repository.FindRollupWatermark(ctx)
+	cancel()
+	if err != nil {
+		clickMetrics.Add("rollup_failed", 1)
+		log.Printf("failed to find the click rollup watermark: %v", err)
+		return
+	}
+	if from == 0 || from > previousHour {
+		from = previousHour
+	}
+
+	for from <= now {
+		to := from + rollupChunk
+		if to > now+1 {
+			to = now + 1
+		}
+
+		err = p.rollupRange(from, to, previousHour)
+		if err != nil {
+			clickMetrics.Add("rollup_failed", 1)
+			log.Printf("failed to refresh click rollups from %d: %v", from, err)
+			return
+		}
+		from = to
+	}
+}
+
+// Recompute the rollups of one time range, then move the watermark to its end or to the previous hour
+
+func (p *clickPipeline) rollupRange(from, to, previousHour int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clickWriteTimeout)
+	defer cancel()
+
+	err := p.repository.RollupClicks(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	watermark := to / 3600 * 3600
+	if watermark > previousHour {
+		watermark = previousHour
+	}
+	return p.repository.SetRollupWatermark(ctx, watermark)
+}
+
+// Stop accepting events, flush the buffer and refresh the rollups one last time
+// Returning ctx error when the pipeline didn't drain in time
+
+func (p *clickPipeline) close(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		p.stopped.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
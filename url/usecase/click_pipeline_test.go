@@ -0,0 +1,140 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClickPipelineBatches(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	events := []domain.ClickEvent{{UrlID: 1}, {UrlID: 2}, {UrlID: 3}}
+
+	written := make(chan struct{})
+	repoMock.On("CreateClickEvents", mock.Anything, events[:2]).Return(nil).
+		Run(func(mock.Arguments) { close(written) }).Once()
+	repoMock.On("CreateClickEvents", mock.Anything, events[2:]).Return(nil).Once()
+	// once on start and once on close
+	repoMock.On("FindRollupWatermark", mock.Anything).Return(int64(0), nil).Twice()
+	repoMock.On("RollupClicks", mock.Anything, mock.AnythingOfType("int64"), mock.AnythingOfType("int64")).Return(nil).Twice()
+	repoMock.On("SetRollupWatermark", mock.Anything, mock.AnythingOfType("int64")).Return(nil).Twice()
+
+	pipeline := newClickPipeline(repoMock, 10, 2, time.Hour, 0, time.Hour)
+	for _, event := range events {
+		assert.True(t, pipeline.enqueue(event))
+	}
+
+	// a full batch is written without waiting for the flush interval
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("batch wasn't written")
+	}
+
+	// closing flushes the partial batch and refreshes the rollups
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, pipeline.close(ctx))
+	assert.False(t, pipeline.enqueue(domain.ClickEvent{UrlID: 4}))
+	repoMock.AssertExpectations(t)
+}
+
+func TestClickPipelineFlushInterval(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+
+	written := make(chan struct{})
+	repoMock.On("CreateClickEvents", mock.Anything, []domain.ClickEvent{{UrlID: 1}}).Return(nil).
+		Run(func(mock.Arguments) { close(written) }).Once()
+	repoMock.On("FindRollupWatermark", mock.Anything).Return(int64(0), nil)
+	repoMock.On("RollupClicks", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	repoMock.On("SetRollupWatermark", mock.Anything, mock.Anything).Return(nil)
+
+	pipeline := newClickPipeline(repoMock, 10, 100, 10*time.Millisecond, 0, time.Hour)
+	assert.True(t, pipeline.enqueue(domain.ClickEvent{UrlID: 1}))
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("event wasn't flushed")
+	}
+	assert.NoError(t, pipeline.close(context.Background()))
+	repoMock.AssertExpectations(t)
+}
+
+func TestClickPipelineDropsWhenFull(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+
+	writing, release := make(chan struct{}), make(chan struct{})
+	repoMock.On("CreateClickEvents", mock.Anything, []domain.ClickEvent{{UrlID: 1}}).Return(nil).
+		Run(func(mock.Arguments) {
+			close(writing)
+			<-release
+		}).Once()
+	repoMock.On("CreateClickEvents", mock.Anything, []domain.ClickEvent{{UrlID: 2}}).Return(nil).Once()
+	repoMock.On("FindRollupWatermark", mock.Anything).Return(int64(0), nil)
+	repoMock.On("RollupClicks", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	repoMock.On("SetRollupWatermark", mock.Anything, mock.Anything).Return(nil)
+
+	pipeline := newClickPipeline(repoMock, 1, 1, time.Hour, 10*time.Millisecond, time.Hour)
+	dropped := clickMetrics.Get("dropped")
+	droppedBefore := int64(0)
+	if dropped != nil {
+		droppedBefore = dropped.(interface{ Value() int64 }).Value()
+	}
+
+	assert.True(t, pipeline.enqueue(domain.ClickEvent{UrlID: 1}))
+	<-writing
+	queuedBefore := clickMetrics.Get("queued").(interface{ Value() int64 }).Value()
+
+	// the writer is busy and the queue holds one event, the third one waits blockTimeout then is dropped
+	assert.True(t, pipeline.enqueue(domain.ClickEvent{UrlID: 2}))
+	assert.False(t, pipeline.enqueue(domain.ClickEvent{UrlID: 3}))
+	assert.Equal(t, droppedBefore+1, clickMetrics.Get("dropped").(interface{ Value() int64 }).Value())
+	assert.Equal(t, queuedBefore+1, clickMetrics.Get("queued").(interface{ Value() int64 }).Value())
+
+	close(release)
+	assert.NoError(t, pipeline.close(context.Background()))
+	assert.Equal(t, queuedBefore, clickMetrics.Get("queued").(interface{ Value() int64 }).Value())
+	repoMock.AssertExpectations(t)
+}
+
+func TestRefreshRollupsCatchesUp(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	pipeline := &clickPipeline{repository: repoMock}
+
+	// two days were missed, they are rolled up a day at a time
+	previousHour := (time.Now().Unix()/3600 - 1) * 3600
+	watermark := previousHour - 2*rollupChunk
+	repoMock.On("FindRollupWatermark", mock.Anything).Return(watermark, nil).Once()
+	repoMock.On("RollupClicks", mock.Anything, watermark, watermark+rollupChunk).Return(nil).Once()
+	repoMock.On("SetRollupWatermark", mock.Anything, watermark+rollupChunk).Return(nil).Once()
+	repoMock.On("RollupClicks", mock.Anything, watermark+rollupChunk, previousHour).Return(nil).Once()
+	repoMock.On("RollupClicks", mock.Anything, previousHour, mock.AnythingOfType("int64")).Return(nil).Once()
+	repoMock.On("SetRollupWatermark", mock.Anything, previousHour).Return(nil).Twice()
+
+	pipeline.refreshRollups()
+	repoMock.AssertExpectations(t)
+}
+
+func TestRefreshRollupsFailure(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	pipeline := &clickPipeline{repository: repoMock}
+
+	// a failed chunk leaves the watermark where it is, the next refresh starts over from there
+	previousHour := (time.Now().Unix()/3600 - 1) * 3600
+	watermark := previousHour - 2*rollupChunk
+	repoMock.On("FindRollupWatermark", mock.Anything).Return(watermark, nil).Once()
+	repoMock.On("RollupClicks", mock.Anything, watermark, watermark+rollupChunk).Return(nil).Once()
+	repoMock.On("SetRollupWatermark", mock.Anything, watermark+rollupChunk).Return(nil).Once()
+	repoMock.On("RollupClicks", mock.Anything, watermark+rollupChunk, previousHour).Return(errors.New("lock wait timeout")).Once()
+
+	pipeline.refreshRollups()
+	repoMock.AssertExpectations(t)
+	repoMock.AssertNumberOfCalls(t, "SetRollupWatermark", 1)
+}
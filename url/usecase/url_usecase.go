@@ -5,66 +5,506 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/mrizalr/urlshortener/config"
 	"github.com/mrizalr/urlshortener/domain"
 	"github.com/mrizalr/urlshortener/utils"
+	"golang.org/x/crypto/bcrypt"
 )
 
-type config struct {
-	UrlMinLength int
-	UrlMaxLength int
+type urlConfig struct {
+	UrlMinLength          int
+	UrlMaxLength          int
+	MaxTargets            int
+	MaxRules              int
+	PasswordMaxLength     int
+	PasswordMaxAttempts   int
+	PasswordAttemptWindow time.Duration
+	StatsDefaultBuckets   int // time buckets returned when the stats range isn't given
+	StatsMaxBuckets       int
+	StatsBreakdownLimit   int // values returned per stats breakdown
+	StatsSummaryDays      int // days of clicks summarized in the url details
+	TitleMaxLength        int // characters of the link preview title
+	DescriptionMaxLength  int
+	ImageMaxLength        int
 }
 
 type urlUsecase struct {
-	urlRepository domain.UrlRepository
+	urlRepository       domain.UrlRepository
+	workspaceRepository domain.WorkspaceRepository
+	config              config.Config
+	passwordAttempts    *attemptLimiter
+	clicks              *clickPipeline
+	previewFetcher      domain.PreviewFetcher // nil when preview fetching is off
 }
 
-var _config config = config{
-	UrlMinLength: 5,
-	UrlMaxLength: 8,
+var _config urlConfig = urlConfig{
+	UrlMinLength:          5,
+	UrlMaxLength:          8,
+	MaxTargets:            10,
+	MaxRules:              20,
+	PasswordMaxLength:     72,
+	PasswordMaxAttempts:   5,
+	PasswordAttemptWindow: 15 * time.Minute,
+	StatsDefaultBuckets:   30,
+	StatsMaxBuckets:       1000,
+	StatsBreakdownLimit:   10,
+	StatsSummaryDays:      30,
+	TitleMaxLength:        255,
+	DescriptionMaxLength:  1024,
+	ImageMaxLength:        2048,
 }
 
-func NewUrlUsecase(urlRepository domain.UrlRepository) domain.UrlUsecase {
-	return &urlUsecase{urlRepository}
+var redirectTypes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
 }
 
+var ruleOperatingSystems = map[string]bool{
+	"":               true,
+	domain.OsIOS:     true,
+	domain.OsAndroid: true,
+	domain.OsWindows: true,
+	domain.OsMacOS:   true,
+	domain.OsLinux:   true,
+}
+
+var ruleDevices = map[string]bool{
+	"":                   true,
+	domain.DeviceMobile:  true,
+	domain.DeviceTablet:  true,
+	domain.DeviceDesktop: true,
+}
+
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+// ISO 3166-1 alpha-2 country code, as reported by the GeoIP database
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+var queryPolicies = map[string]bool{
+	domain.QueryPolicyDrop:     true,
+	domain.QueryPolicyKeep:     true,
+	domain.QueryPolicyOverride: true,
+	domain.QueryPolicyAppend:   true,
+}
+
+// Bucket size and epoch offset of each stats interval, weeks are aligned on monday 1970-01-05
+var statsIntervals = map[string][2]int64{
+	domain.StatsIntervalHour: {60 * 60, 0},
+	domain.StatsIntervalDay:  {24 * 60 * 60, 0},
+	domain.StatsIntervalWeek: {7 * 24 * 60 * 60, 4 * 24 * 60 * 60},
+}
+
+func NewUrlUsecase(urlRepository domain.UrlRepository, workspaceRepository domain.WorkspaceRepository,
+	previewFetcher domain.PreviewFetcher, cfg config.Config) domain.UrlUsecase {
+	return &urlUsecase{
+		urlRepository:       urlRepository,
+		workspaceRepository: workspaceRepository,
+		config:              cfg,
+		passwordAttempts:    newAttemptLimiter(_config.PasswordMaxAttempts, _config.PasswordAttemptWindow),
+		clicks: newClickPipeline(urlRepository, cfg.ClickQueueSize, cfg.ClickBatchSize,
+			cfg.ClickFlushInterval, cfg.ClickBlockTimeout, cfg.ClickRollupInterval),
+		previewFetcher: previewFetcher,
+	}
+}
+
+// Prefixing url without http(s) scheme with https://
+// Keeping empty url as is
+
+func withScheme(url string) string {
+	if url == "" || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		return url
+	}
+	return fmt.Sprintf("https://%s", url)
+}
+
+// Validate the weighted targets of a split url
+// Returning the targets with normalized urls if valid, and validation error if not
+
+func validateTargets(targets []domain.UrlTarget) ([]domain.UrlTarget, error) {
+	if len(targets) > _config.MaxTargets {
+		return nil, fmt.Errorf("validation error: a url can't have more than %d targets", _config.MaxTargets)
+	}
+
+	result := make([]domain.UrlTarget, 0, len(targets))
+	for _, target := range targets {
+		if target.Url == "" {
+			return nil, errors.New("validation error: target url shouldn't be empty")
+		}
+		if target.Weight <= 0 {
+			return nil, errors.New("validation error: target weight must be greater than 0")
+		}
+
+		result = append(result, domain.UrlTarget{Url: withScheme(target.Url), Weight: target.Weight})
+	}
+	return result, nil
+}
+
+// Validate the targeting rules of a url
+// Returning the rules with normalized conditions and positions following the list order if valid,
+// and validation error if not
+
+func validateRules(rules []domain.UrlRule) ([]domain.UrlRule, error) {
+	if len(rules) > _config.MaxRules {
+		return nil, fmt.Errorf("validation error: a url can't have more than %d rules", _config.MaxRules)
+	}
+
+	result := make([]domain.UrlRule, 0, len(rules))
+	for i, rule := range rules {
+		rule := domain.UrlRule{
+			Position: i,
+			Os:       strings.ToLower(rule.Os),
+			Device:   strings.ToLower(rule.Device),
+			Language: strings.ToLower(rule.Language),
+			Country:  strings.ToUpper(rule.Country),
+			Url:      withScheme(rule.Url),
+		}
+
+		if rule.Url == "" {
+			return nil, errors.New("validation error: rule url shouldn't be empty")
+		}
+		if rule.Os == "" && rule.Device == "" && rule.Language == "" && rule.Country == "" {
+			return nil, errors.New("validation error: rule must have at least one condition")
+		}
+		if !ruleOperatingSystems[rule.Os] {
+			return nil, errors.New("validation error: rule os must be one of ios, android, windows, macos or linux")
+		}
+		if !ruleDevices[rule.Device] {
+			return nil, errors.New("validation error: rule device must be one of mobile, tablet or desktop")
+		}
+		if rule.Language != "" && !languageTag.MatchString(rule.Language) {
+			return nil, errors.New("validation error: rule language must be a language tag, e.g. en or pt-br")
+		}
+		if rule.Country != "" && !countryCode.MatchString(rule.Country) {
+			return nil, errors.New("validation error: rule country must be a two letter country code, e.g. ID or US")
+		}
+
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// Validate the preview fields set on a link
+// Returning the preview with trimmed fields if valid, and validation error if not
+
+func validatePreview(preview domain.LinkPreview) (domain.LinkPreview, error) {
+	preview = domain.LinkPreview{
+		Title:       strings.TrimSpace(preview.Title),
+		Description: strings.TrimSpace(preview.Description),
+		Image:       strings.TrimSpace(preview.Image),
+	}
+
+	if utf8.RuneCountInString(preview.Title) > _config.TitleMaxLength {
+		return preview, fmt.Errorf("validation error: title shouldn't be longer than %d characters", _config.TitleMaxLength)
+	}
+	if utf8.RuneCountInString(preview.Description) > _config.DescriptionMaxLength {
+		return preview, fmt.Errorf("validation error: description shouldn't be longer than %d characters", _config.DescriptionMaxLength)
+	}
+	if len(preview.Image) > _config.ImageMaxLength {
+		return preview, fmt.Errorf("validation error: image shouldn't be longer than %d characters", _config.ImageMaxLength)
+	}
+	if preview.Image != "" {
+		image, err := neturl.Parse(preview.Image)
+		if err != nil || (image.Scheme != "http" && image.Scheme != "https") || image.Host == "" {
+			return preview, errors.New("validation error: image must be an absolute http(s) url")
+		}
+	}
+	return preview, nil
+}
+
+// Fill the preview fields left empty from the destination page
+// A failed fetch is logged and leaves them empty, it never fails the link creation
+
+func (u *urlUsecase) fetchPreview(ctx context.Context, url string, preview domain.LinkPreview) domain.LinkPreview {
+	if u.previewFetcher == nil || (preview.Title != "" && preview.Description != "" && preview.Image != "") {
+		return preview
+	}
+
+	fetched, err := u.previewFetcher.Fetch(ctx, url)
+	if err != nil {
+		log.Printf("failed to fetch the preview of %s: %v", url, err)
+		return preview
+	}
+
+	if preview.Title == "" {
+		preview.Title = truncate(strings.TrimSpace(fetched.Title), _config.TitleMaxLength)
+	}
+	if preview.Description == "" {
+		preview.Description = truncate(strings.TrimSpace(fetched.Description), _config.DescriptionMaxLength)
+	}
+	if preview.Image == "" && len(fetched.Image) <= _config.ImageMaxLength {
+		preview.Image = fetched.Image
+	}
+	return preview
+}
+
+// Cut a string to at most maxLength characters
+func truncate(value string, maxLength int) string {
+	if utf8.RuneCountInString(value) <= maxLength {
+		return value
+	}
+	return string([]rune(value)[:maxLength])
+}
+
+// Random short url, never made of digits only so it can't be taken for a url id in the api paths
+
 func generateRandom() string {
-	return utils.GetRandomURL(_config.UrlMinLength, _config.UrlMaxLength)
+	for {
+		shortUrl := utils.GetRandomURL(_config.UrlMinLength, _config.UrlMaxLength)
+		if strings.Trim(shortUrl, "0123456789") != "" {
+			return shortUrl
+		}
+	}
+}
+
+// Owner id the url queries of the caller are scoped to, 0 for admins who manage the urls of every user
+// Returning ErrUnauthenticated when no user is attached to the context
+
+func ownerScope(ctx context.Context) (int, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return 0, domain.ErrUnauthenticated
+	}
+	if user.IsAdmin() {
+		return 0, nil
+	}
+	return user.ID, nil
 }
 
-func (u *urlUsecase) CreateNewURL(ctx context.Context, url string) (domain.Url, error) {
+// Role of the caller in a workspace, admins hold the owner role in every workspace
+// Returning sql.ErrNoRows when the caller isn't a member of the workspace
+
+func (u *urlUsecase) workspaceRole(ctx context.Context, user domain.User, workspaceID int) (string, error) {
+	if user.IsAdmin() {
+		return domain.WorkspaceRoleOwner, nil
+	}
+
+	member, err := u.workspaceRepository.FindMember(ctx, workspaceID, user.ID)
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// Check the caller may act on a url, personal urls belong to their owner and workspace urls to the members of the workspace
+// holding at least the required role. Admins act on every url
+// Returning sql.ErrNoRows when the caller can't see the url, and ErrForbidden when its role is too low
+
+func (u *urlUsecase) authorizeUrl(ctx context.Context, url domain.Url, required string) error {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.ErrUnauthenticated
+	}
+	if user.IsAdmin() {
+		return nil
+	}
+
+	if url.WorkspaceID == 0 {
+		if url.OwnerID != user.ID {
+			return sql.ErrNoRows
+		}
+		return nil
+	}
+
+	role, err := u.workspaceRole(ctx, user, url.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	if !domain.WorkspaceRoleAllows(role, required) {
+		return fmt.Errorf("%w: the %s role is required", domain.ErrForbidden, required)
+	}
+	return nil
+}
+
+// Find a url by its id, when the caller holds the required role on it
+// The repository only finds the urls of the caller, the role it holds on them is checked on top
+
+func (u *urlUsecase) findAuthorized(ctx context.Context, id int, required string) (domain.Url, error) {
+	userID, err := ownerScope(ctx)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	url, err := u.urlRepository.FindByID(ctx, id, userID)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	err = u.authorizeUrl(ctx, url, required)
+	if err != nil {
+		return domain.Url{}, err
+	}
+	return url, nil
+}
+
+func (u *urlUsecase) CreateNewURL(ctx context.Context, request domain.CreateUrlRequest) (domain.Url, error) {
 	result := domain.Url{}
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return result, domain.ErrUnauthenticated
+	}
+
+	targets, err := validateTargets(request.Targets)
+	if err != nil {
+		return result, err
+	}
+
+	rules, err := validateRules(request.Rules)
+	if err != nil {
+		return result, err
+	}
+
+	url := request.Url
+	if url == "" && len(targets) > 0 {
+		url = targets[0].Url
+	}
 	if url == "" {
 		return result, errors.New("validation error: url shouldn't be empty")
 	}
 
-	if !strings.HasPrefix(url, "https://") {
-		url = fmt.Sprintf("https://%s", url)
+	redirectType := request.RedirectType
+	if redirectType == 0 {
+		redirectType = u.config.DefaultRedirectType
+	}
+	if !redirectTypes[redirectType] {
+		return result, errors.New("validation error: redirect_type must be one of 301, 302, 307 or 308")
+	}
+
+	queryPolicy := request.QueryPolicy
+	if queryPolicy == "" {
+		queryPolicy = domain.QueryPolicyDrop
+	}
+	if !queryPolicies[queryPolicy] {
+		return result, errors.New("validation error: query_policy must be one of drop, keep, override or append")
 	}
 
-	shortUrl := generateRandom()
+	if request.ActiveFrom < 0 || request.ExpiresAt < 0 {
+		return result, errors.New("validation error: active_from and expires_at must be unix timestamps")
+	}
+	if request.ExpiresAt != 0 && request.ExpiresAt <= time.Now().Unix() {
+		return result, errors.New("validation error: expires_at must be in the future")
+	}
+	if request.ExpiresAt != 0 && request.ExpiresAt <= request.ActiveFrom {
+		return result, errors.New("validation error: expires_at must be after active_from")
+	}
+
+	preview, err := validatePreview(domain.LinkPreview{
+		Title:       request.Title,
+		Description: request.Description,
+		Image:       request.Image,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	passwordHash := ""
+	if request.Password != "" {
+		if len(request.Password) > _config.PasswordMaxLength {
+			return result, fmt.Errorf("validation error: password shouldn't be longer than %d bytes", _config.PasswordMaxLength)
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return result, err
+		}
+		passwordHash = string(hash)
+	}
+
+	url = withScheme(url)
+
+	// a workspace namespace prefixes the short url with the workspace slug
+	prefix := ""
+	if request.WorkspaceID != 0 {
+		workspace, err := u.workspaceRepository.FindByID(ctx, request.WorkspaceID)
+		if err == sql.ErrNoRows {
+			return result, errors.New("validation error: workspace_id doesn't match a workspace")
+		}
+		if err != nil {
+			return result, err
+		}
+
+		role, err := u.workspaceRole(ctx, user, workspace.ID)
+		if err == sql.ErrNoRows {
+			return result, errors.New("validation error: workspace_id doesn't match a workspace")
+		}
+		if err != nil {
+			return result, err
+		}
+		if !domain.WorkspaceRoleAllows(role, domain.WorkspaceRoleEditor) {
+			return result, fmt.Errorf("%w: the %s role is required", domain.ErrForbidden, domain.WorkspaceRoleEditor)
+		}
+
+		if workspace.SlugNamespace == domain.SlugNamespaceWorkspace {
+			prefix = workspace.Slug + "/"
+		}
+	}
+
+	quota := u.config.UserQuota
+	if request.WorkspaceID != 0 {
+		quota = u.config.WorkspaceQuota
+	}
+	now := time.Now().Unix()
+	periodStart, _ := domain.QuotaPeriod(now)
+
+	// the destination isn't fetched for a link over quota, the create checks the quota again in its transaction
+	err = u.urlRepository.CheckQuota(ctx, domain.CreateUrlParams{OwnerID: user.ID, WorkspaceID: request.WorkspaceID, Quota: quota, PeriodStart: periodStart})
+	if err != nil {
+		return result, err
+	}
+
+	preview = u.fetchPreview(ctx, url, preview)
+
+	shortUrl := prefix + generateRandom()
 	for {
-		_, err := u.urlRepository.FindByShortUrl(context.Background(), shortUrl)
+		_, err := u.urlRepository.FindByShortUrl(ctx, shortUrl)
 		if err == sql.ErrNoRows {
 			break
 		}
-		shortUrl = generateRandom()
+		shortUrl = prefix + generateRandom()
 		time.Sleep(time.Nanosecond)
 	}
 
 	params := domain.CreateUrlParams{
-		Url:      url,
-		ShortUrl: shortUrl,
+		Url:          url,
+		ShortUrl:     shortUrl,
+		CreatedAt:    now,
+		OwnerID:      user.ID,
+		WorkspaceID:  request.WorkspaceID,
+		RedirectType: redirectType,
+		QueryPolicy:  queryPolicy,
+		UtmSource:    request.UtmSource,
+		UtmMedium:    request.UtmMedium,
+		UtmCampaign:  request.UtmCampaign,
+		PasswordHash: passwordHash,
+		SingleUse:    request.SingleUse,
+		ActiveFrom:   request.ActiveFrom,
+		ExpiresAt:    request.ExpiresAt,
+		FallbackUrl:  withScheme(request.FallbackUrl),
+		Title:        preview.Title,
+		Description:  preview.Description,
+		Image:        preview.Image,
+		ForcePreview: request.ForcePreview,
+		Targets:      targets,
+		Rules:        rules,
+		Quota:        quota,
+		PeriodStart:  periodStart,
 	}
 
-	_, err := u.urlRepository.Create(context.Background(), params)
+	_, err = u.urlRepository.Create(ctx, params)
 	if err != nil {
 		return result, err
 	}
 
-	result, err = u.urlRepository.FindByShortUrl(context.Background(), shortUrl)
+	result, err = u.urlRepository.FindByShortUrl(ctx, shortUrl)
 	if err != nil {
 		return result, err
 	}
@@ -73,20 +513,358 @@ func (u *urlUsecase) CreateNewURL(ctx context.Context, url string) (domain.Url,
 
 func (u *urlUsecase) FindUrlByShort(ctx context.Context, shortUrl string) (domain.Url, error) {
 	url, err := u.urlRepository.FindByShortUrl(ctx, shortUrl)
-	return url, err
+	if err != nil {
+		return url, err
+	}
+
+	if url.SingleUse && url.ConsumedAt != 0 {
+		return url, domain.ErrUrlConsumed
+	}
+
+	switch urlStatus(url, time.Now().Unix()) {
+	case domain.UrlStatusScheduled:
+		return url, domain.ErrUrlNotActive
+	case domain.UrlStatusExpired:
+		return url, domain.ErrUrlExpired
+	}
+	return url, nil
+}
+
+func urlStatus(url domain.Url, now int64) string {
+	if url.ActiveFrom > now {
+		return domain.UrlStatusScheduled
+	}
+	if url.ExpiresAt != 0 && url.ExpiresAt <= now {
+		return domain.UrlStatusExpired
+	}
+	return domain.UrlStatusActive
+}
+
+// Verify the password of a protected short url
+// Failed attempts are counted per short url and client ip, blocking further tries once the limit is reached
+
+func (u *urlUsecase) UnlockUrl(ctx context.Context, shortUrl, password, clientIP string) (domain.Url, error) {
+	attemptKey := shortUrl + "|" + clientIP
+	if u.passwordAttempts.blocked(attemptKey) {
+		return domain.Url{}, domain.ErrTooManyAttempts
+	}
+
+	url, err := u.FindUrlByShort(ctx, shortUrl)
+	if err != nil || url.PasswordHash == "" {
+		return url, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password))
+	if err != nil {
+		u.passwordAttempts.fail(attemptKey)
+		return domain.Url{}, domain.ErrInvalidPassword
+	}
+
+	u.passwordAttempts.reset(attemptKey)
+	return url, nil
+}
+
+// Consume a single use url right before it is resolved
+// The repository update is conditional, so concurrent requests can't both consume the same url
+
+func (u *urlUsecase) ConsumeUrl(ctx context.Context, id int) error {
+	affected, err := u.urlRepository.MarkConsumed(ctx, id, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return domain.ErrUrlConsumed
+	}
+	return nil
+}
+
+// Replace the weighted targets of a url, an empty list turns it back into a plain url
+
+func (u *urlUsecase) UpdateTargets(ctx context.Context, id int, targets []domain.UrlTarget) (domain.Url, error) {
+	targets, err := validateTargets(targets)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	_, err = u.findAuthorized(ctx, id, domain.WorkspaceRoleEditor)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	err = u.urlRepository.ReplaceTargets(ctx, id, targets)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	userID, _ := ownerScope(ctx)
+	return u.urlRepository.FindByID(ctx, id, userID)
+}
+
+// Replace the targeting rules of a url, an empty list removes every rule
+
+func (u *urlUsecase) UpdateRules(ctx context.Context, id int, rules []domain.UrlRule) (domain.Url, error) {
+	rules, err := validateRules(rules)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	_, err = u.findAuthorized(ctx, id, domain.WorkspaceRoleEditor)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	err = u.urlRepository.ReplaceRules(ctx, id, rules)
+	if err != nil {
+		return domain.Url{}, err
+	}
+
+	userID, _ := ownerScope(ctx)
+	return u.urlRepository.FindByID(ctx, id, userID)
+}
+
+func (u *urlUsecase) RecordClick(ctx context.Context, event domain.ClickEvent) error {
+	if event.ClickedAt == 0 {
+		event.ClickedAt = time.Now().Unix()
+	}
+	// a dropped event is counted by the pipeline metrics, the redirect goes on regardless
+	u.clicks.enqueue(event)
+	return nil
+}
+
+// Flush the buffered click events, called once on shutdown after the server stopped serving redirects
+
+func (u *urlUsecase) Close(ctx context.Context) error {
+	return u.clicks.close(ctx)
+}
+
+// Aggregate the hourly click rollups of one url over a time range, bot clicks are only counted apart unless requested
+// The range defaults to the last StatsDefaultBuckets intervals and starts at the beginning of its first bucket,
+// buckets without clicks are returned with 0 clicks
+
+func (u *urlUsecase) GetStats(ctx context.Context, id int, request domain.StatsRequest) (domain.UrlStats, error) {
+	interval := request.Interval
+	if interval == "" {
+		interval = domain.StatsIntervalDay
+	}
+	bucket, ok := statsIntervals[interval]
+	if !ok {
+		return domain.UrlStats{}, errors.New("validation error: interval must be one of hour, day or week")
+	}
+	size, offset := bucket[0], bucket[1]
+
+	to := request.To
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	from := request.From
+	if from == 0 {
+		from = to - int64(_config.StatsDefaultBuckets)*size
+	}
+	if from >= to {
+		return domain.UrlStats{}, errors.New("validation error: from must be before to")
+	}
+	if (to-from)/size >= int64(_config.StatsMaxBuckets) {
+		return domain.UrlStats{}, fmt.Errorf("validation error: range can't span more than %d %s buckets", _config.StatsMaxBuckets, interval)
+	}
+
+	_, err := u.findAuthorized(ctx, id, domain.WorkspaceRoleViewer)
+	if err != nil {
+		return domain.UrlStats{}, err
+	}
+
+	from = (from-offset)/size*size + offset
+	filter := domain.StatsFilter{UrlID: id, From: from, To: to, BucketSize: size, BucketOffset: offset, IncludeBots: request.IncludeBots}
+	buckets, err := u.urlRepository.CountClicks(ctx, filter)
+	if err != nil {
+		return domain.UrlStats{}, err
+	}
+
+	stats := domain.UrlStats{UrlID: id, From: from, To: to, Interval: interval, Series: []domain.ClickBucket{}}
+	clicks := map[int64]int{}
+	for _, bucket := range buckets {
+		clicks[bucket.Time] = bucket.Clicks
+		stats.Clicks += bucket.Clicks
+	}
+	stats.BotClicks, err = u.urlRepository.CountBotClicks(ctx, filter)
+	if err != nil {
+		return domain.UrlStats{}, err
+	}
+
+	visitors, err := u.countVisitors(ctx, filter)
+	if err != nil {
+		return domain.UrlStats{}, err
+	}
+	stats.Visitors = visitors[0]
+
+	for start := from; start < to; start += size {
+		stats.Series = append(stats.Series, domain.ClickBucket{Time: start, Clicks: clicks[start], Visitors: visitors[start]})
+	}
+
+	breakdowns := []struct {
+		dimension string
+		counts    *[]domain.ClickCount
+	}{
+		{domain.StatsDimensionReferrer, &stats.Referrers},
+		{domain.StatsDimensionCountry, &stats.Countries},
+		{domain.StatsDimensionBrowser, &stats.Browsers},
+		{domain.StatsDimensionOs, &stats.Os},
+		{domain.StatsDimensionDevice, &stats.Devices},
+	}
+	for _, breakdown := range breakdowns {
+		counts, err := u.urlRepository.CountClicksBy(ctx, filter, breakdown.dimension, _config.StatsBreakdownLimit)
+		if err != nil {
+			return domain.UrlStats{}, err
+		}
+		*breakdown.counts = counts
+	}
+
+	return stats, nil
+}
+
+// Merge the daily visitor sketches of one url over the filter range
+// Returning the estimate of the whole range at key 0, and of each bucket at its start time when buckets span whole days
+
+func (u *urlUsecase) countVisitors(ctx context.Context, filter domain.StatsFilter) (map[int64]int, error) {
+	const day = 24 * 60 * 60
+
+	sketches, err := u.urlRepository.FindVisitorSketches(ctx, filter.UrlID, filter.From/day*day, filter.To)
+	if err != nil {
+		return nil, err
+	}
+
+	total, buckets := utils.NewHyperLogLog(), map[int64]*utils.HyperLogLog{}
+	for _, stored := range sketches {
+		sketch := &utils.HyperLogLog{}
+		err = sketch.UnmarshalBinary(stored.Sketch)
+		if err != nil {
+			return nil, err
+		}
+		total.Merge(sketch)
+
+		if filter.BucketSize%day != 0 {
+			continue
+		}
+		start := (stored.Day-filter.BucketOffset)/filter.BucketSize*filter.BucketSize + filter.BucketOffset
+		if buckets[start] == nil {
+			buckets[start] = utils.NewHyperLogLog()
+		}
+		buckets[start].Merge(sketch)
+	}
+
+	visitors := map[int64]int{0: total.Count()}
+	for start, sketch := range buckets {
+		visitors[start] = sketch.Count()
+	}
+	return visitors, nil
 }
 
-func (u *urlUsecase) FindAllUrl(ctx context.Context) ([]domain.Url, error) {
-	urls, err := u.urlRepository.FindAll(ctx)
+// Find a url by its id, whatever its status, for management endpoints
+
+func (u *urlUsecase) FindUrlByID(ctx context.Context, id int) (domain.Url, error) {
+	return u.findAuthorized(ctx, id, domain.WorkspaceRoleViewer)
+}
+
+// Find a url by its id, whatever its status, along with the summary of its recent clicks
+
+func (u *urlUsecase) GetUrlDetails(ctx context.Context, id int) (domain.UrlDetails, error) {
+	url, err := u.FindUrlByID(ctx, id)
+	if err != nil {
+		return domain.UrlDetails{}, err
+	}
+	return u.urlDetails(ctx, url, time.Now().Unix())
+}
+
+// Find a url by its short url, whatever its status, along with the summary of its recent clicks
+
+func (u *urlUsecase) GetUrlDetailsByShort(ctx context.Context, shortUrl string) (domain.UrlDetails, error) {
+	if _, ok := domain.UserFromContext(ctx); !ok {
+		return domain.UrlDetails{}, domain.ErrUnauthenticated
+	}
+
+	// short urls are looked up by redirects whoever owns them, the url of another user isn't found
+	url, err := u.urlRepository.FindByShortUrl(ctx, shortUrl)
+	if err != nil {
+		return domain.UrlDetails{}, err
+	}
+	err = u.authorizeUrl(ctx, url, domain.WorkspaceRoleViewer)
+	if err != nil {
+		return domain.UrlDetails{}, err
+	}
+	return u.urlDetails(ctx, url, time.Now().Unix())
+}
+
+// Summarize the human clicks of the last StatsSummaryDays UTC days, the current one included
+
+func (u *urlUsecase) urlDetails(ctx context.Context, url domain.Url, now int64) (domain.UrlDetails, error) {
+	const day = 24 * 60 * 60
+
+	from := now/day*day - int64(_config.StatsSummaryDays-1)*day
+	filter := domain.StatsFilter{UrlID: url.ID, From: from, To: now, BucketSize: day}
+	details := domain.UrlDetails{
+		Url:    url,
+		Status: urlStatus(url, now),
+		Stats:  domain.StatsSummary{From: from, To: now},
+	}
+
+	buckets, err := u.urlRepository.CountClicks(ctx, filter)
+	if err != nil {
+		return domain.UrlDetails{}, err
+	}
+	for _, bucket := range buckets {
+		details.Stats.Clicks += bucket.Clicks
+	}
+
+	details.Stats.BotClicks, err = u.urlRepository.CountBotClicks(ctx, filter)
+	if err != nil {
+		return domain.UrlDetails{}, err
+	}
+
+	visitors, err := u.countVisitors(ctx, filter)
+	if err != nil {
+		return domain.UrlDetails{}, err
+	}
+	details.Stats.Visitors = visitors[0]
+	return details, nil
+}
+
+// List the urls of a workspace, or the personal urls of the caller when workspaceID is 0
+
+func (u *urlUsecase) FindAllUrl(ctx context.Context, status string, workspaceID int) ([]domain.Url, error) {
+	switch status {
+	case "", domain.UrlStatusScheduled, domain.UrlStatusActive, domain.UrlStatusExpired:
+	default:
+		return nil, errors.New("validation error: status must be one of scheduled, active or expired")
+	}
+
+	ownerID, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := domain.UrlFilter{Status: status, Now: time.Now().Unix(), OwnerID: ownerID, WorkspaceID: workspaceID}
+	if workspaceID != 0 {
+		user, _ := domain.UserFromContext(ctx)
+		role, err := u.workspaceRole(ctx, user, workspaceID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if !domain.WorkspaceRoleAllows(role, domain.WorkspaceRoleViewer) {
+			return nil, fmt.Errorf("%w: the %s role is required", domain.ErrForbidden, domain.WorkspaceRoleViewer)
+		}
+	}
+
+	urls, err := u.urlRepository.FindAll(ctx, filter)
 	return urls, err
 }
 
 func (u *urlUsecase) DeleteByID(ctx context.Context, id int) (domain.Url, error) {
-	url, err := u.urlRepository.FindByID(context.Background(), id)
+	url, err := u.findAuthorized(ctx, id, domain.WorkspaceRoleEditor)
 	if err != nil {
 		return url, err
 	}
 
-	_, err = u.urlRepository.DeleteByID(ctx, id)
+	userID, _ := ownerScope(ctx)
+	_, err = u.urlRepository.DeleteByID(ctx, id, userID)
 	return url, err
 }
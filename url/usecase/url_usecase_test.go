@@ -2,34 +2,48 @@ package usecase
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mrizalr/urlshortener/config"
 	"github.com/mrizalr/urlshortener/domain"
 	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/mrizalr/urlshortener/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var testConfig = config.Config{DefaultRedirectType: http.StatusFound}
+
+// Context of a request sent by a member, the urls it manages are scoped to its own
+var userCtx = domain.ContextWithUser(context.Background(), domain.User{ID: 3, Role: domain.UserRoleMember})
+
 func TestCreateNewURL(t *testing.T) {
 	repoMock := new(mocks.UrlRepository)
-	urlUsecase := urlUsecase{repoMock}
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
 
 	urlTest := "www.github.com/mrizalr/urlshortener"
 	result := domain.Url{
-		ID:         1,
-		Url:        fmt.Sprintf("https://%s", urlTest),
-		ShortUrl:   "s5HbKw",
-		ClickCount: 172,
-		CreatedAt:  time.Now().Unix(),
+		ID:           1,
+		Url:          fmt.Sprintf("https://%s", urlTest),
+		ShortUrl:     "s5HbKw",
+		ClickCount:   172,
+		CreatedAt:    time.Now().Unix(),
+		RedirectType: http.StatusFound,
 	}
 
 	// mock test with the case if the same random url is found in the database
 	rand.Seed(time.Now().UnixNano())
-	times := rand.Intn(10)
+	times := rand.Intn(10) + 1
 	t.Log(times)
 	for i := 0; i < times; i++ {
 		foundUrl := result
@@ -40,17 +54,20 @@ func TestCreateNewURL(t *testing.T) {
 			errFound = sql.ErrNoRows
 		}
 
-		repoMock.On("FindByShortUrl", context.Background(), mock.AnythingOfType("string")).
+		repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
 			Return(foundUrl, errFound).Once()
 	}
 
-	repoMock.On("Create", context.Background(), mock.AnythingOfType("domain.CreateUrlParams")).
-		Return(1, nil)
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	// the url belongs to the user creating it, and counts for the month it's created in
+	repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return params.OwnerID == 3 && params.CreatedAt >= params.PeriodStart && params.PeriodStart > 0
+	})).Return(1, nil)
 
-	repoMock.On("FindByShortUrl", context.Background(), mock.AnythingOfType("string")).
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
 		Return(result, nil)
 
-	url, err := urlUsecase.CreateNewURL(context.Background(), urlTest)
+	url, err := urlUsecase.CreateNewURL(userCtx, domain.CreateUrlRequest{Url: urlTest})
 	t.Log(url)
 
 	repoMock.AssertExpectations(t)
@@ -59,11 +76,198 @@ func TestCreateNewURL(t *testing.T) {
 	assert.Equal(t, result.Url, url.Url)
 	assert.NotEqual(t, "", url.ShortUrl)
 	assert.NotZero(t, url.CreatedAt)
+	assert.Equal(t, http.StatusFound, url.RedirectType)
+}
+
+func TestCreateNewURLHttp(t *testing.T) {
+	for request, expected := range map[string]string{
+		"http://intranet.example.com/wiki": "http://intranet.example.com/wiki",
+		"www.github.com":                   "https://www.github.com",
+	} {
+		repoMock := new(mocks.UrlRepository)
+		urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+		repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).Return(domain.Url{}, sql.ErrNoRows).Once()
+		repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+		// an http destination keeps its scheme, one without gets https
+		repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+			return params.Url == expected
+		})).Return(1, nil)
+		repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).Return(domain.Url{ID: 1, Url: expected}, nil)
+
+		_, err := urlUsecase.CreateNewURL(userCtx, domain.CreateUrlRequest{Url: request})
+		assert.NoError(t, err, request)
+		repoMock.AssertExpectations(t)
+	}
+}
+
+func TestCreateNewURLRedirectType(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+	request := domain.CreateUrlRequest{
+		Url:          "https://www.github.com/mrizalr",
+		RedirectType: http.StatusTemporaryRedirect,
+		FallbackUrl:  "www.github.com",
+		ForcePreview: true,
+	}
+
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{}, sql.ErrNoRows).Once()
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return params.RedirectType == http.StatusTemporaryRedirect && params.QueryPolicy == domain.QueryPolicyDrop &&
+			params.FallbackUrl == "https://www.github.com" && params.ForcePreview
+	})).Return(1, nil)
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{ID: 1, Url: request.Url, RedirectType: request.RedirectType}, nil)
+
+	url, err := urlUsecase.CreateNewURL(userCtx, request)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTemporaryRedirect, url.RedirectType)
+}
+
+func TestCreateNewURLInvalidRedirectType(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+	request := domain.CreateUrlRequest{
+		Url:          "https://www.github.com/mrizalr",
+		RedirectType: http.StatusOK,
+	}
+
+	_, err := urlUsecase.CreateNewURL(userCtx, request)
+	repoMock.AssertNotCalled(t, "Create")
+	assert.ErrorContains(t, err, "validation error")
+}
+
+func TestCreateNewURLInvalidQueryPolicy(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+	request := domain.CreateUrlRequest{
+		Url:         "https://www.github.com/mrizalr",
+		QueryPolicy: "merge",
+	}
+
+	_, err := urlUsecase.CreateNewURL(userCtx, request)
+	repoMock.AssertNotCalled(t, "Create")
+	assert.ErrorContains(t, err, "query_policy")
+}
+
+func TestCreateNewURLPreview(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	fetcherMock := new(mocks.PreviewFetcher)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig, previewFetcher: fetcherMock}
+
+	request := domain.CreateUrlRequest{
+		Url:   "https://www.github.com/mrizalr",
+		Title: " My profile ",
+	}
+
+	// only the fields left empty are taken from the destination page
+	fetcherMock.On("Fetch", userCtx, request.Url).Return(domain.LinkPreview{
+		Title:       "mrizalr - Overview",
+		Description: "mrizalr has 20 repositories available.",
+		Image:       "https://avatars.githubusercontent.com/u/1",
+	}, nil).Once()
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{}, sql.ErrNoRows).Once()
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return params.Title == "My profile" && params.Description == "mrizalr has 20 repositories available." &&
+			params.Image == "https://avatars.githubusercontent.com/u/1"
+	})).Return(1, nil)
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{ID: 1, Url: request.Url, Title: "My profile"}, nil)
+
+	url, err := urlUsecase.CreateNewURL(userCtx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, "My profile", url.Title)
+	fetcherMock.AssertExpectations(t)
+	repoMock.AssertExpectations(t)
+}
+
+func TestCreateNewURLPreviewFetchFailed(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	fetcherMock := new(mocks.PreviewFetcher)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig, previewFetcher: fetcherMock}
+
+	fetcherMock.On("Fetch", userCtx, "https://www.github.com/mrizalr").
+		Return(domain.LinkPreview{}, errors.New("timeout")).Once()
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{}, sql.ErrNoRows).Once()
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return params.Title == "" && params.Description == "" && params.Image == ""
+	})).Return(1, nil)
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{ID: 1}, nil)
+
+	_, err := urlUsecase.CreateNewURL(userCtx, domain.CreateUrlRequest{Url: "https://www.github.com/mrizalr"})
+	assert.NoError(t, err)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestCreateNewURLOverQuota(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	fetcherMock := new(mocks.PreviewFetcher)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig, previewFetcher: fetcherMock}
+
+	// the destination of a link over quota isn't fetched
+	quotaErr := &domain.QuotaExceededError{Quota: domain.QuotaMonthlyCreates}
+	repoMock.On("CheckQuota", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return params.OwnerID == 3 && params.PeriodStart > 0
+	})).Return(quotaErr)
+
+	_, err := urlUsecase.CreateNewURL(userCtx, domain.CreateUrlRequest{Url: "https://www.github.com/mrizalr"})
+	assert.ErrorIs(t, err, quotaErr)
+	fetcherMock.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+	repoMock.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestCreateNewURLInvalidPreview(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	fetcherMock := new(mocks.PreviewFetcher)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig, previewFetcher: fetcherMock}
+
+	requests := []domain.CreateUrlRequest{
+		{Url: "https://www.github.com", Image: "javascript:alert(1)"},
+		{Url: "https://www.github.com", Image: "/relative.png"},
+		{Url: "https://www.github.com", Title: strings.Repeat("a", 256)},
+		{Url: "https://www.github.com", Description: strings.Repeat("é", 1025)},
+	}
+	for _, request := range requests {
+		_, err := urlUsecase.CreateNewURL(userCtx, request)
+		assert.ErrorContains(t, err, "validation error")
+	}
+	fetcherMock.AssertNotCalled(t, "Fetch")
+	repoMock.AssertNotCalled(t, "Create")
+}
+
+func TestFetchPreviewTruncates(t *testing.T) {
+	fetcherMock := new(mocks.PreviewFetcher)
+	urlUsecase := urlUsecase{previewFetcher: fetcherMock}
+
+	fetcherMock.On("Fetch", context.Background(), "https://example.com").Return(domain.LinkPreview{
+		Title: strings.Repeat("é", 300),
+		Image: "https://example.com/" + strings.Repeat("a", 2048),
+	}, nil).Once()
+
+	preview := urlUsecase.fetchPreview(context.Background(), "https://example.com", domain.LinkPreview{})
+	assert.Equal(t, strings.Repeat("é", 255), preview.Title)
+	assert.Empty(t, preview.Image)
+
+	// nothing is fetched when every field was set
+	full := domain.LinkPreview{Title: "a", Description: "b", Image: "https://example.com/c.png"}
+	assert.Equal(t, full, urlUsecase.fetchPreview(context.Background(), "https://example.com", full))
+	fetcherMock.AssertExpectations(t)
 }
 
 func TestFindUrlByShort(t *testing.T) {
 	repoMock := new(mocks.UrlRepository)
-	urlUsecase := urlUsecase{repoMock}
+	urlUsecase := urlUsecase{urlRepository: repoMock}
 
 	shortUrlTest := "pqS63Ns"
 	result := domain.Url{
@@ -86,9 +290,27 @@ func TestFindUrlByShort(t *testing.T) {
 	assert.Equal(t, result.CreatedAt, url.CreatedAt)
 }
 
+func TestFindUrlByID(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	// expired urls are still found, only redirects check the status
+	result := domain.Url{ID: 23, ShortUrl: "pqS63Ns", OwnerID: 3, ExpiresAt: time.Now().Unix() - 3600}
+	repoMock.On("FindByID", userCtx, 23, 3).Return(result, nil)
+	repoMock.On("FindByID", userCtx, 24, 3).Return(domain.Url{}, sql.ErrNoRows)
+
+	url, err := urlUsecase.FindUrlByID(userCtx, 23)
+	assert.NoError(t, err)
+	assert.Equal(t, result, url)
+
+	_, err = urlUsecase.FindUrlByID(userCtx, 24)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	repoMock.AssertExpectations(t)
+}
+
 func TestFindAllUrl(t *testing.T) {
 	repoMock := new(mocks.UrlRepository)
-	urlUsecase := urlUsecase{repoMock}
+	urlUsecase := urlUsecase{urlRepository: repoMock}
 
 	result := []domain.Url{
 		{
@@ -107,17 +329,22 @@ func TestFindAllUrl(t *testing.T) {
 		},
 	}
 
-	repoMock.On("FindAll", context.Background()).Return(result, nil)
+	repoMock.On("FindAll", userCtx, mock.MatchedBy(func(filter domain.UrlFilter) bool {
+		return filter.Status == domain.UrlStatusActive && filter.Now != 0 && filter.OwnerID == 3
+	})).Return(result, nil)
 
-	urls, err := urlUsecase.FindAllUrl(context.Background())
+	urls, err := urlUsecase.FindAllUrl(userCtx, domain.UrlStatusActive, 0)
 	repoMock.AssertExpectations(t)
 	assert.NoError(t, err)
 	assert.Len(t, urls, 2)
+
+	_, err = urlUsecase.FindAllUrl(userCtx, "deleted", 0)
+	assert.ErrorContains(t, err, "validation error")
 }
 
 func TestDeleteByID(t *testing.T) {
 	repoMock := new(mocks.UrlRepository)
-	urlUsecase := urlUsecase{repoMock}
+	urlUsecase := urlUsecase{urlRepository: repoMock}
 
 	idTest := 1
 	result := domain.Url{
@@ -126,12 +353,13 @@ func TestDeleteByID(t *testing.T) {
 		ShortUrl:   "jUHH23x",
 		ClickCount: 63,
 		CreatedAt:  time.Now().Unix(),
+		OwnerID:    3,
 	}
 
-	repoMock.On("DeleteByID", context.Background(), idTest).Return(idTest, nil)
-	repoMock.On("FindByID", context.Background(), idTest).Return(result, nil)
+	repoMock.On("DeleteByID", userCtx, idTest, 3).Return(idTest, nil)
+	repoMock.On("FindByID", userCtx, idTest, 3).Return(result, nil)
 
-	url, err := urlUsecase.DeleteByID(context.Background(), idTest)
+	url, err := urlUsecase.DeleteByID(userCtx, idTest)
 	repoMock.AssertExpectations(t)
 	assert.NoError(t, err)
 	assert.Equal(t, result.ID, url.ID)
@@ -140,3 +368,491 @@ func TestDeleteByID(t *testing.T) {
 	assert.Equal(t, result.ClickCount, url.ClickCount)
 	assert.Equal(t, result.CreatedAt, url.CreatedAt)
 }
+
+func TestCreateNewURLPassword(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+	request := domain.CreateUrlRequest{
+		Url:      "https://www.github.com/mrizalr",
+		Password: "s3cret",
+	}
+
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{}, sql.ErrNoRows).Once()
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return bcrypt.CompareHashAndPassword([]byte(params.PasswordHash), []byte(request.Password)) == nil
+	})).Return(1, nil)
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{ID: 1, Url: request.Url, Protected: true}, nil)
+
+	url, err := urlUsecase.CreateNewURL(userCtx, request)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.True(t, url.Protected)
+}
+
+func TestUnlockUrl(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig,
+		passwordAttempts: newAttemptLimiter(_config.PasswordMaxAttempts, _config.PasswordAttemptWindow)}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	result := domain.Url{
+		ID:           1,
+		Url:          "https://www.linkedin.com/in/mrizalr",
+		ShortUrl:     "pqS63Ns",
+		PasswordHash: string(hash),
+		Protected:    true,
+	}
+	repoMock.On("FindByShortUrl", context.Background(), result.ShortUrl).Return(result, nil)
+
+	_, err = urlUsecase.UnlockUrl(context.Background(), result.ShortUrl, "wrong", "10.0.0.1")
+	assert.ErrorIs(t, err, domain.ErrInvalidPassword)
+
+	url, err := urlUsecase.UnlockUrl(context.Background(), result.ShortUrl, "s3cret", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, result.Url, url.Url)
+}
+
+func TestUnlockUrlTooManyAttempts(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig,
+		passwordAttempts: newAttemptLimiter(_config.PasswordMaxAttempts, _config.PasswordAttemptWindow)}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	result := domain.Url{ID: 1, ShortUrl: "pqS63Ns", PasswordHash: string(hash), Protected: true}
+	repoMock.On("FindByShortUrl", context.Background(), result.ShortUrl).Return(result, nil)
+
+	for i := 0; i < _config.PasswordMaxAttempts; i++ {
+		_, err = urlUsecase.UnlockUrl(context.Background(), result.ShortUrl, "wrong", "10.0.0.1")
+		assert.ErrorIs(t, err, domain.ErrInvalidPassword)
+	}
+
+	_, err = urlUsecase.UnlockUrl(context.Background(), result.ShortUrl, "s3cret", "10.0.0.1")
+	assert.ErrorIs(t, err, domain.ErrTooManyAttempts)
+
+	// other clients are not affected by the lockout
+	_, err = urlUsecase.UnlockUrl(context.Background(), result.ShortUrl, "s3cret", "10.0.0.2")
+	assert.NoError(t, err)
+}
+
+func TestFindUrlByShortConsumed(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	result := domain.Url{ID: 1, ShortUrl: "pqS63Ns", SingleUse: true, ConsumedAt: time.Now().Unix()}
+	repoMock.On("FindByShortUrl", context.Background(), result.ShortUrl).Return(result, nil)
+
+	_, err := urlUsecase.FindUrlByShort(context.Background(), result.ShortUrl)
+	repoMock.AssertExpectations(t)
+	assert.ErrorIs(t, err, domain.ErrUrlConsumed)
+}
+
+func TestConsumeUrl(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	repoMock.On("MarkConsumed", context.Background(), 1, mock.AnythingOfType("int64")).Return(1, nil).Once()
+	repoMock.On("MarkConsumed", context.Background(), 1, mock.AnythingOfType("int64")).Return(0, nil).Once()
+
+	err := urlUsecase.ConsumeUrl(context.Background(), 1)
+	assert.NoError(t, err)
+
+	err = urlUsecase.ConsumeUrl(context.Background(), 1)
+	assert.ErrorIs(t, err, domain.ErrUrlConsumed)
+	repoMock.AssertExpectations(t)
+}
+
+func TestFindUrlByShortActivationWindow(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	now := time.Now().Unix()
+	scheduled := domain.Url{ID: 1, ShortUrl: "pqS63Ns", ActiveFrom: now + 3600}
+	expired := domain.Url{ID: 2, ShortUrl: "jUHH23x", ExpiresAt: now - 3600}
+	active := domain.Url{ID: 3, ShortUrl: "2HsEgd", ActiveFrom: now - 3600, ExpiresAt: now + 3600}
+	repoMock.On("FindByShortUrl", context.Background(), scheduled.ShortUrl).Return(scheduled, nil)
+	repoMock.On("FindByShortUrl", context.Background(), expired.ShortUrl).Return(expired, nil)
+	repoMock.On("FindByShortUrl", context.Background(), active.ShortUrl).Return(active, nil)
+
+	url, err := urlUsecase.FindUrlByShort(context.Background(), scheduled.ShortUrl)
+	assert.ErrorIs(t, err, domain.ErrUrlNotActive)
+	assert.Equal(t, scheduled.ActiveFrom, url.ActiveFrom)
+
+	_, err = urlUsecase.FindUrlByShort(context.Background(), expired.ShortUrl)
+	assert.ErrorIs(t, err, domain.ErrUrlExpired)
+
+	_, err = urlUsecase.FindUrlByShort(context.Background(), active.ShortUrl)
+	assert.NoError(t, err)
+	repoMock.AssertExpectations(t)
+}
+
+func TestCreateNewURLInvalidActivationWindow(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+	now := time.Now().Unix()
+	requests := []domain.CreateUrlRequest{
+		{Url: "https://www.github.com/mrizalr", ExpiresAt: now - 60},
+		{Url: "https://www.github.com/mrizalr", ActiveFrom: now + 7200, ExpiresAt: now + 3600},
+	}
+
+	for _, request := range requests {
+		_, err := urlUsecase.CreateNewURL(userCtx, request)
+		assert.ErrorContains(t, err, "validation error")
+	}
+	repoMock.AssertNotCalled(t, "Create")
+}
+
+func TestCreateNewURLTargets(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, config: testConfig}
+
+	request := domain.CreateUrlRequest{
+		Targets: []domain.UrlTarget{
+			{Url: "www.github.com/a", Weight: 70},
+			{Url: "https://www.github.com/b", Weight: 30},
+		},
+	}
+
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{}, sql.ErrNoRows).Once()
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	repoMock.On("Create", userCtx, mock.MatchedBy(func(params domain.CreateUrlParams) bool {
+		return params.Url == "https://www.github.com/a" && len(params.Targets) == 2 &&
+			params.Targets[0].Url == "https://www.github.com/a" && params.Targets[1].Weight == 30
+	})).Return(1, nil)
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).
+		Return(domain.Url{ID: 1, Url: "https://www.github.com/a"}, nil)
+
+	_, err := urlUsecase.CreateNewURL(userCtx, request)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+
+	request.Targets[1].Weight = 0
+	_, err = urlUsecase.CreateNewURL(userCtx, request)
+	assert.ErrorContains(t, err, "validation error")
+}
+
+func TestUpdateTargets(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	targets := []domain.UrlTarget{{Url: "https://www.github.com/a", Weight: 1}}
+	result := domain.Url{ID: 1, OwnerID: 3, Url: "https://www.github.com/a", Targets: []domain.UrlTarget{{ID: 4, UrlID: 1, Url: targets[0].Url, Weight: 1}}}
+
+	repoMock.On("FindByID", userCtx, 1, 3).Return(result, nil)
+	repoMock.On("ReplaceTargets", userCtx, 1, targets).Return(nil)
+
+	url, err := urlUsecase.UpdateTargets(userCtx, 1, targets)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Len(t, url.Targets, 1)
+}
+
+func TestRecordClick(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, clicks: newClickPipeline(repoMock, 10, 10, time.Hour, 0, time.Hour)}
+
+	// the event is buffered, then written on close with the time it was recorded
+	before := time.Now().Unix()
+	repoMock.On("CreateClickEvents", mock.Anything, mock.MatchedBy(func(events []domain.ClickEvent) bool {
+		return len(events) == 1 && events[0].UrlID == 1 && events[0].TargetID == 4 && events[0].ClickedAt >= before
+	})).Return(nil).Once()
+	repoMock.On("FindRollupWatermark", mock.Anything).Return(int64(0), nil)
+	repoMock.On("RollupClicks", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	repoMock.On("SetRollupWatermark", mock.Anything, mock.Anything).Return(nil)
+
+	err := urlUsecase.RecordClick(context.Background(), domain.ClickEvent{UrlID: 1, TargetID: 4, Country: "ID"})
+	assert.NoError(t, err)
+	assert.NoError(t, urlUsecase.Close(context.Background()))
+	repoMock.AssertExpectations(t)
+}
+
+func TestValidateRules(t *testing.T) {
+	rules, err := validateRules([]domain.UrlRule{
+		{Os: "iOS", Url: "apps.apple.com/app/id1"},
+		{Device: "desktop", Language: "pt-BR", Url: "https://example.com/br"},
+		{Country: "id", Url: "https://example.com/id"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.UrlRule{
+		{Position: 0, Os: "ios", Url: "https://apps.apple.com/app/id1"},
+		{Position: 1, Device: "desktop", Language: "pt-br", Url: "https://example.com/br"},
+		{Position: 2, Country: "ID", Url: "https://example.com/id"},
+	}, rules)
+
+	invalidRules := [][]domain.UrlRule{
+		{{Url: "https://example.com"}},
+		{{Os: "ios"}},
+		{{Os: "symbian", Url: "https://example.com"}},
+		{{Device: "watch", Url: "https://example.com"}},
+		{{Language: "english!", Url: "https://example.com"}},
+		{{Country: "IDN", Url: "https://example.com"}},
+	}
+	for _, invalid := range invalidRules {
+		_, err := validateRules(invalid)
+		assert.ErrorContains(t, err, "validation error")
+	}
+}
+
+func TestUpdateRules(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	rules := []domain.UrlRule{{Position: 0, Os: "android", Url: "https://play.google.com"}}
+	result := domain.Url{ID: 1, OwnerID: 3, Rules: []domain.UrlRule{{ID: 2, UrlID: 1, Os: "android", Url: "https://play.google.com"}}}
+
+	repoMock.On("FindByID", userCtx, 1, 3).Return(result, nil)
+	repoMock.On("ReplaceRules", userCtx, 1, rules).Return(nil)
+
+	url, err := urlUsecase.UpdateRules(userCtx, 1, rules)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Len(t, url.Rules, 1)
+}
+
+func TestGetUrlDetails(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	// sunday 2023-11-19 12:00 UTC, summarizing from monday 2023-10-21 00:00 UTC
+	url := domain.Url{ID: 7, ShortUrl: "pqS63Ns", ClickCount: 40, ExpiresAt: 1700395200}
+	filter := domain.StatsFilter{UrlID: 7, From: 1697846400, To: 1700395200, BucketSize: 86400}
+
+	repoMock.On("CountClicks", context.Background(), filter).
+		Return([]domain.ClickBucket{{Time: 1697846400, Clicks: 3}, {Time: 1700352000, Clicks: 6}}, nil)
+	repoMock.On("CountBotClicks", context.Background(), filter).Return(2, nil)
+	repoMock.On("FindVisitorSketches", context.Background(), 7, int64(1697846400), int64(1700395200)).
+		Return([]domain.VisitorSketch{{Day: 1700352000, Sketch: visitorSketch(t, "a", "b")}}, nil)
+
+	details, err := urlUsecase.urlDetails(context.Background(), url, 1700395200)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, url, details.Url)
+	assert.Equal(t, domain.UrlStatusExpired, details.Status)
+	assert.Equal(t, domain.StatsSummary{From: 1697846400, To: 1700395200, Clicks: 9, BotClicks: 2, Visitors: 2}, details.Stats)
+}
+
+func TestGetUrlDetailsByShort(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	// consumed single use urls are still found, only redirects check the status
+	url := domain.Url{ID: 7, ShortUrl: "pqS63Ns", OwnerID: 3, SingleUse: true, ConsumedAt: time.Now().Unix() - 60}
+	repoMock.On("FindByShortUrl", userCtx, "pqS63Ns").Return(url, nil)
+	repoMock.On("FindByShortUrl", userCtx, "aaaaa").Return(domain.Url{}, sql.ErrNoRows)
+	repoMock.On("FindByShortUrl", userCtx, "bbbbb").Return(domain.Url{ID: 8, ShortUrl: "bbbbb", OwnerID: 4}, nil)
+	repoMock.On("CountClicks", userCtx, mock.AnythingOfType("domain.StatsFilter")).Return([]domain.ClickBucket{}, nil)
+	repoMock.On("CountBotClicks", userCtx, mock.AnythingOfType("domain.StatsFilter")).Return(0, nil)
+	repoMock.On("FindVisitorSketches", userCtx, 7, mock.Anything, mock.Anything).Return([]domain.VisitorSketch{}, nil)
+
+	details, err := urlUsecase.GetUrlDetailsByShort(userCtx, "pqS63Ns")
+	assert.NoError(t, err)
+	assert.Equal(t, url, details.Url)
+	assert.Equal(t, domain.UrlStatusActive, details.Status)
+
+	_, err = urlUsecase.GetUrlDetailsByShort(userCtx, "aaaaa")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// the url of another user isn't found
+	_, err = urlUsecase.GetUrlDetailsByShort(userCtx, "bbbbb")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	repoMock.AssertExpectations(t)
+}
+
+func TestOwnerScope(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	// admins aren't scoped to an owner, anonymous callers can't manage urls
+	adminCtx := domain.ContextWithUser(context.Background(), domain.User{ID: 1, Role: domain.UserRoleAdmin})
+	repoMock.On("FindByID", adminCtx, 9, 0).Return(domain.Url{ID: 9, OwnerID: 4}, nil)
+	repoMock.On("DeleteByID", adminCtx, 9, 0).Return(9, nil)
+
+	url, err := urlUsecase.DeleteByID(adminCtx, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, url.OwnerID)
+
+	_, err = urlUsecase.DeleteByID(context.Background(), 9)
+	assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+	_, err = urlUsecase.FindAllUrl(context.Background(), "", 0)
+	assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+	_, err = urlUsecase.CreateNewURL(context.Background(), domain.CreateUrlRequest{Url: "https://github.com"})
+	assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+	repoMock.AssertExpectations(t)
+}
+
+func TestWorkspaceUrlRoles(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock, workspaceRepository: workspaceRepoMock}
+
+	// user 3 views workspace 4 and isn't a member of workspace 6
+	repoMock.On("FindByID", userCtx, 9, 3).Return(domain.Url{ID: 9, OwnerID: 5, WorkspaceID: 4}, nil)
+	repoMock.On("FindByID", userCtx, 10, 3).Return(domain.Url{ID: 10, OwnerID: 3, WorkspaceID: 6}, nil)
+	workspaceRepoMock.On("FindMember", userCtx, 4, 3).Return(domain.WorkspaceMember{Role: domain.WorkspaceRoleViewer}, nil)
+	workspaceRepoMock.On("FindMember", userCtx, 6, 3).Return(domain.WorkspaceMember{}, sql.ErrNoRows)
+	repoMock.On("FindAll", userCtx, mock.MatchedBy(func(filter domain.UrlFilter) bool {
+		return filter.WorkspaceID == 4
+	})).Return([]domain.Url{{ID: 9}}, nil)
+
+	url, err := urlUsecase.FindUrlByID(userCtx, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, url.ID)
+	urls, err := urlUsecase.FindAllUrl(userCtx, "", 4)
+	assert.NoError(t, err)
+	assert.Len(t, urls, 1)
+
+	// viewers can't manage links, and links of other workspaces aren't found even for their creator
+	_, err = urlUsecase.DeleteByID(userCtx, 9)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	_, err = urlUsecase.UpdateTargets(userCtx, 9, nil)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	_, err = urlUsecase.FindUrlByID(userCtx, 10)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = urlUsecase.FindAllUrl(userCtx, "", 6)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	repoMock.AssertNotCalled(t, "DeleteByID", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateNewURLInWorkspace(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	cfg := testConfig
+	cfg.UserQuota = domain.Quota{MonthlyCreates: 20}
+	cfg.WorkspaceQuota = domain.Quota{ActiveLinks: 500}
+	urlUsecase := urlUsecase{urlRepository: repoMock, workspaceRepository: workspaceRepoMock, config: cfg}
+
+	workspaceRepoMock.On("FindByID", userCtx, 4).
+		Return(domain.Workspace{ID: 4, Slug: "sales", SlugNamespace: domain.SlugNamespaceWorkspace}, nil)
+	workspaceRepoMock.On("FindByID", userCtx, 6).
+		Return(domain.Workspace{ID: 6, Slug: "ops", SlugNamespace: domain.SlugNamespaceShared}, nil)
+	workspaceRepoMock.On("FindMember", userCtx, 4, 3).Return(domain.WorkspaceMember{Role: domain.WorkspaceRoleEditor}, nil)
+	workspaceRepoMock.On("FindMember", userCtx, 6, 3).Return(domain.WorkspaceMember{Role: domain.WorkspaceRoleViewer}, nil)
+
+	var params domain.CreateUrlParams
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).Return(domain.Url{}, sql.ErrNoRows).Once()
+	repoMock.On("CheckQuota", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).Return(nil)
+	repoMock.On("Create", userCtx, mock.AnythingOfType("domain.CreateUrlParams")).
+		Run(func(args mock.Arguments) { params = args.Get(1).(domain.CreateUrlParams) }).
+		Return(1, nil)
+	repoMock.On("FindByShortUrl", userCtx, mock.AnythingOfType("string")).Return(domain.Url{ID: 1, WorkspaceID: 4}, nil)
+
+	url, err := urlUsecase.CreateNewURL(userCtx, domain.CreateUrlRequest{Url: "https://github.com", WorkspaceID: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, url.WorkspaceID)
+	assert.Equal(t, 4, params.WorkspaceID)
+	assert.True(t, strings.HasPrefix(params.ShortUrl, "sales/"), params.ShortUrl)
+	// links of a workspace count for the quota of the workspace
+	assert.Equal(t, cfg.WorkspaceQuota, params.Quota)
+	assert.Equal(t, time.Unix(params.PeriodStart, 0).UTC().Month(), time.Now().UTC().Month())
+
+	// viewers can't create links in their workspace
+	_, err = urlUsecase.CreateNewURL(userCtx, domain.CreateUrlRequest{Url: "https://github.com", WorkspaceID: 6})
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	repoMock.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestGenerateRandomNotNumeric(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		shortUrl := generateRandom()
+		assert.NotEmpty(t, strings.Trim(shortUrl, "0123456789"), shortUrl)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	// thursday 2023-11-16 10:00 UTC to sunday 2023-11-19 00:00 UTC
+	request := domain.StatsRequest{From: 1700128800, To: 1700352000, Interval: domain.StatsIntervalDay}
+	filter := domain.StatsFilter{UrlID: 1, From: 1700092800, To: request.To, BucketSize: 86400}
+
+	repoMock.On("FindByID", userCtx, 1, 3).Return(domain.Url{ID: 1, OwnerID: 3}, nil)
+	repoMock.On("CountClicks", userCtx, filter).
+		Return([]domain.ClickBucket{{Time: 1700092800, Clicks: 3}, {Time: 1700265600, Clicks: 2}}, nil)
+	repoMock.On("CountBotClicks", userCtx, filter).Return(4, nil)
+	repoMock.On("FindVisitorSketches", userCtx, 1, int64(1700092800), request.To).
+		Return([]domain.VisitorSketch{
+			{Day: 1700092800, Sketch: visitorSketch(t, "a", "b")},
+			{Day: 1700265600, Sketch: visitorSketch(t, "b", "c", "d")},
+		}, nil)
+	for _, dimension := range []string{"referrer", "country", "browser", "os", "device"} {
+		repoMock.On("CountClicksBy", userCtx, filter, dimension, 10).
+			Return([]domain.ClickCount{{Value: dimension, Clicks: 5}}, nil)
+	}
+
+	stats, err := urlUsecase.GetStats(userCtx, 1, request)
+	repoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1700092800), stats.From)
+	assert.Equal(t, 5, stats.Clicks)
+	assert.Equal(t, 4, stats.BotClicks)
+	assert.Equal(t, 4, stats.Visitors)
+	assert.Equal(t, []domain.ClickBucket{
+		{Time: 1700092800, Clicks: 3, Visitors: 2},
+		{Time: 1700179200, Clicks: 0},
+		{Time: 1700265600, Clicks: 2, Visitors: 3},
+	}, stats.Series)
+	assert.Equal(t, []domain.ClickCount{{Value: "country", Clicks: 5}}, stats.Countries)
+	assert.Equal(t, []domain.ClickCount{{Value: "device", Clicks: 5}}, stats.Devices)
+}
+
+func TestGetStatsWeekBuckets(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	request := domain.StatsRequest{From: 1700128800, To: 1700352000, Interval: domain.StatsIntervalWeek, IncludeBots: true}
+	repoMock.On("FindByID", userCtx, 1, 3).Return(domain.Url{ID: 1, OwnerID: 3}, nil)
+	repoMock.On("CountClicks", userCtx, mock.MatchedBy(func(filter domain.StatsFilter) bool {
+		return filter.IncludeBots && filter.BucketOffset == 4*24*60*60
+	})).Return([]domain.ClickBucket{}, nil)
+	repoMock.On("CountClicksBy", userCtx, mock.Anything, mock.Anything, 10).Return([]domain.ClickCount{}, nil)
+	repoMock.On("CountBotClicks", userCtx, mock.Anything).Return(0, nil)
+	repoMock.On("FindVisitorSketches", userCtx, 1, int64(1699833600), request.To).
+		Return([]domain.VisitorSketch{
+			{Day: 1700092800, Sketch: visitorSketch(t, "a", "b")},
+			{Day: 1700179200, Sketch: visitorSketch(t, "a")},
+		}, nil)
+
+	stats, err := urlUsecase.GetStats(userCtx, 1, request)
+	assert.NoError(t, err)
+	// monday 2023-11-13 00:00 UTC
+	assert.Equal(t, []domain.ClickBucket{{Time: 1699833600, Clicks: 0, Visitors: 2}}, stats.Series)
+	assert.Equal(t, 2, stats.Visitors)
+}
+
+func TestGetStatsValidation(t *testing.T) {
+	repoMock := new(mocks.UrlRepository)
+	urlUsecase := urlUsecase{urlRepository: repoMock}
+
+	invalidRequests := []domain.StatsRequest{
+		{Interval: "month"},
+		{From: 1700352000, To: 1700128800},
+		{From: 1600000000, To: 1700000000, Interval: domain.StatsIntervalHour},
+	}
+	for _, request := range invalidRequests {
+		_, err := urlUsecase.GetStats(context.Background(), 1, request)
+		assert.ErrorContains(t, err, "validation error")
+	}
+	repoMock.AssertNotCalled(t, "FindByID", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func visitorSketch(t *testing.T, visitors ...string) []byte {
+	sketch := utils.NewHyperLogLog()
+	for _, visitor := range visitors {
+		sum := sha256.Sum256([]byte(visitor))
+		sketch.Add(binary.BigEndian.Uint64(sum[:8]))
+	}
+
+	data, err := sketch.MarshalBinary()
+	assert.NoError(t, err)
+	return data
+}
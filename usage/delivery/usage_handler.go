@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+type UsageHandler struct {
+	usageUsecase domain.UsageUsecase
+}
+
+func NewUsageHandler(usageUsecase domain.UsageUsecase, m *mux.Router, authMiddleware *auth.Middleware) {
+	handler := UsageHandler{usageUsecase}
+	router_v1 := m.PathPrefix("/api/v1/usage").Subrouter()
+
+	authMiddleware.Require(router_v1.Path("/").HandlerFunc(handler.getUsage).Methods("GET"), domain.ScopeLinksRead)
+	authMiddleware.Require(router_v1.Path("/quota").HandlerFunc(handler.setQuota).Methods("PUT"), domain.ScopeAdmin)
+	router_v1.Use(authMiddleware.Handler)
+}
+
+func (h *UsageHandler) getUsage(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaceID := 0
+	if req.URL.Query().Get("workspace_id") != "" {
+		id, err := strconv.Atoi(req.URL.Query().Get("workspace_id"))
+		if err != nil || id <= 0 {
+			utils.FormatResponse(res, &utils.ResponseErrorParams{
+				Code:   http.StatusBadRequest,
+				Status: "Bad request",
+				Errors: []string{"workspace_id isn't valid"},
+			})
+			return
+		}
+		workspaceID = id
+	}
+
+	usage, err := h.usageUsecase.GetUsage(req.Context(), workspaceID)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   usage,
+	})
+}
+
+func (h *UsageHandler) setQuota(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	requestBody := domain.SetQuotaRequest{}
+	err := json.NewDecoder(req.Body).Decode(&requestBody)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"error while parsing json"},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	usage, err := h.usageUsecase.SetQuota(req.Context(), requestBody)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   usage,
+	})
+}
+
+func errorParams(err error) *utils.ResponseErrorParams {
+	errorParams := utils.ResponseErrorParams{
+		Code:   http.StatusBadGateway,
+		Status: "Bad gateway",
+		Errors: []string{err.Error()},
+	}
+
+	if strings.Contains(strings.ToLower(err.Error()), "validation") {
+		errorParams.Code = http.StatusBadRequest
+		errorParams.Status = "Bad request"
+	}
+	if errors.Is(err, domain.ErrUnauthenticated) {
+		errorParams.Code = http.StatusUnauthorized
+		errorParams.Status = "Unauthorized"
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		errorParams.Code = http.StatusForbidden
+		errorParams.Status = "Forbidden"
+	}
+	return &errorParams
+}
@@ -0,0 +1,79 @@
+package delivery
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetUsageHandler(t *testing.T) {
+	mockUsecase := new(mocks.UsageUsecase)
+	mockUsecase.On("GetUsage", mock.Anything, 4).Return(domain.Usage{SubjectType: "workspace", SubjectID: 4,
+		PeriodStart: 1698796800, PeriodEnd: 1701388800, MonthlyCreates: 12, ActiveLinks: 40, Quota: domain.Quota{MonthlyCreates: 100}}, nil)
+	mockUsecase.On("GetUsage", mock.Anything, 6).Return(domain.Usage{}, fmt.Errorf("%w: the viewer role is required", domain.ErrForbidden))
+
+	handler := UsageHandler{mockUsecase}
+
+	res := httptest.NewRecorder()
+	handler.getUsage(res, httptest.NewRequest("GET", "/api/v1/usage/?workspace_id=4", nil))
+
+	expect := `
+	{
+		"status_code":200,
+		"status":"Success",
+		"data":{
+			"subject_type":"workspace",
+			"subject_id":4,
+			"period_start":1698796800,
+			"period_end":1701388800,
+			"monthly_creates":12,
+			"active_links":40,
+			"quota":{"monthly_creates":100,"active_links":0}
+		}
+	}`
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, expect, res.Body.String())
+
+	res = httptest.NewRecorder()
+	handler.getUsage(res, httptest.NewRequest("GET", "/api/v1/usage/?workspace_id=6", nil))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = httptest.NewRecorder()
+	handler.getUsage(res, httptest.NewRequest("GET", "/api/v1/usage/?workspace_id=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestSetQuotaRoute(t *testing.T) {
+	mockUsecase := new(mocks.UsageUsecase)
+	request := domain.SetQuotaRequest{SubjectType: "user", SubjectID: 3, Quota: domain.Quota{MonthlyCreates: 10, ActiveLinks: 20}}
+	mockUsecase.On("SetQuota", mock.Anything, request).Return(domain.Usage{SubjectType: "user", SubjectID: 3, Quota: request.Quota}, nil)
+
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "admin").
+		Return(domain.User{ID: 1, Role: domain.UserRoleAdmin}, []string{domain.ScopeAdmin}, nil)
+	authenticator.On("Authenticate", mock.Anything, "writer").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksRead, domain.ScopeLinksWrite}, nil)
+
+	router := mux.NewRouter()
+	NewUsageHandler(mockUsecase, router, auth.NewMiddleware(authenticator))
+
+	body := `{"subject_type":"user","subject_id":3,"monthly_creates":10,"active_links":20}`
+	for token, expect := range map[string]int{"admin": http.StatusOK, "writer": http.StatusForbidden} {
+		req := httptest.NewRequest("PUT", "/api/v1/usage/quota", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, expect, res.Code, token)
+	}
+	mockUsecase.AssertNumberOfCalls(t, "SetQuota", 1)
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type usageRepository struct {
+	db *sql.DB
+}
+
+func NewUsageRepository(db *sql.DB) domain.UsageRepository {
+	return &usageRepository{db}
+}
+
+// Fetch the quota set for one subject from quotas table
+// Receiving context, subjectType (string), subjectID (int), and defaults (domain.Quota) as parameter
+// Returning quota data (domain.Quota), the defaults when none is set for the subject, and error if failed
+
+func (r *usageRepository) FindQuota(ctx context.Context, subjectType string, subjectID int, defaults domain.Quota) (domain.Quota, error) {
+	quota := domain.Quota{}
+	err := r.db.QueryRowContext(ctx, queries.FindQuota, subjectType, subjectID).Scan(&quota.MonthlyCreates, &quota.ActiveLinks)
+	if err == sql.ErrNoRows {
+		return defaults, nil
+	}
+	return quota, err
+}
+
+// Set the quota of one subject in quotas table
+// Receiving context, subjectType (string), subjectID (int), and quota (domain.Quota) as parameter
+// Returning error if failed
+
+func (r *usageRepository) SetQuota(ctx context.Context, subjectType string, subjectID int, quota domain.Quota) error {
+	_, err := r.db.ExecContext(ctx, queries.UpsertQuota, subjectType, subjectID, quota.MonthlyCreates, quota.ActiveLinks)
+	return err
+}
+
+// Fetch the usage counters of one subject from usage_counters table
+// Receiving context, subjectType (string), subjectID (int), and periodStart (int64) of the current month as parameter
+// Returning usage data (domain.Usage), counted from its urls when the subject never created a link since quotas exist,
+// and error if failed
+
+func (r *usageRepository) FindUsage(ctx context.Context, subjectType string, subjectID int, periodStart int64) (domain.Usage, error) {
+	usage := domain.Usage{SubjectType: subjectType, SubjectID: subjectID}
+	err := r.db.QueryRowContext(ctx, queries.FindUsage, subjectType, subjectID).
+		Scan(&usage.PeriodStart, &usage.MonthlyCreates, &usage.ActiveLinks)
+	if err != sql.ErrNoRows {
+		return usage, err
+	}
+
+	query := queries.CountUserUsage
+	if subjectType == domain.QuotaSubjectWorkspace {
+		query = queries.CountWorkspaceUsage
+	}
+	usage.PeriodStart = periodStart
+	err = r.db.QueryRowContext(ctx, query, periodStart, subjectID).Scan(&usage.MonthlyCreates, &usage.ActiveLinks)
+	return usage, err
+}
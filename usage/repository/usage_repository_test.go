@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func NewMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		panic(err)
+	}
+	return db, mock
+}
+
+func TestFindQuota(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	defaults := domain.Quota{MonthlyCreates: 100, ActiveLinks: 1000}
+	mock.ExpectQuery(queries.FindQuota).WithArgs(domain.QuotaSubjectWorkspace, 4).
+		WillReturnRows(mock.NewRows([]string{"monthly_creates", "active_links"}).AddRow(500, 0))
+	mock.ExpectQuery(queries.FindQuota).WithArgs(domain.QuotaSubjectUser, 3).WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(queries.UpsertQuota).WithArgs(domain.QuotaSubjectUser, 3, 10, 20).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := usageRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	quota, err := repo.FindQuota(ctx, domain.QuotaSubjectWorkspace, 4, defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Quota{MonthlyCreates: 500}, quota)
+
+	// subjects without a quota of their own get the defaults
+	quota, err = repo.FindQuota(ctx, domain.QuotaSubjectUser, 3, defaults)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults, quota)
+
+	err = repo.SetQuota(ctx, domain.QuotaSubjectUser, 3, domain.Quota{MonthlyCreates: 10, ActiveLinks: 20})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindUsage(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectQuery(queries.FindUsage).WithArgs(domain.QuotaSubjectUser, 3).
+		WillReturnRows(mock.NewRows([]string{"period_start", "monthly_creates", "active_links"}).AddRow(1698796800, 12, 40))
+	mock.ExpectQuery(queries.FindUsage).WithArgs(domain.QuotaSubjectUser, 5).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(queries.CountUserUsage).WithArgs(1698796800, 5).
+		WillReturnRows(mock.NewRows([]string{"monthly_creates", "active_links"}).AddRow(2, 9))
+
+	repo := usageRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	usage, err := repo.FindUsage(ctx, domain.QuotaSubjectUser, 3, 1698796800)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Usage{SubjectType: "user", SubjectID: 3, PeriodStart: 1698796800, MonthlyCreates: 12, ActiveLinks: 40}, usage)
+
+	// links created before the counters of the subject are counted from its urls
+	usage, err = repo.FindUsage(ctx, domain.QuotaSubjectUser, 5, 1698796800)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Usage{SubjectType: "user", SubjectID: 5, PeriodStart: 1698796800, MonthlyCreates: 2, ActiveLinks: 9}, usage)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
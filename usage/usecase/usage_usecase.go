@@ -0,0 +1,100 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type usageUsecase struct {
+	usageRepository     domain.UsageRepository
+	workspaceRepository domain.WorkspaceRepository
+	config              config.Config
+}
+
+func NewUsageUsecase(usageRepository domain.UsageRepository, workspaceRepository domain.WorkspaceRepository, cfg config.Config) domain.UsageUsecase {
+	return &usageUsecase{usageRepository, workspaceRepository, cfg}
+}
+
+// Usage of the caller, or of a workspace it is a member of, along with the quota it is held to
+
+func (u *usageUsecase) GetUsage(ctx context.Context, workspaceID int) (domain.Usage, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.Usage{}, domain.ErrUnauthenticated
+	}
+	if workspaceID == 0 {
+		return u.usage(ctx, domain.QuotaSubjectUser, user.ID)
+	}
+
+	if !user.IsAdmin() {
+		_, err := u.workspaceRepository.FindMember(ctx, workspaceID, user.ID)
+		if err == sql.ErrNoRows {
+			return domain.Usage{}, fmt.Errorf("%w: the %s role is required", domain.ErrForbidden, domain.WorkspaceRoleViewer)
+		}
+		if err != nil {
+			return domain.Usage{}, err
+		}
+	}
+	return u.usage(ctx, domain.QuotaSubjectWorkspace, workspaceID)
+}
+
+// Replace the configured quota of one user or workspace, admins only
+
+func (u *usageUsecase) SetQuota(ctx context.Context, request domain.SetQuotaRequest) (domain.Usage, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.Usage{}, domain.ErrUnauthenticated
+	}
+	if !user.IsAdmin() {
+		return domain.Usage{}, fmt.Errorf("%w: only admins set quotas", domain.ErrForbidden)
+	}
+
+	if request.SubjectType != domain.QuotaSubjectUser && request.SubjectType != domain.QuotaSubjectWorkspace {
+		return domain.Usage{}, errors.New("validation error: subject_type must be one of user or workspace")
+	}
+	if request.SubjectID <= 0 {
+		return domain.Usage{}, errors.New("validation error: subject_id is required")
+	}
+	if request.MonthlyCreates < 0 || request.ActiveLinks < 0 {
+		return domain.Usage{}, errors.New("validation error: monthly_creates and active_links can't be negative, 0 is no limit")
+	}
+
+	err := u.usageRepository.SetQuota(ctx, request.SubjectType, request.SubjectID, request.Quota)
+	if err != nil {
+		return domain.Usage{}, err
+	}
+	return u.usage(ctx, request.SubjectType, request.SubjectID)
+}
+
+// Counters of a subject for the current month, links created in a previous month aren't counted anymore
+
+func (u *usageUsecase) usage(ctx context.Context, subjectType string, subjectID int) (domain.Usage, error) {
+	defaults := u.config.UserQuota
+	if subjectType == domain.QuotaSubjectWorkspace {
+		defaults = u.config.WorkspaceQuota
+	}
+
+	quota, err := u.usageRepository.FindQuota(ctx, subjectType, subjectID, defaults)
+	if err != nil {
+		return domain.Usage{}, err
+	}
+
+	periodStart, periodEnd := domain.QuotaPeriod(time.Now().Unix())
+	usage, err := u.usageRepository.FindUsage(ctx, subjectType, subjectID, periodStart)
+	if err != nil {
+		return domain.Usage{}, err
+	}
+
+	if usage.PeriodStart != periodStart {
+		usage.MonthlyCreates = 0
+	}
+	usage.PeriodStart, usage.PeriodEnd = periodStart, periodEnd
+	usage.Quota = quota
+	return usage, nil
+}
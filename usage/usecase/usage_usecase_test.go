@@ -0,0 +1,89 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/mrizalr/urlshortener/config"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testConfig = config.Config{
+	UserQuota:      domain.Quota{MonthlyCreates: 100, ActiveLinks: 1000},
+	WorkspaceQuota: domain.Quota{MonthlyCreates: 1000},
+}
+
+var userCtx = domain.ContextWithUser(context.Background(), domain.User{ID: 3, Role: domain.UserRoleMember})
+
+func TestGetUsage(t *testing.T) {
+	usageRepoMock := new(mocks.UsageRepository)
+	usageUsecase := usageUsecase{usageRepository: usageRepoMock, config: testConfig}
+
+	periodStart, periodEnd := domain.QuotaPeriod(time.Now().Unix())
+	usageRepoMock.On("FindQuota", userCtx, domain.QuotaSubjectUser, 3, testConfig.UserQuota).Return(testConfig.UserQuota, nil)
+	usageRepoMock.On("FindUsage", userCtx, domain.QuotaSubjectUser, 3, periodStart).
+		Return(domain.Usage{SubjectType: "user", SubjectID: 3, PeriodStart: periodStart, MonthlyCreates: 12, ActiveLinks: 40}, nil)
+
+	usage, err := usageUsecase.GetUsage(userCtx, 0)
+	usageRepoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Usage{SubjectType: "user", SubjectID: 3, PeriodStart: periodStart, PeriodEnd: periodEnd,
+		MonthlyCreates: 12, ActiveLinks: 40, Quota: testConfig.UserQuota}, usage)
+}
+
+func TestGetUsageNewPeriod(t *testing.T) {
+	usageRepoMock := new(mocks.UsageRepository)
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	usageUsecase := usageUsecase{usageRepoMock, workspaceRepoMock, testConfig}
+
+	// counters of a previous month only keep the active links
+	workspaceRepoMock.On("FindMember", userCtx, 4, 3).Return(domain.WorkspaceMember{Role: domain.WorkspaceRoleViewer}, nil)
+	workspaceRepoMock.On("FindMember", userCtx, 6, 3).Return(domain.WorkspaceMember{}, sql.ErrNoRows)
+	usageRepoMock.On("FindQuota", userCtx, domain.QuotaSubjectWorkspace, 4, testConfig.WorkspaceQuota).Return(domain.Quota{MonthlyCreates: 50}, nil)
+	usageRepoMock.On("FindUsage", userCtx, domain.QuotaSubjectWorkspace, 4, mock.AnythingOfType("int64")).
+		Return(domain.Usage{SubjectType: "workspace", SubjectID: 4, PeriodStart: 1698796800, MonthlyCreates: 50, ActiveLinks: 70}, nil)
+
+	usage, err := usageUsecase.GetUsage(userCtx, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, usage.MonthlyCreates)
+	assert.Equal(t, 70, usage.ActiveLinks)
+	assert.Equal(t, 50, usage.Quota.MonthlyCreates)
+
+	_, err = usageUsecase.GetUsage(userCtx, 6)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+}
+
+func TestSetQuota(t *testing.T) {
+	usageRepoMock := new(mocks.UsageRepository)
+	usageUsecase := usageUsecase{usageRepository: usageRepoMock, config: testConfig}
+
+	adminCtx := domain.ContextWithUser(context.Background(), domain.User{ID: 1, Role: domain.UserRoleAdmin})
+	quota := domain.Quota{MonthlyCreates: 10}
+	usageRepoMock.On("SetQuota", adminCtx, domain.QuotaSubjectUser, 3, quota).Return(nil)
+	usageRepoMock.On("FindQuota", adminCtx, domain.QuotaSubjectUser, 3, testConfig.UserQuota).Return(quota, nil)
+	usageRepoMock.On("FindUsage", adminCtx, domain.QuotaSubjectUser, 3, mock.AnythingOfType("int64")).Return(domain.Usage{SubjectType: "user", SubjectID: 3}, nil)
+
+	usage, err := usageUsecase.SetQuota(adminCtx, domain.SetQuotaRequest{SubjectType: "user", SubjectID: 3, Quota: quota})
+	usageRepoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, quota, usage.Quota)
+
+	invalidRequests := []domain.SetQuotaRequest{
+		{SubjectType: "team", SubjectID: 3},
+		{SubjectType: "user"},
+		{SubjectType: "user", SubjectID: 3, Quota: domain.Quota{ActiveLinks: -1}},
+	}
+	for _, request := range invalidRequests {
+		_, err = usageUsecase.SetQuota(adminCtx, request)
+		assert.ErrorContains(t, err, "validation")
+	}
+
+	_, err = usageUsecase.SetQuota(userCtx, domain.SetQuotaRequest{SubjectType: "user", SubjectID: 3, Quota: quota})
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	usageRepoMock.AssertNumberOfCalls(t, "SetQuota", 1)
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// MySQL error number of a unique key violation
+const errDuplicateEntry = 1062
+
+type userRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) domain.UserRepository {
+	return &userRepository{db}
+}
+
+// Inserting new user data to users table
+// Receiving context, and user (domain.User) as parameter
+// Returning inserted user_id (int) if success, and error if failed
+
+func (r *userRepository) Create(ctx context.Context, user domain.User) (int, error) {
+	sqlRes, err := r.db.ExecContext(ctx, queries.InsertUser, user.Name, user.Email, user.Role, user.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	lastInsertID, err := sqlRes.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(lastInsertID), nil
+}
+
+// Fetch one user data from users table
+// Receiving context, and id (int) as parameter
+// Returning user data (domain.User) if success, and error if failed
+
+func (r *userRepository) FindByID(ctx context.Context, id int) (domain.User, error) {
+	user := domain.User{}
+	err := r.db.QueryRowContext(ctx, queries.FindUserByID, id).
+		Scan(&user.ID, &user.Name, &user.Email, &user.Role, &user.CreatedAt)
+	return user, err
+}
+
+// Fetch one user data from users table
+// Receiving context, and email (string) as parameter
+// Returning user data (domain.User) if success, and error if failed
+
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (domain.User, error) {
+	user := domain.User{}
+	err := r.db.QueryRowContext(ctx, queries.FindUserByEmail, email).
+		Scan(&user.ID, &user.Name, &user.Email, &user.Role, &user.CreatedAt)
+	return user, err
+}
+
+// Fetch the user an SSO account is linked to
+// Receiving context, issuer (string) and subject (string) as parameter
+// Returning user data (domain.User) if success, and error if failed
+
+func (r *userRepository) FindByIdentity(ctx context.Context, issuer, subject string) (domain.User, error) {
+	user := domain.User{}
+	err := r.db.QueryRowContext(ctx, queries.FindUserByIdentity, issuer, subject).
+		Scan(&user.ID, &user.Name, &user.Email, &user.Role, &user.CreatedAt)
+	return user, err
+}
+
+// Link an SSO account to a user
+// Receiving context, userID (int), issuer (string), subject (string) and createdAt (int64) as parameter
+// Returning domain.ErrIdentityTaken if the account or the user is already linked, and error if failed
+
+func (r *userRepository) LinkIdentity(ctx context.Context, userID int, issuer, subject string, createdAt int64) error {
+	_, err := r.db.ExecContext(ctx, queries.InsertUserIdentity, issuer, subject, userID, createdAt)
+	mysqlErr := &mysql.MySQLError{}
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+		return domain.ErrIdentityTaken
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var userColumns = []string{"id", "name", "email", "role", "created_at"}
+
+func NewMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		panic(err)
+	}
+	return db, mock
+}
+
+func TestCreateUser(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	user := domain.User{Name: "Rizal", Email: "rizal@example.com", Role: domain.UserRoleMember, CreatedAt: time.Now().Unix()}
+	mock.ExpectExec(queries.InsertUser).WithArgs(user.Name, user.Email, user.Role, user.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+
+	repo := userRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	id, err := repo.Create(ctx, user)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindUser(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	user := domain.User{ID: 4, Name: "Rizal", Email: "rizal@example.com", Role: domain.UserRoleAdmin, CreatedAt: time.Now().Unix()}
+	mock.ExpectQuery(queries.FindUserByID).WithArgs(4).
+		WillReturnRows(mock.NewRows(userColumns).AddRow(user.ID, user.Name, user.Email, user.Role, user.CreatedAt))
+	mock.ExpectQuery(queries.FindUserByEmail).WithArgs(user.Email).
+		WillReturnRows(mock.NewRows(userColumns).AddRow(user.ID, user.Name, user.Email, user.Role, user.CreatedAt))
+	mock.ExpectQuery(queries.FindUserByID).WithArgs(5).WillReturnError(sql.ErrNoRows)
+
+	repo := userRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	found, err := repo.FindByID(ctx, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, user, found)
+	assert.True(t, found.IsAdmin())
+
+	found, err = repo.FindByEmail(ctx, user.Email)
+	assert.NoError(t, err)
+	assert.Equal(t, user, found)
+
+	_, err = repo.FindByID(ctx, 5)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserIdentity(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	user := domain.User{ID: 4, Name: "Rizal", Email: "rizal@example.com", Role: domain.UserRoleMember, CreatedAt: 1700000000}
+	mock.ExpectExec(queries.InsertUserIdentity).WithArgs("https://sso.example.com", "00u1", 4, int64(1700000000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(queries.InsertUserIdentity).WithArgs("https://sso.example.com", "00u2", 4, int64(1700000000)).
+		WillReturnError(&mysql.MySQLError{Number: errDuplicateEntry, Message: "Duplicate entry '4-https://sso.example.com'"})
+	mock.ExpectQuery(queries.FindUserByIdentity).WithArgs("https://sso.example.com", "00u1").
+		WillReturnRows(mock.NewRows(userColumns).AddRow(user.ID, user.Name, user.Email, user.Role, user.CreatedAt))
+
+	repo := userRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, repo.LinkIdentity(ctx, 4, "https://sso.example.com", "00u1", 1700000000))
+	assert.ErrorIs(t, repo.LinkIdentity(ctx, 4, "https://sso.example.com", "00u2", 1700000000), domain.ErrIdentityTaken)
+
+	found, err := repo.FindByIdentity(ctx, "https://sso.example.com", "00u1")
+	assert.NoError(t, err)
+	assert.Equal(t, user, found)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
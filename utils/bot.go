@@ -0,0 +1,52 @@
+package utils
+
+import (
+	_ "embed"
+	"net/http"
+	"strings"
+)
+
+//go:embed bot_signatures.txt
+var botSignatureList string
+
+var botSignatures = parseBotSignatures(botSignatureList)
+
+func parseBotSignatures(list string) []string {
+	signatures := []string{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signatures = append(signatures, line)
+	}
+	return signatures
+}
+
+// Classify a request as made by a bot rather than a person following the link
+// Using the User-Agent signatures of bot_signatures.txt, and the hints left by prefetching clients:
+// HEAD requests, an empty User-Agent, and the Purpose, Sec-Purpose and X-Moz prefetch headers
+
+func IsBot(req *http.Request) bool {
+	if req.Method == http.MethodHead {
+		return true
+	}
+
+	for _, header := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		value := strings.ToLower(req.Header.Get(header))
+		if strings.Contains(value, "prefetch") || strings.Contains(value, "preview") {
+			return true
+		}
+	}
+
+	userAgent := strings.ToLower(req.UserAgent())
+	if strings.TrimSpace(userAgent) == "" {
+		return true
+	}
+	for _, signature := range botSignatures {
+		if strings.Contains(userAgent, signature) {
+			return true
+		}
+	}
+	return false
+}
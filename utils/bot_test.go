@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBot(t *testing.T) {
+	testCases := []struct {
+		name      string
+		method    string
+		userAgent string
+		headers   map[string]string
+		expect    bool
+	}{
+		{name: "chrome", method: "GET", userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0 Safari/537.36"},
+		{name: "iphone", method: "GET", userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"},
+		{name: "slack", method: "GET", userAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", expect: true},
+		{name: "twitter", method: "GET", userAgent: "Twitterbot/1.0", expect: true},
+		{name: "facebook", method: "GET", userAgent: "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", expect: true},
+		{name: "whatsapp", method: "GET", userAgent: "WhatsApp/2.23.20.0", expect: true},
+		{name: "curl", method: "GET", userAgent: "curl/8.4.0", expect: true},
+		{name: "empty user agent", method: "GET", expect: true},
+		{name: "head", method: "HEAD", userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0", expect: true},
+		{name: "chrome prefetch", method: "GET", userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/118.0",
+			headers: map[string]string{"Sec-Purpose": "prefetch;prerender"}, expect: true},
+		{name: "firefox prefetch", method: "GET", userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/119.0",
+			headers: map[string]string{"X-Moz": "prefetch"}, expect: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(testCase.method, "/ha51Fad", nil)
+			req.Header.Set("User-Agent", testCase.userAgent)
+			for key, value := range testCase.headers {
+				req.Header.Set(key, value)
+			}
+
+			assert.Equal(t, testCase.expect, IsBot(req))
+		})
+	}
+}
+
+func TestParseBotSignatures(t *testing.T) {
+	assert.Equal(t, []string{"bot", "curl/"}, parseBotSignatures("# comment\n\nBot\n  curl/  \n"))
+	assert.NotEmpty(t, botSignatures)
+}
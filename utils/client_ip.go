@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolve the ip address of the client behind a request
+// X-Forwarded-For is only honored when the direct peer is a trusted proxy, and is walked from the
+// right, skipping trusted hops, so a client can't spoof its address by sending the header itself
+
+func ClientIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	if !isTrusted(net.ParseIP(remote), trustedProxies) {
+		return remote
+	}
+
+	hops := []string{}
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+
+		client = ip.String()
+		if !isTrusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve the scheme the client used to reach the server, http or https
+// X-Forwarded-Proto is only honored when the direct peer is a trusted proxy
+
+func RequestScheme(req *http.Request, trustedProxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	if isTrusted(net.ParseIP(remote), trustedProxies) {
+		// the first value is the one the outermost proxy was reached with
+		proto := strings.ToLower(strings.TrimSpace(strings.Split(req.Header.Get("X-Forwarded-Proto"), ",")[0]))
+		if proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
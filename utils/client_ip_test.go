@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies := []*net.IPNet{proxies}
+
+	testCases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expect       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:4321", expect: "203.0.113.7"},
+		{name: "untrusted peer", remoteAddr: "203.0.113.7:4321", forwardedFor: []string{"198.51.100.1"}, expect: "203.0.113.7"},
+		{name: "trusted peer", remoteAddr: "10.0.0.2:4321", forwardedFor: []string{"198.51.100.1"}, expect: "198.51.100.1"},
+		{name: "spoofed hop", remoteAddr: "10.0.0.2:4321", forwardedFor: []string{"1.1.1.1, 198.51.100.1, 10.0.0.3"}, expect: "198.51.100.1"},
+		{name: "multiple headers", remoteAddr: "10.0.0.2:4321", forwardedFor: []string{"198.51.100.1", "10.0.0.3"}, expect: "198.51.100.1"},
+		{name: "invalid hop", remoteAddr: "10.0.0.2:4321", forwardedFor: []string{"198.51.100.1, garbage"}, expect: "10.0.0.2"},
+		{name: "only proxies", remoteAddr: "10.0.0.2:4321", forwardedFor: []string{"10.0.0.4, 10.0.0.3"}, expect: "10.0.0.4"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = testCase.remoteAddr
+			for _, value := range testCase.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			assert.Equal(t, testCase.expect, ClientIP(req, trustedProxies))
+		})
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies := []*net.IPNet{proxies}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	assert.Equal(t, "http", RequestScheme(req, trustedProxies))
+
+	req.Header.Set("X-Forwarded-Proto", "HTTPS, http")
+	assert.Equal(t, "https", RequestScheme(req, trustedProxies))
+
+	// the header of an untrusted peer is ignored
+	req.RemoteAddr = "203.0.113.7:4321"
+	assert.Equal(t, "http", RequestScheme(req, trustedProxies))
+
+	req = httptest.NewRequest("GET", "https://sho.rt/", nil)
+	assert.Equal(t, "https", RequestScheme(req, nil))
+}
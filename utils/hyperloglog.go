@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// Precision of the sketches, 2^12 registers for a standard error of about 1.6%
+const hyperLogLogPrecision = 12
+
+const hyperLogLogRegisters = 1 << hyperLogLogPrecision
+
+// Binary encodings of a sketch, the first byte of the marshaled data
+const (
+	hyperLogLogDense  byte = 1 // every register, one byte each
+	hyperLogLogSparse byte = 2 // (uint16 index, uint8 value) of the non zero registers
+)
+
+var ErrInvalidSketch = errors.New("invalid hyperloglog sketch")
+
+// Cardinality estimator of a stream of 64 bit hashes
+// Sketches of the same precision can be merged, the estimate of the merge is the one of the union
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, hyperLogLogRegisters)}
+}
+
+// Add one element, hash must be uniformly distributed, e.g. the first bytes of a sha256 sum
+
+func (h *HyperLogLog) Add(hash uint64) {
+	index := hash >> (64 - hyperLogLogPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hyperLogLogPrecision|1<<(hyperLogLogPrecision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, value := range other.registers {
+		if value > h.registers[i] {
+			h.registers[i] = value
+		}
+	}
+}
+
+// Estimate the number of distinct elements added, with the linear counting correction for small sets
+
+func (h *HyperLogLog) Count() int {
+	m := float64(hyperLogLogRegisters)
+	sum, zeros := 0.0, 0
+	for _, value := range h.registers {
+		sum += math.Pow(2, -float64(value))
+		if value == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// Encode the sketch in whichever of the sparse and dense encodings is smaller
+
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	nonZero := 0
+	for _, value := range h.registers {
+		if value != 0 {
+			nonZero++
+		}
+	}
+
+	if 3*nonZero >= len(h.registers) {
+		return append([]byte{hyperLogLogDense}, h.registers...), nil
+	}
+
+	data := make([]byte, 1, 1+3*nonZero)
+	data[0] = hyperLogLogSparse
+	for i, value := range h.registers {
+		if value != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, value)
+		}
+	}
+	return data, nil
+}
+
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrInvalidSketch
+	}
+
+	registers := make([]uint8, hyperLogLogRegisters)
+	switch data[0] {
+	case hyperLogLogDense:
+		if len(data) != 1+hyperLogLogRegisters {
+			return ErrInvalidSketch
+		}
+		copy(registers, data[1:])
+	case hyperLogLogSparse:
+		if (len(data)-1)%3 != 0 {
+			return ErrInvalidSketch
+		}
+		for i := 1; i < len(data); i += 3 {
+			index := binary.BigEndian.Uint16(data[i:])
+			if int(index) >= hyperLogLogRegisters {
+				return ErrInvalidSketch
+			}
+			registers[index] = data[i+2]
+		}
+	default:
+		return ErrInvalidSketch
+	}
+
+	h.registers = registers
+	return nil
+}
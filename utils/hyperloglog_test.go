@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHash(value string) uint64 {
+	sum := sha256.Sum256([]byte(value))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func TestHyperLogLogCount(t *testing.T) {
+	for _, distinct := range []int{0, 10, 1000, 50000} {
+		sketch := NewHyperLogLog()
+		for i := 0; i < distinct; i++ {
+			// every element is added twice, duplicates must not count
+			sketch.Add(testHash(fmt.Sprintf("visitor-%d", i)))
+			sketch.Add(testHash(fmt.Sprintf("visitor-%d", i)))
+		}
+
+		assert.InDelta(t, distinct, sketch.Count(), float64(distinct)*0.05+1, "distinct %d", distinct)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	monday, tuesday := NewHyperLogLog(), NewHyperLogLog()
+	for i := 0; i < 3000; i++ {
+		monday.Add(testHash(fmt.Sprintf("visitor-%d", i)))
+	}
+	for i := 2000; i < 5000; i++ {
+		tuesday.Add(testHash(fmt.Sprintf("visitor-%d", i)))
+	}
+
+	monday.Merge(tuesday)
+	assert.InDelta(t, 5000, monday.Count(), 250)
+}
+
+func TestHyperLogLogBinary(t *testing.T) {
+	for _, distinct := range []int{5, 20000} {
+		sketch := NewHyperLogLog()
+		for i := 0; i < distinct; i++ {
+			sketch.Add(testHash(fmt.Sprintf("visitor-%d", i)))
+		}
+
+		data, err := sketch.MarshalBinary()
+		assert.NoError(t, err)
+		if distinct == 5 {
+			assert.Len(t, data, 1+3*5)
+		}
+
+		decoded := &HyperLogLog{}
+		assert.NoError(t, decoded.UnmarshalBinary(data))
+		assert.Equal(t, sketch.Count(), decoded.Count())
+	}
+
+	for _, invalid := range [][]byte{nil, {9}, {hyperLogLogDense, 1, 2}, {hyperLogLogSparse, 0xff, 0xff, 1}} {
+		assert.ErrorIs(t, (&HyperLogLog{}).UnmarshalBinary(invalid), ErrInvalidSketch)
+	}
+}
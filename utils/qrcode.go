@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Image formats a QR code is rendered in
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+)
+
+// Error correction levels, the share of the symbol that can be damaged and still scan
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,     // 7%
+	"M": qrcode.Medium,  // 15%
+	"Q": qrcode.High,    // 25%
+	"H": qrcode.Highest, // 30%
+}
+
+var ErrInvalidColor = errors.New("color must be a hex rgb value, e.g. #000 or #1a2b3c")
+
+type QROptions struct {
+	Format     string // one of QRFormat*
+	Size       int    // width and height of the image in pixels
+	Level      string // error correction level, one of L, M, Q or H
+	Margin     int    // quiet zone around the symbol, in modules
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// Tell whether level is one of the supported error correction levels
+
+func IsQRLevel(level string) bool {
+	_, ok := qrLevels[level]
+	return ok
+}
+
+// Parse a #rgb or #rrggbb color, the leading # is optional
+
+func ParseHexColor(value string) (color.RGBA, error) {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	if len(value) != 6 {
+		return color.RGBA{}, ErrInvalidColor
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return color.RGBA{}, ErrInvalidColor
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// Render content as a QR code image
+// Receiving content (string) and options (QROptions) as parameter
+// Returning the encoded png or svg document ([]byte) if success, and error if content doesn't fit in a QR code
+
+func RenderQRCode(content string, options QROptions) ([]byte, error) {
+	level, ok := qrLevels[options.Level]
+	if !ok {
+		return nil, fmt.Errorf("unknown error correction level %q", options.Level)
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	modules := code.Bitmap()
+
+	switch options.Format {
+	case QRFormatSVG:
+		return renderQRSVG(modules, options), nil
+	case QRFormatPNG:
+		return renderQRPNG(modules, options)
+	default:
+		return nil, fmt.Errorf("unknown qr code format %q", options.Format)
+	}
+}
+
+// Draw the modules scaled by a whole number of pixels, so every module has the same size,
+// centered on an image of the requested size
+// The image grows past the requested size when it is too small for one pixel per module
+
+func renderQRPNG(modules [][]bool, options QROptions) ([]byte, error) {
+	total := len(modules) + 2*options.Margin
+	size := options.Size
+	if size < total {
+		size = total
+	}
+	scale := size / total
+	offset := (size-total*scale)/2 + options.Margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{options.Background, options.Foreground})
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	buffer := bytes.Buffer{}
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	err := encoder.Encode(&buffer, img)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Draw the modules as one path in a viewBox of one unit per module, horizontal runs of dark
+// modules are merged into a single rectangle
+
+func renderQRSVG(modules [][]bool, options QROptions) []byte {
+	total := len(modules) + 2*options.Margin
+
+	path := strings.Builder{}
+	for y, row := range modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x+options.Margin, y+options.Margin, run, run)
+			x += run
+		}
+	}
+
+	svg := bytes.Buffer{}
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		options.Size, options.Size, total, total)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="%s"/>`, total, total, hexColor(options.Background))
+	fmt.Fprintf(&svg, `<path d="%s" fill="%s"/></svg>`, path.String(), hexColor(options.Foreground))
+	return svg.Bytes()
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
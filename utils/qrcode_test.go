@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	black = color.RGBA{A: 0xff}
+	white = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+func TestRenderQRCodePNG(t *testing.T) {
+	content := "https://sho.rt/api/v1/url/ha51Fad"
+	data, err := RenderQRCode(content, QROptions{Format: QRFormatPNG, Size: 300, Level: "M", Margin: 4, Foreground: black, Background: white})
+	assert.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	assert.Equal(t, 300, img.Bounds().Dy())
+
+	// every module is drawn with the same whole number of pixels, centered on the image
+	code, _ := qrcode.New(content, qrcode.Medium)
+	code.DisableBorder = true
+	modules := code.Bitmap()
+	total := len(modules) + 8
+	scale := 300 / total
+	offset := (300-total*scale)/2 + 4*scale
+	for y, row := range modules {
+		for x, dark := range row {
+			expect := white
+			if dark {
+				expect = black
+			}
+			r, g, b, _ := img.At(offset+x*scale+scale/2, offset+y*scale+scale/2).RGBA()
+			assert.Equal(t, expect, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff})
+		}
+	}
+
+	// the quiet zone keeps the background color
+	r, g, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, white, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff})
+}
+
+func TestRenderQRCodePNGTooSmall(t *testing.T) {
+	data, err := RenderQRCode("https://sho.rt/x", QROptions{Format: QRFormatPNG, Size: 10, Level: "L", Margin: 1, Foreground: black, Background: white})
+	assert.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 21+2, img.Bounds().Dx())
+}
+
+func TestRenderQRCodeSVG(t *testing.T) {
+	red := color.RGBA{R: 0xcc, A: 0xff}
+	data, err := RenderQRCode("https://sho.rt/x", QROptions{Format: QRFormatSVG, Size: 256, Level: "L", Margin: 2, Foreground: red, Background: white})
+	assert.NoError(t, err)
+
+	svg := string(data)
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256" viewBox="0 0 25 25"`))
+	assert.Contains(t, svg, `fill="#ffffff"`)
+	assert.Contains(t, svg, `fill="#cc0000"`)
+	// the top row starts with the 7 modules of the finder pattern
+	assert.Contains(t, svg, `<path d="M2 2h7v1h-7z`)
+}
+
+func TestRenderQRCodeInvalid(t *testing.T) {
+	_, err := RenderQRCode("x", QROptions{Format: QRFormatPNG, Level: "X"})
+	assert.Error(t, err)
+
+	_, err = RenderQRCode("x", QROptions{Format: "gif", Level: "M"})
+	assert.Error(t, err)
+
+	_, err = RenderQRCode(strings.Repeat("x", 8000), QROptions{Format: QRFormatPNG, Level: "H"})
+	assert.Error(t, err)
+}
+
+func TestParseHexColor(t *testing.T) {
+	testCases := map[string]color.RGBA{
+		"#000":    black,
+		"fff":     white,
+		"#1A2b3c": {R: 0x1a, G: 0x2b, B: 0x3c, A: 0xff},
+	}
+	for value, expect := range testCases {
+		c, err := ParseHexColor(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expect, c)
+	}
+
+	for _, value := range []string{"", "#12", "#12345g", "red"} {
+		_, err := ParseHexColor(value)
+		assert.ErrorIs(t, err, ErrInvalidColor)
+	}
+	assert.True(t, IsQRLevel("Q"))
+	assert.False(t, IsQRLevel("q"))
+}
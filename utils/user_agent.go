@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type UserAgent struct {
+	OS      string
+	Device  string
+	Browser string
+}
+
+// Classify a User-Agent header into os, device class and browser family
+// Unknown values are reported as domain.OsOther and domain.BrowserOther, the device defaults to desktop
+
+func ParseUserAgent(userAgent string) UserAgent {
+	result := UserAgent{
+		OS:      domain.OsOther,
+		Device:  domain.DeviceDesktop,
+		Browser: domain.BrowserOther,
+	}
+
+	switch {
+	case strings.Contains(userAgent, "iPad"):
+		result.OS, result.Device = domain.OsIOS, domain.DeviceTablet
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPod"):
+		result.OS, result.Device = domain.OsIOS, domain.DeviceMobile
+	case strings.Contains(userAgent, "Android"):
+		result.OS, result.Device = domain.OsAndroid, domain.DeviceTablet
+		if strings.Contains(userAgent, "Mobile") {
+			result.Device = domain.DeviceMobile
+		}
+	case strings.Contains(userAgent, "Windows Phone"):
+		result.OS, result.Device = domain.OsWindows, domain.DeviceMobile
+	case strings.Contains(userAgent, "Windows"):
+		result.OS = domain.OsWindows
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		result.OS = domain.OsMacOS
+	case strings.Contains(userAgent, "Linux"), strings.Contains(userAgent, "X11"):
+		result.OS = domain.OsLinux
+	}
+
+	if result.Device == domain.DeviceDesktop && strings.Contains(userAgent, "Mobi") {
+		result.Device = domain.DeviceMobile
+	}
+
+	switch {
+	case strings.Contains(userAgent, "Edg/"), strings.Contains(userAgent, "EdgA/"), strings.Contains(userAgent, "EdgiOS/"):
+		result.Browser = domain.BrowserEdge
+	case strings.Contains(userAgent, "OPR/"), strings.Contains(userAgent, "Opera"):
+		result.Browser = domain.BrowserOpera
+	case strings.Contains(userAgent, "SamsungBrowser/"):
+		result.Browser = domain.BrowserSamsung
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		result.Browser = domain.BrowserChrome
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		result.Browser = domain.BrowserFirefox
+	case strings.Contains(userAgent, "Safari/"):
+		result.Browser = domain.BrowserSafari
+	}
+
+	return result
+}
+
+// Parse an Accept-Language header into lowercased language tags, by descending quality
+// Tags with q=0 and the * wildcard are left out
+
+func ParseAcceptLanguage(acceptLanguage string) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	languages := []language{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		languages = append(languages, language{tag, quality})
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	result := make([]string, len(languages))
+	for i, language := range languages {
+		result[i] = language.tag
+	}
+	return result
+}
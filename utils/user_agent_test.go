@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	testCases := []struct {
+		userAgent string
+		expect    UserAgent
+	}{
+		{
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			expect:    UserAgent{domain.OsIOS, domain.DeviceMobile, domain.BrowserSafari},
+		},
+		{
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/118.0 Mobile/15E148 Safari/604.1",
+			expect:    UserAgent{domain.OsIOS, domain.DeviceTablet, domain.BrowserChrome},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0 Mobile Safari/537.36",
+			expect:    UserAgent{domain.OsAndroid, domain.DeviceMobile, domain.BrowserChrome},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/22.0 Chrome/111.0 Safari/537.36",
+			expect:    UserAgent{domain.OsAndroid, domain.DeviceTablet, domain.BrowserSamsung},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0 Safari/537.36 Edg/118.0",
+			expect:    UserAgent{domain.OsWindows, domain.DeviceDesktop, domain.BrowserEdge},
+		},
+		{
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:119.0) Gecko/20100101 Firefox/119.0",
+			expect:    UserAgent{domain.OsMacOS, domain.DeviceDesktop, domain.BrowserFirefox},
+		},
+		{
+			userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/119.0",
+			expect:    UserAgent{domain.OsLinux, domain.DeviceDesktop, domain.BrowserFirefox},
+		},
+		{
+			userAgent: "curl/8.4.0",
+			expect:    UserAgent{domain.OsOther, domain.DeviceDesktop, domain.BrowserOther},
+		},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expect, ParseUserAgent(testCase.userAgent), testCase.userAgent)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, []string{"fr-ch", "fr", "en", "de"},
+		ParseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5"))
+	assert.Equal(t, []string{"id", "en-us"}, ParseAcceptLanguage("en-US;q=0.5, id, ja;q=0"))
+	assert.Empty(t, ParseAcceptLanguage(""))
+}
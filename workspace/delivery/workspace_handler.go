@@ -0,0 +1,241 @@
+package delivery
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/utils"
+)
+
+type WorkspaceHandler struct {
+	workspaceUsecase domain.WorkspaceUsecase
+}
+
+// Every route needs a valid token, the role of the caller in the workspace is checked by the usecase
+func NewWorkspaceHandler(workspaceUsecase domain.WorkspaceUsecase, m *mux.Router, authMiddleware *auth.Middleware) {
+	handler := WorkspaceHandler{workspaceUsecase}
+	router_v1 := m.PathPrefix("/api/v1/workspaces").Subrouter()
+
+	authMiddleware.Require(router_v1.Path("/").HandlerFunc(handler.getAllWorkspaces).Methods("GET"), domain.ScopeLinksRead)
+	authMiddleware.Require(router_v1.Path("/create").HandlerFunc(handler.createWorkspace).Methods("POST"), domain.ScopeLinksWrite)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}").HandlerFunc(handler.getWorkspace).Methods("GET"), domain.ScopeLinksRead)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}").HandlerFunc(handler.deleteWorkspace).Methods("DELETE"), domain.ScopeLinksWrite)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/members").HandlerFunc(handler.getMembers).Methods("GET"), domain.ScopeLinksRead)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/members").HandlerFunc(handler.setMember).Methods("PUT"), domain.ScopeLinksWrite)
+	authMiddleware.Require(router_v1.Path("/{id:[0-9]+}/members/{user_id:[0-9]+}").HandlerFunc(handler.removeMember).Methods("DELETE"), domain.ScopeLinksWrite)
+	router_v1.Use(authMiddleware.Handler)
+}
+
+func (h *WorkspaceHandler) createWorkspace(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	requestBody := domain.CreateWorkspaceRequest{}
+	err := json.NewDecoder(req.Body).Decode(&requestBody)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"error while parsing json"},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	workspace, err := h.workspaceUsecase.CreateWorkspace(req.Context(), requestBody)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusCreated,
+		Status: "Success Created",
+		Data:   workspace,
+	})
+}
+
+func (h *WorkspaceHandler) getAllWorkspaces(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaces, err := h.workspaceUsecase.FindAllWorkspaces(req.Context())
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   workspaces,
+	})
+}
+
+func (h *WorkspaceHandler) getWorkspace(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaceId, ok := idVar(res, req, "id")
+	if !ok {
+		return
+	}
+
+	workspace, err := h.workspaceUsecase.GetWorkspace(req.Context(), workspaceId)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   workspace,
+	})
+}
+
+func (h *WorkspaceHandler) deleteWorkspace(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaceId, ok := idVar(res, req, "id")
+	if !ok {
+		return
+	}
+
+	err := h.workspaceUsecase.DeleteWorkspace(req.Context(), workspaceId)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   map[string]int{"id": workspaceId},
+	})
+}
+
+func (h *WorkspaceHandler) getMembers(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaceId, ok := idVar(res, req, "id")
+	if !ok {
+		return
+	}
+
+	members, err := h.workspaceUsecase.FindMembers(req.Context(), workspaceId)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   members,
+	})
+}
+
+func (h *WorkspaceHandler) setMember(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaceId, ok := idVar(res, req, "id")
+	if !ok {
+		return
+	}
+
+	requestBody := domain.SetWorkspaceMemberRequest{}
+	err := json.NewDecoder(req.Body).Decode(&requestBody)
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{"error while parsing json"},
+		})
+		return
+	}
+	defer req.Body.Close()
+
+	member, err := h.workspaceUsecase.SetMember(req.Context(), workspaceId, requestBody)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   member,
+	})
+}
+
+func (h *WorkspaceHandler) removeMember(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+
+	workspaceId, ok := idVar(res, req, "id")
+	if !ok {
+		return
+	}
+	userId, ok := idVar(res, req, "user_id")
+	if !ok {
+		return
+	}
+
+	err := h.workspaceUsecase.RemoveMember(req.Context(), workspaceId, userId)
+	if err != nil {
+		utils.FormatResponse(res, errorParams(err))
+		return
+	}
+
+	utils.FormatResponse(res, &utils.ResponseSuccessParams{
+		Code:   http.StatusOK,
+		Status: "Success",
+		Data:   map[string]int{"workspace_id": workspaceId, "user_id": userId},
+	})
+}
+
+// Parse an id of the request path, writing a bad request response when it isn't valid
+
+func idVar(res http.ResponseWriter, req *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(req)[name])
+	if err != nil {
+		utils.FormatResponse(res, &utils.ResponseErrorParams{
+			Code:   http.StatusBadRequest,
+			Status: "Bad request",
+			Errors: []string{strings.ReplaceAll(name, "_", " ") + " isn't valid"},
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func errorParams(err error) *utils.ResponseErrorParams {
+	errorParams := utils.ResponseErrorParams{
+		Code:   http.StatusBadGateway,
+		Status: "Bad gateway",
+		Errors: []string{err.Error()},
+	}
+
+	if strings.Contains(strings.ToLower(err.Error()), "validation") {
+		errorParams.Code = http.StatusBadRequest
+		errorParams.Status = "Bad request"
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		errorParams.Code = http.StatusNotFound
+		errorParams.Status = "Not found"
+		errorParams.Errors = []string{"workspace or member not found"}
+	}
+	if errors.Is(err, domain.ErrUnauthenticated) {
+		errorParams.Code = http.StatusUnauthorized
+		errorParams.Status = "Unauthorized"
+	}
+	if errors.Is(err, domain.ErrForbidden) {
+		errorParams.Code = http.StatusForbidden
+		errorParams.Status = "Forbidden"
+	}
+	return &errorParams
+}
@@ -0,0 +1,106 @@
+package delivery
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mrizalr/urlshortener/auth"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateWorkspaceHandler(t *testing.T) {
+	mockUsecase := new(mocks.WorkspaceUsecase)
+	request := domain.CreateWorkspaceRequest{Name: "Sales", Slug: "sales", SlugNamespace: "workspace"}
+	mockUsecase.On("CreateWorkspace", mock.Anything, request).
+		Return(domain.Workspace{ID: 4, Name: "Sales", Slug: "sales", SlugNamespace: "workspace", CreatedAt: 1700000000, Role: "owner"}, nil)
+
+	req := httptest.NewRequest("POST", "/api/v1/workspaces/create", bytes.NewBufferString(`{"name":"Sales","slug":"sales","slug_namespace":"workspace"}`))
+	res := httptest.NewRecorder()
+
+	handler := WorkspaceHandler{mockUsecase}
+	handler.createWorkspace(res, req)
+
+	expect := `
+	{
+		"status_code":201,
+		"status":"Success Created",
+		"data":{
+			"id":4,
+			"name":"Sales",
+			"slug":"sales",
+			"slug_namespace":"workspace",
+			"created_at":1700000000,
+			"role":"owner"
+		}
+	}`
+
+	mockUsecase.AssertExpectations(t)
+	assert.Equal(t, http.StatusCreated, res.Code)
+	assert.JSONEq(t, expect, res.Body.String())
+}
+
+func TestWorkspaceMembersHandler(t *testing.T) {
+	mockUsecase := new(mocks.WorkspaceUsecase)
+	request := domain.SetWorkspaceMemberRequest{Email: "jane@example.com", Role: "editor"}
+	mockUsecase.On("SetMember", mock.Anything, 4, request).
+		Return(domain.WorkspaceMember{WorkspaceID: 4, UserID: 6, Email: "jane@example.com", Role: "editor"}, nil)
+	mockUsecase.On("SetMember", mock.Anything, 5, request).
+		Return(domain.WorkspaceMember{}, fmt.Errorf("%w: the owner role is required", domain.ErrForbidden))
+	mockUsecase.On("RemoveMember", mock.Anything, 4, 7).Return(sql.ErrNoRows)
+
+	handler := WorkspaceHandler{mockUsecase}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/api/v1/workspaces/4/members", bytes.NewBufferString(`{"email":"jane@example.com","role":"editor"}`))
+	handler.setMember(res, mux.SetURLVars(req, map[string]string{"id": "4"}))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", "/api/v1/workspaces/5/members", bytes.NewBufferString(`{"email":"jane@example.com","role":"editor"}`))
+	handler.setMember(res, mux.SetURLVars(req, map[string]string{"id": "5"}))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/api/v1/workspaces/4/members/7", nil)
+	handler.removeMember(res, mux.SetURLVars(req, map[string]string{"id": "4", "user_id": "7"}))
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
+
+func TestWorkspaceRoutesAuthenticated(t *testing.T) {
+	mockUsecase := new(mocks.WorkspaceUsecase)
+	mockUsecase.On("FindAllWorkspaces", mock.Anything).Return([]domain.Workspace{}, nil)
+
+	authenticator := new(mocks.Authenticator)
+	authenticator.On("Authenticate", mock.Anything, "reader").
+		Return(domain.User{ID: 3, Role: domain.UserRoleMember}, []string{domain.ScopeLinksRead}, nil)
+
+	router := mux.NewRouter()
+	NewWorkspaceHandler(mockUsecase, router, auth.NewMiddleware(authenticator))
+
+	req := httptest.NewRequest("GET", "/api/v1/workspaces/", nil)
+	req.Header.Set("Authorization", "Bearer reader")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// managing workspaces needs the links:write scope
+	req = httptest.NewRequest("POST", "/api/v1/workspaces/create", bytes.NewBufferString(`{"name":"Sales","slug":"sales"}`))
+	req.Header.Set("Authorization", "Bearer reader")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/api/v1/workspaces/", nil))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	mockUsecase.AssertExpectations(t)
+}
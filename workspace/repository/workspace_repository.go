@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+// MySQL error number of a unique key violation
+const errDuplicateEntry = 1062
+
+type workspaceRepository struct {
+	db *sql.DB
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func NewWorkspaceRepository(db *sql.DB) domain.WorkspaceRepository {
+	return &workspaceRepository{db}
+}
+
+// Inserting new workspace data to workspaces table, with its owner to workspace_members table
+// Receiving context, workspace (domain.Workspace), and ownerID (int) as parameter
+// Returning inserted workspace_id (int) if success, and domain.ErrWorkspaceSlugTaken or error if failed
+
+func (r *workspaceRepository) Create(ctx context.Context, workspace domain.Workspace, ownerID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	sqlRes, err := tx.ExecContext(ctx, queries.InsertWorkspace, workspace.Name, workspace.Slug, workspace.SlugNamespace, workspace.CreatedAt)
+	mysqlErr := &mysql.MySQLError{}
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+		return 0, domain.ErrWorkspaceSlugTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	lastInsertID, err := sqlRes.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, queries.UpsertWorkspaceMember, lastInsertID, ownerID, domain.WorkspaceRoleOwner, workspace.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(lastInsertID), nil
+}
+
+// Fetch one workspace data from workspaces table
+// Receiving context, and id (int) as parameter
+// Returning workspace data (domain.Workspace) if success, and error if failed
+
+func (r *workspaceRepository) FindByID(ctx context.Context, id int) (domain.Workspace, error) {
+	workspace := domain.Workspace{}
+	err := r.db.QueryRowContext(ctx, queries.FindWorkspaceByID, id).
+		Scan(&workspace.ID, &workspace.Name, &workspace.Slug, &workspace.SlugNamespace, &workspace.CreatedAt)
+	return workspace, err
+}
+
+// Fetch the workspaces one user is a member of, with the role of the user in each
+// Receiving context, and userID (int) as parameter
+// Returning workspace data ([]domain.Workspace) if success, and error if failed
+
+func (r *workspaceRepository) FindByUserID(ctx context.Context, userID int) ([]domain.Workspace, error) {
+	workspaces := []domain.Workspace{}
+	rows, err := r.db.QueryContext(ctx, queries.FindWorkspacesByUserID, userID)
+	if err != nil {
+		return workspaces, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		workspace := domain.Workspace{}
+		err = rows.Scan(&workspace.ID, &workspace.Name, &workspace.Slug, &workspace.SlugNamespace, &workspace.CreatedAt, &workspace.Role)
+		if err != nil {
+			return workspaces, err
+		}
+
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// Delete one workspace data from workspaces table
+// Receiving context, and id (int) as parameter
+// Returning affected rows (int) if success, and error if failed
+
+func (r *workspaceRepository) DeleteByID(ctx context.Context, id int) (int, error) {
+	sqlRes, err := r.db.ExecContext(ctx, queries.DeleteWorkspaceByID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := sqlRes.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
+
+func scanMember(row scanner) (domain.WorkspaceMember, error) {
+	member := domain.WorkspaceMember{}
+	err := row.Scan(&member.WorkspaceID, &member.UserID, &member.Name, &member.Email, &member.Role, &member.CreatedAt)
+	return member, err
+}
+
+// Fetch one member of a workspace from workspace_members table
+// Receiving context, workspaceID (int), and userID (int) as parameter
+// Returning member data (domain.WorkspaceMember) if success, and error if failed
+
+func (r *workspaceRepository) FindMember(ctx context.Context, workspaceID, userID int) (domain.WorkspaceMember, error) {
+	return scanMember(r.db.QueryRowContext(ctx, queries.FindWorkspaceMember, workspaceID, userID))
+}
+
+// Fetch the members of a workspace from workspace_members table
+// Receiving context, and workspaceID (int) as parameter
+// Returning member data ([]domain.WorkspaceMember) if success, and error if failed
+
+func (r *workspaceRepository) FindMembers(ctx context.Context, workspaceID int) ([]domain.WorkspaceMember, error) {
+	members := []domain.WorkspaceMember{}
+	rows, err := r.db.QueryContext(ctx, queries.FindWorkspaceMembers, workspaceID)
+	if err != nil {
+		return members, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return members, err
+		}
+
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// Add a member to a workspace in workspace_members table, or change the role of an existing member
+// Receiving context, and member (domain.WorkspaceMember) as parameter
+// Returning domain.ErrLastWorkspaceOwner when it would leave the workspace without an owner, and error if failed
+
+func (r *workspaceRepository) SetMember(ctx context.Context, member domain.WorkspaceMember) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if member.Role != domain.WorkspaceRoleOwner {
+		err = keepAnOwner(ctx, tx, member.WorkspaceID, member.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, queries.UpsertWorkspaceMember, member.WorkspaceID, member.UserID, member.Role, member.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Remove a member from a workspace in workspace_members table
+// Receiving context, workspaceID (int), and userID (int) as parameter
+// Returning affected rows (int), 0 when the user isn't a member, domain.ErrLastWorkspaceOwner when it would leave
+// the workspace without an owner, and error if failed
+
+func (r *workspaceRepository) DeleteMember(ctx context.Context, workspaceID, userID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = keepAnOwner(ctx, tx, workspaceID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlRes, err := tx.ExecContext(ctx, queries.DeleteWorkspaceMember, workspaceID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := sqlRes.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), tx.Commit()
+}
+
+// Refuse to take the owner role away from userID when no other member holds it
+// The owners stay locked until tx ends, so concurrent changes can't each leave the other one as the last owner
+
+func keepAnOwner(ctx context.Context, tx *sql.Tx, workspaceID, userID int) error {
+	rows, err := tx.QueryContext(ctx, queries.FindWorkspaceOwnersForUpdate, workspaceID, domain.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	isOwner, otherOwners := false, 0
+	for rows.Next() {
+		var ownerID int
+		err = rows.Scan(&ownerID)
+		if err != nil {
+			return err
+		}
+
+		if ownerID == userID {
+			isOwner = true
+		} else {
+			otherOwners++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if isOwner && otherOwners == 0 {
+		return domain.ErrLastWorkspaceOwner
+	}
+	return nil
+}
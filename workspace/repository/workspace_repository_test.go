@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mrizalr/urlshortener/db/queries"
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var memberColumns = []string{"workspace_id", "user_id", "name", "email", "role", "created_at"}
+
+func NewMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		panic(err)
+	}
+	return db, mock
+}
+
+func TestCreateWorkspace(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	workspace := domain.Workspace{Name: "Sales", Slug: "sales", SlugNamespace: domain.SlugNamespaceWorkspace, CreatedAt: time.Now().Unix()}
+	mock.ExpectBegin()
+	mock.ExpectExec(queries.InsertWorkspace).WithArgs(workspace.Name, workspace.Slug, workspace.SlugNamespace, workspace.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	mock.ExpectExec(queries.UpsertWorkspaceMember).WithArgs(4, 3, domain.WorkspaceRoleOwner, workspace.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := workspaceRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	id, err := repo.Create(ctx, workspace, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, id)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateWorkspaceSlugTaken(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	workspace := domain.Workspace{Name: "Sales", Slug: "sales", SlugNamespace: domain.SlugNamespaceShared}
+	mock.ExpectBegin()
+	mock.ExpectExec(queries.InsertWorkspace).WithArgs(workspace.Name, workspace.Slug, workspace.SlugNamespace, workspace.CreatedAt).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'sales' for key 'slug'"})
+	mock.ExpectRollback()
+
+	repo := workspaceRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := repo.Create(ctx, workspace, 3)
+	assert.ErrorIs(t, err, domain.ErrWorkspaceSlugTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindWorkspaces(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	mock.ExpectQuery(queries.FindWorkspaceByID).WithArgs(4).
+		WillReturnRows(mock.NewRows([]string{"id", "name", "slug", "slug_namespace", "created_at"}).
+			AddRow(4, "Sales", "sales", "workspace", 1700000000))
+	mock.ExpectQuery(queries.FindWorkspacesByUserID).WithArgs(3).
+		WillReturnRows(mock.NewRows([]string{"id", "name", "slug", "slug_namespace", "created_at", "role"}).
+			AddRow(4, "Sales", "sales", "workspace", 1700000000, "owner").
+			AddRow(6, "Marketing", "marketing", "shared", 1700003600, "viewer"))
+
+	repo := workspaceRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	workspace, err := repo.FindByID(ctx, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Workspace{ID: 4, Name: "Sales", Slug: "sales", SlugNamespace: "workspace", CreatedAt: 1700000000}, workspace)
+
+	workspaces, err := repo.FindByUserID(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, workspaces, 2)
+	assert.Equal(t, domain.WorkspaceRoleViewer, workspaces[1].Role)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWorkspaceMembers(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	member := domain.WorkspaceMember{WorkspaceID: 4, UserID: 5, Role: domain.WorkspaceRoleEditor, CreatedAt: 1700000000}
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindWorkspaceOwnersForUpdate).WithArgs(4, domain.WorkspaceRoleOwner).
+		WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec(queries.UpsertWorkspaceMember).WithArgs(4, 5, domain.WorkspaceRoleEditor, member.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(queries.FindWorkspaceMember).WithArgs(4, 5).
+		WillReturnRows(mock.NewRows(memberColumns).AddRow(4, 5, "Jane", "jane@example.com", "editor", 1700000000))
+	mock.ExpectQuery(queries.FindWorkspaceMember).WithArgs(4, 6).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(queries.FindWorkspaceMembers).WithArgs(4).
+		WillReturnRows(mock.NewRows(memberColumns).
+			AddRow(4, 3, "Rizal", "rizal@example.com", "owner", 1690000000).
+			AddRow(4, 5, "Jane", "jane@example.com", "editor", 1700000000))
+	mock.ExpectBegin()
+	mock.ExpectQuery(queries.FindWorkspaceOwnersForUpdate).WithArgs(4, domain.WorkspaceRoleOwner).
+		WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec(queries.DeleteWorkspaceMember).WithArgs(4, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := workspaceRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := repo.SetMember(ctx, member)
+	assert.NoError(t, err)
+
+	found, err := repo.FindMember(ctx, 4, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.com", found.Email)
+	assert.Equal(t, domain.WorkspaceRoleEditor, found.Role)
+
+	_, err = repo.FindMember(ctx, 4, 6)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	members, err := repo.FindMembers(ctx, 4)
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+
+	affected, err := repo.DeleteMember(ctx, 4, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, affected)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWorkspaceLastOwner(t *testing.T) {
+	db, mock := NewMock()
+	defer db.Close()
+
+	// the owners are checked, and locked, in the transaction making the change
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery(queries.FindWorkspaceOwnersForUpdate).WithArgs(4, domain.WorkspaceRoleOwner).
+			WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow(3))
+		mock.ExpectRollback()
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(queries.UpsertWorkspaceMember).WithArgs(4, 3, domain.WorkspaceRoleOwner, int64(1690000000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := workspaceRepository{db}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := repo.SetMember(ctx, domain.WorkspaceMember{WorkspaceID: 4, UserID: 3, Role: domain.WorkspaceRoleEditor, CreatedAt: 1690000000})
+	assert.ErrorIs(t, err, domain.ErrLastWorkspaceOwner)
+
+	_, err = repo.DeleteMember(ctx, 4, 3)
+	assert.ErrorIs(t, err, domain.ErrLastWorkspaceOwner)
+
+	// keeping the owner role needs no check
+	err = repo.SetMember(ctx, domain.WorkspaceMember{WorkspaceID: 4, UserID: 3, Role: domain.WorkspaceRoleOwner, CreatedAt: 1690000000})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,224 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mrizalr/urlshortener/domain"
+)
+
+type workspaceConfig struct {
+	NameMaxLength int
+}
+
+var _config workspaceConfig = workspaceConfig{
+	NameMaxLength: 255,
+}
+
+// Lowercase letters, digits and dashes, with at least one letter so a namespaced slug never looks like a url id
+var workspaceSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+// Slugs taken by the api paths a namespaced short url shares its first segment with
+var reservedSlugs = map[string]bool{
+	"by-slug": true,
+}
+
+var slugNamespaces = map[string]bool{
+	domain.SlugNamespaceShared:    true,
+	domain.SlugNamespaceWorkspace: true,
+}
+
+var workspaceRoles = map[string]bool{
+	domain.WorkspaceRoleViewer: true,
+	domain.WorkspaceRoleEditor: true,
+	domain.WorkspaceRoleOwner:  true,
+}
+
+type workspaceUsecase struct {
+	workspaceRepository domain.WorkspaceRepository
+	userRepository      domain.UserRepository
+}
+
+func NewWorkspaceUsecase(workspaceRepository domain.WorkspaceRepository, userRepository domain.UserRepository) domain.WorkspaceUsecase {
+	return &workspaceUsecase{workspaceRepository, userRepository}
+}
+
+// Check the caller holds at least the required role in a workspace, admins hold the owner role in every workspace
+// Returning the role of the caller, sql.ErrNoRows when the workspace doesn't exist or the caller isn't a member,
+// and ErrForbidden when its role is too low
+
+func (u *workspaceUsecase) authorize(ctx context.Context, workspaceID int, required string) (domain.User, string, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return user, "", domain.ErrUnauthenticated
+	}
+
+	if user.IsAdmin() {
+		_, err := u.workspaceRepository.FindByID(ctx, workspaceID)
+		return user, domain.WorkspaceRoleOwner, err
+	}
+
+	member, err := u.workspaceRepository.FindMember(ctx, workspaceID, user.ID)
+	if err != nil {
+		return user, "", err
+	}
+	if !domain.WorkspaceRoleAllows(member.Role, required) {
+		return user, member.Role, fmt.Errorf("%w: the %s role is required", domain.ErrForbidden, required)
+	}
+	return user, member.Role, nil
+}
+
+// Create a workspace owned by the caller
+
+func (u *workspaceUsecase) CreateWorkspace(ctx context.Context, request domain.CreateWorkspaceRequest) (domain.Workspace, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.Workspace{}, domain.ErrUnauthenticated
+	}
+
+	workspace := domain.Workspace{
+		Name:          strings.TrimSpace(request.Name),
+		Slug:          strings.ToLower(strings.TrimSpace(request.Slug)),
+		SlugNamespace: request.SlugNamespace,
+		CreatedAt:     time.Now().Unix(),
+		Role:          domain.WorkspaceRoleOwner,
+	}
+	if workspace.SlugNamespace == "" {
+		workspace.SlugNamespace = domain.SlugNamespaceShared
+	}
+
+	if workspace.Name == "" || utf8.RuneCountInString(workspace.Name) > _config.NameMaxLength {
+		return domain.Workspace{}, fmt.Errorf("validation error: name is required and can't be longer than %d characters", _config.NameMaxLength)
+	}
+	if !workspaceSlug.MatchString(workspace.Slug) || strings.Trim(workspace.Slug, "0123456789-") == "" || reservedSlugs[workspace.Slug] {
+		return domain.Workspace{}, errors.New("validation error: slug must be 2 to 32 lowercase letters, digits or dashes, with at least one letter")
+	}
+	if !slugNamespaces[workspace.SlugNamespace] {
+		return domain.Workspace{}, errors.New("validation error: slug_namespace must be one of shared or workspace")
+	}
+
+	id, err := u.workspaceRepository.Create(ctx, workspace, user.ID)
+	if err != nil {
+		return domain.Workspace{}, err
+	}
+	workspace.ID = id
+	return workspace, nil
+}
+
+// List the workspaces the caller is a member of
+
+func (u *workspaceUsecase) FindAllWorkspaces(ctx context.Context) ([]domain.Workspace, error) {
+	user, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return nil, domain.ErrUnauthenticated
+	}
+	return u.workspaceRepository.FindByUserID(ctx, user.ID)
+}
+
+func (u *workspaceUsecase) GetWorkspace(ctx context.Context, id int) (domain.Workspace, error) {
+	_, role, err := u.authorize(ctx, id, domain.WorkspaceRoleViewer)
+	if err != nil {
+		return domain.Workspace{}, err
+	}
+
+	workspace, err := u.workspaceRepository.FindByID(ctx, id)
+	workspace.Role = role
+	return workspace, err
+}
+
+// Delete a workspace, its links are kept by the users who created them
+
+func (u *workspaceUsecase) DeleteWorkspace(ctx context.Context, id int) error {
+	_, _, err := u.authorize(ctx, id, domain.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	affected, err := u.workspaceRepository.DeleteByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (u *workspaceUsecase) FindMembers(ctx context.Context, workspaceID int) ([]domain.WorkspaceMember, error) {
+	_, _, err := u.authorize(ctx, workspaceID, domain.WorkspaceRoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return u.workspaceRepository.FindMembers(ctx, workspaceID)
+}
+
+// Add a registered user to a workspace, or change the role of a member
+// A workspace always keeps at least one owner
+
+func (u *workspaceUsecase) SetMember(ctx context.Context, workspaceID int, request domain.SetWorkspaceMemberRequest) (domain.WorkspaceMember, error) {
+	if !workspaceRoles[request.Role] {
+		return domain.WorkspaceMember{}, errors.New("validation error: role must be one of owner, editor or viewer")
+	}
+
+	_, _, err := u.authorize(ctx, workspaceID, domain.WorkspaceRoleOwner)
+	if err != nil {
+		return domain.WorkspaceMember{}, err
+	}
+
+	user, err := u.userRepository.FindByEmail(ctx, strings.TrimSpace(request.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.WorkspaceMember{}, errors.New("validation error: email doesn't match a registered user")
+	}
+	if err != nil {
+		return domain.WorkspaceMember{}, err
+	}
+
+	member := domain.WorkspaceMember{
+		WorkspaceID: workspaceID,
+		UserID:      user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Role:        request.Role,
+		CreatedAt:   time.Now().Unix(),
+	}
+	err = u.workspaceRepository.SetMember(ctx, member)
+	if err != nil {
+		return domain.WorkspaceMember{}, err
+	}
+
+	// an existing member keeps the time it joined
+	return u.workspaceRepository.FindMember(ctx, workspaceID, user.ID)
+}
+
+// Remove a member from a workspace, owners remove anyone and every member can leave
+
+func (u *workspaceUsecase) RemoveMember(ctx context.Context, workspaceID, userID int) error {
+	caller, ok := domain.UserFromContext(ctx)
+	if !ok {
+		return domain.ErrUnauthenticated
+	}
+
+	required := domain.WorkspaceRoleOwner
+	if caller.ID == userID {
+		required = domain.WorkspaceRoleViewer
+	}
+	_, _, err := u.authorize(ctx, workspaceID, required)
+	if err != nil {
+		return err
+	}
+
+	affected, err := u.workspaceRepository.DeleteMember(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/mrizalr/urlshortener/domain"
+	"github.com/mrizalr/urlshortener/domain/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var ownerCtx = domain.ContextWithUser(context.Background(), domain.User{ID: 3, Role: domain.UserRoleMember})
+
+var members = []domain.WorkspaceMember{
+	{WorkspaceID: 4, UserID: 3, Role: domain.WorkspaceRoleOwner},
+	{WorkspaceID: 4, UserID: 5, Role: domain.WorkspaceRoleEditor},
+}
+
+func TestCreateWorkspace(t *testing.T) {
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	workspaceUsecase := workspaceUsecase{workspaceRepository: workspaceRepoMock}
+
+	workspaceRepoMock.On("Create", ownerCtx, mock.MatchedBy(func(ws domain.Workspace) bool {
+		return ws.Name == "Sales" && ws.Slug == "sales" && ws.SlugNamespace == domain.SlugNamespaceShared
+	}), 3).Return(4, nil)
+
+	workspace, err := workspaceUsecase.CreateWorkspace(ownerCtx, domain.CreateWorkspaceRequest{Name: " Sales ", Slug: "Sales"})
+	workspaceRepoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, workspace.ID)
+	assert.Equal(t, domain.WorkspaceRoleOwner, workspace.Role)
+}
+
+func TestCreateWorkspaceInvalid(t *testing.T) {
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	workspaceUsecase := workspaceUsecase{workspaceRepository: workspaceRepoMock}
+
+	invalidRequests := []domain.CreateWorkspaceRequest{
+		{Name: "", Slug: "sales"},
+		{Name: "Sales", Slug: "s"},
+		{Name: "Sales", Slug: "12345"},
+		{Name: "Sales", Slug: "-sales"},
+		{Name: "Sales", Slug: "sales/eu"},
+		{Name: "Sales", Slug: "by-slug"},
+		{Name: "Sales", Slug: "sales", SlugNamespace: "global"},
+	}
+	for _, request := range invalidRequests {
+		_, err := workspaceUsecase.CreateWorkspace(ownerCtx, request)
+		assert.ErrorContains(t, err, "validation", request.Slug)
+	}
+
+	_, err := workspaceUsecase.CreateWorkspace(context.Background(), domain.CreateWorkspaceRequest{Name: "Sales", Slug: "sales"})
+	assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+	workspaceRepoMock.AssertNotCalled(t, "Create")
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	workspaceUsecase := workspaceUsecase{workspaceRepository: workspaceRepoMock}
+
+	editorCtx := domain.ContextWithUser(context.Background(), domain.User{ID: 5, Role: domain.UserRoleMember})
+	strangerCtx := domain.ContextWithUser(context.Background(), domain.User{ID: 8, Role: domain.UserRoleMember})
+	adminCtx := domain.ContextWithUser(context.Background(), domain.User{ID: 1, Role: domain.UserRoleAdmin})
+
+	workspaceRepoMock.On("FindMember", editorCtx, 4, 5).Return(members[1], nil)
+	workspaceRepoMock.On("FindMember", strangerCtx, 4, 8).Return(domain.WorkspaceMember{}, sql.ErrNoRows)
+	workspaceRepoMock.On("FindByID", editorCtx, 4).Return(domain.Workspace{ID: 4, Slug: "sales"}, nil)
+	workspaceRepoMock.On("FindByID", adminCtx, 4).Return(domain.Workspace{ID: 4, Slug: "sales"}, nil)
+	workspaceRepoMock.On("DeleteByID", adminCtx, 4).Return(1, nil)
+
+	workspace, err := workspaceUsecase.GetWorkspace(editorCtx, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.WorkspaceRoleEditor, workspace.Role)
+
+	// editors can't delete the workspace, and non members don't see it
+	err = workspaceUsecase.DeleteWorkspace(editorCtx, 4)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	_, err = workspaceUsecase.GetWorkspace(strangerCtx, 4)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// admins act as owners of every workspace
+	err = workspaceUsecase.DeleteWorkspace(adminCtx, 4)
+	assert.NoError(t, err)
+	workspaceRepoMock.AssertNumberOfCalls(t, "DeleteByID", 1)
+}
+
+func TestSetWorkspaceMember(t *testing.T) {
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	userRepoMock := new(mocks.UserRepository)
+	workspaceUsecase := workspaceUsecase{workspaceRepoMock, userRepoMock}
+
+	jane := domain.User{ID: 6, Name: "Jane", Email: "jane@example.com"}
+	workspaceRepoMock.On("FindMember", ownerCtx, 4, 3).Return(members[0], nil).Once()
+	userRepoMock.On("FindByEmail", ownerCtx, "jane@example.com").Return(jane, nil)
+	workspaceRepoMock.On("SetMember", ownerCtx, mock.MatchedBy(func(member domain.WorkspaceMember) bool {
+		return member.WorkspaceID == 4 && member.UserID == 6 && member.Role == domain.WorkspaceRoleViewer
+	})).Return(nil)
+	workspaceRepoMock.On("FindMember", ownerCtx, 4, 6).
+		Return(domain.WorkspaceMember{WorkspaceID: 4, UserID: 6, Email: jane.Email, Role: domain.WorkspaceRoleViewer}, nil)
+
+	member, err := workspaceUsecase.SetMember(ownerCtx, 4, domain.SetWorkspaceMemberRequest{Email: " jane@example.com ", Role: "viewer"})
+	workspaceRepoMock.AssertExpectations(t)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, member.UserID)
+
+	_, err = workspaceUsecase.SetMember(ownerCtx, 4, domain.SetWorkspaceMemberRequest{Email: jane.Email, Role: "admin"})
+	assert.ErrorContains(t, err, "validation")
+}
+
+func TestRemoveWorkspaceMember(t *testing.T) {
+	workspaceRepoMock := new(mocks.WorkspaceRepository)
+	workspaceUsecase := workspaceUsecase{workspaceRepository: workspaceRepoMock}
+
+	editorCtx := domain.ContextWithUser(context.Background(), domain.User{ID: 5, Role: domain.UserRoleMember})
+	workspaceRepoMock.On("FindMember", mock.Anything, 4, 3).Return(members[0], nil)
+	workspaceRepoMock.On("FindMember", mock.Anything, 4, 5).Return(members[1], nil)
+	workspaceRepoMock.On("DeleteMember", ownerCtx, 4, 3).Return(0, domain.ErrLastWorkspaceOwner)
+	workspaceRepoMock.On("DeleteMember", editorCtx, 4, 5).Return(1, nil)
+
+	// the last owner can't leave, an editor can't remove others but can leave
+	err := workspaceUsecase.RemoveMember(ownerCtx, 4, 3)
+	assert.ErrorContains(t, err, "validation")
+	err = workspaceUsecase.RemoveMember(editorCtx, 4, 3)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	err = workspaceUsecase.RemoveMember(editorCtx, 4, 5)
+	assert.NoError(t, err)
+	workspaceRepoMock.AssertNumberOfCalls(t, "DeleteMember", 2)
+}